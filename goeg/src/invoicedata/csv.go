@@ -0,0 +1,252 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Every row has the same columns so that spreadsheets can sort and
+// filter them: invoice rows leave the item columns empty and item rows
+// leave the invoice columns empty except for the invoice's Id.
+var csvHeader = []string{"Kind", "Invoice", "Customer", "Raised", "Due",
+    "Paid", "Item", "Price", "Currency", "Quantity", "Note"}
+
+// Files written before prices became Money have no Currency column and
+// their prices may have any number of decimal places.
+var csvHeader100 = []string{"Kind", "Invoice", "Customer", "Raised",
+    "Due", "Paid", "Item", "Price", "Quantity", "Note"}
+
+const (
+    csvInvoice = "INVOICE"
+    csvItem    = "ITEM"
+)
+
+// The separators that are tried (in order) when reading with a zero
+// Comma; the same ones that guess_separator knows about plus the usual
+// spreadsheet ones.
+var csvSeparators = []string{",", "\t", ";", "|", "*", "•"}
+
+// Comma is the field delimiter: when writing 0 means ','; when reading 0
+// means guess it from the header row. Lines always end with "\r\n" as
+// RFC 4180 and Excel expect.
+type CSVMarshaler struct {
+    Comma rune
+}
+
+func (marshaler CSVMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) error {
+    csvWriter := csv.NewWriter(writer)
+    if marshaler.Comma != 0 {
+        csvWriter.Comma = marshaler.Comma
+    }
+    csvWriter.UseCRLF = true
+    if err := csvWriter.Write(csvHeader); err != nil {
+        return err
+    }
+    for _, invoice := range invoices {
+        if err := checkFits(invoice, "a "+marshaler.suffix()+" file",
+            csvTextFits, csvTextFits); err != nil {
+            return err
+        }
+        if err := csvWriter.Write(csvRowForInvoice(invoice)); err != nil {
+            return err
+        }
+        for _, item := range invoice.Items {
+            if err := csvWriter.Write(csvRowForItem(invoice.Id, item));
+                err != nil {
+                return err
+            }
+        }
+    }
+    csvWriter.Flush()
+    return csvWriter.Error()
+}
+
+func (marshaler CSVMarshaler) suffix() string {
+    if marshaler.Comma == '\t' {
+        return ".tsv"
+    }
+    return ".csv"
+}
+
+// csvTextFits returns whether the text survives being read back: the
+// writer ends lines with "\r\n", even inside quoted fields, and the reader
+// turns them back into "\n", so any other '\r' is lost.
+func csvTextFits(text string) bool {
+    return !strings.Contains(text, "\r")
+}
+
+func csvRowForInvoice(invoice *Invoice) []string {
+    return []string{csvInvoice, strconv.Itoa(invoice.Id),
+        strconv.Itoa(invoice.CustomerId),
+        invoice.Raised.Format(dateFormat), invoice.Due.Format(dateFormat),
+        strconv.FormatBool(invoice.Paid), "", "", "", "", invoice.Note}
+}
+
+func csvRowForItem(invoiceId int, item *Item) []string {
+    return []string{csvItem, strconv.Itoa(invoiceId), "", "", "", "",
+        item.Id, item.Price.Decimal(), item.Price.Currency,
+        strconv.Itoa(item.Quantity), item.Note}
+}
+
+func (marshaler CSVMarshaler) UnmarshalInvoices(reader io.Reader) (
+    []*Invoice, error) {
+    bufferedReader := bufio.NewReader(reader)
+    comma := marshaler.Comma
+    if comma == 0 {
+        var err error
+        if comma, err = guessCSVSeparator(bufferedReader); err != nil {
+            return nil, err
+        }
+    }
+    csvReader := csv.NewReader(bufferedReader)
+    csvReader.Comma = comma
+    header, err := checkCSVHeader(csvReader)
+    if err != nil {
+        return nil, err
+    }
+    var invoices []*Invoice
+    for {
+        row, err := csvReader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, err
+        }
+        lino, _ := csvReader.FieldPos(0)
+        if len(header) < len(csvHeader) { // Add the missing Currency
+            row = append(row[:8], append([]string{""}, row[8:]...)...)
+        }
+        if invoices, err = parseCSVRow(lino, row, invoices); err != nil {
+            return nil, err
+        }
+    }
+    return invoices, nil
+}
+
+// guessCSVSeparator peeks at the header row and, like guess_separator,
+// counts each candidate separator in it: the one that occurs exactly
+// once between each pair of columns wins.
+func guessCSVSeparator(reader *bufio.Reader) (rune, error) {
+    line, err := reader.Peek(reader.Size())
+    if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+        return 0, err
+    }
+    header := string(line)
+    if i := strings.IndexAny(header, "\r\n"); i > -1 {
+        header = header[:i]
+    }
+    for _, separator := range csvSeparators {
+        count := strings.Count(header, separator)
+        if count == len(csvHeader)-1 || count == len(csvHeader100)-1 {
+            return []rune(separator)[0], nil
+        }
+    }
+    return 0, errors.New("cannot read non-invoices csv file")
+}
+
+// checkCSVHeader returns the header that the file uses; the reader then
+// expects every row to have the same number of columns.
+func checkCSVHeader(reader *csv.Reader) ([]string, error) {
+    row, err := reader.Read()
+    if err != nil {
+        return nil, err
+    }
+    if len(row) > 0 {
+        row[0] = strings.TrimPrefix(row[0], "\uFEFF")
+    }
+    for _, header := range [][]string{csvHeader, csvHeader100} {
+        if strings.Join(row, "\n") == strings.Join(header, "\n") {
+            return header, nil
+        }
+    }
+    return nil, errors.New("cannot read non-invoices csv file")
+}
+
+func parseCSVRow(lino int, row []string, invoices []*Invoice) (
+    []*Invoice, error) {
+    switch row[0] {
+    case csvInvoice:
+        invoice, err := parseCSVInvoice(lino, row)
+        if err != nil {
+            return nil, err
+        }
+        return append(invoices, invoice), nil
+    case csvItem:
+        if len(invoices) == 0 {
+            return nil, fmt.Errorf("item outside of an invoice line %d",
+                lino)
+        }
+        invoice := invoices[len(invoices)-1]
+        if row[1] != strconv.Itoa(invoice.Id) {
+            return nil, fmt.Errorf("item for invoice %s after invoice %d "+
+                "line %d", row[1], invoice.Id, lino)
+        }
+        item, err := parseCSVItem(lino, row)
+        if err != nil {
+            return nil, err
+        }
+        invoice.Items = append(invoice.Items, item)
+        return invoices, nil
+    }
+    return nil, fmt.Errorf("invalid kind %q line %d", row[0], lino)
+}
+
+func parseCSVInvoice(lino int, row []string) (invoice *Invoice,
+    err error) {
+    invoice = &Invoice{Note: row[10]}
+    if invoice.Id, err = strconv.Atoi(row[1]); err != nil {
+        return nil, fmt.Errorf("invalid invoice %v line %d", err, lino)
+    }
+    if invoice.CustomerId, err = strconv.Atoi(row[2]); err != nil {
+        return nil, fmt.Errorf("invalid customer %v line %d", err, lino)
+    }
+    if invoice.Raised, err = time.Parse(dateFormat, row[3]); err != nil {
+        return nil, fmt.Errorf("invalid raised %v line %d", err, lino)
+    }
+    if invoice.Due, err = time.Parse(dateFormat, row[4]); err != nil {
+        return nil, fmt.Errorf("invalid due %v line %d", err, lino)
+    }
+    if invoice.Paid, err = strconv.ParseBool(row[5]); err != nil {
+        return nil, fmt.Errorf("invalid paid %v line %d", err, lino)
+    }
+    return invoice, nil
+}
+
+func parseCSVItem(lino int, row []string) (item *Item, err error) {
+    item = &Item{Id: row[6], Note: row[10]}
+    if row[8] == "" { // No currency so it's an old float64 price
+        var x float64
+        if x, err = strconv.ParseFloat(row[7], 64); err == nil {
+            item.Price = MoneyFromFloat(x, defaultCurrency)
+        }
+    } else {
+        item.Price, err = ParseMoney(row[7] + " " + row[8])
+    }
+    if err != nil {
+        return nil, fmt.Errorf("invalid price %v line %d", err, lino)
+    }
+    if item.Quantity, err = strconv.Atoi(row[9]); err != nil {
+        return nil, fmt.Errorf("invalid quantity %v line %d", err, lino)
+    }
+    return item, nil
+}
@@ -0,0 +1,102 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "log"
+    "testing"
+    "time"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+    log.SetFlags(0)
+    log.Println("TEST invoicedata csv")
+
+    raised := time.Date(2012, 9, 6, 0, 0, 0, 0, time.UTC)
+    original := []*Invoice{
+        {5441, 960, raised, raised.AddDate(0, 0, 30), true,
+            `Says "hi", then; leaves | quickly`, []*Item{
+                item("BE9066", "400.89 USD", 7,
+                    "Keep out of <direct> sunlight"),
+                item("AB1324", "0.125 KWD", 1200, "tabs\tand, commas")}},
+        {9928, 917, raised, raised.AddDate(0, 1, 0), false, "", nil},
+    }
+    for _, suffix := range []string{".gob", ".inv", ".jsn", ".txt",
+        ".xml"} {
+        var buffer bytes.Buffer
+        if err := writeInvoices(&buffer, suffix, original); err != nil {
+            t.Fatal(err)
+        }
+        invoices, err := readInvoices(&buffer, suffix)
+        if err != nil {
+            t.Fatalf("%s: %v", suffix, err)
+        }
+        for _, csvSuffix := range []string{".csv", ".tsv"} {
+            buffer.Reset()
+            if err := writeInvoices(&buffer, csvSuffix, invoices);
+                err != nil {
+                t.Fatal(err)
+            }
+            // Read with a zero Comma so the separator has to be guessed
+            actual, err := CSVMarshaler{}.UnmarshalInvoices(&buffer)
+            if err != nil {
+                t.Fatalf("%s via %s: %v", suffix, csvSuffix, err)
+            }
+            if len(actual) != len(invoices) {
+                t.Fatalf("%s via %s: %d invoices != %d", suffix, csvSuffix,
+                    len(actual), len(invoices))
+            }
+            for i := range actual {
+                if !sameInvoice(actual[i], invoices[i]) {
+                    t.Errorf("%s via %s: %v != %v", suffix, csvSuffix,
+                        *actual[i], *invoices[i])
+                }
+            }
+        }
+    }
+}
+
+func TestCSVErrors(t *testing.T) {
+    for _, data := range []string{
+        "",
+        "Id,Customer\r\n",
+        "Kind,Invoice,Customer,Raised,Due,Paid,Item,Price,Quantity,Note\r\n" +
+            "ITEM,1,,,,,A,1,1,\r\n",
+        "Kind,Invoice,Customer,Raised,Due,Paid,Item,Price,Quantity,Note\r\n" +
+            "INVOICE,1,2,2012-01-01,2012-02-01,true,,,,\r\n" +
+            "ITEM,2,,,,,A,1,1,\r\n",
+        "Kind,Invoice,Customer,Raised,Due,Paid,Item,Price,Quantity,Note\r\n" +
+            "INVOICE,1,2,2012-01-01,soon,true,,,,\r\n",
+    } {
+        if _, err := (CSVMarshaler{}).UnmarshalInvoices(
+            bytes.NewBufferString(data)); err == nil {
+            t.Errorf("no error for %q", data)
+        }
+    }
+}
+
+func sameInvoice(a, b *Invoice) bool {
+    if a.Id != b.Id || a.CustomerId != b.CustomerId ||
+        !a.Raised.Equal(b.Raised) || !a.Due.Equal(b.Due) ||
+        a.Paid != b.Paid || a.Note != b.Note || len(a.Items) != len(b.Items) {
+        return false
+    }
+    for i, item := range a.Items {
+        if *item != *b.Items[i] {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,111 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "encoding/gob"
+    "encoding/json"
+    "errors"
+    "io"
+    "os"
+)
+
+const peekSize = 512 // Enough to get past any XML prolog or whitespace
+
+var (
+    gzipMagic    = []byte{0x1F, 0x8B}
+    invMagic     = make([]byte, 4)
+    gobMagic     []byte // The gob encoding of magicNumber
+    jsonMagic    []byte // The JSON encoding of fileType
+    pbMagic      = appendPBVarint(nil, 1, magicNumber)
+    msgpackMagic = appendMsgpackString(nil, fileType)
+)
+
+func init() {
+    byteOrder.PutUint32(invMagic, magicNumber)
+    var buffer bytes.Buffer
+    if err := gob.NewEncoder(&buffer).Encode(magicNumber); err != nil {
+        panic(err)
+    }
+    gobMagic = buffer.Bytes()
+    var err error
+    if jsonMagic, err = json.Marshal(fileType); err != nil {
+        panic(err)
+    }
+}
+
+// openDetectedInvoiceFile is like openInvoiceFile except that it ignores
+// the filename's suffix: gzip compression and the invoice format are
+// both recognized from the file's content. The returned reader is
+// buffered and only ever holds the first few bytes it has peeked at, so
+// when the format has an InvoiceReader even huge files are streamed.
+func openDetectedInvoiceFile(filename string) (io.Reader, string, func(),
+    error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, "", nil, err
+    }
+    closer := func() { file.Close() }
+    reader := bufio.NewReaderSize(file, peekSize)
+    if isGzipped(reader) {
+        decompressor, err := gzip.NewReader(reader)
+        if err != nil {
+            return nil, "", closer, err
+        }
+        closer = func() { decompressor.Close(); file.Close() }
+        reader = bufio.NewReaderSize(decompressor, peekSize)
+    }
+    suffix, err := detectFormat(reader)
+    return reader, suffix, closer, err
+}
+
+func isGzipped(reader *bufio.Reader) bool {
+    header, _ := reader.Peek(len(gzipMagic))
+    return bytes.Equal(header, gzipMagic)
+}
+
+// detectFormat returns the suffix (e.g., ".inv") of the format that the
+// reader's content is in without consuming any of it.
+func detectFormat(reader *bufio.Reader) (string, error) {
+    header, err := reader.Peek(peekSize)
+    if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+        return "", err
+    }
+    switch {
+    case bytes.HasPrefix(header, invMagic):
+        return ".inv", nil
+    case bytes.HasPrefix(header, gobMagic):
+        return ".gob", nil
+    case bytes.HasPrefix(header, pbMagic):
+        return ".pb", nil
+    case bytes.HasPrefix(header, msgpackMagic):
+        return ".msgpack", nil
+    }
+    header = bytes.TrimLeft(header, " \t\r\n\uFEFF")
+    switch {
+    case bytes.HasPrefix(header, []byte(fileType+" ")):
+        return ".txt", nil
+    case bytes.HasPrefix(header, jsonMagic):
+        return ".jsn", nil
+    case bytes.HasPrefix(header, []byte(csvHeader[0])):
+        return ".csv", nil
+    case bytes.HasPrefix(header, []byte("<?xml")),
+        bytes.HasPrefix(header, []byte("<"+fileType)):
+        return ".xml", nil
+    }
+    return "", errors.New("unrecognized invoices file format")
+}
@@ -0,0 +1,133 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestDetectFormat(t *testing.T) {
+    log.SetFlags(0)
+    log.Println("TEST invoicedata format detection")
+
+    raised := time.Date(2012, 9, 6, 0, 0, 0, 0, time.UTC)
+    invoices := []*Invoice{{5441, 960, raised, raised.AddDate(0, 0, 30),
+        true, "", []*Item{item("BE9066", "400.89 USD", 7,
+            "Fragile")}}}
+    for _, suffix := range []string{".csv", ".gob", ".inv", ".jsn",
+        ".msgpack", ".pb", ".txt", ".xml"} {
+        var buffer bytes.Buffer
+        if err := writeInvoices(&buffer, suffix, invoices); err != nil {
+            t.Fatal(err)
+        }
+        reader := bufio.NewReaderSize(&buffer, peekSize)
+        detected, err := detectFormat(reader)
+        if err != nil {
+            t.Fatalf("%s: %v", suffix, err)
+        }
+        if detected != suffix {
+            t.Fatalf("%s detected as %s", suffix, detected)
+        }
+        if _, err := readInvoices(reader, detected); err != nil {
+            t.Fatalf("%s: peeking consumed data: %v", suffix, err)
+        }
+    }
+    reader := bufio.NewReader(bytes.NewBufferString("not invoices"))
+    if _, err := detectFormat(reader); err == nil {
+        t.Fatal("detected a format for non-invoices data")
+    }
+}
+
+func TestValidateInvoices(t *testing.T) {
+    raised := time.Date(2012, 9, 6, 0, 0, 0, 0, time.UTC)
+    invoices := []*Invoice{
+        {1, 960, raised, raised.AddDate(0, 0, 30), false, "",
+            []*Item{item("BE9066", "400.89 USD", 7, "")}},
+        {2, 960, raised, raised, false, "",
+            []*Item{item("BE9066", "400.89 USD", 0, ""),
+                item("AB1324", "1.00 USD", -2, "")}},
+        {1, 960, raised, raised.AddDate(0, 1, 0), false, "", nil},
+        {4, 960, raised, raised.AddDate(0, 1, 0), false, "",
+            []*Item{item("BE9066", "400.89 USD", 7, ""), nil}},
+    }
+    expected := []Violation{{2, 0, ""}, {2, 1, ""}, {2, 2, ""}, {3, 0, ""},
+        {4, 2, ""}}
+    violations := validateInvoices(invoices)
+    if len(violations) != len(expected) {
+        t.Fatalf("%d violations != %d: %v", len(violations),
+            len(expected), violations)
+    }
+    for i, violation := range violations {
+        if violation.Invoice != expected[i].Invoice ||
+            violation.Item != expected[i].Item {
+            t.Errorf("violation %v at wrong location", violation)
+        }
+    }
+}
+
+// convert streams a detected file whose suffix says nothing about its
+// format straight through to the output file.
+func TestConvertDetectedStream(t *testing.T) {
+    invoices, err := readInvoices(bytes.NewBufferString(sampleTxt), ".txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir, err := ioutil.TempDir("", "invoicedata")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    inFilename := filepath.Join(dir, "invoices.dat")
+    outFilename := filepath.Join(dir, "invoices.inv")
+    if err := writeInvoiceFile(inFilename+".xml.gz", invoices); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Rename(inFilename+".xml.gz", inFilename); err != nil {
+        t.Fatal(err)
+    }
+    streamer, err := detectedStreamer(inFilename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if streamer != streamerForSuffix(".xml") {
+        t.Fatalf("detected streamer %T is not the .xml one", streamer)
+    }
+    if err = forEachDetectedInvoice(inFilename, streamer, func(
+        reader InvoiceReader) error {
+        return writeInvoiceStream(reader, outFilename,
+            streamerForSuffix(".inv"))
+    }); err != nil {
+        t.Fatal(err)
+    }
+    converted, err := readInvoiceFile(outFilename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(converted) != len(invoices) {
+        t.Fatalf("converted %d of %d invoices", len(converted),
+            len(invoices))
+    }
+    for i, invoice := range converted {
+        if !sameInvoice(invoice, invoices[i]) {
+            t.Errorf("%v != %v", *invoice, *invoices[i])
+        }
+    }
+}
@@ -0,0 +1,272 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "invoicing"
+    "io/ioutil"
+    "math"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+var benchmarkSuffixes = []string{".csv", ".gob", ".inv", ".jsn",
+    ".msgpack", ".pb", ".txt", ".xml"}
+
+// The benchmarks use the sample invoices that come with the program and
+// report each format's size as well as its speed, e.g.:
+//  go test -run=NONE -bench=. -benchmem
+func benchmarkInvoices(b *testing.B) []*Invoice {
+    file, err := os.Open("invoices.gob.gz")
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer file.Close()
+    reader, err := gzip.NewReader(file)
+    if err != nil {
+        b.Fatal(err)
+    }
+    invoices, err := readInvoices(reader, ".gob")
+    if err != nil {
+        b.Fatal(err)
+    }
+    return invoices
+}
+
+func BenchmarkMarshal(b *testing.B) {
+    invoices := benchmarkInvoices(b)
+    for _, suffix := range benchmarkSuffixes {
+        b.Run(suffix[1:], func(b *testing.B) {
+            var buffer bytes.Buffer
+            for i := 0; i < b.N; i++ {
+                buffer.Reset()
+                if err := writeInvoices(&buffer, suffix, invoices);
+                    err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.ReportMetric(float64(buffer.Len()), "bytes")
+        })
+    }
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+    invoices := benchmarkInvoices(b)
+    for _, suffix := range benchmarkSuffixes {
+        b.Run(suffix[1:], func(b *testing.B) {
+            var buffer bytes.Buffer
+            if err := writeInvoices(&buffer, suffix, invoices); err != nil {
+                b.Fatal(err)
+            }
+            data := buffer.Bytes()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if _, err := readInvoices(bytes.NewReader(data), suffix);
+                    err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.ReportMetric(float64(len(data)), "bytes")
+        })
+    }
+}
+
+func TestPBAndMsgpackRoundTrip(t *testing.T) {
+    invoices, err := readInvoices(bytes.NewBufferString(sampleTxt), ".txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, suffix := range []string{".pb", ".msgpack"} {
+        var buffer bytes.Buffer
+        if err := writeInvoices(&buffer, suffix, invoices); err != nil {
+            t.Fatal(err)
+        }
+        actual, err := readInvoices(&buffer, suffix)
+        if err != nil {
+            t.Fatalf("%s: %v", suffix, err)
+        }
+        if len(actual) != len(invoices) {
+            t.Fatalf("%s: %d invoices != %d", suffix, len(actual),
+                len(invoices))
+        }
+        for i := range actual {
+            if !sameInvoice(actual[i], invoices[i]) {
+                t.Errorf("%s: %v != %v", suffix, *actual[i], *invoices[i])
+            }
+        }
+    }
+}
+
+func TestPBKnownEncoding(t *testing.T) {
+    // Field 1 varint 0x125D, field 2 varint 100, then an invoice with
+    // Id -1 (sign extended), an unknown field 9, and an item with price 2
+    var data []byte
+    data = appendPBVarint(data, 1, magicNumber)
+    data = appendPBVarint(data, 2, fileVersion)
+    item := appendPBBytes(nil, 1, []byte("X"))
+    item = append(item, 0x11, 0, 0, 0, 0, 0, 0, 0, 0x40)
+    invoice := appendPBVarint(nil, 1, ^uint64(0))
+    invoice = appendPBVarint(invoice, 9, 42)
+    invoice = appendPBBytes(invoice, 7, item)
+    data = appendPBBytes(data, 3, invoice)
+    invoices, err := PBMarshaler{}.UnmarshalInvoices(bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(invoices) != 1 || invoices[0].Id != -1 ||
+        len(invoices[0].Items) != 1 || invoices[0].Items[0].Id != "X" ||
+        invoices[0].Items[0].Price.String() != "2.00 USD" {
+        t.Fatalf("wrongly decoded %v", invoices)
+    }
+}
+
+// A null item is an error when reading rather than a panic later on.
+func TestJSONNullItem(t *testing.T) {
+    data := `"INVOICES"
+104
+[{"Id":1,"CustomerId":2,"Raised":"2012-09-06","Due":"2012-10-06",` +
+        `"Paid":false,"Note":"","Items":[null]}]`
+    _, err := readInvoices(bytes.NewBufferString(data), ".jsn")
+    if err != invoicing.ErrNullItem {
+        t.Fatalf("expected %v got %v", invoicing.ErrNullItem, err)
+    }
+}
+
+// A known field with the wrong wire type is an error rather than being
+// skipped as unknown fields are.
+func TestPBWrongWireType(t *testing.T) {
+    header := func() []byte {
+        data := appendPBVarint(nil, 1, magicNumber)
+        return appendPBVarint(data, 2, fileVersion)
+    }
+    for _, test := range []struct {
+        data     []byte
+        expected string
+    }{
+        {appendPBBytes(header(), 2, []byte{104}),
+            "pb field 2 has wire type 2 not 0"},
+        {appendPBBytes(header(), 3, appendPBVarint(nil, 6, 1)),
+            "pb field 6 has wire type 0 not 2"},
+        {appendPBBytes(header(), 3, appendPBBytes(nil, 7,
+            appendPBVarint(nil, 2, 2))),
+            "pb field 2 has wire type 0 not 1"},
+    } {
+        _, err := PBMarshaler{}.UnmarshalInvoices(bytes.NewReader(
+            test.data))
+        if err == nil || err.Error() != test.expected {
+            t.Errorf("expected %q got %v", test.expected, err)
+        }
+    }
+}
+
+// .inv files hold quantities in int64s and refuse ids that won't fit
+// their int32s rather than truncate either.
+func TestInvRanges(t *testing.T) {
+    raised := time.Date(2012, 9, 6, 0, 0, 0, 0, time.UTC)
+    invoices := []*Invoice{{1, 960, raised, raised, false, "",
+        []*Item{item("BE9066", "1.00 USD", 40000, ""),
+            item("AB1324", "1.00 USD", math.MinInt64, "")}}}
+    var buffer bytes.Buffer
+    if err := writeInvoices(&buffer, ".inv", invoices); err != nil {
+        t.Fatal(err)
+    }
+    actual, err := readInvoices(&buffer, ".inv")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if actual[0].Items[0].Quantity != 40000 ||
+        actual[0].Items[1].Quantity != math.MinInt64 {
+        t.Errorf("quantities changed to %d and %d",
+            actual[0].Items[0].Quantity, actual[0].Items[1].Quantity)
+    }
+    invoices[0].CustomerId = math.MaxInt32 + 1
+    if err := writeInvoices(ioutil.Discard, ".inv", invoices); err == nil {
+        t.Error("expected an error writing an out of range id")
+    }
+}
+
+// What a format can't hold is refused rather than changed.
+func TestFormatLimits(t *testing.T) {
+    raised := time.Date(2012, 9, 6, 0, 0, 0, 0, time.UTC)
+    for _, test := range []struct {
+        suffix, note, itemId string
+        date                 time.Time
+        fits                 bool
+    }{
+        {".csv", "two\nlines", "BE9066", raised, true},
+        {".csv", "carriage\rreturn", "BE9066", raised, false},
+        {".tsv", "BE9066", "carriage\rreturn", raised, false},
+        {".txt", "two\nlines", "BE9066", raised, false},
+        {".txt", " spaced ", "BE9066", raised, false},
+        {".txt", "", "BE:9066", raised, false},
+        {".txt", "", "BE 9066", raised, false},
+        {".xml", "carriage\rreturn", "BE9066", raised, true},
+        {".xml", "nul\x00", "BE9066", raised, false},
+        {".xml", "\tspaced", "BE9066", raised, false},
+        {".jsn", "invalid \xff", "BE9066", raised, false},
+        {".inv", "", "BE9066", raised.Add(time.Hour), false},
+        {".pb", "", "BE9066", raised.AddDate(10000, 0, 0), false},
+        {".gob", "", "BE9066", raised.Add(time.Hour), true},
+    } {
+        invoices := []*Invoice{{1, 960, raised, test.date, false, test.note,
+            []*Item{item(test.itemId, "1.00 USD", 1, "")}}}
+        var buffer bytes.Buffer
+        err := writeInvoices(&buffer, test.suffix, invoices)
+        if test.fits {
+            var actual []*Invoice
+            if err == nil {
+                actual, err = readInvoices(&buffer, test.suffix)
+            }
+            if err != nil || !sameInvoice(actual[0], invoices[0]) {
+                t.Errorf("%s: %q %q %s changed: %v", test.suffix, test.note,
+                    test.itemId, test.date, err)
+            }
+        } else if err == nil || !wontFit.MatchString(err.Error()) {
+            t.Errorf("%s: %q %q %s: expected a won't fit error, got %v",
+                test.suffix, test.note, test.itemId, test.date, err)
+        }
+    }
+}
+
+// Versions 101 and 102 are invoicedata_ans's, whose invoices differ.
+func TestRejectAnsVersions(t *testing.T) {
+    for suffix, text := range map[string]string{
+        ".jsn": "\"INVOICES\"\n102\n[]\n",
+        ".txt": "INVOICES 101\n",
+        ".xml": `<INVOICES version="102"></INVOICES>`,
+    } {
+        if _, err := readInvoices(strings.NewReader(text), suffix);
+            err == nil || !strings.Contains(err.Error(), "invoicedata_ans") {
+            t.Errorf("%s: expected an invoicedata_ans error, got %v", suffix,
+                err)
+        }
+    }
+}
+
+const sampleTxt = `INVOICES 100
+INVOICE ID=5441 CUSTOMER=960 RAISED=2012-09-06 DUE=2012-10-06 PAID=true
+ITEM ID=BE9066 PRICE=400.89 QUANTITY=7: Keep out of <direct> sunlight
+ITEM ID=AM7240 PRICE=183.69 QUANTITY=2
+ITEM ID=PT9110 PRICE=105.90 QUANTITY=3: Flammable
+
+INVOICE ID=5442 CUSTOMER=-3 RAISED=2012-09-07 DUE=2012-10-07 PAID=false: ` +
+    `A long note that is longer than thirty-one bytes so it needs str8
+
+INVOICE ID=5443 CUSTOMER=70000 RAISED=2012-09-07 DUE=2012-10-07 PAID=false
+ITEM ID=ZZ0001 PRICE=0.01 QUANTITY=-40000
+`
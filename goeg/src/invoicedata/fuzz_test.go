@@ -0,0 +1,153 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "math/rand"
+    "testing"
+)
+
+// The fuzz targets check that malformed input gives errors rather than
+// panics or hangs. Their seed corpora (which plain go test runs) are each
+// format's encoding of some random invoices and every truncation of it;
+// go test -fuzz=FuzzName mutates them further.
+
+func seedInvoices() []*Invoice {
+    random := rand.New(rand.NewSource(1))
+    invoices := randomInvoices{}.Generate(random, 3).Interface()
+    return invoices.(randomInvoices)
+}
+
+func encodedSeed(f *testing.F, suffix string) []byte {
+    var buffer bytes.Buffer
+    if err := writeInvoices(&buffer, suffix, seedInvoices()); err != nil {
+        f.Fatal(err)
+    }
+    return buffer.Bytes()
+}
+
+func fuzzUnmarshaler(f *testing.F, suffix string) {
+    data := encodedSeed(f, suffix)
+    for i := 0; i <= len(data); i++ {
+        f.Add(data[:i])
+    }
+    f.Fuzz(func(t *testing.T, data []byte) {
+        invoices, err := readInvoices(bytes.NewReader(data), suffix)
+        if err != nil {
+            return
+        }
+        // Whatever can be read must be writable.
+        var buffer bytes.Buffer
+        if err = writeInvoices(&buffer, suffix, invoices); err != nil {
+            t.Fatalf("%s: read but couldn't write: %v", suffix, err)
+        }
+    })
+}
+
+func FuzzTxtUnmarshal(f *testing.F)     { fuzzUnmarshaler(f, ".txt") }
+func FuzzInvUnmarshal(f *testing.F)     { fuzzUnmarshaler(f, ".inv") }
+func FuzzXMLUnmarshal(f *testing.F)     { fuzzUnmarshaler(f, ".xml") }
+func FuzzJSONUnmarshal(f *testing.F)    { fuzzUnmarshaler(f, ".jsn") }
+func FuzzGobUnmarshal(f *testing.F)     { fuzzUnmarshaler(f, ".gob") }
+func FuzzCSVUnmarshal(f *testing.F)     { fuzzUnmarshaler(f, ".csv") }
+func FuzzPBUnmarshal(f *testing.F)      { fuzzUnmarshaler(f, ".pb") }
+func FuzzMsgpackUnmarshal(f *testing.F) { fuzzUnmarshaler(f, ".msgpack") }
+
+// FuzzConvert follows convert: it detects the input's format, reads and
+// validates the invoices, and then writes the valid ones in every format.
+// Each write must either read back as the same invoices or fail with an
+// error saying what won't fit.
+func FuzzConvert(f *testing.F) {
+    for _, suffix := range roundTripSuffixes {
+        f.Add(encodedSeed(f, suffix))
+    }
+    f.Add([]byte(`"INVOICES"
+104
+[{"Id":1,"CustomerId":2,"Raised":"2012-09-06","Due":"2012-10-06",` +
+        `"Paid":false,"Note":"","Items":[null]}]
+`))
+    f.Fuzz(func(t *testing.T, data []byte) {
+        reader := bufio.NewReaderSize(bytes.NewReader(data), peekSize)
+        suffix, err := detectFormat(reader)
+        if err != nil {
+            return
+        }
+        invoices, err := readInvoices(reader, suffix)
+        if err != nil || len(validateInvoices(invoices)) > 0 {
+            return
+        }
+        for _, outSuffix := range roundTripSuffixes {
+            var buffer bytes.Buffer
+            if err = writeInvoices(&buffer, outSuffix, invoices);
+                err != nil {
+                if !wontFit.MatchString(err.Error()) {
+                    t.Fatalf("%s to %s: %v", suffix, outSuffix, err)
+                }
+                continue
+            }
+            actual, err := readInvoices(&buffer, outSuffix)
+            if err != nil {
+                t.Fatalf("%s to %s: can't read back: %v", suffix,
+                    outSuffix, err)
+            }
+            if len(actual) != len(invoices) {
+                t.Fatalf("%s to %s: %d invoices != %d", suffix, outSuffix,
+                    len(actual), len(invoices))
+            }
+            for i := range actual {
+                if !sameInvoice(actual[i], invoices[i]) {
+                    t.Fatalf("%s to %s: %v != %v", suffix, outSuffix,
+                        *actual[i], *invoices[i])
+                }
+            }
+        }
+    })
+}
+
+func FuzzParseTxtLine(f *testing.F) {
+    for _, line := range bytes.Split(encodedSeed(f, ".txt"), []byte("\n")) {
+        f.Add(100, string(line))
+        f.Add(fileVersion, string(line))
+    }
+    f.Fuzz(func(t *testing.T, version int, line string) {
+        invoices := []*Invoice{{}}
+        parseTxtLine(version, 2, line, invoices)
+    })
+}
+
+func FuzzReadInvInvoice(f *testing.F) {
+    data := encodedSeed(f, ".inv")
+    _, count, err := readInvHeader(bytes.NewReader(data))
+    if err != nil {
+        f.Fatal(err)
+    }
+    offset := int64(10) // Magic, version, and count
+    for i := 0; i < count; i++ {
+        reader := bytes.NewReader(data[offset:])
+        payload, err := readInvRecord(reader)
+        if err != nil {
+            f.Fatal(err)
+        }
+        for j := 0; j <= len(payload); j++ {
+            f.Add(100, payload[:j])
+            f.Add(fileVersion, payload[:j])
+        }
+        offset = int64(len(data) - reader.Len())
+    }
+    f.Fuzz(func(t *testing.T, version int, data []byte) {
+        readInvInvoice(version, bytes.NewReader(data))
+    })
+}
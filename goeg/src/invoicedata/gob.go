@@ -17,9 +17,8 @@ import (
 //    "bytes"
     "encoding/gob"
     "errors"
-    "fmt"
     "io"
-//    "time"
+    "time"
 )
 /*
     // Here is how to make a custom type satisfy the gob.Encoder and
@@ -87,10 +86,52 @@ func (GobMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
     if err := decoder.Decode(&version); err != nil {
         return nil, err
     }
-    if version > fileVersion {
-        return nil, fmt.Errorf("version %d is too new to read", version)
+    if err := checkVersion(version); err != nil {
+        return nil, err
+    }
+    if version < 103 {
+        return decodeGobInvoices100(decoder)
     }
     var invoices []*Invoice
     err := decoder.Decode(&invoices)
     return invoices, err
 }
+
+// Gob matches fields by name, so old files whose prices were float64s
+// are decoded into these otherwise identical types.
+type GobInvoice100 struct {
+    Id         int
+    CustomerId int
+    Raised     time.Time
+    Due        time.Time
+    Paid       bool
+    Note       string
+    Items      []*GobItem100
+}
+
+type GobItem100 struct {
+    Id       string
+    Price    float64
+    Quantity int
+    Note     string
+}
+
+func decodeGobInvoices100(decoder *gob.Decoder) ([]*Invoice, error) {
+    var gobInvoices []*GobInvoice100
+    if err := decoder.Decode(&gobInvoices); err != nil {
+        return nil, err
+    }
+    invoices := make([]*Invoice, 0, len(gobInvoices))
+    for _, gobInvoice := range gobInvoices {
+        invoice := &Invoice{gobInvoice.Id, gobInvoice.CustomerId,
+            gobInvoice.Raised, gobInvoice.Due, gobInvoice.Paid,
+            gobInvoice.Note, make([]*Item, 0, len(gobInvoice.Items))}
+        for _, gobItem := range gobInvoice.Items {
+            invoice.Items = append(invoice.Items, &Item{Id: gobItem.Id,
+                Price: MoneyFromFloat(gobItem.Price, defaultCurrency),
+                Quantity: gobItem.Quantity, Note: gobItem.Note})
+        }
+        invoices = append(invoices, invoice)
+    }
+    return invoices, nil
+}
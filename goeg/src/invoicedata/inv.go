@@ -14,10 +14,14 @@
 package main
 
 import (
+    "bufio"
+    "bytes"
     "encoding/binary"
     "errors"
     "fmt"
+    "hash/crc32"
     "io"
+    "math"
     "strconv"
     "time"
 )
@@ -28,32 +32,129 @@ const invDateFormat = "20060102" // This date must always be used.
 
 var byteOrder = binary.LittleEndian
 
+// An unknownInvCount is written in place of the number of invoices when a
+// streaming writer can't seek back to fill it in; readers then read
+// invoices until the end of the file.
+const unknownInvCount = -1
+
 func (InvMarshaler) MarshalInvoices(writer io.Writer,
     invoices []*Invoice) error {
+    invoiceWriter, err := newInvInvoiceWriter(writer, len(invoices))
+    if err != nil {
+        return err
+    }
+    return writeAllInvoices(invoiceWriter, invoices)
+}
+
+// An invInvoiceWriter that is writing to an io.Seeker writes the
+// unknownInvCount and fills in the real count when it is closed, so an
+// interrupted file is read up to where it was cut off rather than as
+// having no invoices.
+type invInvoiceWriter struct {
+    writer      *bufio.Writer
+    write       invWriterFunc
+    payload     *bytes.Buffer // The current invoice's record
+    seeker      io.Seeker
+    countOffset int64
+    count       int
+}
+
+// NewInvoiceWriter's files can only be read by versions of this program
+// that know about the unknownInvCount unless the writer is an io.Seeker
+// (e.g., an uncompressed *os.File).
+func (InvMarshaler) NewInvoiceWriter(writer io.Writer) (InvoiceWriter,
+    error) {
+    if seeker, ok := writer.(io.Seeker); ok {
+        if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+            invoiceWriter, err := newInvInvoiceWriter(writer,
+                unknownInvCount)
+            if err == nil {
+                invoiceWriter.seeker = seeker
+                invoiceWriter.countOffset = offset + 6 // magic & version
+            }
+            return invoiceWriter, err
+        }
+    }
+    return newInvInvoiceWriter(writer, unknownInvCount)
+}
+
+func newInvInvoiceWriter(writer io.Writer, count int) (*invInvoiceWriter,
+    error) {
+    bufferedWriter := bufio.NewWriter(writer)
     var write invWriterFunc = func(x interface{}) error {
-        return binary.Write(writer, byteOrder, x)
+        return binary.Write(bufferedWriter, byteOrder, x)
     }
     if err := write(uint32(magicNumber)); err != nil {
-        return err
+        return nil, err
     }
     if err := write(uint16(fileVersion)); err != nil {
+        return nil, err
+    }
+    if err := write(int32(count)); err != nil {
+        return nil, err
+    }
+    return &invInvoiceWriter{writer: bufferedWriter, write: write,
+        payload: new(bytes.Buffer)}, nil
+}
+
+// Write writes the invoice as a record: its length, the invoice itself,
+// and the CRC32 of the invoice. (Before version 104 only the invoice was
+// written.)
+func (writer *invInvoiceWriter) Write(invoice *Invoice) error {
+    writer.count++
+    writer.payload.Reset()
+    var write invWriterFunc = func(x interface{}) error {
+        return binary.Write(writer.payload, byteOrder, x)
+    }
+    if err := write.writeInvoice(invoice); err != nil {
         return err
     }
-    if err := write(int32(len(invoices))); err != nil {
+    payload := writer.payload.Bytes()
+    if err := writer.write(uint32(len(payload))); err != nil {
         return err
     }
-    for _, invoice := range invoices {
-        if err := write.writeInvoice(invoice); err != nil {
-            return err
-        }
+    if err := writer.write(payload); err != nil {
+        return err
     }
-    return nil
+    return writer.write(crc32.ChecksumIEEE(payload))
+}
+
+func (writer *invInvoiceWriter) Close() error {
+    if err := writer.writer.Flush(); err != nil {
+        return err
+    }
+    if writer.seeker == nil {
+        return nil
+    }
+    end, err := writer.seeker.Seek(0, io.SeekCurrent)
+    if err != nil {
+        return err
+    }
+    if _, err = writer.seeker.Seek(writer.countOffset, io.SeekStart);
+        err != nil {
+        return err
+    }
+    if err = writer.write(int32(writer.count)); err == nil {
+        err = writer.writer.Flush()
+    }
+    if err != nil {
+        return err
+    }
+    _, err = writer.seeker.Seek(end, io.SeekStart)
+    return err
 }
 
 type invWriterFunc func(interface{}) error
 
 func (write invWriterFunc) writeInvoice(invoice *Invoice) error {
+    if err := checkFits(invoice, "an .inv file", nil, nil); err != nil {
+        return err
+    }
     for _, i := range []int{invoice.Id, invoice.CustomerId} {
+        if i < math.MinInt32 || i > math.MaxInt32 {
+            return fmt.Errorf("invoice %d: id %d won't fit an .inv file",
+                invoice.Id, i)
+        }
         if err := write(int32(i)); err != nil {
             return err
         }
@@ -107,33 +208,137 @@ func (write invWriterFunc) writeItem(item *Item) error {
     if err := write.writeString(item.Id); err != nil {
         return err
     }
-    if err := write(item.Price); err != nil {
+    if err := write(item.Price.Amount); err != nil {
+        return err
+    }
+    if err := write.writeString(item.Price.Currency); err != nil {
         return err
     }
-    if err := write(int16(item.Quantity)); err != nil {
+    if err := write(int64(item.Quantity)); err != nil { // int16 before 104
         return err
     }
     return write.writeString(item.Note)
 }
 
-func (InvMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
-    error) {
-    if err := checkInvVersion(reader); err != nil {
+func (marshaler InvMarshaler) UnmarshalInvoices(reader io.Reader) (
+    []*Invoice, error) {
+    invoiceReader, err := marshaler.NewInvoiceReader(reader)
+    if err != nil {
         return nil, err
     }
-    count, err := readIntFromInt32(reader)
+    return readAllInvoices(invoiceReader)
+}
+
+type invInvoiceReader struct {
+    reader  *countingReader
+    version int
+    count   int // The number still to read or unknownInvCount
+}
+
+// A countingReader knows its offset so that errors can say where in the
+// file they are.
+type countingReader struct {
+    *bufio.Reader
+    offset int64
+}
+
+func (reader *countingReader) Read(data []byte) (int, error) {
+    n, err := reader.Reader.Read(data)
+    reader.offset += int64(n)
+    return n, err
+}
+
+func (InvMarshaler) NewInvoiceReader(reader io.Reader) (InvoiceReader,
+    error) {
+    countingReader := &countingReader{Reader: bufio.NewReader(reader)}
+    version, count, err := readInvHeader(countingReader)
     if err != nil {
         return nil, err
     }
-    invoices := make([]*Invoice, 0, count)
-    for i := 0; i < count; i++ {
-        invoice, err := readInvInvoice(reader)
-        if err != nil {
-            return nil, err
+    return &invInvoiceReader{countingReader, version, count}, nil
+}
+
+func readInvHeader(reader io.Reader) (version, count int, err error) {
+    if version, err = checkInvVersion(reader); err != nil {
+        return 0, 0, err
+    }
+    if count, err = readIntFromInt32(reader); err != nil {
+        return 0, 0, err
+    }
+    if count < 0 && count != unknownInvCount {
+        return 0, 0, fmt.Errorf("invalid invoice count %d", count)
+    }
+    return version, count, nil
+}
+
+func (reader *invInvoiceReader) Next() (*Invoice, error) {
+    if reader.count == 0 {
+        return nil, io.EOF
+    }
+    if reader.count == unknownInvCount {
+        if _, err := reader.reader.Peek(1); err != nil {
+            return nil, err // io.EOF at the end
+        }
+    } else {
+        reader.count--
+    }
+    offset := reader.reader.offset
+    var invoice *Invoice
+    var err error
+    if reader.version < 104 {
+        invoice, err = readInvInvoice(reader.version, reader.reader)
+    } else {
+        var payload []byte
+        if payload, err = readInvRecord(reader.reader); err == nil {
+            invoice, err = parseInvPayload(reader.version, payload)
         }
-        invoices = append(invoices, invoice)
     }
-    return invoices, nil
+    if err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return nil, fmt.Errorf("invalid invoice at offset %d: %v", offset,
+            err)
+    }
+    return invoice, nil
+}
+
+// The largest record (or string) that will be read; anything bigger must
+// be corrupt.
+const maxInvRecordLength = 1 << 24
+
+var errInvChecksum = errors.New("checksum mismatch")
+
+// readInvRecord returns a record's payload if its checksum is correct.
+func readInvRecord(reader io.Reader) ([]byte, error) {
+    var length uint32
+    if err := binary.Read(reader, byteOrder, &length); err != nil {
+        return nil, err
+    }
+    if length > maxInvRecordLength {
+        return nil, fmt.Errorf("invalid record length %d", length)
+    }
+    payload, err := readInvBytes(reader, int64(length))
+    if err != nil {
+        return nil, err
+    }
+    var checksum uint32
+    if err := binary.Read(reader, byteOrder, &checksum); err != nil {
+        return nil, err
+    }
+    if checksum != crc32.ChecksumIEEE(payload) {
+        return nil, errInvChecksum
+    }
+    return payload, nil
+}
+
+func parseInvPayload(version int, payload []byte) (*Invoice, error) {
+    reader := bytes.NewReader(payload)
+    invoice, err := readInvInvoice(version, reader)
+    if err == nil && reader.Len() > 0 {
+        err = fmt.Errorf("%d unexpected bytes in record", reader.Len())
+    }
+    return invoice, err
 }
 
 func readIntFromInt32(reader io.Reader) (int, error) {
@@ -154,25 +359,23 @@ func readBoolFromInt8(reader io.Reader) (bool, error) {
     return i8 == 1, err
 }
 
-func checkInvVersion(reader io.Reader) error {
+func checkInvVersion(reader io.Reader) (int, error) {
     var magic uint32
     if err := binary.Read(reader, byteOrder, &magic); err != nil {
-        return err
+        return 0, err
     }
     if magic != magicNumber {
-        return errors.New("cannot read non-invoices inv file")
+        return 0, errors.New("cannot read non-invoices inv file")
     }
     var version uint16
     if err := binary.Read(reader, byteOrder, &version); err != nil {
-        return err
-    }
-    if version > fileVersion {
-        return fmt.Errorf("version %d is too new to read", version)
+        return 0, err
     }
-    return nil
+    return int(version), checkVersion(int(version))
 }
 
-func readInvInvoice(reader io.Reader) (invoice *Invoice, err error) {
+func readInvInvoice(version int, reader io.Reader) (invoice *Invoice,
+    err error) {
     invoice = &Invoice{}
     for _, pId := range []*int{&invoice.Id, &invoice.CustomerId} {
         if *pId, err = readIntFromInt32(reader); err != nil {
@@ -194,14 +397,18 @@ func readInvInvoice(reader io.Reader) (invoice *Invoice, err error) {
     if count, err = readIntFromInt32(reader); err != nil {
         return nil, err
     }
-    invoice.Items, err = readInvItems(reader, count)
+    if count < 0 || count > maxInvRecordLength {
+        return nil, fmt.Errorf("invalid item count %d", count)
+    }
+    invoice.Items, err = readInvItems(version, reader, count)
     return invoice, err
 }
 
-func readInvItems(reader io.Reader, count int) ([]*Item, error) {
-    items := make([]*Item, 0, count)
+func readInvItems(version int, reader io.Reader, count int) ([]*Item,
+    error) {
+    var items []*Item
     for i := 0; i < count; i++ {
-        item, err := readInvItem(reader)
+        item, err := readInvItem(version, reader)
         if err != nil {
             return nil, err
         }
@@ -215,32 +422,71 @@ func readInvDate(reader io.Reader) (time.Time, error) {
     if err := binary.Read(reader, byteOrder, &n); err != nil {
         return time.Time{}, err
     }
-    return time.Parse(invDateFormat, fmt.Sprint(n))
+    // The int32s of years before 1000 have lost their leading zeros
+    return time.Parse(invDateFormat, fmt.Sprintf("%08d", n))
 }
 
 func readInvString(reader io.Reader) (string, error) {
     var length int32
     if err := binary.Read(reader, byteOrder, &length); err != nil {
-        return "", nil
-    }
-    raw := make([]byte, length)
-    if err := binary.Read(reader, byteOrder, &raw); err != nil {
         return "", err
     }
-    return string(raw), nil
+    if length < 0 || length > maxInvRecordLength {
+        return "", fmt.Errorf("invalid string length %d", length)
+    }
+    raw, err := readInvBytes(reader, int64(length))
+    return string(raw), err
 }
 
-func readInvItem(reader io.Reader) (item *Item, err error) {
+// readInvBytes reads length bytes. It grows its buffer as the data
+// arrives rather than allocating length bytes first, so a corrupt length
+// near the end of the data can't make it allocate up to the maximum.
+func readInvBytes(reader io.Reader, length int64) ([]byte, error) {
+    var buffer bytes.Buffer
+    if _, err := io.CopyN(&buffer, reader, length); err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return nil, err
+    }
+    return buffer.Bytes(), nil
+}
+
+func readInvItem(version int, reader io.Reader) (item *Item, err error) {
     item = &Item{}
     if item.Id, err = readInvString(reader); err != nil {
         return nil, err
     }
-    if err = binary.Read(reader, byteOrder, &item.Price); err != nil {
+    if item.Price, err = readInvPrice(version, reader); err != nil {
+        return nil, err
+    }
+    if version < 104 {
+        item.Quantity, err = readIntFromInt16(reader)
+    } else {
+        var quantity int64
+        err = binary.Read(reader, byteOrder, &quantity)
+        item.Quantity = int(quantity)
+    }
+    if err != nil {
         return nil, err
     }
-    if item.Quantity, err = readIntFromInt16(reader); err != nil {
+    if item.Note, err = readInvString(reader); err != nil {
         return nil, err
     }
-    item.Note, err = readInvString(reader)
     return item, nil
 }
+
+func readInvPrice(version int, reader io.Reader) (price Money, err error) {
+    if version < 103 {
+        var x float64
+        if err = binary.Read(reader, byteOrder, &x); err != nil {
+            return price, err
+        }
+        return MoneyFromFloat(x, defaultCurrency), nil
+    }
+    if err = binary.Read(reader, byteOrder, &price.Amount); err != nil {
+        return price, err
+    }
+    price.Currency, err = readInvString(reader)
+    return price, err
+}
@@ -17,6 +17,7 @@ import (
     "compress/gzip"
     "errors"
     "fmt"
+    "invoicing"
     "io"
     "log"
     "os"
@@ -28,8 +29,10 @@ import (
 const (
     fileType             = "INVOICES"   // Used by text formats
     magicNumber          = 0x125D       // Used by binary formats
-    fileVersion          = 100          // Used by all formats
-    dateFormat           = "2006-01-02" // This date must always be used
+    fileVersion          = 104          // Used by all formats
+    minAnsVersion        = 101          // invoicedata_ans's own versions
+    maxAnsVersion        = 102
+    dateFormat           = invoicing.DateFormat
     nanosecondsToSeconds = 1e9
 )
 
@@ -43,12 +46,8 @@ type Invoice struct {
     Items      []*Item
 }
 
-type Item struct {
-    Id       string
-    Price    float64
-    Quantity int
-    Note     string
-}
+// An Item's Price was a float64 with no currency before version 103.
+type Item = invoicing.Item
 
 type InvoicesMarshaler interface {
     MarshalInvoices(writer io.Writer, invoices []*Invoice) error
@@ -58,18 +57,59 @@ type InvoicesUnmarshaler interface {
     UnmarshalInvoices(reader io.Reader) ([]*Invoice, error)
 }
 
+// checkVersion returns an error if a file's version can't be read. Both
+// invoicedata and invoicedata_ans grew from version 100 files, so this
+// program skipped the versions that invoicedata_ans uses for its
+// different invoices rather than misread them.
+func checkVersion(version int) error {
+    if version > fileVersion {
+        return fmt.Errorf("version %d is too new to read", version)
+    }
+    if version >= minAnsVersion && version <= maxAnsVersion {
+        return fmt.Errorf("version %d is an invoicedata_ans file", version)
+    }
+    return nil
+}
+
 func main() {
     log.SetFlags(0)
     report := false
     args := os.Args[1:]
+    if len(args) > 0 && args[0] == "convert" {
+        convert(args[1:])
+        return
+    }
+    if len(args) > 0 && args[0] == "report" {
+        reportCommand(args[1:])
+        return
+    }
+    if len(args) > 0 && args[0] == "query" {
+        queryCommand(args[1:])
+        return
+    }
+    if len(args) > 0 && args[0] == "verify" {
+        verifyCommand(args[1:])
+        return
+    }
+    if len(args) > 0 && args[0] == "recover" {
+        recoverCommand(args[1:])
+        return
+    }
     if len(args) > 0 && (args[0] == "-t" || args[0] == "--time") {
         report = true
         args = args[1:]
     }
     if len(args) != 2 || args[0] == "-h" || args[0] == "--help" {
-        log.Fatalf("usage: %s [-t|--time] infile.ext outfile.ext\n"+
-            ".ext may be any of .gob, .inv, .jsn, .json, .txt, "+
-            "or .xml, optionally gzipped (e.g., .gob.gz)\n",
+        log.Fatalf("usage: %[1]s [-t|--time] infile.ext outfile.ext\n"+
+            "       %[1]s convert infile outfile.ext\n"+
+            "       %[1]s report [options] aging|revenue|unpaid|items "+
+            "infile\n"+
+            "       %[1]s query [options] query infile [outfile.ext]\n"+
+            "       %[1]s verify infile.inv\n"+
+            "       %[1]s recover infile.inv outfile.ext\n"+
+            ".ext may be any of .csv, .gob, .inv, .jsn, .json, "+
+            ".msgpack, .pb, .tsv, .txt, or .xml, optionally gzipped "+
+            "(e.g., .gob.gz)\n",
             filepath.Base(os.Args[0]))
     }
     inFilename, outFilename := args[0], args[1]
@@ -77,6 +117,14 @@ func main() {
         log.Fatalln("won't overwrite a file with itself")
     }
 
+    if !report && streamerForSuffix(suffixOf(inFilename)) != nil &&
+        streamerForSuffix(suffixOf(outFilename)) != nil {
+        if err := streamInvoiceFile(inFilename, outFilename); err != nil {
+            log.Fatalln("Failed to convert:", err)
+        }
+        return
+    }
+
     start := time.Now()
     invoices, err := readInvoiceFile(inFilename)
     if err == nil && report {
@@ -99,6 +147,118 @@ func main() {
     }
 }
 
+// convert is like main() except that the input file's format is detected
+// from its content rather than from its suffix, and the invoices are
+// validated before being written.
+func convert(args []string) {
+    if len(args) != 2 || args[0] == "-h" || args[0] == "--help" {
+        log.Fatalf("usage: %s convert infile outfile.ext\n"+
+            "infile may be in any invoices format, optionally gzipped, "+
+            "whatever its suffix\n"+
+            ".ext may be any of .csv, .gob, .inv, .jsn, .json, "+
+            ".msgpack, .pb, .tsv, .txt, or .xml, optionally gzipped "+
+            "(e.g., .gob.gz)\n",
+            filepath.Base(os.Args[0]))
+    }
+    inFilename, outFilename := args[0], args[1]
+    if inFilename == outFilename {
+        log.Fatalln("won't overwrite a file with itself")
+    }
+    inStreamer, err := detectedStreamer(inFilename)
+    if err != nil {
+        log.Fatalln("Failed to read:", err)
+    }
+    outStreamer := streamerForSuffix(suffixOf(outFilename))
+    if inStreamer == nil || outStreamer == nil {
+        invoices, err := readDetectedInvoiceFile(inFilename)
+        if err != nil {
+            log.Fatalln("Failed to read:", err)
+        }
+        checkViolations(inFilename, validateInvoices(invoices))
+        if err = writeInvoiceFile(outFilename, invoices); err != nil {
+            log.Fatalln("Failed to write:", err)
+        }
+        return
+    }
+    // Both formats stream, so rather than hold every invoice in memory
+    // the file is read twice: once to validate it and once to convert it.
+    validator := newInvoiceValidator()
+    if err = forEachDetectedInvoice(inFilename, inStreamer, func(
+        reader InvoiceReader) error {
+        for {
+            invoice, err := reader.Next()
+            if err != nil {
+                if err == io.EOF {
+                    return nil
+                }
+                return err
+            }
+            validator.check(invoice)
+        }
+    }); err != nil {
+        log.Fatalln("Failed to read:", err)
+    }
+    checkViolations(inFilename, validator.violations)
+    if err = forEachDetectedInvoice(inFilename, inStreamer, func(
+        reader InvoiceReader) error {
+        return writeInvoiceStream(reader, outFilename, outStreamer)
+    }); err != nil {
+        log.Fatalln("Failed to convert:", err)
+    }
+}
+
+func checkViolations(filename string, violations []Violation) {
+    if len(violations) > 0 {
+        for _, violation := range violations {
+            log.Printf("%s: %s\n", filename, violation)
+        }
+        log.Fatalf("Failed to validate: %d problems in %s\n",
+            len(violations), filename)
+    }
+}
+
+// detectedStreamer returns the streamer for the file's detected format,
+// or nil if the format has none.
+func detectedStreamer(filename string) (InvoicesStreamer, error) {
+    _, suffix, closer, err := openDetectedInvoiceFile(filename)
+    if closer != nil {
+        closer()
+    }
+    if err != nil {
+        return nil, err
+    }
+    return streamerForSuffix(suffix), nil
+}
+
+// forEachDetectedInvoice opens the file and passes the function a reader
+// that returns the file's invoices one at a time.
+func forEachDetectedInvoice(filename string, streamer InvoicesStreamer,
+    function func(InvoiceReader) error) error {
+    file, _, closer, err := openDetectedInvoiceFile(filename)
+    if closer != nil {
+        defer closer()
+    }
+    if err != nil {
+        return err
+    }
+    reader, err := streamer.NewInvoiceReader(file)
+    if err != nil {
+        return err
+    }
+    return function(reader)
+}
+
+func readDetectedInvoiceFile(filename string) ([]*Invoice, error) {
+    reader, suffix, closer, err := openDetectedInvoiceFile(filename)
+    if closer != nil {
+        defer closer()
+    }
+    if err != nil {
+        return nil, err
+    }
+    return readInvoices(reader, suffix)
+}
+
 func readInvoiceFile(filename string) ([]*Invoice, error) {
     file, closer, err := openInvoiceFile(filename)
     if closer != nil {
@@ -141,6 +301,14 @@ func readInvoices(reader io.Reader, suffix string) ([]*Invoice, error) {
         unmarshaler = TxtMarshaler{}
     case ".xml":
         unmarshaler = XMLMarshaler{}
+    case ".csv":
+        unmarshaler = CSVMarshaler{}
+    case ".tsv":
+        unmarshaler = CSVMarshaler{'\t'}
+    case ".pb":
+        unmarshaler = PBMarshaler{}
+    case ".msgpack":
+        unmarshaler = MsgpackMarshaler{}
     }
     if unmarshaler != nil {
         return unmarshaler.UnmarshalInvoices(reader)
@@ -189,6 +357,14 @@ func writeInvoices(writer io.Writer, suffix string,
         marshaler = TxtMarshaler{}
     case ".xml":
         marshaler = XMLMarshaler{}
+    case ".csv":
+        marshaler = CSVMarshaler{}
+    case ".tsv":
+        marshaler = CSVMarshaler{'\t'}
+    case ".pb":
+        marshaler = PBMarshaler{}
+    case ".msgpack":
+        marshaler = MsgpackMarshaler{}
     }
     if marshaler != nil {
         return marshaler.MarshalInvoices(writer, invoices)
@@ -196,6 +372,38 @@ func writeInvoices(writer io.Writer, suffix string,
     return errors.New("unrecognized output suffix")
 }
 
+// checkFits returns an error if the invoice's dates, notes, or item ids
+// won't fit the file, e.g., "a .txt file", that is being written. Every
+// format except .gob stores dates as days (.pb as the seconds at their
+// start) and can hold the UTC days of years 0 to 9999; noteFits and
+// itemIdFits, if not nil, say which notes and item ids the format can
+// hold.
+func checkFits(invoice *Invoice, file string,
+    noteFits, itemIdFits func(string) bool) error {
+    for _, date := range []time.Time{invoice.Raised, invoice.Due} {
+        if date.Location() != time.UTC || date.Year() < 0 ||
+            date.Year() > 9999 || !date.Equal(date.Truncate(24*time.Hour)) {
+            return fmt.Errorf("invoice %d: date %s won't fit %s",
+                invoice.Id, date.Format(time.RFC3339), file)
+        }
+    }
+    if noteFits != nil && !noteFits(invoice.Note) {
+        return fmt.Errorf("invoice %d: note %q won't fit %s", invoice.Id,
+            invoice.Note, file)
+    }
+    for _, item := range invoice.Items {
+        if itemIdFits != nil && !itemIdFits(item.Id) {
+            return fmt.Errorf("invoice %d: item id %q won't fit %s",
+                invoice.Id, item.Id, file)
+        }
+        if noteFits != nil && !noteFits(item.Note) {
+            return fmt.Errorf("invoice %d: item %s note %q won't fit %s",
+                invoice.Id, item.Id, item.Note, file)
+        }
+    }
+    return nil
+}
+
 func suffixOf(filename string) string {
     suffix := filepath.Ext(filename)
     if suffix == ".gz" {
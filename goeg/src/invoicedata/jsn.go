@@ -14,76 +14,97 @@
 package main
 
 import (
+    "bufio"
     "encoding/json"
     "errors"
-    "fmt"
+    "invoicing"
     "io"
-    "time"
+    "unicode/utf8"
 )
 
-type JSONInvoice struct {
-    Id         int
-    CustomerId int
-    Raised     string // time.Time in Invoice struct
-    Due        string // time.Time in Invoice struct
-    Paid       bool
-    Note       string
-    Items      []*Item
+// Invoices use the JSON encoding shared with invoicestore.
+func (invoice Invoice) MarshalJSON() ([]byte, error) {
+    return json.Marshal(invoicing.Invoice(invoice))
 }
 
-func (invoice Invoice) MarshalJSON() ([]byte, error) {
-    jsonInvoice := JSONInvoice{
-        invoice.Id,
-        invoice.CustomerId,
-        invoice.Raised.Format(dateFormat),
-        invoice.Due.Format(dateFormat),
-        invoice.Paid,
-        invoice.Note,
-        invoice.Items,
-    }
-    return json.Marshal(jsonInvoice)
+func (invoice *Invoice) UnmarshalJSON(data []byte) error {
+    return (*invoicing.Invoice)(invoice).UnmarshalJSON(data)
 }
 
-func (invoice *Invoice) UnmarshalJSON(data []byte) (err error) {
-    var jsonInvoice JSONInvoice
-    if err = json.Unmarshal(data, &jsonInvoice); err != nil {
+type JSONMarshaler struct{}
+
+func (marshaler JSONMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) error {
+    invoiceWriter, err := marshaler.NewInvoiceWriter(writer)
+    if err != nil {
         return err
     }
-    var raised, due time.Time
-    if raised, err = time.Parse(dateFormat, jsonInvoice.Raised);
-        err != nil {
+    return writeAllInvoices(invoiceWriter, invoices)
+}
+
+// A jsonInvoiceWriter writes the invoices array's brackets and commas
+// itself so that it can encode one invoice at a time.
+type jsonInvoiceWriter struct {
+    writer    *bufio.Writer
+    separator string
+}
+
+func (JSONMarshaler) NewInvoiceWriter(writer io.Writer) (InvoiceWriter,
+    error) {
+    bufferedWriter := bufio.NewWriter(writer)
+    encoder := json.NewEncoder(bufferedWriter)
+    if err := encoder.Encode(fileType); err != nil {
+        return nil, err
+    }
+    if err := encoder.Encode(fileVersion); err != nil {
+        return nil, err
+    }
+    return &jsonInvoiceWriter{bufferedWriter, "["}, nil
+}
+
+func (writer *jsonInvoiceWriter) Write(invoice *Invoice) error {
+    // The encoder would replace invalid UTF-8 with U+FFFD
+    if err := checkFits(invoice, "a .jsn file", utf8.ValidString,
+        utf8.ValidString); err != nil {
         return err
     }
-    if due, err = time.Parse(dateFormat, jsonInvoice.Due); err != nil {
+    raw, err := json.Marshal(invoice)
+    if err != nil {
         return err
     }
-    *invoice = Invoice{
-        jsonInvoice.Id,
-        jsonInvoice.CustomerId,
-        raised,
-        due,
-        jsonInvoice.Paid,
-        jsonInvoice.Note,
-        jsonInvoice.Items,
+    if _, err = writer.writer.WriteString(writer.separator); err != nil {
+        return err
     }
-    return nil
+    writer.separator = ","
+    _, err = writer.writer.Write(raw)
+    return err
 }
 
-type JSONMarshaler struct{}
-
-func (JSONMarshaler) MarshalInvoices(writer io.Writer,
-    invoices []*Invoice) error {
-    encoder := json.NewEncoder(writer)
-    if err := encoder.Encode(fileType); err != nil {
-        return err
+func (writer *jsonInvoiceWriter) Close() error {
+    end := "]\n"
+    if writer.separator == "[" { // No invoices
+        end = "[]\n"
     }
-    if err := encoder.Encode(fileVersion); err != nil {
+    if _, err := writer.writer.WriteString(end); err != nil {
         return err
     }
-    return encoder.Encode(invoices)
+    return writer.writer.Flush()
+}
+
+func (marshaler JSONMarshaler) UnmarshalInvoices(reader io.Reader) (
+    []*Invoice, error) {
+    invoiceReader, err := marshaler.NewInvoiceReader(reader)
+    if err != nil {
+        return nil, err
+    }
+    return readAllInvoices(invoiceReader)
+}
+
+type jsonInvoiceReader struct {
+    decoder *json.Decoder
 }
 
-func (JSONMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
+func (JSONMarshaler) NewInvoiceReader(reader io.Reader) (InvoiceReader,
     error) {
     decoder := json.NewDecoder(reader)
     var kind string
@@ -97,10 +118,29 @@ func (JSONMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
     if err := decoder.Decode(&version); err != nil {
         return nil, err
     }
-    if version > fileVersion {
-        return nil, fmt.Errorf("version %d is too new to read", version)
+    if err := checkVersion(version); err != nil {
+        return nil, err
+    }
+    token, err := decoder.Token()
+    if err != nil {
+        return nil, err
+    }
+    if token == nil { // An empty slice used to be written as null
+        return &jsonInvoiceReader{}, nil
+    }
+    if token != json.Delim('[') {
+        return nil, errors.New("invalid json file: expected invoices")
+    }
+    return &jsonInvoiceReader{decoder}, nil
+}
+
+func (reader *jsonInvoiceReader) Next() (*Invoice, error) {
+    if reader.decoder == nil || !reader.decoder.More() {
+        return nil, io.EOF
+    }
+    var invoice Invoice
+    if err := reader.decoder.Decode(&invoice); err != nil {
+        return nil, err
     }
-    var invoices []*Invoice
-    err := decoder.Decode(&invoices)
-    return invoices, err
+    return &invoice, nil
 }
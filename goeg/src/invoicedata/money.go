@@ -0,0 +1,50 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "fmt"
+    "invoicing"
+)
+
+// Money is shared with invoicestore so that both programs read and write
+// prices identically.
+type Money = invoicing.Money
+
+const defaultCurrency = invoicing.DefaultCurrency
+
+var (
+    MoneyFromFloat     = invoicing.MoneyFromFloat
+    ParseMoney         = invoicing.ParseMoney
+    minorUnitsPerMajor = invoicing.MinorUnitsPerMajor
+)
+
+// Total returns the exact sum of the invoice's item prices times their
+// quantities; all the items must be in the same currency.
+func (invoice *Invoice) Total() (total Money, err error) {
+    total.Currency = defaultCurrency
+    for i, item := range invoice.Items {
+        if i == 0 {
+            total.Currency = item.Price.Currency
+        }
+        var amount Money
+        if amount, err = item.Price.Times(item.Quantity); err == nil {
+            total, err = total.Plus(amount)
+        }
+        if err != nil {
+            return total, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+        }
+    }
+    return total, nil
+}
@@ -0,0 +1,83 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "log"
+    "math"
+    "path/filepath"
+    "testing"
+)
+
+// item returns a test item whose price is given as text, e.g., "1.50 USD".
+func item(id, price string, quantity int, note string) *Item {
+    money, err := ParseMoney(price)
+    if err != nil {
+        panic(err)
+    }
+    return &Item{Id: id, Price: money, Quantity: quantity, Note: note}
+}
+
+func TestInvoiceTotal(t *testing.T) {
+    log.SetFlags(0)
+    log.Println("TEST invoicedata money")
+
+    invoice := &Invoice{Items: []*Item{item("A", "0.10 USD", 3, ""),
+        item("B", "0.20 USD", 7, "")}}
+    if total, err := invoice.Total(); err != nil ||
+        total.String() != "1.70 USD" {
+        t.Errorf("total = %v %v", total, err)
+    }
+    invoice.Items[1].Price.Currency = "EUR"
+    if _, err := invoice.Total(); err == nil {
+        t.Error("totalled different currencies")
+    }
+    invoice.Items[1].Price.Currency = "USD"
+    invoice.Items[1].Quantity = math.MaxInt64 / 10 // Times 20 overflows
+    if total, err := invoice.Total(); err == nil {
+        t.Errorf("expected an overflow got %v", total)
+    }
+}
+
+// The testdata files were written by the last version that stored
+// prices as float64s.
+func TestReadFloatPriceFiles(t *testing.T) {
+    expected := []string{"400.89 USD", "0.10 USD", "105.90 USD"}
+    filenames, err := filepath.Glob(filepath.Join("testdata",
+        "invoices100.*"))
+    if err != nil || len(filenames) == 0 {
+        t.Fatal("missing test data", err)
+    }
+    for _, filename := range filenames {
+        data, err := ioutil.ReadFile(filename)
+        if err != nil {
+            t.Fatal(err)
+        }
+        invoices, err := readInvoices(bytes.NewReader(data),
+            suffixOf(filename))
+        if err != nil {
+            t.Fatalf("%s: %v", filename, err)
+        }
+        if len(invoices) != 2 || len(invoices[0].Items) != len(expected) {
+            t.Fatalf("%s: wrong invoices", filename)
+        }
+        for i, item := range invoices[0].Items {
+            if item.Price.String() != expected[i] {
+                t.Errorf("%s: %v != %v", filename, item.Price, expected[i])
+            }
+        }
+    }
+}
@@ -0,0 +1,449 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "time"
+)
+
+// The .msgpack format has the same structure as the .jsn format: the
+// fileType string, the fileVersion integer, and then an array of
+// invoices, each of them a map keyed by the Invoice field names (with
+// dates as strings in dateFormat) and whose Items are maps keyed by the
+// Item field names, except that each Price is given by an integer Amount
+// of minor units and a Currency string. (Before version 103 it was a
+// float64 Price.)
+
+type MsgpackMarshaler struct{}
+
+func (MsgpackMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) error {
+    var data []byte
+    data = appendMsgpackString(data, fileType)
+    data = appendMsgpackInt(data, fileVersion)
+    data = appendMsgpackHeader(data, 0x90, 0xDC, len(invoices))
+    if _, err := writer.Write(data); err != nil {
+        return err
+    }
+    for _, invoice := range invoices {
+        if err := checkFits(invoice, "a .msgpack file", nil, nil); err != nil {
+            return err
+        }
+        if _, err := writer.Write(msgpackInvoice(invoice)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func msgpackInvoice(invoice *Invoice) []byte {
+    data := appendMsgpackHeader(nil, 0x80, 0xDE, 7)
+    data = appendMsgpackString(data, "Id")
+    data = appendMsgpackInt(data, int64(invoice.Id))
+    data = appendMsgpackString(data, "CustomerId")
+    data = appendMsgpackInt(data, int64(invoice.CustomerId))
+    data = appendMsgpackString(data, "Raised")
+    data = appendMsgpackString(data, invoice.Raised.Format(dateFormat))
+    data = appendMsgpackString(data, "Due")
+    data = appendMsgpackString(data, invoice.Due.Format(dateFormat))
+    data = appendMsgpackString(data, "Paid")
+    data = appendMsgpackBool(data, invoice.Paid)
+    data = appendMsgpackString(data, "Note")
+    data = appendMsgpackString(data, invoice.Note)
+    data = appendMsgpackString(data, "Items")
+    data = appendMsgpackHeader(data, 0x90, 0xDC, len(invoice.Items))
+    for _, item := range invoice.Items {
+        data = appendMsgpackHeader(data, 0x80, 0xDE, 5)
+        data = appendMsgpackString(data, "Id")
+        data = appendMsgpackString(data, item.Id)
+        data = appendMsgpackString(data, "Amount")
+        data = appendMsgpackInt(data, item.Price.Amount)
+        data = appendMsgpackString(data, "Currency")
+        data = appendMsgpackString(data, item.Price.Currency)
+        data = appendMsgpackString(data, "Quantity")
+        data = appendMsgpackInt(data, int64(item.Quantity))
+        data = appendMsgpackString(data, "Note")
+        data = appendMsgpackString(data, item.Note)
+    }
+    return data
+}
+
+// appendMsgpackHeader appends an array (0x90, 0xDC) or map (0x80, 0xDE)
+// header using the fix form for small lengths.
+func appendMsgpackHeader(data []byte, fix, code byte, length int) []byte {
+    switch {
+    case length < 16:
+        return append(data, fix|byte(length))
+    case length <= math.MaxUint16:
+        return binary.BigEndian.AppendUint16(append(data, code),
+            uint16(length))
+    }
+    return binary.BigEndian.AppendUint32(append(data, code+1),
+        uint32(length))
+}
+
+func appendMsgpackString(data []byte, s string) []byte {
+    switch length := len(s); {
+    case length < 32:
+        data = append(data, 0xA0|byte(length))
+    case length <= math.MaxUint8:
+        data = append(data, 0xD9, byte(length))
+    case length <= math.MaxUint16:
+        data = binary.BigEndian.AppendUint16(append(data, 0xDA),
+            uint16(length))
+    default:
+        data = binary.BigEndian.AppendUint32(append(data, 0xDB),
+            uint32(length))
+    }
+    return append(data, s...)
+}
+
+func appendMsgpackInt(data []byte, i int64) []byte {
+    switch {
+    case i >= 0 && i < 128:
+        return append(data, byte(i))
+    case i < 0 && i >= -32:
+        return append(data, byte(i))
+    case i >= math.MinInt16 && i <= math.MaxInt16:
+        return binary.BigEndian.AppendUint16(append(data, 0xD1),
+            uint16(i))
+    case i >= math.MinInt32 && i <= math.MaxInt32:
+        return binary.BigEndian.AppendUint32(append(data, 0xD2),
+            uint32(i))
+    }
+    return binary.BigEndian.AppendUint64(append(data, 0xD3), uint64(i))
+}
+
+func appendMsgpackBool(data []byte, b bool) []byte {
+    if b {
+        return append(data, 0xC3)
+    }
+    return append(data, 0xC2)
+}
+
+func (MsgpackMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
+    error) {
+    decoder := msgpackDecoder{bufio.NewReader(reader)}
+    if kind, err := decoder.readString(); err != nil || kind != fileType {
+        return nil, errors.New("cannot read non-invoices msgpack file")
+    }
+    version, err := decoder.readInt()
+    if err != nil {
+        return nil, err
+    }
+    if err := checkVersion(version); err != nil {
+        return nil, err
+    }
+    count, err := decoder.readArrayLength()
+    if err != nil {
+        return nil, err
+    }
+    invoices := make([]*Invoice, 0, minInt(count, 1024))
+    for i := 0; i < count; i++ {
+        invoice, err := decoder.readInvoice()
+        if err != nil {
+            return nil, err
+        }
+        invoices = append(invoices, invoice)
+    }
+    return invoices, nil
+}
+
+// The number of bytes following each of the uint and int type codes.
+var msgpackIntSizes = map[byte]int{0xCC: 1, 0xCD: 2, 0xCE: 4, 0xCF: 8,
+    0xD0: 1, 0xD1: 2, 0xD2: 4, 0xD3: 8}
+
+type msgpackDecoder struct {
+    *bufio.Reader
+}
+
+func (decoder msgpackDecoder) readInvoice() (*Invoice, error) {
+    invoice := &Invoice{}
+    err := decoder.readMap(func(key string) (err error) {
+        switch key {
+        case "Id":
+            invoice.Id, err = decoder.readInt()
+        case "CustomerId":
+            invoice.CustomerId, err = decoder.readInt()
+        case "Raised":
+            invoice.Raised, err = decoder.readDate()
+        case "Due":
+            invoice.Due, err = decoder.readDate()
+        case "Paid":
+            invoice.Paid, err = decoder.readBool()
+        case "Note":
+            invoice.Note, err = decoder.readString()
+        case "Items":
+            invoice.Items, err = decoder.readItems()
+        default:
+            err = decoder.skip()
+        }
+        return err
+    })
+    return invoice, err
+}
+
+func (decoder msgpackDecoder) readItems() ([]*Item, error) {
+    count, err := decoder.readArrayLength()
+    if err != nil {
+        return nil, err
+    }
+    items := make([]*Item, 0, minInt(count, 1024))
+    for i := 0; i < count; i++ {
+        item := &Item{}
+        price := math.NaN() // Only old files have a float64 Price
+        item.Price.Currency = defaultCurrency
+        err := decoder.readMap(func(key string) (err error) {
+            switch key {
+            case "Id":
+                item.Id, err = decoder.readString()
+            case "Price":
+                price, err = decoder.readFloat()
+            case "Amount":
+                var amount int
+                amount, err = decoder.readInt()
+                item.Price.Amount = int64(amount)
+            case "Currency":
+                item.Price.Currency, err = decoder.readString()
+            case "Quantity":
+                item.Quantity, err = decoder.readInt()
+            case "Note":
+                item.Note, err = decoder.readString()
+            default:
+                err = decoder.skip()
+            }
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        if !math.IsNaN(price) {
+            item.Price = MoneyFromFloat(price, item.Price.Currency)
+        }
+        items = append(items, item)
+    }
+    return items, nil
+}
+
+// readMap calls readValue() with each of the map's keys; it must read
+// (or skip) the key's value.
+func (decoder msgpackDecoder) readMap(
+    readValue func(key string) error) error {
+    code, err := decoder.ReadByte()
+    if err != nil {
+        return err
+    }
+    var count int
+    switch {
+    case code&0xF0 == 0x80:
+        count = int(code & 0x0F)
+    case code == 0xDE:
+        count, err = decoder.readLength(2)
+    case code == 0xDF:
+        count, err = decoder.readLength(4)
+    default:
+        err = fmt.Errorf("expected msgpack map, got 0x%02X", code)
+    }
+    for i := 0; i < count && err == nil; i++ {
+        var key string
+        if key, err = decoder.readString(); err == nil {
+            err = readValue(key)
+        }
+    }
+    return err
+}
+
+func (decoder msgpackDecoder) readArrayLength() (int, error) {
+    code, err := decoder.ReadByte()
+    if err != nil {
+        return 0, err
+    }
+    switch {
+    case code&0xF0 == 0x90:
+        return int(code & 0x0F), nil
+    case code == 0xDC:
+        return decoder.readLength(2)
+    case code == 0xDD:
+        return decoder.readLength(4)
+    }
+    return 0, fmt.Errorf("expected msgpack array, got 0x%02X", code)
+}
+
+func (decoder msgpackDecoder) readString() (string, error) {
+    code, err := decoder.ReadByte()
+    if err != nil {
+        return "", err
+    }
+    var length int
+    switch {
+    case code&0xE0 == 0xA0:
+        length = int(code & 0x1F)
+    case code == 0xD9:
+        length, err = decoder.readLength(1)
+    case code == 0xDA:
+        length, err = decoder.readLength(2)
+    case code == 0xDB:
+        length, err = decoder.readLength(4)
+    default:
+        err = fmt.Errorf("expected msgpack string, got 0x%02X", code)
+    }
+    if err != nil {
+        return "", err
+    }
+    raw, err := decoder.readRaw(length)
+    return string(raw), err
+}
+
+func (decoder msgpackDecoder) readDate() (time.Time, error) {
+    s, err := decoder.readString()
+    if err != nil {
+        return time.Time{}, err
+    }
+    return time.Parse(dateFormat, s)
+}
+
+func (decoder msgpackDecoder) readBool() (bool, error) {
+    code, err := decoder.ReadByte()
+    if err != nil {
+        return false, err
+    }
+    if code != 0xC2 && code != 0xC3 {
+        return false, fmt.Errorf("expected msgpack bool, got 0x%02X", code)
+    }
+    return code == 0xC3, nil
+}
+
+// readInt accepts any of msgpack's integer encodings since other
+// encoders may pick a different (but equally valid) one than ours.
+func (decoder msgpackDecoder) readInt() (int, error) {
+    code, err := decoder.ReadByte()
+    if err != nil {
+        return 0, err
+    }
+    if code < 0x80 || code >= 0xE0 {
+        return int(int8(code)), nil
+    }
+    size, found := msgpackIntSizes[code]
+    if !found {
+        return 0, fmt.Errorf("expected msgpack integer, got 0x%02X", code)
+    }
+    raw, err := decoder.readRaw(size)
+    if err != nil {
+        return 0, err
+    }
+    var u uint64
+    for _, b := range raw {
+        u = u<<8 | uint64(b)
+    }
+    if code >= 0xD0 { // Signed so sign extend
+        shift := uint(64 - 8*size)
+        return int(int64(u<<shift) >> shift), nil
+    }
+    return int(u), nil
+}
+
+func (decoder msgpackDecoder) readFloat() (float64, error) {
+    code, err := decoder.ReadByte()
+    if err != nil {
+        return 0, err
+    }
+    switch code {
+    case 0xCA:
+        raw, err := decoder.readRaw(4)
+        if err != nil {
+            return 0, err
+        }
+        return float64(math.Float32frombits(
+            binary.BigEndian.Uint32(raw))), nil
+    case 0xCB:
+        raw, err := decoder.readRaw(8)
+        if err != nil {
+            return 0, err
+        }
+        return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+    }
+    if err = decoder.UnreadByte(); err != nil {
+        return 0, err
+    }
+    i, err := decoder.readInt() // Whole prices may be sent as integers
+    return float64(i), err
+}
+
+func (decoder msgpackDecoder) readLength(size int) (int, error) {
+    raw, err := decoder.readRaw(size)
+    if err != nil {
+        return 0, err
+    }
+    length := 0
+    for _, b := range raw {
+        length = length<<8 | int(b)
+    }
+    return length, nil
+}
+
+func (decoder msgpackDecoder) readRaw(size int) ([]byte, error) {
+    raw := make([]byte, 0, minInt(size, 4096))
+    for len(raw) < size { // Don't trust size for the allocation
+        chunk := make([]byte, minInt(size-len(raw), 4096))
+        if _, err := io.ReadFull(decoder, chunk); err != nil {
+            return nil, err
+        }
+        raw = append(raw, chunk...)
+    }
+    return raw, nil
+}
+
+// skip reads past a value of a type that isn't expected, e.g., from a
+// newer writer; only the types our own format uses are supported.
+func (decoder msgpackDecoder) skip() error {
+    code, err := decoder.Peek(1)
+    if err != nil {
+        return err
+    }
+    switch {
+    case code[0] == 0xC0: // nil
+        _, err = decoder.ReadByte()
+    case code[0] == 0xC2 || code[0] == 0xC3:
+        _, err = decoder.readBool()
+    case code[0] == 0xCA || code[0] == 0xCB:
+        _, err = decoder.readFloat()
+    case code[0]&0xE0 == 0xA0 || (code[0] >= 0xD9 && code[0] <= 0xDB):
+        _, err = decoder.readString()
+    case code[0]&0xF0 == 0x90 || code[0] == 0xDC || code[0] == 0xDD:
+        var count int
+        if count, err = decoder.readArrayLength(); err == nil {
+            for i := 0; i < count && err == nil; i++ {
+                err = decoder.skip()
+            }
+        }
+    case code[0]&0xF0 == 0x80 || code[0] == 0xDE || code[0] == 0xDF:
+        err = decoder.readMap(func(string) error { return decoder.skip() })
+    default:
+        _, err = decoder.readInt()
+    }
+    return err
+}
+
+func minInt(a, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
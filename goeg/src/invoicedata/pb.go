@@ -0,0 +1,290 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "math"
+    "time"
+)
+
+// The .pb format is the protocol buffers wire format for this schema, so
+// non-Go programs can read and write it with their own generated code:
+//
+//  syntax = "proto3";
+//  message Invoices {
+//      uint32 magic = 1;               // Always 0x125D
+//      uint32 version = 2;             // fileVersion
+//      repeated Invoice invoices = 3;
+//  }
+//  message Invoice {
+//      int64 id = 1;
+//      int64 customer_id = 2;
+//      int64 raised = 3;               // Seconds since the Unix epoch
+//      int64 due = 4;                  // Seconds since the Unix epoch
+//      bool paid = 5;
+//      string note = 6;
+//      repeated Item items = 7;
+//  }
+//  message Item {
+//      string id = 1;
+//      double price = 2;               // Only used before version 103
+//      int64 quantity = 3;
+//      string note = 4;
+//      int64 amount = 5;               // In minor units
+//      string currency = 6;
+//  }
+//
+// The encoding is done by hand since it only needs a few wire types.
+
+const (
+    pbVarint  = 0
+    pbFixed64 = 1
+    pbBytes   = 2
+    pbFixed32 = 5
+)
+
+type PBMarshaler struct{}
+
+func (PBMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) error {
+    var data []byte
+    data = appendPBVarint(data, 1, magicNumber)
+    data = appendPBVarint(data, 2, fileVersion)
+    if _, err := writer.Write(data); err != nil {
+        return err
+    }
+    for _, invoice := range invoices { // Repeated fields can be streamed
+        if err := checkFits(invoice, "a .pb file", nil, nil); err != nil {
+            return err
+        }
+        data = appendPBBytes(data[:0], 3, pbInvoice(invoice))
+        if _, err := writer.Write(data); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func pbInvoice(invoice *Invoice) []byte {
+    var data []byte
+    data = appendPBVarint(data, 1, uint64(invoice.Id))
+    data = appendPBVarint(data, 2, uint64(invoice.CustomerId))
+    data = appendPBVarint(data, 3, uint64(invoice.Raised.Unix()))
+    data = appendPBVarint(data, 4, uint64(invoice.Due.Unix()))
+    if invoice.Paid {
+        data = appendPBVarint(data, 5, 1)
+    }
+    data = appendPBBytes(data, 6, []byte(invoice.Note))
+    for _, item := range invoice.Items {
+        data = appendPBBytes(data, 7, pbItem(item))
+    }
+    return data
+}
+
+func pbItem(item *Item) []byte {
+    var data []byte
+    data = appendPBBytes(data, 1, []byte(item.Id))
+    data = appendPBVarint(data, 3, uint64(item.Quantity))
+    data = appendPBBytes(data, 4, []byte(item.Note))
+    data = appendPBVarint(data, 5, uint64(item.Price.Amount))
+    return appendPBBytes(data, 6, []byte(item.Price.Currency))
+}
+
+func appendPBKey(data []byte, field, wireType int) []byte {
+    return binary.AppendUvarint(data, uint64(field<<3|wireType))
+}
+
+// appendPBVarint is used for every integer and bool field; negative
+// int64s are sign extended to ten bytes as protocol buffers require.
+func appendPBVarint(data []byte, field int, x uint64) []byte {
+    return binary.AppendUvarint(appendPBKey(data, field, pbVarint), x)
+}
+
+func appendPBBytes(data []byte, field int, raw []byte) []byte {
+    data = binary.AppendUvarint(appendPBKey(data, field, pbBytes),
+        uint64(len(raw)))
+    return append(data, raw...)
+}
+
+func (PBMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
+    error) {
+    data, err := ioutil.ReadAll(reader) // Messages aren't self-delimiting
+    if err != nil {
+        return nil, err
+    }
+    var magic, version uint64
+    var invoices []*Invoice
+    err = parsePBMessage(data, pbInvoicesWireTypes, func(field int,
+        value pbValue) error {
+        switch field {
+        case 1:
+            magic = value.x
+        case 2:
+            version = value.x
+        case 3:
+            invoice, err := parsePBInvoice(value.raw)
+            if err != nil {
+                return err
+            }
+            invoices = append(invoices, invoice)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    if magic != magicNumber {
+        return nil, errors.New("cannot read non-invoices pb file")
+    }
+    if version > fileVersion {
+        return nil, fmt.Errorf("version %d is too new to read", version)
+    }
+    if err := checkVersion(int(version)); err != nil {
+        return nil, err
+    }
+    return invoices, nil
+}
+
+func parsePBInvoice(data []byte) (*Invoice, error) {
+    invoice := &Invoice{Raised: time.Unix(0, 0).UTC(),
+        Due: time.Unix(0, 0).UTC()}
+    err := parsePBMessage(data, pbInvoiceWireTypes, func(field int,
+        value pbValue) error {
+        switch field {
+        case 1:
+            invoice.Id = int(int64(value.x))
+        case 2:
+            invoice.CustomerId = int(int64(value.x))
+        case 3:
+            invoice.Raised = time.Unix(int64(value.x), 0).UTC()
+        case 4:
+            invoice.Due = time.Unix(int64(value.x), 0).UTC()
+        case 5:
+            invoice.Paid = value.x != 0
+        case 6:
+            invoice.Note = string(value.raw)
+        case 7:
+            item, err := parsePBItem(value.raw)
+            if err != nil {
+                return err
+            }
+            invoice.Items = append(invoice.Items, item)
+        }
+        return nil
+    })
+    return invoice, err
+}
+
+func parsePBItem(data []byte) (*Item, error) {
+    item := &Item{Price: Money{Currency: defaultCurrency}}
+    price := math.NaN() // Only old files have a double price
+    err := parsePBMessage(data, pbItemWireTypes, func(field int,
+        value pbValue) error {
+        switch field {
+        case 1:
+            item.Id = string(value.raw)
+        case 2:
+            price = math.Float64frombits(value.x)
+        case 3:
+            item.Quantity = int(int64(value.x))
+        case 4:
+            item.Note = string(value.raw)
+        case 5:
+            item.Price.Amount = int64(value.x)
+        case 6:
+            item.Price.Currency = string(value.raw)
+        }
+        return nil
+    })
+    if !math.IsNaN(price) {
+        item.Price = MoneyFromFloat(price, item.Price.Currency)
+    }
+    return item, err
+}
+
+// The wire types of each message's fields, keyed by field number.
+var (
+    pbInvoicesWireTypes = map[int]int{1: pbVarint, 2: pbVarint, 3: pbBytes}
+    pbInvoiceWireTypes  = map[int]int{1: pbVarint, 2: pbVarint,
+        3: pbVarint, 4: pbVarint, 5: pbVarint, 6: pbBytes, 7: pbBytes}
+    pbItemWireTypes = map[int]int{1: pbBytes, 2: pbFixed64, 3: pbVarint,
+        4: pbBytes, 5: pbVarint, 6: pbBytes}
+)
+
+// A pbValue holds a field's value: x for the numeric wire types and raw
+// for length-delimited ones.
+type pbValue struct {
+    x   uint64
+    raw []byte
+}
+
+// parsePBMessage calls visit() for every field in the message in the
+// order they occur; fields the caller doesn't know about are ignored
+// as protocol buffers require, but a known field with the wrong wire
+// type is an error.
+func parsePBMessage(data []byte, wireTypes map[int]int,
+    visit func(field int, value pbValue) error) error {
+    for len(data) > 0 {
+        key, n := binary.Uvarint(data)
+        if n <= 0 {
+            return errors.New("invalid pb field key")
+        }
+        data = data[n:]
+        field := int(key >> 3)
+        if wireType, known := wireTypes[field];
+            known && uint64(wireType) != key&7 {
+            return fmt.Errorf("pb field %d has wire type %d not %d", field,
+                key&7, wireType)
+        }
+        var value pbValue
+        switch key & 7 {
+        case pbVarint:
+            if value.x, n = binary.Uvarint(data); n <= 0 {
+                return fmt.Errorf("invalid pb varint field %d", field)
+            }
+            data = data[n:]
+        case pbFixed64:
+            if len(data) < 8 {
+                return fmt.Errorf("truncated pb field %d", field)
+            }
+            value.x = binary.LittleEndian.Uint64(data)
+            data = data[8:]
+        case pbFixed32:
+            if len(data) < 4 {
+                return fmt.Errorf("truncated pb field %d", field)
+            }
+            value.x = uint64(binary.LittleEndian.Uint32(data))
+            data = data[4:]
+        case pbBytes:
+            length, n := binary.Uvarint(data)
+            if n <= 0 || length > uint64(len(data)-n) {
+                return fmt.Errorf("truncated pb field %d", field)
+            }
+            value.raw = data[n : n+int(length)]
+            data = data[n+int(length):]
+        default:
+            return fmt.Errorf("unsupported pb wire type %d field %d",
+                key&7, field)
+        }
+        if err := visit(field, value); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,609 @@
+// Copyright © 2011-12 Qtrac Ltd.
+//
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "math/big"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// A query is a boolean expression of comparisons, e.g.,
+//
+//  customer=123 and not paid and due<2026-01-01 and item.price>100
+//
+// Comparisons are field op value where op is one of = != < <= > >= or ~
+// (a case-insensitive substring match for text fields); a bool field on
+// its own is true if the field is. Comparisons may be combined with and,
+// or, not, and parentheses. An item.* comparison is true if it is true
+// for any of the invoice's items. Text values containing spaces or
+// operators must be double-quoted; dates are YYYY-MM-DD; amounts of
+// money are compared exactly in the currency's major units.
+
+type queryKind int
+
+const (
+    queryNumber queryKind = iota
+    queryDate
+    queryText
+    queryBool
+)
+
+// A queryField's getter returns the field's value as an int, Money,
+// time.Time, string, or bool, or nil if it has none (e.g., the total of
+// an invoice with items in more than one currency). Exactly one of the
+// getters is non-nil.
+type queryField struct {
+    kind    queryKind
+    invoice func(*Invoice) interface{}
+    item    func(*Item) interface{}
+}
+
+var queryFields = map[string]queryField{
+    "id": {kind: queryNumber, invoice: func(invoice *Invoice) interface{} {
+        return invoice.Id
+    }},
+    "customer": {kind: queryNumber,
+        invoice: func(invoice *Invoice) interface{} {
+            return invoice.CustomerId
+        }},
+    "raised": {kind: queryDate, invoice: func(invoice *Invoice) interface{} {
+        return invoice.Raised
+    }},
+    "due": {kind: queryDate, invoice: func(invoice *Invoice) interface{} {
+        return invoice.Due
+    }},
+    "paid": {kind: queryBool, invoice: func(invoice *Invoice) interface{} {
+        return invoice.Paid
+    }},
+    "note": {kind: queryText, invoice: func(invoice *Invoice) interface{} {
+        return invoice.Note
+    }},
+    "items": {kind: queryNumber, invoice: func(invoice *Invoice) interface{} {
+        return len(invoice.Items)
+    }},
+    "total": {kind: queryNumber, invoice: func(invoice *Invoice) interface{} {
+        if total, err := invoice.Total(); err == nil {
+            return total
+        }
+        return nil
+    }},
+    "currency": {kind: queryText,
+        invoice: func(invoice *Invoice) interface{} {
+            if total, err := invoice.Total(); err == nil {
+                return total.Currency
+            }
+            return nil
+        }},
+    "item.id": {kind: queryText, item: func(item *Item) interface{} {
+        return item.Id
+    }},
+    "item.price": {kind: queryNumber, item: func(item *Item) interface{} {
+        return item.Price
+    }},
+    "item.currency": {kind: queryText, item: func(item *Item) interface{} {
+        return item.Price.Currency
+    }},
+    "item.quantity": {kind: queryNumber, item: func(item *Item) interface{} {
+        return item.Quantity
+    }},
+    "item.note": {kind: queryText, item: func(item *Item) interface{} {
+        return item.Note
+    }},
+}
+
+const defaultQuerySelection = "id,customer,raised,due,paid,total,currency"
+
+func queryCommand(args []string) {
+    commandLine := flag.NewFlagSet("query", flag.ExitOnError)
+    selection := commandLine.String("select", defaultQuerySelection,
+        "comma-separated fields to show; item.* fields give a row per item")
+    sortFields := commandLine.String("sort", "",
+        "comma-separated fields to sort by; prefix a field with - to "+
+            "sort it descending")
+    format := commandLine.String("format", "text",
+        "text, csv, or json (ignored if there is an outfile)")
+    commandLine.Usage = func() {
+        log.Printf("usage: %s query [options] query infile [outfile.ext]\n"+
+            "e.g.: %s query 'customer=123 and not paid and "+
+            "item.price>100' invoices.gob\n"+
+            "infile may be in any invoices format, optionally gzipped; "+
+            "if outfile is given\nthe matching invoices are written to it "+
+            "in its format rather than shown\nfields: %s\noptions:\n",
+            filepath.Base(os.Args[0]), filepath.Base(os.Args[0]),
+            strings.Join(queryFieldNames(), " "))
+        commandLine.PrintDefaults()
+    }
+    commandLine.Parse(args)
+    if commandLine.NArg() != 2 && commandLine.NArg() != 3 {
+        commandLine.Usage()
+        os.Exit(2)
+    }
+    match, err := compileQuery(commandLine.Arg(0))
+    if err != nil {
+        log.Fatalln(err)
+    }
+    keys, err := parseQuerySortKeys(*sortFields)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    invoices, err := readDetectedInvoiceFile(commandLine.Arg(1))
+    if err != nil {
+        log.Fatalln("Failed to read:", err)
+    }
+    invoices = queryInvoices(invoices, match, keys)
+    if commandLine.NArg() == 3 {
+        if err := writeInvoiceFile(commandLine.Arg(2), invoices);
+            err != nil {
+            log.Fatalln("Failed to write:", err)
+        }
+        return
+    }
+    table, err := projectInvoices(invoices, *selection)
+    if err == nil {
+        err = writeTable(os.Stdout, *format, table)
+    }
+    if err != nil {
+        log.Fatalln(err)
+    }
+}
+
+func queryFieldNames() []string {
+    names := make([]string, 0, len(queryFields))
+    for name := range queryFields {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// queryInvoices returns the invoices that match in the order given by
+// the sort keys (or in their original order if there are none).
+func queryInvoices(invoices []*Invoice, match func(*Invoice) bool,
+    keys []querySortKey) []*Invoice {
+    var matched []*Invoice
+    for _, invoice := range invoices {
+        if match(invoice) {
+            matched = append(matched, invoice)
+        }
+    }
+    sort.SliceStable(matched, func(i, j int) bool {
+        for _, key := range keys {
+            c := compareQueryValues(key.field.invoice(matched[i]),
+                key.field.invoice(matched[j]))
+            if key.descending {
+                c = -c
+            }
+            if c != 0 {
+                return c < 0
+            }
+        }
+        return false
+    })
+    return matched
+}
+
+type querySortKey struct {
+    field      queryField
+    descending bool
+}
+
+func parseQuerySortKeys(text string) ([]querySortKey, error) {
+    var keys []querySortKey
+    for _, name := range splitQueryFieldNames(text) {
+        var key querySortKey
+        if strings.HasPrefix(name, "-") {
+            name, key.descending = name[1:], true
+        }
+        var found bool
+        if key.field, found = queryFields[name]; !found {
+            return nil, fmt.Errorf("cannot sort by unknown field %q", name)
+        }
+        if key.field.invoice == nil {
+            return nil, fmt.Errorf("cannot sort invoices by %s", name)
+        }
+        keys = append(keys, key)
+    }
+    return keys, nil
+}
+
+func splitQueryFieldNames(text string) []string {
+    var names []string
+    for _, name := range strings.Split(text, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            names = append(names, name)
+        }
+    }
+    return names
+}
+
+// projectInvoices returns a table of the selected fields with a row per
+// invoice, or a row per item if any item.* fields are selected.
+func projectInvoices(invoices []*Invoice, selection string) (*Table,
+    error) {
+    names := splitQueryFieldNames(selection)
+    if len(names) == 0 {
+        return nil, errors.New("no fields selected")
+    }
+    perItem := false
+    for _, name := range names {
+        field, found := queryFields[name]
+        if !found {
+            return nil, fmt.Errorf("cannot select unknown field %q", name)
+        }
+        perItem = perItem || field.item != nil
+    }
+    table := &Table{Title: fmt.Sprintf("%d matching invoices",
+        len(invoices)), Header: names}
+    for _, invoice := range invoices {
+        items := []*Item{nil}
+        if perItem && len(invoice.Items) > 0 {
+            items = invoice.Items
+        }
+        for _, item := range items {
+            cells := make([]interface{}, 0, len(names))
+            for _, name := range names {
+                field := queryFields[name]
+                var value interface{}
+                if field.invoice != nil {
+                    value = field.invoice(invoice)
+                } else if item != nil {
+                    value = field.item(item)
+                }
+                cells = append(cells, queryCell(value))
+            }
+            table.addRow(cells...)
+        }
+    }
+    return table, nil
+}
+
+func queryCell(value interface{}) interface{} {
+    switch value := value.(type) {
+    case nil:
+        return ""
+    case Money:
+        return value.Decimal()
+    case time.Time:
+        return value.Format(dateFormat)
+    }
+    return value
+}
+
+// compareQueryValues returns -1, 0, or 1; a missing value is less than
+// any other.
+func compareQueryValues(a, b interface{}) int {
+    a, b = queryKey(a), queryKey(b)
+    switch {
+    case a == nil && b == nil:
+        return 0
+    case a == nil:
+        return -1
+    case b == nil:
+        return 1
+    }
+    switch a := a.(type) {
+    case *big.Rat:
+        return a.Cmp(b.(*big.Rat))
+    case time.Time:
+        if a.Before(b.(time.Time)) {
+            return -1
+        } else if a.After(b.(time.Time)) {
+            return 1
+        }
+    case string:
+        return strings.Compare(a, b.(string))
+    case bool:
+        if a != b.(bool) {
+            if a {
+                return 1
+            }
+            return -1
+        }
+    }
+    return 0
+}
+
+// queryKey converts numbers and money to exact rationals so that they can
+// be compared with each other.
+func queryKey(value interface{}) interface{} {
+    switch value := value.(type) {
+    case int:
+        return big.NewRat(int64(value), 1)
+    case Money:
+        return big.NewRat(value.Amount, minorUnitsPerMajor(value.Currency))
+    }
+    return value
+}
+
+type queryToken struct {
+    text   string
+    column int
+    quoted bool
+}
+
+const queryOperatorChars = "=!<>~"
+
+var queryOperators = map[string]bool{"=": true, "!=": true, "<": true,
+    "<=": true, ">": true, ">=": true, "~": true}
+
+func tokenizeQuery(text string) ([]queryToken, error) {
+    var tokens []queryToken
+    runes := []rune(text)
+    for i := 0; i < len(runes); {
+        start := i
+        switch r := runes[i]; {
+        case unicode.IsSpace(r):
+            i++
+            continue
+        case r == '(' || r == ')':
+            i++
+        case strings.ContainsRune(queryOperatorChars, r):
+            for i < len(runes) &&
+                strings.ContainsRune(queryOperatorChars, runes[i]) {
+                i++
+            }
+        case r == '"':
+            for i++; i < len(runes) && runes[i] != '"'; i++ {
+                if runes[i] == '\\' {
+                    i++
+                }
+            }
+            if i >= len(runes) {
+                return nil, queryError(start+1, "unterminated string")
+            }
+            i++
+            unquoted, err := strconv.Unquote(string(runes[start:i]))
+            if err != nil {
+                return nil, queryError(start+1, "invalid string")
+            }
+            tokens = append(tokens, queryToken{unquoted, start + 1, true})
+            continue
+        default:
+            for i < len(runes) && !unicode.IsSpace(runes[i]) &&
+                !strings.ContainsRune(queryOperatorChars+`()"`, runes[i]) {
+                i++
+            }
+        }
+        tokens = append(tokens, queryToken{string(runes[start:i]),
+            start + 1, false})
+    }
+    return tokens, nil
+}
+
+func queryError(column int, message string) error {
+    return fmt.Errorf("invalid query: %s at column %d", message, column)
+}
+
+// compileQuery returns a function that reports whether an invoice
+// matches the query; an empty query matches every invoice.
+func compileQuery(text string) (func(*Invoice) bool, error) {
+    tokens, err := tokenizeQuery(text)
+    if err != nil {
+        return nil, err
+    }
+    if len(tokens) == 0 {
+        return func(*Invoice) bool { return true }, nil
+    }
+    parser := &queryParser{tokens: tokens, end: len([]rune(text)) + 1}
+    match, err := parser.parseOr()
+    if err == nil && parser.i < len(parser.tokens) {
+        token := parser.tokens[parser.i]
+        err = queryError(token.column, fmt.Sprintf("unexpected %q",
+            token.text))
+    }
+    return match, err
+}
+
+// queryParser is a recursive descent parser for the grammar:
+//
+//  or         = and { "or" and }
+//  and        = not { "and" not }
+//  not        = "not" not | primary
+//  primary    = "(" or ")" | comparison | field
+//  comparison = field operator value
+type queryParser struct {
+    tokens []queryToken
+    i      int
+    end    int // The column after the last one
+}
+
+func (parser *queryParser) peek() (queryToken, bool) {
+    if parser.i < len(parser.tokens) {
+        return parser.tokens[parser.i], true
+    }
+    return queryToken{column: parser.end}, false
+}
+
+func (parser *queryParser) acceptKeyword(keyword string) bool {
+    if token, ok := parser.peek(); ok && !token.quoted &&
+        strings.ToLower(token.text) == keyword {
+        parser.i++
+        return true
+    }
+    return false
+}
+
+func (parser *queryParser) parseOr() (func(*Invoice) bool, error) {
+    left, err := parser.parseAnd()
+    for err == nil && parser.acceptKeyword("or") {
+        var right func(*Invoice) bool
+        if right, err = parser.parseAnd(); err == nil {
+            a, b := left, right
+            left = func(invoice *Invoice) bool {
+                return a(invoice) || b(invoice)
+            }
+        }
+    }
+    return left, err
+}
+
+func (parser *queryParser) parseAnd() (func(*Invoice) bool, error) {
+    left, err := parser.parseNot()
+    for err == nil && parser.acceptKeyword("and") {
+        var right func(*Invoice) bool
+        if right, err = parser.parseNot(); err == nil {
+            a, b := left, right
+            left = func(invoice *Invoice) bool {
+                return a(invoice) && b(invoice)
+            }
+        }
+    }
+    return left, err
+}
+
+func (parser *queryParser) parseNot() (func(*Invoice) bool, error) {
+    if parser.acceptKeyword("not") {
+        match, err := parser.parseNot()
+        if err != nil {
+            return nil, err
+        }
+        return func(invoice *Invoice) bool { return !match(invoice) }, nil
+    }
+    return parser.parsePrimary()
+}
+
+func (parser *queryParser) parsePrimary() (func(*Invoice) bool, error) {
+    token, ok := parser.peek()
+    if !ok {
+        return nil, queryError(token.column, "unexpected end")
+    }
+    parser.i++
+    if token.text == "(" && !token.quoted {
+        match, err := parser.parseOr()
+        if err != nil {
+            return nil, err
+        }
+        if closing, ok := parser.peek(); !ok || closing.text != ")" {
+            return nil, queryError(closing.column, `expected ")"`)
+        }
+        parser.i++
+        return match, nil
+    }
+    field, found := queryFields[token.text]
+    if !found || token.quoted {
+        return nil, queryError(token.column, fmt.Sprintf(
+            "unknown field %q", token.text))
+    }
+    operator, ok := parser.peek()
+    if !ok || !queryOperators[operator.text] || operator.quoted {
+        if field.kind == queryBool {
+            return fieldMatcher(field, func(value interface{}) bool {
+                return value == true
+            }), nil
+        }
+        return nil, queryError(operator.column, fmt.Sprintf(
+            "expected an operator after %s", token.text))
+    }
+    parser.i++
+    literal, ok := parser.peek()
+    if !ok {
+        return nil, queryError(literal.column, fmt.Sprintf(
+            "expected a value after %s", operator.text))
+    }
+    parser.i++
+    test, err := comparison(field, operator.text, literal.text)
+    if err != nil {
+        return nil, queryError(literal.column, err.Error())
+    }
+    return fieldMatcher(field, test), nil
+}
+
+// fieldMatcher applies the test to the invoice's field or, for item
+// fields, to each item's field until one passes.
+func fieldMatcher(field queryField,
+    test func(interface{}) bool) func(*Invoice) bool {
+    if field.invoice != nil {
+        return func(invoice *Invoice) bool {
+            return test(field.invoice(invoice))
+        }
+    }
+    return func(invoice *Invoice) bool {
+        for _, item := range invoice.Items {
+            if test(field.item(item)) {
+                return true
+            }
+        }
+        return false
+    }
+}
+
+func comparison(field queryField, operator, text string) (
+    func(interface{}) bool, error) {
+    if operator == "~" {
+        if field.kind != queryText {
+            return nil, errors.New("~ only applies to text fields")
+        }
+        text = strings.ToLower(text)
+        return func(value interface{}) bool {
+            s, ok := value.(string)
+            return ok && strings.Contains(strings.ToLower(s), text)
+        }, nil
+    }
+    var literal interface{}
+    switch field.kind {
+    case queryNumber:
+        number, ok := new(big.Rat).SetString(text)
+        if !ok {
+            return nil, fmt.Errorf("invalid number %q", text)
+        }
+        literal = number
+    case queryDate:
+        date, err := time.Parse(dateFormat, text)
+        if err != nil {
+            return nil, fmt.Errorf("invalid date %q", text)
+        }
+        literal = date
+    case queryBool:
+        if operator != "=" && operator != "!=" {
+            return nil, fmt.Errorf("%s doesn't apply to bool fields",
+                operator)
+        }
+        b, err := strconv.ParseBool(text)
+        if err != nil {
+            return nil, fmt.Errorf("invalid bool %q", text)
+        }
+        literal = b
+    default:
+        literal = text
+    }
+    return func(value interface{}) bool {
+        if value == nil {
+            return false
+        }
+        c := compareQueryValues(value, literal)
+        switch operator {
+        case "=":
+            return c == 0
+        case "!=":
+            return c != 0
+        case "<":
+            return c < 0
+        case "<=":
+            return c <= 0
+        case ">":
+            return c > 0
+        }
+        return c >= 0
+    }, nil
+}
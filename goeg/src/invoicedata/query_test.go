@@ -0,0 +1,101 @@
+// Copyright © 2011-12 Qtrac Ltd.
+//
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestCompileQuery(t *testing.T) {
+    invoices := reportInvoices()
+    for query, expected := range map[string][]int{
+        "":                                       {1, 2, 3, 4},
+        "customer=960":                           {1, 2},
+        "customer=960 and not paid":              {1, 2},
+        "paid or item.currency=JPY":              {3, 4},
+        "not (paid or item.currency = JPY)":      {1, 2},
+        "due<2012-10-06":                         {4},
+        "due>=2012-10-06 AND raised<=2012-09-30": {1, 3},
+        "item.price>400.89":                      {4},
+        "item.price>=400.89 and currency=USD":    {1, 3},
+        "total=2806.23":                          {1},
+        `item.id~"be9" and quantity!=0`:          nil,
+        `item.id ~ "be9" and items=1`:            {1, 3},
+        "paid=false and id!=4":                   {1, 2},
+    } {
+        match, err := compileQuery(query)
+        if err != nil {
+            if expected == nil {
+                continue
+            }
+            t.Fatalf("%q: %v", query, err)
+        } else if expected == nil {
+            t.Fatalf("%q: expected an error", query)
+        }
+        var ids []int
+        for _, invoice := range queryInvoices(invoices, match, nil) {
+            ids = append(ids, invoice.Id)
+        }
+        if len(ids) != len(expected) {
+            t.Fatalf("%q: expected %v got %v", query, expected, ids)
+        }
+        for i := range ids {
+            if ids[i] != expected[i] {
+                t.Fatalf("%q: expected %v got %v", query, expected, ids)
+            }
+        }
+    }
+}
+
+func TestQueryErrors(t *testing.T) {
+    for query, message := range map[string]string{
+        "customer=":            "expected a value after = at column 10",
+        "customer":             "expected an operator after customer",
+        "(paid":                `expected ")" at column 6`,
+        "paid paid":            `unexpected "paid" at column 6`,
+        "due<tomorrow":         `invalid date "tomorrow" at column 5`,
+        "total~12":             "~ only applies to text fields",
+        "paid<true":            "< doesn't apply to bool fields",
+        `note="unterminated`:   "unterminated string at column 6",
+        "customer=1 and owner": `unknown field "owner" at column 16`,
+    } {
+        if _, err := compileQuery(query); err == nil ||
+            !strings.Contains(err.Error(), message) {
+            t.Errorf("%q: expected %q got %v", query, message, err)
+        }
+    }
+}
+
+func TestQuerySortAndProject(t *testing.T) {
+    keys, err := parseQuerySortKeys("customer,-raised")
+    if err != nil {
+        t.Fatal(err)
+    }
+    match, _ := compileQuery("")
+    invoices := queryInvoices(reportInvoices(), match, keys)
+    table, err := projectInvoices(invoices, "id,item.id,item.price")
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkRows(t, table, [][]interface{}{{3, "BE9066", "400.89"},
+        {4, "AM7240", "1000"}, {2, "PT9110", "105.90"},
+        {1, "BE9066", "400.89"}})
+    if _, err := parseQuerySortKeys("item.price"); err == nil {
+        t.Error("expected an error sorting by an item field")
+    }
+    if _, err := projectInvoices(invoices, "id,owner"); err == nil {
+        t.Error("expected an error selecting an unknown field")
+    }
+}
@@ -0,0 +1,217 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+)
+
+// An invDamage is a run of bytes in an .inv file that held no readable
+// invoices.
+type invDamage struct {
+    Offset int64
+    Length int64
+    Err    error
+}
+
+func (damage invDamage) String() string {
+    return fmt.Sprintf("offset %d: %v (%d bytes skipped)", damage.Offset,
+        damage.Err, damage.Length)
+}
+
+// scanInvFile reads every undamaged invoice in an .inv file. In version
+// 104 files each record has a length and checksum, so after damage the
+// scan moves forward a byte at a time until it finds a valid record; in
+// older files nothing after the first damage can be trusted. Only damage
+// to the header and failures to read are reported as errors.
+func scanInvFile(reader io.Reader) (invoices []*Invoice,
+    damages []invDamage, err error) {
+    scanner := &invScanner{reader: bufio.NewReader(reader)}
+    version, count, err := readInvHeader(scanner)
+    if err != nil {
+        return nil, nil, err
+    }
+    for (count == unknownInvCount || len(invoices) < count) &&
+        len(scanner.peek(1)) > 0 {
+        offset := scanner.offset
+        var invoice *Invoice
+        if version < 104 {
+            if invoice, err = readInvInvoice(version, scanner); err != nil {
+                if err == io.EOF {
+                    err = io.ErrUnexpectedEOF
+                }
+                if _, err := io.Copy(ioutil.Discard, scanner); err != nil {
+                    return nil, nil, err
+                }
+                damages = append(damages, invDamage{offset,
+                    scanner.offset - offset, err})
+                break
+            }
+            invoices = append(invoices, invoice)
+            continue
+        }
+        var size int64
+        if invoice, size, err = scanner.record(version); err == nil {
+            invoices = append(invoices, invoice)
+            scanner.skip(size)
+            continue
+        }
+        damage := invDamage{Offset: offset, Err: err}
+        for scanner.skip(1); len(scanner.peek(1)) > 0; scanner.skip(1) {
+            if _, _, err := scanner.record(version); err == nil {
+                break
+            }
+        }
+        damage.Length = scanner.offset - offset
+        damages = append(damages, damage)
+    }
+    offset := scanner.offset
+    if _, err := io.Copy(ioutil.Discard, scanner); err != nil {
+        return nil, nil, err
+    }
+    if scanner.err != nil {
+        return nil, nil, scanner.err
+    }
+    if scanner.offset > offset {
+        damages = append(damages, invDamage{offset, scanner.offset - offset,
+            fmt.Errorf("unexpected data after the %d invoices", count)})
+    }
+    return invoices, damages, nil
+}
+
+// An invScanner reads an .inv file and knows its offset. It can look as
+// far ahead as a record's length says, but only reads ahead as much data
+// as there is, so a corrupt length can't make it allocate more memory
+// than the file's size.
+type invScanner struct {
+    reader  io.Reader
+    pending bytes.Buffer // Read ahead but not yet consumed
+    offset  int64        // Of the next byte to consume
+    err     error        // The first error other than io.EOF in a peek
+}
+
+func (scanner *invScanner) Read(data []byte) (n int, err error) {
+    if scanner.pending.Len() > 0 {
+        n, err = scanner.pending.Read(data)
+    } else {
+        n, err = scanner.reader.Read(data)
+    }
+    scanner.offset += int64(n)
+    return n, err
+}
+
+// peek returns up to the next n bytes without consuming them; fewer are
+// returned only at the end of the data.
+func (scanner *invScanner) peek(n int64) []byte {
+    if short := n - int64(scanner.pending.Len()); short > 0 &&
+        scanner.err == nil {
+        if _, err := io.CopyN(&scanner.pending, scanner.reader, short);
+            err != nil && err != io.EOF {
+            scanner.err = err
+        }
+    }
+    data := scanner.pending.Bytes()
+    if int64(len(data)) > n {
+        data = data[:n]
+    }
+    return data
+}
+
+// skip consumes up to n bytes that have been peeked.
+func (scanner *invScanner) skip(n int64) {
+    scanner.offset += int64(len(scanner.pending.Next(int(n))))
+}
+
+// record returns the invoice in the version 104 record that follows and
+// the record's size, without consuming it.
+func (scanner *invScanner) record(version int) (*Invoice, int64, error) {
+    header := scanner.peek(4)
+    if len(header) < 4 {
+        return nil, 0, io.ErrUnexpectedEOF
+    }
+    length := int64(byteOrder.Uint32(header))
+    if length > maxInvRecordLength {
+        return nil, 0, fmt.Errorf("invalid record length %d", length)
+    }
+    data := scanner.peek(length + 8) // Length, payload, and checksum
+    if int64(len(data)) < length+8 {
+        return nil, 0, io.ErrUnexpectedEOF
+    }
+    payload := data[4 : 4+length]
+    if byteOrder.Uint32(data[4+length:]) != crc32.ChecksumIEEE(payload) {
+        return nil, 0, errInvChecksum
+    }
+    invoice, err := parseInvPayload(version, payload)
+    return invoice, length + 8, err
+}
+
+// scanInvFileAndReport logs the damages and returns the good invoices.
+func scanInvFileAndReport(filename string) ([]*Invoice, int) {
+    file, closer, err := openInvoiceFile(filename)
+    if closer != nil {
+        defer closer()
+    }
+    if err != nil {
+        log.Fatalln("Failed to read:", err)
+    }
+    invoices, damages, err := scanInvFile(file)
+    if err != nil {
+        log.Fatalf("Failed to read %s: %v\n", filename, err)
+    }
+    for _, damage := range damages {
+        log.Printf("%s: %s\n", filename, damage)
+    }
+    return invoices, len(damages)
+}
+
+// verifyCommand exits with status 1 if the file has any damage.
+func verifyCommand(args []string) {
+    if len(args) != 1 || args[0] == "-h" || args[0] == "--help" {
+        log.Fatalf("usage: %s verify infile.inv\n",
+            filepath.Base(os.Args[0]))
+    }
+    invoices, damaged := scanInvFileAndReport(args[0])
+    fmt.Printf("%s: %d invoices OK, %d damaged areas\n", args[0],
+        len(invoices), damaged)
+    if damaged > 0 {
+        os.Exit(1)
+    }
+}
+
+// recoverCommand writes the invoices that are undamaged to outfile in any
+// format.
+func recoverCommand(args []string) {
+    if len(args) != 2 || args[0] == "-h" || args[0] == "--help" {
+        log.Fatalf("usage: %s recover infile.inv outfile.ext\n",
+            filepath.Base(os.Args[0]))
+    }
+    inFilename, outFilename := args[0], args[1]
+    if inFilename == outFilename {
+        log.Fatalln("won't overwrite a file with itself")
+    }
+    invoices, damaged := scanInvFileAndReport(inFilename)
+    if err := writeInvoiceFile(outFilename, invoices); err != nil {
+        log.Fatalln("Failed to write:", err)
+    }
+    fmt.Printf("%s: recovered %d invoices, skipped %d damaged areas\n",
+        inFilename, len(invoices), damaged)
+}
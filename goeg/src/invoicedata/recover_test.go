@@ -0,0 +1,140 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "testing"
+    "time"
+)
+
+func recoverInvoices() []*Invoice {
+    raised := time.Date(2012, 9, 6, 0, 0, 0, 0, time.UTC)
+    var invoices []*Invoice
+    for i := 0; i < 5; i++ {
+        invoices = append(invoices, &Invoice{i + 1, 960, raised,
+            raised.AddDate(0, 0, 30), false, "Note",
+            []*Item{item("BE9066", "400.89 USD", 7, "Fragile")}})
+    }
+    return invoices
+}
+
+// invRecordOffsets returns the offset of each invoice's record.
+func invRecordOffsets(data []byte) []int64 {
+    offsets := []int64{10} // Magic, version, and count
+    for len(offsets) < 5 {
+        offset := offsets[len(offsets)-1]
+        length := int64(byteOrder.Uint32(data[offset:]))
+        offsets = append(offsets, offset+length+8) // Length and checksum
+    }
+    return offsets
+}
+
+func TestScanInvFile(t *testing.T) {
+    var buffer bytes.Buffer
+    if err := writeInvoices(&buffer, ".inv", recoverInvoices()); err != nil {
+        t.Fatal(err)
+    }
+    data := buffer.Bytes()
+    offsets := invRecordOffsets(data)
+    data[offsets[1]+20]++ // Corrupt the second invoice's payload
+    data[offsets[3]]++    // and the fourth invoice's length
+    invoices, damages, err := scanInvFile(bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(invoices) != 3 || invoices[0].Id != 1 || invoices[1].Id != 3 ||
+        invoices[2].Id != 5 {
+        t.Fatalf("expected invoices 1, 3, and 5, got %d", len(invoices))
+    }
+    if len(damages) != 2 || damages[0].Offset != offsets[1] ||
+        damages[0].Length != offsets[2]-offsets[1] ||
+        damages[0].Err != errInvChecksum ||
+        damages[1].Offset != offsets[3] ||
+        damages[1].Length != offsets[4]-offsets[3] {
+        t.Fatalf("unexpected damages %v for offsets %v", damages, offsets)
+    }
+
+    // Streaming reads stop at the first damage and say where it is.
+    _, err = readInvoices(bytes.NewReader(data), ".inv")
+    if err == nil || !strings.Contains(err.Error(),
+        "invalid invoice at offset") {
+        t.Fatalf("expected an offset in the error, got %v", err)
+    }
+
+    // The damage to the fourth invoice now runs into the truncated fifth.
+    data = data[:len(data)-3]
+    if invoices, damages, err = scanInvFile(bytes.NewReader(data));
+        err != nil {
+        t.Fatal(err)
+    }
+    if len(invoices) != 2 || len(damages) != 2 ||
+        damages[1].Length != int64(len(data))-offsets[3] {
+        t.Fatalf("unexpected truncated scan %d %v", len(invoices), damages)
+    }
+}
+
+// A corrupt length mustn't make the scan allocate that much memory.
+func TestScanInvFileBadLength(t *testing.T) {
+    var buffer bytes.Buffer
+    if err := writeInvoices(&buffer, ".inv", recoverInvoices()); err != nil {
+        t.Fatal(err)
+    }
+    data := buffer.Bytes()
+    offsets := invRecordOffsets(data)
+    byteOrder.PutUint32(data[offsets[3]:], maxInvRecordLength)
+    var before, after runtime.MemStats
+    runtime.ReadMemStats(&before)
+    invoices, damages, err := scanInvFile(bytes.NewReader(data))
+    runtime.ReadMemStats(&after)
+    if err != nil || len(invoices) != 4 || len(damages) != 1 {
+        t.Fatalf("unexpected scan %d %v %v", len(invoices), damages, err)
+    }
+    if allocated := after.TotalAlloc - before.TotalAlloc;
+        allocated > maxInvRecordLength/16 {
+        t.Errorf("allocated %d bytes to scan %d", allocated, len(data))
+    }
+}
+
+// Old files have no records but are still read, and are scanned as far
+// as the first damage.
+func TestScanOldInvFile(t *testing.T) {
+    data, err := ioutil.ReadFile(filepath.Join("testdata",
+        "invoices100.inv"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    invoices, damages, err := scanInvFile(bytes.NewReader(data))
+    if err != nil || len(damages) != 0 {
+        t.Fatalf("unexpected damage to an old file: %v %v", err, damages)
+    }
+    expected, err := readInvoices(bytes.NewReader(data), ".inv")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(invoices) != len(expected) || len(invoices) == 0 {
+        t.Fatalf("scanned %d invoices, read %d", len(invoices),
+            len(expected))
+    }
+    invoices, damages, err = scanInvFile(bytes.NewReader(data[:len(data)-1]))
+    if err != nil || len(damages) != 1 ||
+        len(invoices) != len(expected)-1 {
+        t.Fatalf("unexpected scan of a truncated old file: %d %v %v",
+            len(invoices), damages, err)
+    }
+}
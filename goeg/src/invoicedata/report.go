@@ -0,0 +1,431 @@
+// Copyright © 2011-12 Qtrac Ltd.
+//
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+// A Table is a report's result; every output format is produced from it.
+// Cells are strings or ints; amounts of money are given as decimal
+// strings with their currency in a column of its own so that nothing is
+// lost to floating-point.
+type Table struct {
+    Title  string
+    Header []string
+    Rows   [][]interface{}
+}
+
+func (table *Table) addRow(cells ...interface{}) {
+    table.Rows = append(table.Rows, cells)
+}
+
+type agingBucket struct {
+    name    string
+    maxDays int // Days past due
+}
+
+// Invoices that aren't due yet go in the first bucket.
+var agingBuckets = []agingBucket{{"not due", -1}, {"0-30", 30},
+    {"31-60", 60}, {"61-90", 90}, {"90+", math.MaxInt32}}
+
+func reportCommand(args []string) {
+    commandLine := flag.NewFlagSet("report", flag.ExitOnError)
+    format := commandLine.String("format", "text", "text, csv, or json")
+    asOf := commandLine.String("asof", time.Now().Format(dateFormat),
+        "the date to age invoices from (aging only)")
+    top := commandLine.Int("top", 10,
+        "how many items to show (items only)")
+    commandLine.Usage = func() {
+        log.Printf("usage: %s report [options] aging|revenue|unpaid|items "+
+            "infile\ninfile may be in any invoices format, optionally "+
+            "gzipped\noptions:\n", filepath.Base(os.Args[0]))
+        commandLine.PrintDefaults()
+    }
+    commandLine.Parse(args)
+    if commandLine.NArg() != 2 {
+        commandLine.Usage()
+        os.Exit(2)
+    }
+    kind, inFilename := commandLine.Arg(0), commandLine.Arg(1)
+    date, err := time.Parse(dateFormat, *asOf)
+    if err != nil {
+        log.Fatalln("invalid -asof date:", err)
+    }
+    invoices, err := readDetectedInvoiceFile(inFilename)
+    if err != nil {
+        log.Fatalln("Failed to read:", err)
+    }
+    var table *Table
+    switch kind {
+    case "aging":
+        table, err = agingReport(invoices, date)
+    case "revenue":
+        table, err = revenueReport(invoices)
+    case "unpaid":
+        table, err = unpaidReport(invoices)
+    case "items":
+        table, err = topItemsReport(invoices, *top)
+    default:
+        log.Fatalln("unrecognized report:", kind)
+    }
+    if err == nil {
+        err = writeTable(os.Stdout, *format, table)
+    }
+    if err != nil {
+        log.Fatalln("Failed to report:", err)
+    }
+}
+
+// agingReport buckets the unpaid invoices' totals by how many days past
+// due they are on the asOf date.
+func agingReport(invoices []*Invoice, asOf time.Time) (*Table, error) {
+    totals := newMoneyTotals()
+    for _, invoice := range invoices {
+        if invoice.Paid {
+            continue
+        }
+        total, err := invoice.Total()
+        if err != nil {
+            return nil, err
+        }
+        days := int(asOf.Sub(invoice.Due).Hours() / 24)
+        if err = totals.add(agingBucketFor(days), total); err != nil {
+            return nil, err
+        }
+    }
+    table := &Table{Title: "Accounts receivable aging as of " +
+        asOf.Format(dateFormat), Header: []string{"Days Past Due",
+        "Currency", "Invoices", "Unpaid"}}
+    for _, bucket := range agingBuckets {
+        for _, currency := range totals.currencies(bucket.name) {
+            count, money := totals.get(bucket.name, currency)
+            table.addRow(bucket.name, currency, count, money.Decimal())
+        }
+    }
+    return table, nil
+}
+
+func agingBucketFor(days int) string {
+    for _, bucket := range agingBuckets {
+        if days <= bucket.maxDays {
+            return bucket.name
+        }
+    }
+    return agingBuckets[len(agingBuckets)-1].name
+}
+
+// revenueReport totals the invoices raised per customer per month.
+func revenueReport(invoices []*Invoice) (*Table, error) {
+    type customerMonth struct {
+        customer int
+        month    string
+    }
+    totals := newMoneyTotals()
+    for _, invoice := range invoices {
+        total, err := invoice.Total()
+        if err != nil {
+            return nil, err
+        }
+        if err = totals.add(customerMonth{invoice.CustomerId,
+            invoice.Raised.Format("2006-01")}, total); err != nil {
+            return nil, err
+        }
+    }
+    table := &Table{Title: "Revenue per customer per month",
+        Header: []string{"Customer", "Month", "Currency", "Invoices",
+            "Revenue"}}
+    keys := totals.keys()
+    sort.Slice(keys, func(i, j int) bool {
+        a, b := keys[i].(customerMonth), keys[j].(customerMonth)
+        if a.customer != b.customer {
+            return a.customer < b.customer
+        }
+        return a.month < b.month
+    })
+    for _, key := range keys {
+        for _, currency := range totals.currencies(key) {
+            count, money := totals.get(key, currency)
+            table.addRow(key.(customerMonth).customer,
+                key.(customerMonth).month, currency, count, money.Decimal())
+        }
+    }
+    return table, nil
+}
+
+// unpaidReport totals the unpaid invoices per customer with a grand total
+// per currency at the end.
+func unpaidReport(invoices []*Invoice) (*Table, error) {
+    totals := newMoneyTotals()
+    grandTotals := newMoneyTotals()
+    for _, invoice := range invoices {
+        if invoice.Paid {
+            continue
+        }
+        total, err := invoice.Total()
+        if err != nil {
+            return nil, err
+        }
+        if err = totals.add(invoice.CustomerId, total); err != nil {
+            return nil, err
+        }
+        if err = grandTotals.add("Total", total); err != nil {
+            return nil, err
+        }
+    }
+    table := &Table{Title: "Unpaid invoices per customer",
+        Header: []string{"Customer", "Currency", "Invoices", "Unpaid"}}
+    keys := totals.keys()
+    sort.Slice(keys, func(i, j int) bool {
+        return keys[i].(int) < keys[j].(int)
+    })
+    for _, key := range append(keys, "Total") {
+        source := totals
+        if key == "Total" {
+            source = grandTotals
+        }
+        for _, currency := range source.currencies(key) {
+            count, money := source.get(key, currency)
+            table.addRow(key, currency, count, money.Decimal())
+        }
+    }
+    return table, nil
+}
+
+// topItemsReport lists the items that brought in the most revenue
+// (within each currency) with the biggest first.
+func topItemsReport(invoices []*Invoice, top int) (*Table, error) {
+    type itemRevenue struct {
+        id       string
+        quantity int
+        revenue  Money
+    }
+    revenues := make(map[string]*itemRevenue)
+    for _, invoice := range invoices {
+        for _, item := range invoice.Items {
+            key := item.Id + "\t" + item.Price.Currency
+            revenue, found := revenues[key]
+            if !found {
+                revenue = &itemRevenue{id: item.Id,
+                    revenue: Money{Currency: item.Price.Currency}}
+                revenues[key] = revenue
+            }
+            quantity := revenue.quantity + item.Quantity
+            if (item.Quantity > 0 && quantity < revenue.quantity) ||
+                (item.Quantity < 0 && quantity > revenue.quantity) {
+                return nil, fmt.Errorf("item %s: total quantity overflows",
+                    item.Id)
+            }
+            revenue.quantity = quantity
+            amount, err := item.Price.Times(item.Quantity)
+            if err != nil {
+                return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+            }
+            if revenue.revenue, err = revenue.revenue.Plus(amount);
+                err != nil {
+                return nil, fmt.Errorf("item %s: %v", item.Id, err)
+            }
+        }
+    }
+    items := make([]*itemRevenue, 0, len(revenues))
+    for _, revenue := range revenues {
+        items = append(items, revenue)
+    }
+    sort.Slice(items, func(i, j int) bool {
+        a, b := items[i].revenue, items[j].revenue
+        if a.Currency != b.Currency {
+            return a.Currency < b.Currency
+        }
+        if a.Amount != b.Amount {
+            return a.Amount > b.Amount
+        }
+        return items[i].id < items[j].id
+    })
+    table := &Table{Title: fmt.Sprintf("Top %d items by revenue", top),
+        Header: []string{"Item", "Currency", "Quantity", "Revenue"}}
+    counts := make(map[string]int)
+    for _, item := range items {
+        if counts[item.revenue.Currency] < top {
+            counts[item.revenue.Currency]++
+            table.addRow(item.id, item.revenue.Currency, item.quantity,
+                item.revenue.Decimal())
+        }
+    }
+    return table, nil
+}
+
+// moneyTotals keeps a count and total per key per currency since money
+// in different currencies can't be added together. The keys may be of
+// any comparable type.
+type moneyTotals struct {
+    counts map[interface{}]map[string]int
+    totals map[interface{}]map[string]Money
+}
+
+func newMoneyTotals() moneyTotals {
+    return moneyTotals{make(map[interface{}]map[string]int),
+        make(map[interface{}]map[string]Money)}
+}
+
+// add returns an error if the key's total would overflow.
+func (totals moneyTotals) add(key interface{}, money Money) error {
+    if totals.totals[key] == nil {
+        totals.counts[key] = make(map[string]int)
+        totals.totals[key] = make(map[string]Money)
+    }
+    total, found := totals.totals[key][money.Currency]
+    if !found {
+        total.Currency = money.Currency
+    }
+    total, err := total.Plus(money)
+    if err != nil {
+        return fmt.Errorf("%v: %v", key, err)
+    }
+    totals.counts[key][money.Currency]++
+    totals.totals[key][money.Currency] = total
+    return nil
+}
+
+func (totals moneyTotals) get(key interface{}, currency string) (int,
+    Money) {
+    return totals.counts[key][currency], totals.totals[key][currency]
+}
+
+func (totals moneyTotals) keys() []interface{} {
+    keys := make([]interface{}, 0, len(totals.totals))
+    for key := range totals.totals {
+        keys = append(keys, key)
+    }
+    return keys
+}
+
+func (totals moneyTotals) currencies(key interface{}) []string {
+    currencies := make([]string, 0, len(totals.totals[key]))
+    for currency := range totals.totals[key] {
+        currencies = append(currencies, currency)
+    }
+    sort.Strings(currencies)
+    return currencies
+}
+
+func writeTable(writer io.Writer, format string, table *Table) error {
+    switch format {
+    case "text":
+        return writeTextTable(writer, table)
+    case "csv":
+        return writeCSVTable(writer, table)
+    case "json":
+        return writeJSONTable(writer, table)
+    }
+    return fmt.Errorf("unrecognized report format: %s", format)
+}
+
+// writeTextTable right-aligns numbers (and amounts) and left-aligns
+// everything else.
+func writeTextTable(writer io.Writer, table *Table) error {
+    widths := make([]int, len(table.Header))
+    for column, name := range table.Header {
+        widths[column] = utf8.RuneCountInString(name)
+    }
+    for _, row := range table.Rows {
+        for column, cell := range row {
+            if width := utf8.RuneCountInString(fmt.Sprint(cell));
+                width > widths[column] {
+                widths[column] = width
+            }
+        }
+    }
+    lines := []string{table.Title, strings.Repeat("=",
+        utf8.RuneCountInString(table.Title))}
+    var fields []string
+    for column, name := range table.Header {
+        fields = append(fields, fmt.Sprintf("%-*s", widths[column], name))
+    }
+    lines = append(lines, strings.TrimRight(strings.Join(fields, "  "),
+        " "))
+    for _, row := range table.Rows {
+        fields = fields[:0]
+        for column, cell := range row {
+            format := "%-*v"
+            if isNumeric(cell) {
+                format = "%*v"
+            }
+            fields = append(fields, fmt.Sprintf(format, widths[column],
+                cell))
+        }
+        lines = append(lines, strings.TrimRight(strings.Join(fields,
+            "  "), " "))
+    }
+    _, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+    return err
+}
+
+func isNumeric(cell interface{}) bool {
+    switch cell := cell.(type) {
+    case int:
+        return true
+    case string:
+        _, err := ParseMoney(cell)
+        return err == nil
+    }
+    return false
+}
+
+func writeCSVTable(writer io.Writer, table *Table) error {
+    csvWriter := csv.NewWriter(writer)
+    csvWriter.UseCRLF = true
+    if err := csvWriter.Write(table.Header); err != nil {
+        return err
+    }
+    for _, row := range table.Rows {
+        fields := make([]string, 0, len(row))
+        for _, cell := range row {
+            fields = append(fields, fmt.Sprint(cell))
+        }
+        if err := csvWriter.Write(fields); err != nil {
+            return err
+        }
+    }
+    csvWriter.Flush()
+    return csvWriter.Error()
+}
+
+// writeJSONTable writes an object with the title and the rows as an
+// array of objects keyed by the header names.
+func writeJSONTable(writer io.Writer, table *Table) error {
+    rows := make([]map[string]interface{}, 0, len(table.Rows))
+    for _, row := range table.Rows {
+        object := make(map[string]interface{}, len(row))
+        for column, cell := range row {
+            object[table.Header[column]] = cell
+        }
+        rows = append(rows, object)
+    }
+    return json.NewEncoder(writer).Encode(struct {
+        Title string
+        Rows  []map[string]interface{}
+    }{table.Title, rows})
+}
@@ -0,0 +1,141 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "math"
+    "strings"
+    "testing"
+    "time"
+)
+
+func reportInvoices() []*Invoice {
+    raised := time.Date(2012, 9, 6, 0, 0, 0, 0, time.UTC)
+    due := raised.AddDate(0, 0, 30)
+    return []*Invoice{
+        {1, 960, raised, due, false, "",
+            []*Item{item("BE9066", "400.89 USD", 7, "")}},
+        {2, 960, raised.AddDate(0, 1, 0), due.AddDate(0, 1, 0), false, "",
+            []*Item{item("PT9110", "105.90 USD", 1, "")}},
+        {3, 917, raised, due, true, "",
+            []*Item{item("BE9066", "400.89 USD", 1, "")}},
+        {4, 917, raised, due.AddDate(0, 0, -90), false, "",
+            []*Item{item("AM7240", "1000 JPY", 3, "")}},
+    }
+}
+
+func TestAgingReport(t *testing.T) {
+    table, err := agingReport(reportInvoices(), time.Date(2012, 11, 5, 0, 0,
+        0, 0, time.UTC))
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := [][]interface{}{{"not due", "USD", 1, "105.90"},
+        {"0-30", "USD", 1, "2806.23"}, {"90+", "JPY", 1, "3000"}}
+    checkRows(t, table, expected)
+}
+
+func TestRevenueReport(t *testing.T) {
+    table, err := revenueReport(reportInvoices())
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := [][]interface{}{{917, "2012-09", "JPY", 1, "3000"},
+        {917, "2012-09", "USD", 1, "400.89"},
+        {960, "2012-09", "USD", 1, "2806.23"},
+        {960, "2012-10", "USD", 1, "105.90"}}
+    checkRows(t, table, expected)
+}
+
+func TestUnpaidAndItemsReports(t *testing.T) {
+    table, err := unpaidReport(reportInvoices())
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkRows(t, table, [][]interface{}{{917, "JPY", 1, "3000"},
+        {960, "USD", 2, "2912.13"}, {"Total", "JPY", 1, "3000"},
+        {"Total", "USD", 2, "2912.13"}})
+    if table, err = topItemsReport(reportInvoices(), 1); err != nil {
+        t.Fatal(err)
+    }
+    checkRows(t, table, [][]interface{}{{"AM7240", "JPY", 3, "3000"},
+        {"BE9066", "USD", 8, "3207.12"}})
+}
+
+// Totals that won't fit an int64 are errors rather than wrapping around.
+func TestReportOverflow(t *testing.T) {
+    invoices := reportInvoices()
+    for _, invoice := range invoices[:2] {
+        invoice.Items[0].Price.Amount = 1
+        invoice.Items[0].Quantity = math.MaxInt64/2 + 1
+    }
+    if table, err := unpaidReport(invoices); err == nil {
+        t.Errorf("expected an overflow got %v", table.Rows)
+    }
+    if _, err := revenueReport(invoices); err != nil { // Different months
+        t.Errorf("unexpected error %v", err)
+    }
+    if table, err := agingReport(invoices, time.Date(2012, 9, 6, 0, 0, 0,
+        0, time.UTC)); err == nil { // Both not yet due
+        t.Errorf("expected an overflow got %v", table.Rows)
+    }
+    invoices[1].Items[0].Id = "BE9066"
+    if table, err := topItemsReport(invoices, 1); err == nil {
+        t.Errorf("expected an overflow got %v", table.Rows)
+    }
+}
+
+func TestWriteTable(t *testing.T) {
+    table := &Table{"Title", []string{"Customer", "Unpaid"},
+        [][]interface{}{{917, "3.50"}, {"Total", "3.50"}}}
+    expected := map[string]string{
+        "text": "Title\n=====\nCustomer  Unpaid\n     917    3.50\n" +
+            "Total       3.50\n",
+        "csv": "Customer,Unpaid\r\n917,3.50\r\nTotal,3.50\r\n",
+        "json": `{"Title":"Title","Rows":[{"Customer":917,` +
+            `"Unpaid":"3.50"},{"Customer":"Total","Unpaid":"3.50"}]}` +
+            "\n",
+    }
+    for format, text := range expected {
+        var buffer bytes.Buffer
+        if err := writeTable(&buffer, format, table); err != nil {
+            t.Fatal(err)
+        }
+        if buffer.String() != text {
+            t.Errorf("%s: expected\n%q got\n%q", format, text,
+                buffer.String())
+        }
+    }
+    if err := writeTable(&bytes.Buffer{}, "html", table); err == nil ||
+        !strings.Contains(err.Error(), "html") {
+        t.Errorf("expected an unrecognized format error, got %v", err)
+    }
+}
+
+func checkRows(t *testing.T, table *Table, expected [][]interface{}) {
+    if len(table.Rows) != len(expected) {
+        t.Fatalf("expected %d rows, got %d: %v", len(expected),
+            len(table.Rows), table.Rows)
+    }
+    for i, row := range table.Rows {
+        for column, cell := range row {
+            if cell != expected[i][column] {
+                t.Errorf("row %d: expected %v, got %v", i, expected[i],
+                    row)
+                break
+            }
+        }
+    }
+}
@@ -0,0 +1,176 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "math"
+    "math/rand"
+    "reflect"
+    "regexp"
+    "strings"
+    "testing"
+    "testing/quick"
+    "time"
+)
+
+var roundTripSuffixes = []string{".csv", ".gob", ".inv", ".jsn", ".msgpack",
+    ".pb", ".tsv", ".txt", ".xml"}
+
+// randomInvoices are generated by testing/quick within some of the limits
+// that every format shares: .inv holds ids in int32s and dates as
+// YYYYMMDD, and .txt item ids are single words without the note
+// separator. Notes may hold anything, including what some formats can't
+// (e.g., newlines in .txt files), in which case writing them must fail
+// with an error saying so.
+type randomInvoices []*Invoice
+
+var edgeDates = []time.Time{
+    time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC),
+    time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC),
+    time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+    time.Date(2000, 2, 29, 0, 0, 0, 0, time.UTC),
+    time.Date(2038, 1, 19, 0, 0, 0, 0, time.UTC),
+    time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC),
+}
+
+// Runes from a mix of scripts; none are control characters, spaces, or
+// outside what XML allows.
+var noteRuneRanges = [][2]rune{{'!', '~'}, {0xA1, 0x17F}, {0x391, 0x3C9},
+    {0x410, 0x44F}, {0x4E00, 0x4FFF}, {0x1F600, 0x1F64F}}
+
+// Runes that some formats can't hold in notes, or only in some places.
+var awkwardNoteRunes = []rune{'\x00', '\t', '\n', '\v', '\f', '\r', 0x1B,
+    0x7F, 0x85, 0xA0, 0x2028, 0xFFFE, '\uFFFD'}
+
+var currencies = []string{"USD", "EUR", "GBP", "JPY", "KWD"}
+
+func (randomInvoices) Generate(random *rand.Rand, size int) reflect.Value {
+    invoices := make(randomInvoices, random.Intn(size+1))
+    // About half the time every note is one that all the formats hold
+    awkwardOdds := 1 + 4*len(invoices)
+    for i := range invoices {
+        invoice := &Invoice{
+            Id:         random.Intn(math.MaxInt32),
+            CustomerId: int(random.Int31()) - random.Intn(2)*math.MaxInt32,
+            Raised:     randomDate(random),
+            Due:        randomDate(random),
+            Paid:       random.Intn(2) == 1,
+            Note:       randomNote(random, size, awkwardOdds),
+        }
+        for j := random.Intn(4); j > 0; j-- { // Often zero items
+            currency := currencies[random.Intn(len(currencies))]
+            invoice.Items = append(invoice.Items, &Item{
+                Id:       randomItemId(random),
+                Price:    Money{Amount: random.Int63n(1<<53) - 1<<52,
+                    Currency: currency},
+                Quantity: randomQuantity(random),
+                Note:     randomNote(random, size, awkwardOdds),
+            })
+        }
+        invoices[i] = invoice
+    }
+    return reflect.ValueOf(invoices)
+}
+
+var edgeQuantities = []int{math.MinInt64, math.MinInt32 - 1,
+    math.MinInt16 - 1, -1, 0, 1, math.MaxInt16 + 1, math.MaxInt32 + 1,
+    math.MaxInt64}
+
+func randomQuantity(random *rand.Rand) int {
+    if random.Intn(4) == 0 {
+        return edgeQuantities[random.Intn(len(edgeQuantities))]
+    }
+    return int(random.Uint64())
+}
+
+func randomItemId(random *rand.Rand) string {
+    const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+    id := make([]byte, 1+random.Intn(10))
+    for i := range id {
+        id[i] = chars[random.Intn(len(chars))]
+    }
+    return string(id)
+}
+
+func randomDate(random *rand.Rand) time.Time {
+    if random.Intn(4) == 0 {
+        return edgeDates[random.Intn(len(edgeDates))]
+    }
+    return edgeDates[0].AddDate(0, 0, random.Intn(9000*365))
+}
+
+// randomNote returns a note that one time in awkwardOdds may have awkward
+// runes or surrounding space.
+func randomNote(random *rand.Rand, size, awkwardOdds int) string {
+    awkward := random.Intn(awkwardOdds) == 0
+    runes := make([]rune, random.Intn(size+1))
+    for i := range runes {
+        if random.Intn(8) == 0 {
+            runes[i] = ' '
+            continue
+        }
+        if awkward && random.Intn(4) == 0 {
+            runes[i] = awkwardNoteRunes[random.Intn(len(awkwardNoteRunes))]
+            continue
+        }
+        runeRange := noteRuneRanges[random.Intn(len(noteRuneRanges))]
+        runes[i] = runeRange[0] + rune(random.Intn(int(runeRange[1]-
+            runeRange[0]+1)))
+    }
+    if awkward {
+        return string(runes)
+    }
+    return strings.TrimSpace(string(runes))
+}
+
+// Writers refuse what their format can't hold with errors like this.
+var wontFit = regexp.MustCompile(`won't fit an? \.\w+ file$`)
+
+func TestMarshalersRoundTrip(t *testing.T) {
+    for _, suffix := range roundTripSuffixes {
+        roundTrip := func(invoices randomInvoices) bool {
+            var buffer bytes.Buffer
+            if err := writeInvoices(&buffer, suffix, invoices); err != nil {
+                if wontFit.MatchString(err.Error()) {
+                    return true
+                }
+                t.Logf("%s: %v", suffix, err)
+                return false
+            }
+            actual, err := readInvoices(&buffer, suffix)
+            if err != nil {
+                t.Logf("%s: %v", suffix, err)
+                return false
+            }
+            if len(actual) != len(invoices) {
+                t.Logf("%s: %d invoices != %d", suffix, len(actual),
+                    len(invoices))
+                return false
+            }
+            for i := range actual {
+                if !sameInvoice(actual[i], invoices[i]) {
+                    t.Logf("%s: %v != %v", suffix, *actual[i],
+                        *invoices[i])
+                    return false
+                }
+            }
+            return true
+        }
+        if err := quick.Check(roundTrip, &quick.Config{MaxCount: 50,
+            Rand: rand.New(rand.NewSource(1))}); err != nil {
+            t.Error(suffix, err)
+        }
+    }
+}
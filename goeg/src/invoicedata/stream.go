@@ -0,0 +1,130 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "fmt"
+    "io"
+)
+
+// An InvoiceReader reads one invoice at a time so that files of any size
+// can be processed in bounded memory. Next returns io.EOF once there are
+// no more invoices.
+type InvoiceReader interface {
+    Next() (*Invoice, error)
+}
+
+// An InvoiceWriter writes one invoice at a time; Close must be called
+// after the last Write to finish the file (it doesn't close the
+// underlying writer).
+type InvoiceWriter interface {
+    Write(invoice *Invoice) error
+    Close() error
+}
+
+// InvoicesStreamer is implemented by the marshalers that can read and
+// write incrementally. Both functions read or write the file's header
+// straight away so that a file of the wrong kind is reported at once.
+type InvoicesStreamer interface {
+    NewInvoiceReader(reader io.Reader) (InvoiceReader, error)
+    NewInvoiceWriter(writer io.Writer) (InvoiceWriter, error)
+}
+
+func readAllInvoices(reader InvoiceReader) ([]*Invoice, error) {
+    var invoices []*Invoice
+    for {
+        invoice, err := reader.Next()
+        if err == io.EOF {
+            return invoices, nil
+        } else if err != nil {
+            return nil, err
+        }
+        invoices = append(invoices, invoice)
+    }
+}
+
+func writeAllInvoices(writer InvoiceWriter, invoices []*Invoice) error {
+    for _, invoice := range invoices {
+        if err := writer.Write(invoice); err != nil {
+            return err
+        }
+    }
+    return writer.Close()
+}
+
+func streamerForSuffix(suffix string) InvoicesStreamer {
+    switch suffix {
+    case ".inv":
+        return InvMarshaler{}
+    case ".jsn", ".json":
+        return JSONMarshaler{}
+    case ".txt":
+        return TxtMarshaler{}
+    case ".xml":
+        return XMLMarshaler{}
+    }
+    return nil
+}
+
+// streamInvoiceFile copies the invoices one at a time; it can only be
+// used if both files' formats have streamers.
+func streamInvoiceFile(inFilename, outFilename string) error {
+    inStreamer := streamerForSuffix(suffixOf(inFilename))
+    outStreamer := streamerForSuffix(suffixOf(outFilename))
+    if inStreamer == nil || outStreamer == nil {
+        return fmt.Errorf("cannot stream from %s to %s", inFilename,
+            outFilename)
+    }
+    file, closer, err := openInvoiceFile(inFilename)
+    if closer != nil {
+        defer closer()
+    }
+    if err != nil {
+        return err
+    }
+    reader, err := inStreamer.NewInvoiceReader(file)
+    if err != nil {
+        return err
+    }
+    return writeInvoiceStream(reader, outFilename, outStreamer)
+}
+
+// writeInvoiceStream writes each invoice the reader returns to the named
+// file as soon as it is read.
+func writeInvoiceStream(reader InvoiceReader, outFilename string,
+    outStreamer InvoicesStreamer) error {
+    outFile, outCloser, err := createInvoiceFile(outFilename)
+    if outCloser != nil {
+        defer outCloser()
+    }
+    if err != nil {
+        return err
+    }
+    writer, err := outStreamer.NewInvoiceWriter(outFile)
+    if err != nil {
+        return err
+    }
+    for {
+        invoice, err := reader.Next()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return err
+        }
+        if err = writer.Write(invoice); err != nil {
+            return err
+        }
+    }
+    return writer.Close()
+}
@@ -0,0 +1,150 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "io/ioutil"
+    "os"
+    "testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+    invoices, err := readInvoices(bytes.NewBufferString(sampleTxt), ".txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    invoices[2].Items[0].Quantity = -400 // .inv quantities are int16s
+    for _, suffix := range []string{".inv", ".jsn", ".txt", ".xml"} {
+        streamer := streamerForSuffix(suffix)
+        var buffer bytes.Buffer
+        writer, err := streamer.NewInvoiceWriter(&buffer)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, invoice := range invoices {
+            if err := writer.Write(invoice); err != nil {
+                t.Fatalf("%s: %v", suffix, err)
+            }
+        }
+        if err := writer.Close(); err != nil {
+            t.Fatalf("%s: %v", suffix, err)
+        }
+        reader, err := streamer.NewInvoiceReader(&buffer)
+        if err != nil {
+            t.Fatalf("%s: %v", suffix, err)
+        }
+        for i := 0; ; i++ {
+            invoice, err := reader.Next()
+            if err == io.EOF {
+                if i != len(invoices) {
+                    t.Fatalf("%s: read %d of %d invoices", suffix, i,
+                        len(invoices))
+                }
+                break
+            } else if err != nil {
+                t.Fatalf("%s: %v", suffix, err)
+            }
+            if !sameInvoice(invoice, invoices[i]) {
+                t.Errorf("%s: %v != %v", suffix, *invoice, *invoices[i])
+            }
+        }
+    }
+}
+
+// A streaming writer to a file fills in the invoice count so that the
+// result is the same as MarshalInvoices', and until then the file reads
+// as the invoices written so far.
+func TestInvStreamSeeking(t *testing.T) {
+    invoices, err := readInvoices(bytes.NewBufferString(sampleTxt), ".txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    file, err := ioutil.TempFile("", "invoicedata")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(file.Name())
+    defer file.Close()
+    writer, err := InvMarshaler{}.NewInvoiceWriter(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = writer.Write(invoices[0]); err != nil {
+        t.Fatal(err)
+    }
+    if err = writer.(*invInvoiceWriter).writer.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    data, err := ioutil.ReadFile(file.Name())
+    if err != nil {
+        t.Fatal(err)
+    }
+    interrupted, err := readInvoices(bytes.NewReader(data), ".inv")
+    if err != nil || len(interrupted) != 1 {
+        t.Fatalf("interrupted file read as %d invoices: %v",
+            len(interrupted), err)
+    }
+    if err = writeAllInvoices(writer, invoices[1:]); err != nil {
+        t.Fatal(err)
+    }
+    var expected bytes.Buffer
+    if err = (InvMarshaler{}).MarshalInvoices(&expected, invoices);
+        err != nil {
+        t.Fatal(err)
+    }
+    actual, err := ioutil.ReadFile(file.Name())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(actual, expected.Bytes()) {
+        t.Fatal("streamed .inv file differs from the marshaled one")
+    }
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+    return 0, errors.New("read past the first invoice")
+}
+
+// The readers must return each invoice without reading the whole file.
+func TestStreamReadersAreIncremental(t *testing.T) {
+    invoices, err := readInvoices(bytes.NewBufferString(sampleTxt), ".txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    invoices = append(invoices, invoices[0])
+    for _, suffix := range []string{".inv", ".jsn", ".txt", ".xml"} {
+        var buffer bytes.Buffer
+        if err := writeInvoices(&buffer, suffix, invoices); err != nil {
+            t.Fatal(err)
+        }
+        // Enough for the first invoice but not all of them.
+        prefix := buffer.Bytes()[:buffer.Len()*3/4]
+        streamer := streamerForSuffix(suffix)
+        reader, err := streamer.NewInvoiceReader(io.MultiReader(
+            bytes.NewReader(prefix), failingReader{}))
+        if err != nil {
+            t.Fatalf("%s: %v", suffix, err)
+        }
+        if invoice, err := reader.Next(); err != nil {
+            t.Fatalf("%s: %v", suffix, err)
+        } else if !sameInvoice(invoice, invoices[0]) {
+            t.Errorf("%s: %v != %v", suffix, *invoice, *invoices[0])
+        }
+    }
+}
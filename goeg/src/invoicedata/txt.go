@@ -20,35 +20,56 @@ import (
     "io"
     "strings"
     "time"
+    "unicode"
 )
 
 const noteSep = ":"
 
 type TxtMarshaler struct{}
 
-func (TxtMarshaler) MarshalInvoices(writer io.Writer,
+func (marshaler TxtMarshaler) MarshalInvoices(writer io.Writer,
     invoices []*Invoice) error {
+    invoiceWriter, err := marshaler.NewInvoiceWriter(writer)
+    if err != nil {
+        return err
+    }
+    return writeAllInvoices(invoiceWriter, invoices)
+}
+
+type txtInvoiceWriter struct {
+    writer *bufio.Writer
+    write  writerFunc
+}
+
+func (TxtMarshaler) NewInvoiceWriter(writer io.Writer) (InvoiceWriter,
+    error) {
     bufferedWriter := bufio.NewWriter(writer)
-    defer bufferedWriter.Flush()
     var write writerFunc = func(format string,
         args ...interface{}) error {
         _, err := fmt.Fprintf(bufferedWriter, format, args...)
         return err
     }
     if err := write("%s %d\n", fileType, fileVersion); err != nil {
-        return err
-    }
-    for _, invoice := range invoices {
-        if err := write.writeInvoice(invoice); err != nil {
-            return err
-        }
+        return nil, err
     }
-    return nil
+    return &txtInvoiceWriter{bufferedWriter, write}, nil
+}
+
+func (writer *txtInvoiceWriter) Write(invoice *Invoice) error {
+    return writer.write.writeInvoice(invoice)
+}
+
+func (writer *txtInvoiceWriter) Close() error {
+    return writer.writer.Flush()
 }
 
 type writerFunc func(string, ...interface{}) error
 
 func (write writerFunc) writeInvoice(invoice *Invoice) error {
+    if err := checkFits(invoice, "a .txt file", txtNoteFits,
+        txtItemIdFits); err != nil {
+        return err
+    }
     note := ""
     if invoice.Note != "" {
         note = noteSep + " " + invoice.Note
@@ -71,50 +92,100 @@ func (write writerFunc) writeItems(items []*Item) error {
         if item.Note != "" {
             note = noteSep + " " + item.Note
         }
-        if err := write("ITEM ID=%s PRICE=%.2f QUANTITY=%d%s\n", item.Id,
-            item.Price, item.Quantity, note); err != nil {
+        if err := write("ITEM ID=%s PRICE=%s CURRENCY=%s QUANTITY=%d%s\n",
+            item.Id, item.Price.Decimal(), item.Price.Currency,
+            item.Quantity, note); err != nil {
             return err
         }
     }
     return nil
 }
 
-func (TxtMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
+// txtNoteFits returns whether the note survives being read back: notes
+// end at the end of their line and lose the space around them.
+func txtNoteFits(note string) bool {
+    return !strings.Contains(note, "\n") && strings.TrimSpace(note) == note
+}
+
+// txtItemIdFits returns whether the item id survives being read back: ids
+// are scanned as single words and notes start at the first noteSep.
+func txtItemIdFits(id string) bool {
+    return id != "" && !strings.Contains(id, noteSep) &&
+        strings.IndexFunc(id, unicode.IsSpace) == -1
+}
+
+func (marshaler TxtMarshaler) UnmarshalInvoices(reader io.Reader) (
+    []*Invoice, error) {
+    invoiceReader, err := marshaler.NewInvoiceReader(reader)
+    if err != nil {
+        return nil, err
+    }
+    return readAllInvoices(invoiceReader)
+}
+
+// A txtInvoiceReader can only tell that an invoice has ended when it
+// reads the next invoice's line (or reaches the end of the file), so it
+// holds that invoice in pending until Next is called again.
+type txtInvoiceReader struct {
+    reader  *bufio.Reader
+    version int
+    lino    int
+    pending []*Invoice // At most one
+    eof     bool
+}
+
+func (TxtMarshaler) NewInvoiceReader(reader io.Reader) (InvoiceReader,
     error) {
     bufferedReader := bufio.NewReader(reader)
-    if err := checkTxtVersion(bufferedReader); err != nil {
+    version, err := checkTxtVersion(bufferedReader)
+    if err != nil {
         return nil, err
     }
-    var invoices []*Invoice
-    eof := false
-    for lino := 2; !eof; lino++ {
-        line, err := bufferedReader.ReadString('\n')
+    return &txtInvoiceReader{reader: bufferedReader, version: version,
+        lino: 1}, nil
+}
+
+func (reader *txtInvoiceReader) Next() (*Invoice, error) {
+    for !reader.eof {
+        line, err := reader.reader.ReadString('\n')
         if err == io.EOF {
-            err = nil       // io.EOF isn't really an error
-            eof = true      // this will end the loop at the next iteration
+            reader.eof = true // io.EOF isn't really an error
         } else if err != nil {
             return nil, err // finish immediately for real errors
         }
-        if invoices, err = parseTxtLine(lino, line, invoices); err != nil {
+        reader.lino++
+        invoices, err := parseTxtLine(reader.version, reader.lino, line,
+            reader.pending)
+        if err != nil {
             return nil, err
         }
+        if len(invoices) > 1 {
+            reader.pending = invoices[1:]
+            return invoices[0], nil
+        }
+        reader.pending = invoices
+    }
+    if len(reader.pending) > 0 {
+        invoice := reader.pending[0]
+        reader.pending = nil
+        return invoice, nil
     }
-    return invoices, nil
+    return nil, io.EOF
 }
 
-func checkTxtVersion(bufferedReader *bufio.Reader) error {
-    var version int
-    if _, err := fmt.Fscanf(bufferedReader, "INVOICES %d\n", &version);
+func checkTxtVersion(bufferedReader *bufio.Reader) (version int,
+    err error) {
+    if _, err = fmt.Fscanf(bufferedReader, "INVOICES %d\n", &version);
         err != nil {
-        return errors.New("cannot read non-invoices text file")
-    } else if version > fileVersion {
-        return fmt.Errorf("version %d is too new to read", version)
+        err = errors.New("cannot read non-invoices text file")
+    } else {
+        err = checkVersion(version)
     }
-    return nil
+    return version, err
 }
 
-func parseTxtLine(lino int, line string, invoices []*Invoice) ([]*Invoice,
-    error) {
+func parseTxtLine(version, lino int, line string, invoices []*Invoice) (
+    []*Invoice, error) {
     var err error
     if strings.HasPrefix(line, "INVOICE") {
         var invoice *Invoice
@@ -125,7 +196,7 @@ func parseTxtLine(lino int, line string, invoices []*Invoice) ([]*Invoice,
             err = fmt.Errorf("item outside of an invoice line %d", lino)
         } else {
             var item *Item
-            item, err = parseTxtItem(lino, line)
+            item, err = parseTxtItem(version, lino, line)
             items := &invoices[len(invoices)-1].Items
             *items = append(*items, item)
         }
@@ -154,11 +225,27 @@ func parseTxtInvoice(lino int, line string) (invoice *Invoice,
     return invoice, nil
 }
 
-func parseTxtItem(lino int, line string) (item *Item, err error) {
+func parseTxtItem(version, lino int, line string) (item *Item,
+    err error) {
     item = &Item{}
-    if _, err = fmt.Sscanf(line, "ITEM ID=%s PRICE=%f QUANTITY=%d",
-        &item.Id, &item.Price, &item.Quantity); err != nil {
-        return nil, fmt.Errorf("invalid item %v line %d", err, lino)
+    if version < 103 {
+        var price float64
+        if _, err = fmt.Sscanf(line, "ITEM ID=%s PRICE=%f QUANTITY=%d",
+            &item.Id, &price, &item.Quantity); err != nil {
+            return nil, fmt.Errorf("invalid item %v line %d", err, lino)
+        }
+        item.Price = MoneyFromFloat(price, defaultCurrency)
+    } else {
+        var price, currency string
+        if _, err = fmt.Sscanf(line, "ITEM ID=%s PRICE=%s CURRENCY=%s "+
+            "QUANTITY=%d", &item.Id, &price, &currency, &item.Quantity);
+            err != nil {
+            return nil, fmt.Errorf("invalid item %v line %d", err, lino)
+        }
+        if item.Price, err = ParseMoney(price + " " + currency);
+            err != nil {
+            return nil, fmt.Errorf("invalid item %v line %d", err, lino)
+        }
     }
     if i := strings.Index(line, noteSep); i > -1 {
         item.Note = strings.TrimSpace(line[i+len(noteSep):])
@@ -0,0 +1,83 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "fmt"
+)
+
+type Violation struct {
+    Invoice int // Index of the invoice in the file, counting from 1
+    Item    int // Index of the item in the invoice, counting from 1; or 0
+    Message string
+}
+
+func (violation Violation) String() string {
+    if violation.Item == 0 {
+        return fmt.Sprintf("invoice #%d: %s", violation.Invoice,
+            violation.Message)
+    }
+    return fmt.Sprintf("invoice #%d item #%d: %s", violation.Invoice,
+        violation.Item, violation.Message)
+}
+
+// validateInvoices returns every violation found rather than stopping at
+// the first so that all of a file's problems can be fixed in one go.
+func validateInvoices(invoices []*Invoice) []Violation {
+    validator := newInvoiceValidator()
+    for _, invoice := range invoices {
+        validator.check(invoice)
+    }
+    return validator.violations
+}
+
+// An invoiceValidator checks one invoice at a time so that a stream of
+// invoices can be validated without holding them all in memory.
+type invoiceValidator struct {
+    seen       map[int]int // key is invoice ID, value is its index
+    count      int         // The number of invoices checked
+    violations []Violation
+}
+
+func newInvoiceValidator() *invoiceValidator {
+    return &invoiceValidator{seen: make(map[int]int)}
+}
+
+func (validator *invoiceValidator) check(invoice *Invoice) {
+    i := validator.count
+    validator.count++
+    add := func(j int, format string, args ...interface{}) {
+        validator.violations = append(validator.violations,
+            Violation{i + 1, j, fmt.Sprintf(format, args...)})
+    }
+    if first, found := validator.seen[invoice.Id]; found {
+        add(0, "duplicate ID %d (first used by invoice #%d)", invoice.Id,
+            first+1)
+    } else {
+        validator.seen[invoice.Id] = i
+    }
+    if !invoice.Due.After(invoice.Raised) {
+        add(0, "ID %d is due %s which is not after it was raised on %s",
+            invoice.Id, invoice.Due.Format(dateFormat),
+            invoice.Raised.Format(dateFormat))
+    }
+    for j, item := range invoice.Items {
+        if item == nil {
+            add(j+1, "ID %d has a null item", invoice.Id)
+        } else if item.Quantity <= 0 {
+            add(j+1, "ID %s has nonpositive quantity %d", item.Id,
+                item.Quantity)
+        }
+    }
+}
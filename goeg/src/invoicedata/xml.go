@@ -15,20 +15,18 @@ package main
 
 import (
     "encoding/xml"
+    "errors"
     "fmt"
     "io"
+    "strconv"
     "strings"
     "time"
+    "unicode"
+    "unicode/utf8"
 )
 
 type XMLMarshaler struct{}
 
-type XMLInvoices struct {
-    XMLName xml.Name      `xml:"INVOICES"`
-    Version int           `xml:"version,attr"`
-    Invoice []*XMLInvoice `xml:"INVOICE"`
-}
-
 type XMLInvoice struct {
     XMLName    xml.Name   `xml:"INVOICE"`
     Id         int        `xml:",attr"`
@@ -43,25 +41,13 @@ type XMLInvoice struct {
 type XMLItem struct {
     XMLName  xml.Name `xml:"ITEM"`
     Id       string   `xml:",attr"`
-    Price    float64  `xml:",attr"`
+    Price    string   `xml:",attr"` // float64 before version 103
+    Currency string   `xml:",attr,omitempty"`
     Quantity int      `xml:",attr"`
     Note     string   `xml:"NOTE"`
 }
 
 
-func XMLInvoicesForInvoices(invoices []*Invoice) *XMLInvoices {
-    xmlInvoices := &XMLInvoices{
-        Version: fileVersion,
-        Invoice: make([]*XMLInvoice, 0, len(invoices)),
-    }
-    for _, invoice := range invoices {
-        xmlInvoices.Invoice = append(xmlInvoices.Invoice,
-            XMLInvoiceForInvoice(invoice))
-    }
-    return xmlInvoices
-}
-
-
 func XMLInvoiceForInvoice(invoice *Invoice) *XMLInvoice {
     xmlInvoice := &XMLInvoice{
         Id:         invoice.Id,
@@ -75,7 +61,8 @@ func XMLInvoiceForInvoice(invoice *Invoice) *XMLInvoice {
     for _, item := range invoice.Items {
         xmlItem := &XMLItem{
             Id:       item.Id,
-            Price:    item.Price,
+            Price:    item.Price.Decimal(),
+            Currency: item.Price.Currency,
             Quantity: item.Quantity,
             Note:     item.Note,
         }
@@ -85,21 +72,8 @@ func XMLInvoiceForInvoice(invoice *Invoice) *XMLInvoice {
 }
 
 
-func (xmlInvoices *XMLInvoices) Invoices() (invoices []*Invoice,
+func (xmlInvoice *XMLInvoice) Invoice(version int) (invoice *Invoice,
     err error) {
-    invoices = make([]*Invoice, 0, len(xmlInvoices.Invoice))
-    for _, xmlInvoice := range xmlInvoices.Invoice {
-        invoice, err := xmlInvoice.Invoice()
-        if err != nil {
-            return nil, err
-        }
-        invoices = append(invoices, invoice)
-    }
-    return invoices, nil
-}
-
-
-func (xmlInvoice *XMLInvoice) Invoice() (invoice *Invoice, err error) {
     invoice = &Invoice{
         Id:         xmlInvoice.Id,
         CustomerId: xmlInvoice.CustomerId,
@@ -118,37 +92,180 @@ func (xmlInvoice *XMLInvoice) Invoice() (invoice *Invoice, err error) {
     for _, xmlItem := range xmlInvoice.Item {
         item := &Item{
             Id:       xmlItem.Id,
-            Price:    xmlItem.Price,
             Quantity: xmlItem.Quantity,
             Note:     strings.TrimSpace(xmlItem.Note),
         }
+        if item.Price, err = xmlItem.price(version); err != nil {
+            return nil, err
+        }
         invoice.Items = append(invoice.Items, item)
     }
     return invoice, nil
 }
 
 
-func (XMLMarshaler) MarshalInvoices(writer io.Writer,
+func (xmlItem *XMLItem) price(version int) (Money, error) {
+    if version < 103 {
+        x, err := strconv.ParseFloat(xmlItem.Price, 64)
+        return MoneyFromFloat(x, defaultCurrency), err
+    }
+    return ParseMoney(xmlItem.Price + " " + xmlItem.Currency)
+}
+
+
+func (marshaler XMLMarshaler) MarshalInvoices(writer io.Writer,
     invoices []*Invoice) error {
-    if _, err := writer.Write([]byte(xml.Header)); err != nil {
+    invoiceWriter, err := marshaler.NewInvoiceWriter(writer)
+    if err != nil {
         return err
     }
-    xmlInvoices := XMLInvoicesForInvoices(invoices)
+    return writeAllInvoices(invoiceWriter, invoices)
+}
+
+
+// An xmlInvoiceWriter writes an INVOICES element whose version attribute
+// is the fileVersion and which holds one XMLInvoice per invoice.
+type xmlInvoiceWriter struct {
+    encoder *xml.Encoder
+}
+
+func (XMLMarshaler) NewInvoiceWriter(writer io.Writer) (InvoiceWriter,
+    error) {
+    if _, err := writer.Write([]byte(xml.Header)); err != nil {
+        return nil, err
+    }
     encoder := xml.NewEncoder(writer)
-    return encoder.Encode(xmlInvoices)
+    if err := encoder.EncodeToken(xmlInvoicesStart(fileVersion));
+        err != nil {
+        return nil, err
+    }
+    return &xmlInvoiceWriter{encoder}, nil
 }
 
+func xmlInvoicesStart(version int) xml.StartElement {
+    return xml.StartElement{Name: xml.Name{Local: "INVOICES"},
+        Attr: []xml.Attr{{Name: xml.Name{Local: "version"},
+            Value: strconv.Itoa(version)}}}
+}
+
+func (writer *xmlInvoiceWriter) Write(invoice *Invoice) error {
+    if err := checkFits(invoice, "an .xml file", xmlNoteFits,
+        xmlTextFits); err != nil {
+        return err
+    }
+    return writer.encoder.Encode(XMLInvoiceForInvoice(invoice))
+}
 
-func (XMLMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
+// xmlNoteFits returns whether the note survives being read back: the
+// reader trims the space around notes.
+func xmlNoteFits(note string) bool {
+    return xmlTextFits(note) && strings.TrimSpace(note) == note
+}
+
+// xmlTextFits returns whether the text survives being read back: the
+// encoder replaces characters that XML doesn't allow with U+FFFD.
+func xmlTextFits(text string) bool {
+    if !utf8.ValidString(text) {
+        return false
+    }
+    for _, r := range text {
+        if !isXMLChar(r) {
+            return false
+        }
+    }
+    return true
+}
+
+// isXMLChar returns whether r is in the XML 1.0 Char production.
+func isXMLChar(r rune) bool {
+    return r == '\t' || r == '\n' || r == '\r' ||
+        (r >= 0x20 && r <= 0xD7FF) || (r >= 0xE000 && r <= 0xFFFD) ||
+        (r >= 0x10000 && r <= unicode.MaxRune)
+}
+
+func (writer *xmlInvoiceWriter) Close() error {
+    if err := writer.encoder.EncodeToken(
+        xmlInvoicesStart(fileVersion).End()); err != nil {
+        return err
+    }
+    return writer.encoder.Flush()
+}
+
+
+func (marshaler XMLMarshaler) UnmarshalInvoices(reader io.Reader) (
+    []*Invoice, error) {
+    invoiceReader, err := marshaler.NewInvoiceReader(reader)
+    if err != nil {
+        return nil, err
+    }
+    return readAllInvoices(invoiceReader)
+}
+
+
+// An xmlInvoiceReader reads tokens until it reaches an INVOICE element
+// and then decodes just that element.
+type xmlInvoiceReader struct {
+    decoder *xml.Decoder
+    version int
+}
+
+func (XMLMarshaler) NewInvoiceReader(reader io.Reader) (InvoiceReader,
     error) {
-    xmlInvoices := &XMLInvoices{}
     decoder := xml.NewDecoder(reader)
-    if err := decoder.Decode(xmlInvoices); err != nil {
-        return nil, err
+    for {
+        token, err := decoder.Token()
+        if err != nil {
+            return nil, err
+        }
+        if start, ok := token.(xml.StartElement); ok {
+            if start.Name.Local != "INVOICES" {
+                return nil, errors.New("cannot read non-invoices xml file")
+            }
+            version, err := xmlVersion(start)
+            if err != nil {
+                return nil, err
+            }
+            return &xmlInvoiceReader{decoder, version}, nil
+        }
+    }
+}
+
+func xmlVersion(start xml.StartElement) (version int, err error) {
+    for _, attr := range start.Attr {
+        if attr.Name.Local == "version" {
+            if version, err = strconv.Atoi(attr.Value); err != nil {
+                return 0, fmt.Errorf("invalid version %q", attr.Value)
+            }
+        }
+    }
+    if err := checkVersion(version); err != nil {
+        return 0, err
     }
-    if xmlInvoices.Version > fileVersion {
-        return nil, fmt.Errorf("version %d is too new to read",
-            xmlInvoices.Version)
+    return version, nil
+}
+
+func (reader *xmlInvoiceReader) Next() (*Invoice, error) {
+    for {
+        token, err := reader.decoder.Token()
+        if err != nil {
+            return nil, err
+        }
+        switch token := token.(type) {
+        case xml.StartElement:
+            if token.Name.Local != "INVOICE" {
+                if err := reader.decoder.Skip(); err != nil {
+                    return nil, err
+                }
+                continue
+            }
+            var xmlInvoice XMLInvoice
+            if err := reader.decoder.DecodeElement(&xmlInvoice, &token);
+                err != nil {
+                return nil, err
+            }
+            return xmlInvoice.Invoice(reader.version)
+        case xml.EndElement: // </INVOICES>
+            return nil, io.EOF
+        }
     }
-    return xmlInvoices.Invoices()
 }
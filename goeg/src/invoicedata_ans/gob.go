@@ -17,9 +17,8 @@ import (
 //    "bytes"
     "encoding/gob"
     "errors"
-    "fmt"
     "io"
-//    "time"
+    "time"
 )
 /*
 type GobInvoice struct {
@@ -72,18 +71,78 @@ func (invoice *Invoice) GobDecode(data []byte) error {
     return nil
 }
 */
-type GobMarshaler struct{}
+// Version is the file version to write; 0 means the current fileVersion.
+type GobMarshaler struct {
+    Version int
+}
+
+// Gob matches struct fields by name, so version 100 files are written
+// with types that simply lack the fields that were added later.
+type GobInvoice100 struct {
+    Id         int
+    CustomerId int
+    Raised     time.Time
+    Due        time.Time
+    Paid       bool
+    Note       string
+    Items      []*GobItem100
+}
 
-func (GobMarshaler) MarshalInvoices(writer io.Writer,
-    invoices []*Invoice) error {
+type GobItem100 struct {
+    Id       string
+    Price    float64
+    Quantity int
+    Note     string
+}
+
+type GobInvoice101 struct {
+    Id           int
+    CustomerId   int
+    DepartmentId string
+    Raised       time.Time
+    Due          time.Time
+    Paid         bool
+    Note         string
+    Items        []*Item
+}
+
+func (marshaler GobMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) (err error) {
+    version := targetVersion(marshaler.Version)
+    if invoices, err = invoicesForVersion(invoices, version); err != nil {
+        return err
+    }
     encoder := gob.NewEncoder(writer)
     if err := encoder.Encode(magicNumber); err != nil {
         return err
     }
-    if err := encoder.Encode(fileVersion); err != nil {
+    if err := encoder.Encode(version); err != nil {
         return err
     }
-    return encoder.Encode(invoices)
+    if version >= 102 {
+        return encoder.Encode(invoices)
+    }
+    if version == 101 {
+        gobInvoices := make([]*GobInvoice101, 0, len(invoices))
+        for _, invoice := range invoices {
+            gobInvoices = append(gobInvoices, &GobInvoice101{invoice.Id,
+                invoice.CustomerId, invoice.DepartmentId, invoice.Raised,
+                invoice.Due, invoice.Paid, invoice.Note, invoice.Items})
+        }
+        return encoder.Encode(gobInvoices)
+    }
+    gobInvoices := make([]*GobInvoice100, 0, len(invoices))
+    for _, invoice := range invoices {
+        gobInvoice := &GobInvoice100{invoice.Id, invoice.CustomerId,
+            invoice.Raised, invoice.Due, invoice.Paid, invoice.Note,
+            make([]*GobItem100, 0, len(invoice.Items))}
+        for _, item := range invoice.Items {
+            gobInvoice.Items = append(gobInvoice.Items, &GobItem100{
+                item.Id, item.Price, item.Quantity, item.Note})
+        }
+        gobInvoices = append(gobInvoices, gobInvoice)
+    }
+    return encoder.Encode(gobInvoices)
 }
 
 func (GobMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
@@ -100,17 +159,15 @@ func (GobMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
     if err := decoder.Decode(&version); err != nil {
         return nil, err
     }
-    if version > fileVersion {
-        return nil, fmt.Errorf("version %d is too new to read", version)
+    if err := checkVersion(version); err != nil {
+        return nil, err
     }
     var invoices []*Invoice
     if err := decoder.Decode(&invoices); err != nil {
         return nil, err
     }
-    if version < fileVersion {
-        if err := update(invoices); err != nil {
-            return nil, err
-        }
+    if err := migrate(invoices, version, fileVersion); err != nil {
+        return nil, err
     }
     return invoices, nil
 }
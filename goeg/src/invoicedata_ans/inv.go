@@ -22,28 +22,35 @@ import (
     "time"
 )
 
-type InvMarshaler struct{}
+// Version is the file version to write; 0 means the current fileVersion.
+type InvMarshaler struct {
+    Version int
+}
 
 const invDateFormat = "20060102"
 
 var byteOrder = binary.LittleEndian
 
-func (InvMarshaler) MarshalInvoices(writer io.Writer,
-    invoices []*Invoice) error {
+func (marshaler InvMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) (err error) {
+    version := targetVersion(marshaler.Version)
+    if invoices, err = invoicesForVersion(invoices, version); err != nil {
+        return err
+    }
     var write invWriterFunc = func(x interface{}) error {
         return binary.Write(writer, byteOrder, x)
     }
     if err := write(uint32(magicNumber)); err != nil {
         return err
     }
-    if err := write(uint16(fileVersion)); err != nil {
+    if err := write(uint16(version)); err != nil {
         return err
     }
     if err := write(int32(len(invoices))); err != nil {
         return err
     }
     for _, invoice := range invoices {
-        if err := write.writeInvoice(invoice); err != nil {
+        if err := write.writeInvoice(version, invoice); err != nil {
             return err
         }
     }
@@ -52,14 +59,25 @@ func (InvMarshaler) MarshalInvoices(writer io.Writer,
 
 type invWriterFunc func(interface{}) error
 
-func (write invWriterFunc) writeInvoice(invoice *Invoice) error {
+func (write invWriterFunc) writeInvoice(version int,
+    invoice *Invoice) error {
     for _, i := range []int{invoice.Id, invoice.CustomerId} {
         if err := write(int32(i)); err != nil {
             return err
         }
     }
-    if err := write.writeString(invoice.DepartmentId); err != nil {
-        return err
+    if version >= 101 {
+        if err := write.writeString(invoice.DepartmentId); err != nil {
+            return err
+        }
+    }
+    if version >= 102 {
+        for _, text := range []string{invoice.Currency,
+            invoice.Jurisdiction} {
+            if err := write.writeString(text); err != nil {
+                return err
+            }
+        }
     }
     for _, date := range []time.Time{invoice.Raised, invoice.Due} {
         if err := write.writeDate(date); err != nil {
@@ -76,7 +94,7 @@ func (write invWriterFunc) writeInvoice(invoice *Invoice) error {
         return err
     }
     for _, item := range invoice.Items {
-        if err := write.writeItem(item); err != nil {
+        if err := write.writeItem(version, item); err != nil {
             return err
         }
     }
@@ -106,15 +124,18 @@ func (write invWriterFunc) writeString(s string) error {
     return write([]byte(s))
 }
 
-func (write invWriterFunc) writeItem(item *Item) error {
+func (write invWriterFunc) writeItem(version int, item *Item) error {
     if err := write.writeString(item.Id); err != nil {
         return err
     }
     if err := write(item.Price); err != nil {
         return err
     }
-    for _, i := range []int{item.Quantity, item.TaxBand} {
-        if err := write(int16(i)); err != nil {
+    if err := write(int16(item.Quantity)); err != nil {
+        return err
+    }
+    if version >= 101 {
+        if err := write(int16(item.TaxBand)); err != nil {
             return err
         }
     }
@@ -139,6 +160,9 @@ func (InvMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
         }
         invoices = append(invoices, invoice)
     }
+    if err := migrate(invoices, version, fileVersion); err != nil {
+        return nil, err
+    }
     return invoices, nil
 }
 
@@ -172,10 +196,7 @@ func checkInvVersion(reader io.Reader) (int, error) {
     if err := binary.Read(reader, byteOrder, &version); err != nil {
         return 0, err
     }
-    if version > fileVersion {
-        return 0, fmt.Errorf("version %d is too new to read", version)
-    }
-    return int(version), nil
+    return int(version), checkVersion(int(version))
 }
 
 func readInvInvoice(version int, reader io.Reader) (invoice *Invoice,
@@ -186,11 +207,19 @@ func readInvInvoice(version int, reader io.Reader) (invoice *Invoice,
             return nil, err
         }
     }
-    if version == fileVersion {
+    if version >= 101 {
         if invoice.DepartmentId, err = readInvString(reader); err != nil {
             return nil, err
         }
     }
+    if version >= 102 {
+        for _, text := range []*string{&invoice.Currency,
+            &invoice.Jurisdiction} {
+            if *text, err = readInvString(reader); err != nil {
+                return nil, err
+            }
+        }
+    }
     for _, date := range []*time.Time{&invoice.Raised, &invoice.Due} {
         if *date, err = readInvDate(reader); err != nil {
             return nil, err
@@ -210,9 +239,6 @@ func readInvInvoice(version int, reader io.Reader) (invoice *Invoice,
         err != nil {
         return nil, err
     }
-    if version < fileVersion {
-        updateInvoice(invoice)
-    }
     return invoice, nil
 }
 
@@ -260,7 +286,7 @@ func readInvItem(version int, reader io.Reader) (item *Item, err error) {
     if item.Quantity, err = readIntFromInt16(reader); err != nil {
         return nil, err
     }
-    if version == fileVersion {
+    if version >= 101 {
         if item.TaxBand, err = readIntFromInt16(reader); err != nil {
             return nil, err
         }
@@ -268,10 +294,5 @@ func readInvItem(version int, reader io.Reader) (item *Item, err error) {
     if item.Note, err = readInvString(reader); err != nil {
         return nil, err
     }
-    if version < fileVersion {
-        if err = updateItem(item); err != nil {
-            return nil, err
-        }
-    }
     return item, nil
 }
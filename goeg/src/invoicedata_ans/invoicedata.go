@@ -28,7 +28,7 @@ import (
 
 const (
     magicNumber = 0x125D
-    fileVersion = 101
+    fileVersion = 102
     fileType    = "INVOICES"
     dateFormat  = "2006-01-02" // This date must always be used (see text).
 )
@@ -37,6 +37,8 @@ type Invoice struct { // fileVersion
     Id           int       // 100
     CustomerId   int       // 100
     DepartmentId string    // 101
+    Currency     string    // 102
+    Jurisdiction string    // 102
     Raised       time.Time // 100
     Due          time.Time // 100
     Paid         bool      // 100
@@ -62,22 +64,43 @@ type InvoicesUnmarshaler interface {
 
 func main() {
     log.SetFlags(0)
-    if len(os.Args) != 3 || os.Args[1] == "-h" || os.Args[1] == "--help" {
-        log.Fatalf("usage: %s infile.ext outfile.ext\n"+
+    version := fileVersion
+    args := os.Args[1:]
+    if len(args) > 0 && args[0] == "render" {
+        render(args[1:])
+        return
+    }
+    if len(args) > 1 && (args[0] == "-v" || args[0] == "--version") {
+        var err error
+        if version, err = strconv.Atoi(args[1]); err != nil {
+            log.Fatalln("invalid version:", args[1])
+        }
+        args = args[2:]
+    }
+    if len(args) != 2 || args[0] == "-h" || args[0] == "--help" {
+        log.Fatalf("usage: %s [-v|--version N] infile.ext outfile.ext\n"+
+            "       %s render [options] infile.ext outdir\n"+
             ".ext may be any of .gob, .inv, .jsn, .json, .txt, "+
-            "or .xml, optionally gzipped (e.g., .gob.gz)\n",
-            filepath.Base(os.Args[0]))
+            "or .xml, optionally gzipped (e.g., .gob.gz)\n"+
+            "files of versions %d-%d are read and upgraded; the "+
+            "output is written as version N (default %d)\n",
+            filepath.Base(os.Args[0]), filepath.Base(os.Args[0]),
+            minFileVersion, fileVersion, fileVersion)
     }
-    inFilename, outFilename := os.Args[1], os.Args[2]
+    inFilename, outFilename := args[0], args[1]
     if inFilename == outFilename {
         log.Fatalln("won't overwrite a file with itself")
     }
+    if err := checkVersion(version); err != nil {
+        log.Fatalln("Cannot write:", err)
+    }
 
     invoices, err := readInvoiceFile(inFilename)
     if err != nil {
         log.Fatalln("Failed to read:", err)
     }
-    if err := writeInvoiceFile(outFilename, invoices); err != nil {
+    if err := writeInvoiceFile(outFilename, invoices, version);
+        err != nil {
         log.Fatalln("Failed to write:", err)
     }
 }
@@ -131,7 +154,8 @@ func readInvoices(reader io.Reader, suffix string) ([]*Invoice, error) {
     return nil, fmt.Errorf("unrecognized input suffix: %s", suffix)
 }
 
-func writeInvoiceFile(filename string, invoices []*Invoice) error {
+func writeInvoiceFile(filename string, invoices []*Invoice,
+    version int) error {
     file, closer, err := createInvoiceFile(filename)
     if closer != nil {
         defer closer()
@@ -139,7 +163,7 @@ func writeInvoiceFile(filename string, invoices []*Invoice) error {
     if err != nil {
         return err
     }
-    return writeInvoices(file, suffixOf(filename), invoices)
+    return writeInvoices(file, suffixOf(filename), invoices, version)
 }
 
 func createInvoiceFile(filename string) (io.WriteCloser, func(), error) {
@@ -158,20 +182,20 @@ func createInvoiceFile(filename string) (io.WriteCloser, func(), error) {
     return writer, closer, nil
 }
 
-func writeInvoices(writer io.Writer, suffix string,
-    invoices []*Invoice) error {
+func writeInvoices(writer io.Writer, suffix string, invoices []*Invoice,
+    version int) error {
     var marshaler InvoicesMarshaler
     switch suffix {
     case ".gob":
-        marshaler = GobMarshaler{}
+        marshaler = GobMarshaler{version}
     case ".inv":
-        marshaler = InvMarshaler{}
+        marshaler = InvMarshaler{version}
     case ".jsn", ".json":
-        marshaler = JSONMarshaler{}
+        marshaler = JSONMarshaler{version}
     case ".txt":
-        marshaler = TxtMarshaler{}
+        marshaler = TxtMarshaler{version}
     case ".xml":
-        marshaler = XMLMarshaler{}
+        marshaler = XMLMarshaler{version}
     }
     if marshaler != nil {
         return marshaler.MarshalInvoices(writer, invoices)
@@ -221,6 +245,9 @@ func updateInvoice(invoice *Invoice) {
 }
 
 func updateItem(item *Item) (err error) {
+    if len(item.Id) < 3 { // The tax band is the ID's third character
+        return fmt.Errorf("invalid item ID: %s", item.Id)
+    }
     if item.TaxBand, err = strconv.Atoi(item.Id[2:3]); err != nil {
         return fmt.Errorf("invalid item ID: %s", item.Id)
     }
@@ -16,7 +16,6 @@ package main
 import (
     "encoding/json"
     "errors"
-    "fmt"
     "io"
     "time"
 )
@@ -27,6 +26,8 @@ type JSONInvoice struct {
     Id           int
     CustomerId   int
     DepartmentId string
+    Currency     string `json:",omitempty"` // Omitted before version 102
+    Jurisdiction string `json:",omitempty"`
     Raised       string
     Due          string
     Paid         bool
@@ -41,7 +42,31 @@ type JSONInvoice100 struct {
     Due        string
     Paid       bool
     Note       string
-    Items      []*Item
+    Items      []*JSONItem100
+}
+
+type JSONItem100 struct {
+    Id       string
+    Price    float64
+    Quantity int
+    Note     string
+}
+
+func JSONInvoice100ForInvoice(invoice *Invoice) *JSONInvoice100 {
+    jsonInvoice := &JSONInvoice100{
+        invoice.Id,
+        invoice.CustomerId,
+        invoice.Raised.Format(dateFormat),
+        invoice.Due.Format(dateFormat),
+        invoice.Paid,
+        invoice.Note,
+        make([]*JSONItem100, 0, len(invoice.Items)),
+    }
+    for _, item := range invoice.Items {
+        jsonInvoice.Items = append(jsonInvoice.Items, &JSONItem100{
+            item.Id, item.Price, item.Quantity, item.Note})
+    }
+    return jsonInvoice
 }
 
 func (invoice Invoice) MarshalJSON() ([]byte, error) {
@@ -49,6 +74,8 @@ func (invoice Invoice) MarshalJSON() ([]byte, error) {
         invoice.Id,
         invoice.CustomerId,
         invoice.DepartmentId,
+        invoice.Currency,
+        invoice.Jurisdiction,
         invoice.Raised.Format(dateFormat),
         invoice.Due.Format(dateFormat),
         invoice.Paid,
@@ -60,7 +87,7 @@ func (invoice Invoice) MarshalJSON() ([]byte, error) {
 
 func (invoice *Invoice) UnmarshalJSON(data []byte) (err error) {
     var jsonInvoice JSONInvoice
-    if version == fileVersion {
+    if version >= 101 {
         if err = json.Unmarshal(data, &jsonInvoice); err != nil {
             return err
         }
@@ -73,11 +100,18 @@ func (invoice *Invoice) UnmarshalJSON(data []byte) (err error) {
             jsonInvoice100.Id,
             jsonInvoice100.CustomerId,
             "",
+            "",
+            "",
             jsonInvoice100.Raised,
             jsonInvoice100.Due,
             jsonInvoice100.Paid,
             jsonInvoice100.Note,
-            jsonInvoice100.Items,
+            make([]*Item, 0, len(jsonInvoice100.Items)),
+        }
+        for _, item := range jsonInvoice100.Items {
+            jsonInvoice.Items = append(jsonInvoice.Items, &Item{Id: item.Id,
+                Price: item.Price, Quantity: item.Quantity,
+                Note: item.Note})
         }
     }
     var raised, due time.Time
@@ -91,6 +125,8 @@ func (invoice *Invoice) UnmarshalJSON(data []byte) (err error) {
         jsonInvoice.Id,
         jsonInvoice.CustomerId,
         jsonInvoice.DepartmentId,
+        jsonInvoice.Currency,
+        jsonInvoice.Jurisdiction,
         raised,
         due,
         jsonInvoice.Paid,
@@ -100,18 +136,33 @@ func (invoice *Invoice) UnmarshalJSON(data []byte) (err error) {
     return nil
 }
 
-type JSONMarshaler struct{}
+// Version is the file version to write; 0 means the current fileVersion.
+type JSONMarshaler struct {
+    Version int
+}
 
-func (JSONMarshaler) MarshalInvoices(writer io.Writer,
-    invoices []*Invoice) error {
+func (marshaler JSONMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) (err error) {
+    version := targetVersion(marshaler.Version)
+    if invoices, err = invoicesForVersion(invoices, version); err != nil {
+        return err
+    }
     encoder := json.NewEncoder(writer)
     if err := encoder.Encode(fileType); err != nil {
         return err
     }
-    if err := encoder.Encode(fileVersion); err != nil {
+    if err := encoder.Encode(version); err != nil {
         return err
     }
-    return encoder.Encode(invoices)
+    if version >= 101 {
+        return encoder.Encode(invoices)
+    }
+    jsonInvoices := make([]*JSONInvoice100, 0, len(invoices))
+    for _, invoice := range invoices {
+        jsonInvoices = append(jsonInvoices,
+            JSONInvoice100ForInvoice(invoice))
+    }
+    return encoder.Encode(jsonInvoices)
 }
 
 func (JSONMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
@@ -127,14 +178,14 @@ func (JSONMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
     if err := decoder.Decode(&version); err != nil {
         return nil, err
     }
-    if version > fileVersion {
-        return nil, fmt.Errorf("version %d is too new to read", version)
+    if err := checkVersion(version); err != nil {
+        return nil, err
     }
     var invoices []*Invoice
     if err := decoder.Decode(&invoices); err != nil {
         return nil, err
     }
-    if err := update(invoices); err != nil {
+    if err := migrate(invoices, version, fileVersion); err != nil {
         return nil, err
     }
     return invoices, nil
@@ -0,0 +1,188 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "fmt"
+)
+
+const minFileVersion = 100 // The oldest version we can read or write
+
+// invoicedata also grew from version 100 files but uses these versions
+// for its own, different invoices.
+const (
+    minInvoicedataVersion = 103
+    maxInvoicedataVersion = 104
+)
+
+// Every format reads the fields its file's version has and leaves the
+// rest zero; migrate() then upgrades the invoices in memory. Writing to
+// an older version copies and downgrades the invoices before they are
+// written. Each step converts between two adjacent versions, so
+// supporting a new version just means adding one more migration.
+type migration struct {
+    from, to  int
+    upgrade   func(invoices []*Invoice) error
+    downgrade func(invoices []*Invoice) error
+}
+
+var migrations = []migration{
+    {100, 101, update, downgrade101},
+    {101, 102, upgrade102, downgrade102},
+}
+
+func checkVersion(version int) error {
+    if version >= minInvoicedataVersion &&
+        version <= maxInvoicedataVersion {
+        return fmt.Errorf("version %d is an invoicedata file", version)
+    }
+    if version > fileVersion {
+        return fmt.Errorf("version %d is too new to read", version)
+    }
+    if version < minFileVersion {
+        return fmt.Errorf("version %d is too old to read", version)
+    }
+    return nil
+}
+
+// migrate upgrades or downgrades the invoices in place from one version
+// to another one step at a time.
+func migrate(invoices []*Invoice, from, to int) error {
+    for _, version := range []int{from, to} {
+        if err := checkVersion(version); err != nil {
+            return err
+        }
+    }
+    if from >= 102 { // .jsn and .xml files omit empty currencies
+        setDefaultCurrency(invoices)
+    }
+    for from < to {
+        step, found := migrationFrom(from)
+        if !found {
+            return fmt.Errorf("no migration from version %d", from)
+        }
+        if err := step.upgrade(invoices); err != nil {
+            return err
+        }
+        from = step.to
+    }
+    for from > to {
+        step, found := migrationTo(from)
+        if !found {
+            return fmt.Errorf("no migration to version %d", from)
+        }
+        if err := step.downgrade(invoices); err != nil {
+            return err
+        }
+        from = step.from
+    }
+    return nil
+}
+
+func migrationFrom(version int) (migration, bool) {
+    for _, step := range migrations {
+        if step.from == version {
+            return step, true
+        }
+    }
+    return migration{}, false
+}
+
+func migrationTo(version int) (migration, bool) {
+    for _, step := range migrations {
+        if step.to == version {
+            return step, true
+        }
+    }
+    return migration{}, false
+}
+
+// invoicesForVersion returns the invoices ready to be written as the
+// given version; the caller's invoices are never modified.
+func invoicesForVersion(invoices []*Invoice, version int) ([]*Invoice,
+    error) {
+    if version == fileVersion {
+        return invoices, nil
+    }
+    invoices = copyInvoices(invoices)
+    if err := migrate(invoices, fileVersion, version); err != nil {
+        return nil, err
+    }
+    return invoices, nil
+}
+
+func copyInvoices(invoices []*Invoice) []*Invoice {
+    copies := make([]*Invoice, 0, len(invoices))
+    for _, invoice := range invoices {
+        duplicate := *invoice
+        duplicate.Items = make([]*Item, 0, len(invoice.Items))
+        for _, item := range invoice.Items {
+            itemCopy := *item
+            duplicate.Items = append(duplicate.Items, &itemCopy)
+        }
+        copies = append(copies, &duplicate)
+    }
+    return copies
+}
+
+// targetVersion lets the zero value of each marshaler write the current
+// file version.
+func targetVersion(version int) int {
+    if version == 0 {
+        return fileVersion
+    }
+    return version
+}
+
+func downgrade101(invoices []*Invoice) error {
+    for _, invoice := range invoices {
+        invoice.DepartmentId = ""
+        for _, item := range invoice.Items {
+            item.TaxBand = 0
+        }
+    }
+    return nil
+}
+
+// Before version 102 every invoice was in the defaultCurrency and raised
+// in the defaultJurisdiction.
+func upgrade102(invoices []*Invoice) error {
+    setDefaultCurrency(invoices)
+    return nil
+}
+
+// setDefaultCurrency gives invoices with no currency or jurisdiction the
+// defaults.
+func setDefaultCurrency(invoices []*Invoice) {
+    for _, invoice := range invoices {
+        if invoice.Currency == "" {
+            invoice.Currency = defaultCurrency
+        }
+        if invoice.Jurisdiction == "" {
+            invoice.Jurisdiction = defaultJurisdiction
+        }
+    }
+}
+
+func downgrade102(invoices []*Invoice) error {
+    for _, invoice := range invoices {
+        if invoice.Currency != defaultCurrency {
+            return fmt.Errorf("invoice %d: version 101 cannot hold %s "+
+                "prices", invoice.Id, invoice.Currency)
+        }
+        invoice.Currency = ""
+        invoice.Jurisdiction = ""
+    }
+    return nil
+}
@@ -0,0 +1,236 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files")
+
+var suffixes = []string{".gob", ".inv", ".jsn", ".txt", ".xml"}
+
+func goldenFilename(version int, suffix string) string {
+    return filepath.Join("testdata", fmt.Sprintf("invoices%d%s", version,
+        suffix))
+}
+
+func TestMigrateGolden(t *testing.T) {
+    for version := minFileVersion; version <= fileVersion; version++ {
+        for _, suffix := range suffixes {
+            filename := goldenFilename(version, suffix)
+            var buffer bytes.Buffer
+            if err := writeInvoices(&buffer, suffix, expectedInvoices(),
+                version); err != nil {
+                t.Fatalf("%s: %v", filename, err)
+            }
+            if *updateGolden {
+                if err := ioutil.WriteFile(filename, buffer.Bytes(),
+                    0644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            golden, err := ioutil.ReadFile(filename)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(buffer.Bytes(), golden) {
+                t.Errorf("%s: written file != golden file", filename)
+            }
+            invoices, err := readInvoices(bytes.NewReader(golden), suffix)
+            if err != nil {
+                t.Fatalf("%s: %v", filename, err)
+            }
+            compareInvoices(t, filename, invoices, expectedInvoices())
+        }
+    }
+}
+
+// The baseline files were written by the original programs: version 100
+// ones by invoicedata and version 101 ones by invoicedata_ans, both
+// converting baseline100.txt (the first three of invoicedata's sample
+// invoices).
+func TestReadBaselineFiles(t *testing.T) {
+    for _, version := range []int{100, 101} {
+        for _, suffix := range suffixes {
+            filename := filepath.Join("testdata", fmt.Sprintf(
+                "baseline%d%s", version, suffix))
+            data, err := ioutil.ReadFile(filename)
+            if err != nil {
+                t.Fatal(err)
+            }
+            invoices, err := readInvoices(bytes.NewReader(data), suffix)
+            if err != nil {
+                t.Fatalf("%s: %v", filename, err)
+            }
+            compareInvoices(t, filename, invoices, baselineInvoices())
+        }
+    }
+}
+
+// A version 100 item ID too short to have a tax band is an error.
+func TestMigrateShortItemId(t *testing.T) {
+    invoices := expectedInvoices()
+    invoices[0].Items[0].Id = "AB"
+    if err := migrate(invoices, 100, fileVersion); err == nil {
+        t.Error("expected an error migrating item ID \"AB\"")
+    }
+}
+
+func TestMigrateLeavesInvoicesUnchanged(t *testing.T) {
+    invoices := expectedInvoices()
+    for _, suffix := range suffixes {
+        if err := writeInvoices(ioutil.Discard, suffix, invoices,
+            minFileVersion); err != nil {
+            t.Fatal(err)
+        }
+    }
+    compareInvoices(t, "downgraded", invoices, expectedInvoices())
+}
+
+func TestMigrateUnsupportedVersions(t *testing.T) {
+    for _, version := range []int{minFileVersion - 1, fileVersion + 1,
+        maxInvoicedataVersion} {
+        if err := migrate(expectedInvoices(), version, fileVersion);
+            err == nil {
+            t.Errorf("migrated from unsupported version %d", version)
+        }
+        if err := writeInvoices(ioutil.Discard, ".txt", expectedInvoices(),
+            version); err == nil {
+            t.Errorf("wrote unsupported version %d", version)
+        }
+    }
+}
+
+// Invoices with no currency or jurisdiction are read as having the
+// defaults, so they can be written in every format and version.
+func TestMigrateNoCurrency(t *testing.T) {
+    invoices := expectedInvoices()
+    for _, invoice := range invoices {
+        invoice.Currency, invoice.Jurisdiction = "", ""
+    }
+    var buffer bytes.Buffer
+    if err := writeInvoices(&buffer, ".jsn", invoices, fileVersion);
+        err != nil {
+        t.Fatal(err)
+    }
+    invoices, err := readInvoices(&buffer, ".jsn")
+    if err != nil {
+        t.Fatal(err)
+    }
+    compareInvoices(t, "no currency", invoices, expectedInvoices())
+    for version := minFileVersion; version <= fileVersion; version++ {
+        for _, suffix := range suffixes {
+            name := fmt.Sprintf("no currency version %d%s", version,
+                suffix)
+            buffer.Reset()
+            if err := writeInvoices(&buffer, suffix, invoices, version);
+                err != nil {
+                t.Fatalf("%s: %v", name, err)
+            }
+            actual, err := readInvoices(&buffer, suffix)
+            if err != nil {
+                t.Fatalf("%s: %v", name, err)
+            }
+            compareInvoices(t, name, actual, expectedInvoices())
+        }
+    }
+}
+
+func compareInvoices(t *testing.T, name string, actual,
+    expected []*Invoice) {
+    if len(actual) != len(expected) {
+        t.Fatalf("%s: %d invoices != %d", name, len(actual),
+            len(expected))
+    }
+    for i, invoice := range actual {
+        if !equalInvoices(invoice, expected[i]) {
+            t.Errorf("%s: invoice #%d %+v != %+v", name, i, *invoice,
+                *expected[i])
+        }
+    }
+}
+
+func equalInvoices(a, b *Invoice) bool {
+    if a.Id != b.Id || a.CustomerId != b.CustomerId ||
+        a.DepartmentId != b.DepartmentId || a.Currency != b.Currency ||
+        a.Jurisdiction != b.Jurisdiction || !a.Raised.Equal(b.Raised) ||
+        !a.Due.Equal(b.Due) || a.Paid != b.Paid || a.Note != b.Note ||
+        len(a.Items) != len(b.Items) {
+        return false
+    }
+    for i, item := range a.Items {
+        if *item != *b.Items[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func baselineInvoices() []*Invoice {
+    date := func(s string) time.Time {
+        t, _ := time.Parse(dateFormat, s)
+        return t
+    }
+    return []*Invoice{
+        {5441, 960, "EXP", "USD", "US", date("2012-09-06"),
+            date("2012-10-06"), true, "", []*Item{
+                {"BE9066", 400.89, 7, 9, "Keep out of <direct> sunlight"},
+            }},
+        {9928, 917, "X15", "USD", "US", date("2012-09-03"),
+            date("2012-10-03"), true, "Use trade entrance", []*Item{
+                {"OM2574", 415.80, 5, 2, ""},
+                {"MI7296", 447.54, 3, 7, ""},
+                {"YB2154", 392.42, 6, 2, ""},
+                {"UR9433", 347.92, 7, 9, ""},
+                {"UL2469", 235.23, 5, 2, ""},
+            }},
+        {5565, 694, "EXP", "USD", "US", date("2012-09-01"),
+            date("2012-10-01"), true, "Use trade entrance", []*Item{
+                {"PT9110", 105.40, 3, 9, "Flammable"},
+                {"AM7240", 183.69, 2, 7, ""},
+                {"UJ9108", 91.31, 6, 9, ""},
+                {"HW7822", 271.32, 7, 7, ""},
+            }},
+    }
+}
+
+// The department and tax band values are those that the 100 to 101
+// migration derives from the invoice and item IDs, and the currency and
+// jurisdiction are those that the 101 to 102 migration sets.
+func expectedInvoices() []*Invoice {
+    date := func(s string) time.Time {
+        t, _ := time.Parse(dateFormat, s)
+        return t
+    }
+    return []*Invoice{
+        {2178, 372, "GEN", "USD", "US", date("2012-01-31"),
+            date("2012-03-01"), true, "", []*Item{
+                {"AB1324", 19.99, 3, 1, ""},
+                {"CD7035", 1250.5, 1, 7, "Fragile"},
+            }},
+        {5441, 960, "EXP", "USD", "US", date("2012-09-06"),
+            date("2012-10-06"), false, "Use trade entrance", []*Item{
+                {"BE9066", 400.89, 7, 9, "Keep out of <direct> sunlight"},
+            }},
+        {9928, 917, "X15", "USD", "US", date("2012-12-31"),
+            date("2013-01-30"), false, "", []*Item{}},
+    }
+}
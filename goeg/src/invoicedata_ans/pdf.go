@@ -0,0 +1,257 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// PDFs are generated directly using only the standard Helvetica fonts
+// that every PDF reader has, so nothing needs to be embedded and no
+// external tools or services are needed.
+
+const (
+    pdfPageWidth  = 595 // A4 in points
+    pdfPageHeight = 842
+    pdfMargin     = 50
+    pdfFontSize   = 10
+    pdfLeading    = 16
+)
+
+type pdfText struct {
+    x, y  float64
+    size  float64
+    bold  bool
+    right bool // If true x is where the text ends
+    text  string
+}
+
+type pdfPage []pdfText
+
+// writePDF writes a PDF 1.4 document: objects 1 and 2 are the catalog and
+// page tree, 3 and 4 the fonts, and then each page has a page object
+// followed by its content stream.
+func writePDF(writer io.Writer, pages []pdfPage) error {
+    var buffer bytes.Buffer
+    var offsets []int
+    object := func(format string, args ...interface{}) {
+        offsets = append(offsets, buffer.Len())
+        fmt.Fprintf(&buffer, "%d 0 obj\n", len(offsets))
+        fmt.Fprintf(&buffer, format, args...)
+        buffer.WriteString("\nendobj\n")
+    }
+    buffer.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+    kids := make([]string, len(pages))
+    for i := range pages {
+        kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+    }
+    object("<< /Type /Catalog /Pages 2 0 R >>")
+    object("<< /Type /Pages /Kids [%s] /Count %d >>",
+        strings.Join(kids, " "), len(pages))
+    for _, font := range []string{"Helvetica", "Helvetica-Bold"} {
+        object("<< /Type /Font /Subtype /Type1 /BaseFont /%s "+
+            "/Encoding /WinAnsiEncoding >>", font)
+    }
+    for i, page := range pages {
+        object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+            "/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> "+
+            "/Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, 6+2*i)
+        content := pdfContent(page)
+        object("<< /Length %d >>\nstream\n%s\nendstream", len(content),
+            content)
+    }
+    xref := buffer.Len()
+    fmt.Fprintf(&buffer, "xref\n0 %d\n0000000000 65535 f \n",
+        len(offsets)+1)
+    for _, offset := range offsets {
+        fmt.Fprintf(&buffer, "%010d 00000 n \n", offset)
+    }
+    fmt.Fprintf(&buffer, "trailer\n<< /Size %d /Root 1 0 R >>\n"+
+        "startxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+    _, err := buffer.WriteTo(writer)
+    return err
+}
+
+func pdfContent(page pdfPage) string {
+    var content bytes.Buffer
+    for _, text := range page {
+        font := "F1"
+        if text.bold {
+            font = "F2"
+        }
+        x := text.x
+        if text.right {
+            x -= pdfTextWidth(text.text, text.size)
+        }
+        fmt.Fprintf(&content, "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n",
+            font, text.size, x, text.y, pdfString(text.text))
+    }
+    return content.String()
+}
+
+// pdfString returns the text in WinAnsiEncoding (which matches Latin-1
+// for printable characters) with ?s for anything else, escaped for use
+// in a PDF literal string.
+func pdfString(text string) string {
+    var result []byte
+    for _, r := range text {
+        switch {
+        case r == '(' || r == ')' || r == '\\':
+            result = append(result, '\\', byte(r))
+        case r >= ' ' && r < 0x7F || r >= 0xA0 && r <= 0xFF:
+            result = append(result, byte(r))
+        default:
+            result = append(result, '?')
+        }
+    }
+    return string(result)
+}
+
+// pdfTextWidth is only used to right-align numbers so it knows the exact
+// Helvetica widths (in thousandths of the font size) of the characters
+// they use and guesses the rest.
+func pdfTextWidth(text string, size float64) float64 {
+    width := 0
+    for _, r := range text {
+        switch {
+        case r >= '0' && r <= '9':
+            width += 556
+        case r == '.' || r == ',' || r == ' ':
+            width += 278
+        case r == '-':
+            width += 333
+        default:
+            width += 556
+        }
+    }
+    return float64(width) * size / 1000
+}
+
+// pdfLayout adds lines of text to pages from the top down, starting a
+// new page when the current one is full.
+type pdfLayout struct {
+    pages []pdfPage
+    y     float64
+}
+
+func (layout *pdfLayout) line(size float64, texts ...pdfText) {
+    if len(layout.pages) == 0 || layout.y-size < pdfMargin {
+        layout.pages = append(layout.pages, nil)
+        layout.y = pdfPageHeight - pdfMargin
+    }
+    layout.y -= size + pdfLeading - pdfFontSize
+    page := &layout.pages[len(layout.pages)-1]
+    for _, text := range texts {
+        text.y, text.size = layout.y, size
+        *page = append(*page, text)
+    }
+}
+
+// The x positions of the line item columns; numbers are right-aligned.
+var pdfColumns = []pdfText{{x: pdfMargin}, {x: 120},
+    {x: 380, right: true}, {x: 430, right: true}, {x: 490, right: true},
+    {x: pdfPageWidth - pdfMargin, right: true}}
+
+func (layout *pdfLayout) row(bold bool, cells ...string) {
+    texts := make([]pdfText, 0, len(cells))
+    for i, cell := range cells {
+        text := pdfColumns[i]
+        if cell == "" {
+            continue
+        }
+        text.text, text.bold = cell, bold
+        texts = append(texts, text)
+    }
+    layout.line(pdfFontSize, texts...)
+}
+
+// renderInvoicePDF lays out the same information as the default HTML
+// template.
+func renderInvoicePDF(writer io.Writer, view *invoiceView) error {
+    invoice := view.Invoice
+    money := func(amount Money) string {
+        return formatMoney(amount, view.Currency)
+    }
+    layout := &pdfLayout{}
+    layout.line(20, pdfText{x: pdfMargin, bold: true,
+        text: fmt.Sprintf("Invoice %d", invoice.Id)})
+    layout.line(pdfFontSize)
+    customer := fmt.Sprintf("Customer %d", invoice.CustomerId)
+    if invoice.DepartmentId != "" {
+        customer += " \u00B7 Department " + invoice.DepartmentId
+    }
+    layout.line(pdfFontSize, pdfText{x: pdfMargin, text: customer})
+    dates := fmt.Sprintf("Raised %s \u00B7 Due %s",
+        invoice.Raised.Format(dateFormat), invoice.Due.Format(dateFormat))
+    if invoice.Paid {
+        dates += " \u00B7 Paid"
+    }
+    layout.line(pdfFontSize, pdfText{x: pdfMargin, text: dates})
+    layout.line(pdfFontSize)
+    layout.row(true, "Item", "Note", "Tax Band", "Quantity", "Price",
+        "Amount")
+    for _, line := range view.Lines {
+        layout.row(false, line.Id, truncate(line.Note, 40),
+            fmt.Sprint(line.TaxBand), fmt.Sprint(line.Quantity),
+            money(line.Price), money(line.Amount))
+    }
+    layout.line(pdfFontSize)
+    for _, band := range view.Bands {
+        layout.row(false, fmt.Sprintf("Subtotal for tax band %d",
+            band.TaxBand), "", "", "", "", money(band.Subtotal))
+    }
+    layout.row(false, "Net", "", "", "", "", money(view.Net))
+    for _, band := range view.Bands {
+        layout.row(false, fmt.Sprintf("Tax for band %d at %g%%",
+            band.TaxBand, band.Rate), "", "", "", "", money(band.Tax))
+    }
+    layout.row(true, "Total "+view.Currency, "", "", "", "",
+        money(view.Total))
+    if invoice.Note != "" {
+        layout.line(pdfFontSize)
+        for _, line := range wrap(invoice.Note, 90) {
+            layout.line(pdfFontSize, pdfText{x: pdfMargin, text: line})
+        }
+    }
+    return writePDF(writer, layout.pages)
+}
+
+func truncate(text string, maximum int) string {
+    if runes := []rune(text); len(runes) > maximum {
+        return string(runes[:maximum-3]) + "..."
+    }
+    return text
+}
+
+func wrap(text string, width int) []string {
+    var lines []string
+    line := ""
+    for _, word := range strings.Fields(text) {
+        if line != "" && len([]rune(line))+1+len([]rune(word)) > width {
+            lines = append(lines, line)
+            line = ""
+        }
+        if line != "" {
+            line += " "
+        }
+        line += word
+    }
+    if line != "" {
+        lines = append(lines, line)
+    }
+    return lines
+}
@@ -0,0 +1,104 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "invoicing"
+    "io"
+    "math"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// Invoices from files older than version 102 are in this currency.
+const defaultCurrency = invoicing.DefaultCurrency
+
+// Money is the exact amount type that invoicedata uses too, so that both
+// programs agree on every currency's minor units.
+type Money = invoicing.Money
+
+// moneyFromFloat rounds the amount half away from zero to the currency's
+// minor unit. Float64 prices can't hold most decimal fractions exactly,
+// so products like 19.99 * 150 = 2998.4999... are first rounded to a
+// millionth of a minor unit to make halves round as they would on paper.
+func moneyFromFloat(amount float64, currency string) Money {
+    return Money{Amount: roundMinorUnits(amount * float64(
+        invoicing.MinorUnitsPerMajor(currency))), Currency: currency}
+}
+
+func roundMinorUnits(units float64) int64 {
+    return int64(math.Round(math.Round(units*1e6) / 1e6))
+}
+
+// ExchangeRates holds how many units of each currency one unit of some
+// common base currency buys; the base itself should have a rate of 1.
+type ExchangeRates map[string]float64
+
+// ReadExchangeRates reads a rates file which has one "CODE RATE" pair per
+// line, e.g., "EUR 0.9231"; blank lines and lines that begin with # are
+// ignored.
+func ReadExchangeRates(filename string) (ExchangeRates, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return readExchangeRates(file)
+}
+
+func readExchangeRates(reader io.Reader) (ExchangeRates, error) {
+    rates := make(ExchangeRates)
+    scanner := bufio.NewScanner(reader)
+    for lino := 1; scanner.Scan(); lino++ {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+            continue
+        }
+        if len(fields) != 2 || !validCurrency(fields[0]) {
+            return nil, fmt.Errorf("invalid exchange rate line %d", lino)
+        }
+        rate, err := strconv.ParseFloat(fields[1], 64)
+        if err != nil || rate <= 0 {
+            return nil, fmt.Errorf("invalid exchange rate %q line %d",
+                fields[1], lino)
+        }
+        rates[fields[0]] = rate
+    }
+    return rates, scanner.Err()
+}
+
+func validCurrency(code string) bool {
+    return len(code) == 3 && strings.ToUpper(code) == code
+}
+
+// Convert returns the amount in the from currency as an amount in the to
+// currency; it is not rounded.
+func (rates ExchangeRates) Convert(amount float64, from, to string) (
+    float64, error) {
+    if from == to {
+        return amount, nil
+    }
+    fromRate, found := rates[from]
+    if !found {
+        return 0, fmt.Errorf("no exchange rate for %s", from)
+    }
+    toRate, found := rates[to]
+    if !found {
+        return 0, fmt.Errorf("no exchange rate for %s", to)
+    }
+    return amount / fromRate * toRate, nil
+}
@@ -0,0 +1,285 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "fmt"
+    "html/template"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// The built-in template; a templates directory may override it with an
+// invoice.html file for every customer and with customer-ID.html files
+// for particular customers. Templates are executed with an invoiceView.
+// They are only used for HTML: PDFs always have renderInvoicePDF's fixed
+// layout.
+const defaultInvoiceTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Id}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.3em; text-align: left; }
+.number { text-align: right; }
+.total td { font-weight: bold; border-bottom: none; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Id}}</h1>
+<p>Customer {{.CustomerId}}{{with .DepartmentId}} &middot; Department {{.}}{{end}}<br>
+Raised {{date .Raised}} &middot; Due {{date .Due}}{{if .Paid}} &middot; <strong>Paid</strong>{{end}}</p>
+<table>
+<tr><th>Item</th><th>Note</th><th class="number">Tax Band</th><th class="number">Quantity</th><th class="number">Price</th><th class="number">Amount</th></tr>
+{{range .Lines}}<tr><td>{{.Id}}</td><td>{{.Note}}</td><td class="number">{{.TaxBand}}</td><td class="number">{{.Quantity}}</td><td class="number">{{money .Price $.Currency}}</td><td class="number">{{money .Amount $.Currency}}</td></tr>
+{{end}}{{range .Bands}}<tr><td colspan="5">Subtotal for tax band {{.TaxBand}}</td><td class="number">{{money .Subtotal $.Currency}}</td></tr>
+{{end}}<tr><td colspan="5">Net</td><td class="number">{{money .Net .Currency}}</td></tr>
+{{range .Bands}}<tr><td colspan="5">Tax for band {{.TaxBand}} at {{.Rate}}%</td><td class="number">{{money .Tax $.Currency}}</td></tr>
+{{end}}<tr class="total"><td colspan="5">Total {{.Currency}}</td><td class="number">{{money .Total .Currency}}</td></tr>
+</table>
+{{with .Note}}<p>{{.}}</p>{{end}}
+</body>
+</html>
+`
+
+var templateFuncs = template.FuncMap{
+    "date":  func(t time.Time) string { return t.Format(dateFormat) },
+    "money": formatMoney,
+}
+
+// invoiceView is what templates are given: the invoice itself plus the
+// amounts that templates shouldn't have to work out for themselves. The
+// amounts are in the view's Currency which may differ from the invoice's.
+type invoiceView struct {
+    *Invoice
+    Currency string
+    Lines    []lineView
+    Bands    []bandView // In TaxBand order
+    Net      Money
+    Tax      Money
+    Total    Money // Net plus Tax
+}
+
+type lineView struct {
+    *Item
+    Price  Money
+    Amount Money
+}
+
+type bandView struct {
+    TaxBand  int
+    Rate     float64 // Percentage
+    Subtotal Money
+    Tax      Money
+}
+
+func newInvoiceView(invoice *Invoice, calculator Calculator) (
+    *invoiceView, error) {
+    totals, err := calculator.Totals(invoice)
+    if err != nil {
+        return nil, err
+    }
+    view := &invoiceView{Invoice: invoice, Currency: totals.Currency,
+        Net: totals.Net, Tax: totals.Tax, Total: totals.Gross}
+    for i, item := range invoice.Items {
+        view.Lines = append(view.Lines, lineView{item,
+            totals.Lines[i].Price, totals.Lines[i].Net})
+    }
+    for _, band := range totals.Bands {
+        view.Bands = append(view.Bands, bandView{band.TaxBand, band.Rate,
+            band.Net, band.Tax})
+    }
+    return view, nil
+}
+
+// formatMoney gives the amount to as many decimal places as its currency
+// has minor unit digits. Templates may pass a currency as well, as they
+// did when amounts had none, but the amount's own currency is used.
+func formatMoney(amount Money, currency ...string) string {
+    return amount.Decimal()
+}
+
+func render(args []string) {
+    var templateDir, taxFilename, ratesFilename string
+    var calculator Calculator
+    pdf := false
+    for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+        if args[0] == "-p" || args[0] == "--pdf" {
+            pdf = true
+            args = args[1:]
+        } else if len(args) > 1 && (args[0] == "-t" ||
+            args[0] == "--templates") {
+            templateDir = args[1]
+            args = args[2:]
+        } else if len(args) > 1 && (args[0] == "-x" ||
+            args[0] == "--taxes") {
+            taxFilename = args[1]
+            args = args[2:]
+        } else if len(args) > 1 && (args[0] == "-r" ||
+            args[0] == "--rates") {
+            ratesFilename = args[1]
+            args = args[2:]
+        } else if len(args) > 1 && (args[0] == "-c" ||
+            args[0] == "--currency") {
+            calculator.Currency = args[1]
+            args = args[2:]
+        } else {
+            break
+        }
+    }
+    if len(args) != 2 || args[0] == "-h" || args[0] == "--help" {
+        log.Fatalf("usage: %s render [-t|--templates dir] [-p|--pdf] "+
+            "[-x|--taxes taxfile]\n    [-r|--rates ratesfile] "+
+            "[-c|--currency CODE] infile.ext outdir\nwrites an "+
+            "invoice-ID.html (and with --pdf an invoice-ID.pdf) for "+
+            "every invoice;\nthe templates dir may have an invoice.html "+
+            "and customer-ID.html files\n(these only apply to the HTML; "+
+            "the PDF layout is fixed);\nthe taxfile has "+
+            "\"JURISDICTION BAND PERCENT\" lines (* matches any);\nthe "+
+            "ratesfile has \"CODE RATE\" lines for converting to CODE\n",
+            filepath.Base(os.Args[0]))
+    }
+    if calculator.Currency != "" && !validCurrency(calculator.Currency) {
+        log.Fatalln("invalid currency:", calculator.Currency)
+    }
+    if taxFilename != "" {
+        var err error
+        if calculator.Rules, err = ReadTaxTable(taxFilename); err != nil {
+            log.Fatalln("Failed to read tax rules:", err)
+        }
+    }
+    if ratesFilename != "" {
+        var err error
+        if calculator.Rates, err = ReadExchangeRates(ratesFilename);
+            err != nil {
+            log.Fatalln("Failed to read exchange rates:", err)
+        }
+    }
+    inFilename, outDir := args[0], args[1]
+    invoices, err := readInvoiceFile(inFilename)
+    if err != nil {
+        log.Fatalln("Failed to read:", err)
+    }
+    renderer, err := newInvoiceRenderer(templateDir, calculator)
+    if err != nil {
+        log.Fatalln("Failed to read templates:", err)
+    }
+    if err := os.MkdirAll(outDir, 0755); err != nil {
+        log.Fatalln(err)
+    }
+    for _, invoice := range invoices {
+        filename := filepath.Join(outDir, fmt.Sprintf("invoice-%d",
+            invoice.Id))
+        if err := writeRenderedFile(filename+".html", invoice,
+            renderer.renderHTML); err != nil {
+            log.Fatalln("Failed to render:", err)
+        }
+        if pdf {
+            if err := writeRenderedFile(filename+".pdf", invoice,
+                renderer.renderPDF); err != nil {
+                log.Fatalln("Failed to render:", err)
+            }
+        }
+    }
+}
+
+func writeRenderedFile(filename string, invoice *Invoice,
+    render func(io.Writer, *Invoice) error) error {
+    file, err := os.Create(filename)
+    if err != nil {
+        return err
+    }
+    if err = render(file, invoice); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+// An invoiceRenderer holds the default template and any per-customer
+// templates keyed by customer ID, and the calculator that works out the
+// amounts for both HTML and PDF.
+type invoiceRenderer struct {
+    defaultTemplate   *template.Template
+    customerTemplates map[int]*template.Template
+    calculator        Calculator
+}
+
+// newInvoiceRenderer reads the templates in templateDir (which may be
+// empty for just the built-in template).
+func newInvoiceRenderer(templateDir string, calculator Calculator) (
+    *invoiceRenderer, error) {
+    renderer := &invoiceRenderer{calculator: calculator,
+        defaultTemplate: template.Must(template.New("invoice").Funcs(
+            templateFuncs).Parse(defaultInvoiceTemplate)),
+        customerTemplates: make(map[int]*template.Template)}
+    if templateDir == "" {
+        return renderer, nil
+    }
+    filenames, err := filepath.Glob(filepath.Join(templateDir, "*.html"))
+    if err != nil {
+        return nil, err
+    }
+    for _, filename := range filenames {
+        var customerId int
+        name := filepath.Base(filename)
+        if name != "invoice.html" {
+            if _, err := fmt.Sscanf(name, "customer-%d.html",
+                &customerId); err != nil {
+                continue
+            }
+        }
+        tmpl, err := template.New(name).Funcs(templateFuncs).ParseFiles(
+            filename)
+        if err != nil {
+            return nil, err
+        }
+        if name == "invoice.html" {
+            renderer.defaultTemplate = tmpl
+        } else {
+            renderer.customerTemplates[customerId] = tmpl
+        }
+    }
+    return renderer, nil
+}
+
+func (renderer *invoiceRenderer) renderHTML(writer io.Writer,
+    invoice *Invoice) error {
+    tmpl, found := renderer.customerTemplates[invoice.CustomerId]
+    if !found {
+        tmpl = renderer.defaultTemplate
+    }
+    view, err := newInvoiceView(invoice, renderer.calculator)
+    if err != nil {
+        return err
+    }
+    return tmpl.Execute(writer, view)
+}
+
+// renderPDF ignores the templates since they are HTML.
+func (renderer *invoiceRenderer) renderPDF(writer io.Writer,
+    invoice *Invoice) error {
+    view, err := newInvoiceView(invoice, renderer.calculator)
+    if err != nil {
+        return err
+    }
+    return renderInvoicePDF(writer, view)
+}
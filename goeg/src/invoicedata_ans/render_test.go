@@ -0,0 +1,131 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func TestRenderHTML(t *testing.T) {
+    renderer, err := newInvoiceRenderer("", Calculator{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    invoice := expectedInvoices()[0]
+    var buffer bytes.Buffer
+    if err := renderer.renderHTML(&buffer, invoice); err != nil {
+        t.Fatal(err)
+    }
+    html := buffer.String()
+    for _, text := range []string{"<title>Invoice 2178</title>",
+        "Department GEN", "<strong>Paid</strong>", "<td>CD7035</td>",
+        "Subtotal for tax band 1</td><td class=\"number\">59.97",
+        "Subtotal for tax band 7</td><td class=\"number\">1250.50",
+        "Total USD</td><td class=\"number\">1310.47"} {
+        if !strings.Contains(html, text) {
+            t.Errorf("expected %q in\n%s", text, html)
+        }
+    }
+    buffer.Reset()
+    if err := renderer.renderHTML(&buffer, expectedInvoices()[1]);
+        err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(buffer.String(),
+        "Keep out of &lt;direct&gt; sunlight") {
+        t.Errorf("notes not escaped in\n%s", buffer.String())
+    }
+}
+
+func TestRenderCustomerTemplates(t *testing.T) {
+    dir, err := ioutil.TempDir("", "invoicedata_ans")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    for name, text := range map[string]string{
+        "invoice.html":      "Any {{.Id}}",
+        "customer-960.html": "Customer {{.CustomerId}} owes {{money .Total}}",
+        "notes.html":        "Ignored",
+    } {
+        if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text),
+            0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    renderer, err := newInvoiceRenderer(dir, Calculator{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, expected := range []string{"Any 2178",
+        "Customer 960 owes 2806.23", "Any 9928"} {
+        var buffer bytes.Buffer
+        if err := renderer.renderHTML(&buffer, expectedInvoices()[i]);
+            err != nil {
+            t.Fatal(err)
+        }
+        if buffer.String() != expected {
+            t.Errorf("expected %q got %q", expected, buffer.String())
+        }
+    }
+}
+
+func TestRenderPDF(t *testing.T) {
+    invoice := expectedInvoices()[1]
+    for i := 0; i < 60; i++ { // Enough for a second page
+        invoice.Items = append(invoice.Items, &Item{fmt.Sprintf(
+            "XX%04d", i), 1, 1, 0, "(Note)"})
+    }
+    renderer, err := newInvoiceRenderer("", Calculator{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var buffer bytes.Buffer
+    if err := renderer.renderPDF(&buffer, invoice); err != nil {
+        t.Fatal(err)
+    }
+    pdf := buffer.Bytes()
+    if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) ||
+        !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+        t.Fatal("invalid PDF header or trailer")
+    }
+    for _, text := range []string{"(Invoice 5441)", "(2806.23)",
+        "(\\(Note\\))", "(Customer 960 \xB7 Department EXP)",
+        "/Count 2"} {
+        if !bytes.Contains(pdf, []byte(text)) {
+            t.Errorf("expected %q in the PDF", text)
+        }
+    }
+    // Every xref entry must give the offset of its object.
+    xref := regexp.MustCompile(`(?m)^(\d{10}) 00000 n $`).FindAllSubmatch(
+        pdf, -1)
+    if len(xref) != 8 {
+        t.Fatalf("expected 8 objects, got %d", len(xref))
+    }
+    for i, match := range xref {
+        offset, _ := strconv.Atoi(string(match[1]))
+        if !bytes.HasPrefix(pdf[offset:], []byte(fmt.Sprintf("%d 0 obj",
+            i+1))) {
+            t.Errorf("xref entry %d has the wrong offset %d", i+1, offset)
+        }
+    }
+}
@@ -0,0 +1,208 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Invoices from files older than version 102 were raised here.
+const defaultJurisdiction = "US"
+
+// TaxRules give the tax rate as a percentage (e.g., 17.5) for items in
+// the given tax band on invoices raised in the given jurisdiction.
+type TaxRules interface {
+    TaxRate(jurisdiction string, taxBand int) (float64, error)
+}
+
+// In a TaxTable key anyJurisdiction and anyTaxBand match those that
+// have no more specific entry of their own.
+const (
+    anyJurisdiction = "*"
+    anyTaxBand      = -1
+)
+
+type taxKey struct {
+    Jurisdiction string
+    TaxBand      int
+}
+
+// TaxTable is the TaxRules implementation that tax files are read into.
+type TaxTable map[taxKey]float64
+
+// Without a tax file nothing is taxed.
+var defaultTaxRules TaxRules = TaxTable{{anyJurisdiction, anyTaxBand}: 0}
+
+// TaxRate prefers an exact match, then one for any band in the
+// jurisdiction, then one for the band in any jurisdiction, and lastly
+// the catch-all.
+func (table TaxTable) TaxRate(jurisdiction string, taxBand int) (float64,
+    error) {
+    for _, key := range []taxKey{{jurisdiction, taxBand},
+        {jurisdiction, anyTaxBand}, {anyJurisdiction, taxBand},
+        {anyJurisdiction, anyTaxBand}} {
+        if rate, found := table[key]; found {
+            return rate, nil
+        }
+    }
+    return 0, fmt.Errorf("no tax rate for band %d in %q", taxBand,
+        jurisdiction)
+}
+
+// ReadTaxTable reads a tax file which has one "JURISDICTION BAND PERCENT"
+// rule per line, e.g., "GB 7 20"; the jurisdiction or band may be * to
+// match any. Blank lines and lines that begin with # are ignored.
+func ReadTaxTable(filename string) (TaxTable, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return readTaxTable(file)
+}
+
+func readTaxTable(reader io.Reader) (TaxTable, error) {
+    table := make(TaxTable)
+    scanner := bufio.NewScanner(reader)
+    for lino := 1; scanner.Scan(); lino++ {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+            continue
+        }
+        if len(fields) != 3 {
+            return nil, fmt.Errorf("invalid tax rule line %d", lino)
+        }
+        key := taxKey{fields[0], anyTaxBand}
+        if fields[1] != "*" {
+            var err error
+            if key.TaxBand, err = strconv.Atoi(fields[1]); err != nil ||
+                key.TaxBand < 0 {
+                return nil, fmt.Errorf("invalid tax band %q line %d",
+                    fields[1], lino)
+            }
+        }
+        rate, err := strconv.ParseFloat(fields[2], 64)
+        if err != nil || rate < 0 {
+            return nil, fmt.Errorf("invalid tax rate %q line %d",
+                fields[2], lino)
+        }
+        table[key] = rate
+    }
+    return table, scanner.Err()
+}
+
+// A Calculator works out invoice totals. Every consumer (e.g., the HTML
+// and PDF renderers) must use one so that they all show the same
+// amounts. The data formats (.gob, .inv, .jsn, .txt, and .xml) hold only
+// the invoices and not their totals: the totals depend on the tax rules,
+// exchange rates, and currency a Calculator is given, so stored ones
+// would be wrong as soon as any of those changed.
+type Calculator struct {
+    Rules    TaxRules      // nil means defaultTaxRules
+    Rates    ExchangeRates // Only needed to convert between currencies
+    Currency string        // "" means in each invoice's own currency
+}
+
+// Totals are exact amounts of the currency: prices are converted and
+// rounded to the currency's minor unit, and the tax is worked out and
+// rounded once per tax band.
+type Totals struct {
+    Currency string
+    Lines    []LineTotal // One per item
+    Bands    []BandTotal // In TaxBand order
+    Net      Money
+    Tax      Money
+    Gross    Money
+}
+
+type LineTotal struct {
+    Price Money // In the Totals' currency
+    Net   Money
+}
+
+type BandTotal struct {
+    TaxBand int
+    Rate    float64 // Percentage
+    Net     Money
+    Tax     Money
+}
+
+func (calculator Calculator) Totals(invoice *Invoice) (*Totals, error) {
+    from := invoice.Currency
+    if from == "" {
+        from = defaultCurrency
+    }
+    totals := &Totals{Currency: calculator.Currency}
+    if totals.Currency == "" {
+        totals.Currency = from
+    }
+    rules := calculator.Rules
+    if rules == nil {
+        rules = defaultTaxRules
+    }
+    zero := Money{Currency: totals.Currency}
+    totals.Net, totals.Tax = zero, zero
+    bandNets := make(map[int]Money)
+    for _, item := range invoice.Items {
+        price, err := calculator.Rates.Convert(item.Price, from,
+            totals.Currency)
+        if err != nil {
+            return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+        }
+        line := LineTotal{Price: moneyFromFloat(price, totals.Currency)}
+        if line.Net, err = line.Price.Times(item.Quantity); err != nil {
+            return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+        }
+        totals.Lines = append(totals.Lines, line)
+        net, found := bandNets[item.TaxBand]
+        if !found {
+            net = zero
+        }
+        if bandNets[item.TaxBand], err = net.Plus(line.Net); err != nil {
+            return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+        }
+    }
+    for band, net := range bandNets {
+        rate, err := rules.TaxRate(invoice.Jurisdiction, band)
+        if err != nil {
+            return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+        }
+        tax := Money{Amount: roundMinorUnits(float64(net.Amount) * rate /
+            100), Currency: totals.Currency}
+        totals.Bands = append(totals.Bands, BandTotal{band, rate, net,
+            tax})
+    }
+    sort.Slice(totals.Bands, func(i, j int) bool {
+        return totals.Bands[i].TaxBand < totals.Bands[j].TaxBand
+    })
+    var err error
+    for _, band := range totals.Bands {
+        if totals.Net, err = totals.Net.Plus(band.Net); err != nil {
+            return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+        }
+        if totals.Tax, err = totals.Tax.Plus(band.Tax); err != nil {
+            return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+        }
+    }
+    if totals.Gross, err = totals.Net.Plus(totals.Tax); err != nil {
+        return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+    }
+    return totals, nil
+}
@@ -0,0 +1,168 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "strings"
+    "testing"
+)
+
+const taxText = `# Jurisdiction band percent
+GB 1 5
+GB * 20
+*  9 12.5
+*  * 0
+`
+
+const ratesText = `USD 1
+GBP 0.8
+JPY 150
+`
+
+func testCalculator(t *testing.T, currency string) Calculator {
+    rules, err := readTaxTable(strings.NewReader(taxText))
+    if err != nil {
+        t.Fatal(err)
+    }
+    rates, err := readExchangeRates(strings.NewReader(ratesText))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return Calculator{rules, rates, currency}
+}
+
+func TestTaxTable(t *testing.T) {
+    rules := testCalculator(t, "").Rules
+    for _, test := range []struct {
+        jurisdiction string
+        taxBand      int
+        rate         float64
+    }{{"GB", 1, 5}, {"GB", 7, 20}, {"GB", 9, 20}, {"US", 9, 12.5},
+        {"US", 1, 0}, {"", 7, 0}} {
+        rate, err := rules.TaxRate(test.jurisdiction, test.taxBand)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if rate != test.rate {
+            t.Errorf("%s band %d: expected %g%% got %g%%",
+                test.jurisdiction, test.taxBand, test.rate, rate)
+        }
+    }
+    if _, err := (TaxTable{}).TaxRate("GB", 1); err == nil {
+        t.Error("expected an error for a missing rule")
+    }
+    for _, text := range []string{"GB 1", "GB x 20", "GB -1 20",
+        "GB 1 -5"} {
+        if _, err := readTaxTable(strings.NewReader(text)); err == nil {
+            t.Errorf("expected an error for %q", text)
+        }
+    }
+    for _, text := range []string{"USD", "usd 1", "EUR 0", "EUR x"} {
+        if _, err := readExchangeRates(strings.NewReader(text));
+            err == nil {
+            t.Errorf("expected an error for %q", text)
+        }
+    }
+}
+
+func TestTotals(t *testing.T) {
+    invoice := expectedInvoices()[0] // Bands 1 and 7
+    invoice.Items = append(invoice.Items, &Item{"EF0091", 0.05, 3, 9, ""})
+    for _, test := range []struct {
+        jurisdiction, currency string
+        lines, bandTaxes       []int64 // In minor units
+        net, tax, gross        int64
+    }{
+        {"US", "", []int64{5997, 125050, 15}, []int64{0, 0, 2}, 131062, 2,
+            131064},
+        {"GB", "", []int64{5997, 125050, 15}, []int64{300, 25010, 3},
+            131062, 25313, 156375},
+        {"GB", "GBP", []int64{4797, 100040, 12}, []int64{240, 20008, 2},
+            104849, 20250, 125099},
+        {"US", "JPY", []int64{8997, 187575, 24}, []int64{0, 0, 3}, 196596,
+            3, 196599},
+    } {
+        invoice.Jurisdiction = test.jurisdiction
+        totals, err := testCalculator(t, test.currency).Totals(invoice)
+        if err != nil {
+            t.Fatal(err)
+        }
+        name := test.jurisdiction + " " + totals.Currency
+        for i, line := range totals.Lines {
+            if line.Net.Amount != test.lines[i] ||
+                line.Net.Currency != totals.Currency {
+                t.Errorf("%s: line %d expected %v got %v", name, i,
+                    test.lines[i], line.Net)
+            }
+        }
+        for i, band := range totals.Bands {
+            if band.Tax.Amount != test.bandTaxes[i] ||
+                band.Tax.Currency != totals.Currency {
+                t.Errorf("%s: band %d tax expected %v got %v", name,
+                    band.TaxBand, test.bandTaxes[i], band.Tax)
+            }
+        }
+        if totals.Net.Amount != test.net || totals.Tax.Amount != test.tax ||
+            totals.Gross.Amount != test.gross ||
+            totals.Gross.Currency != totals.Currency {
+            t.Errorf("%s: expected %v + %v = %v got %v + %v = %v", name,
+                test.net, test.tax, test.gross, totals.Net, totals.Tax,
+                totals.Gross)
+        }
+    }
+    if _, err := testCalculator(t, "EUR").Totals(invoice); err == nil {
+        t.Error("expected an error converting to a currency without a rate")
+    }
+}
+
+// The HTML and PDF renderings must show the same amounts.
+func TestRenderedTotalsAgree(t *testing.T) {
+    invoice := expectedInvoices()[0]
+    invoice.Jurisdiction = "GB"
+    renderer, err := newInvoiceRenderer("", testCalculator(t, "GBP"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var html, pdf bytes.Buffer
+    if err := renderer.renderHTML(&html, invoice); err != nil {
+        t.Fatal(err)
+    }
+    if err := renderer.renderPDF(&pdf, invoice); err != nil {
+        t.Fatal(err)
+    }
+    for _, amount := range []string{"47.97", "1000.40", "1048.37", "2.40",
+        "200.08", "1250.85"} {
+        if !strings.Contains(html.String(), ">"+amount+"<") {
+            t.Errorf("expected %s in the HTML", amount)
+        }
+        if !bytes.Contains(pdf.Bytes(), []byte("("+amount+")")) {
+            t.Errorf("expected %s in the PDF", amount)
+        }
+    }
+}
+
+func TestDowngradeForeignCurrency(t *testing.T) {
+    invoices := expectedInvoices()
+    invoices[1].Currency = "EUR"
+    if err := writeInvoices(ioutil.Discard, ".txt", invoices, 101);
+        err == nil {
+        t.Error("expected an error writing EUR prices as version 101")
+    }
+    if err := writeInvoices(ioutil.Discard, ".txt", invoices, 102);
+        err != nil {
+        t.Error(err)
+    }
+}
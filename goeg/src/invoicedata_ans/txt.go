@@ -24,10 +24,17 @@ import (
 
 const noteSep = ":"
 
-type TxtMarshaler struct{}
+// Version is the file version to write; 0 means the current fileVersion.
+type TxtMarshaler struct {
+    Version int
+}
 
-func (TxtMarshaler) MarshalInvoices(writer io.Writer,
-    invoices []*Invoice) error {
+func (marshaler TxtMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) (err error) {
+    version := targetVersion(marshaler.Version)
+    if invoices, err = invoicesForVersion(invoices, version); err != nil {
+        return err
+    }
     bufferedWriter := bufio.NewWriter(writer)
     defer bufferedWriter.Flush()
     var write writerFunc = func(format string,
@@ -35,11 +42,11 @@ func (TxtMarshaler) MarshalInvoices(writer io.Writer,
         _, err := fmt.Fprintf(bufferedWriter, format, args...)
         return err
     }
-    if err := write("%s %d\n", fileType, fileVersion); err != nil {
+    if err := write("%s %d\n", fileType, version); err != nil {
         return err
     }
     for _, invoice := range invoices {
-        if err := write.writeInvoice(invoice); err != nil {
+        if err := write.writeInvoice(version, invoice); err != nil {
             return err
         }
     }
@@ -48,31 +55,43 @@ func (TxtMarshaler) MarshalInvoices(writer io.Writer,
 
 type writerFunc func(string, ...interface{}) error
 
-func (write writerFunc) writeInvoice(invoice *Invoice) error {
+func (write writerFunc) writeInvoice(version int, invoice *Invoice) error {
     note := ""
     if invoice.Note != "" {
         note = noteSep + " " + invoice.Note
     }
-    if err := write("INVOICE ID=%d CUSTOMER=%d DEPARTMENT=%s RAISED=%s "+
-        "DUE=%s PAID=%t%s\n", invoice.Id, invoice.CustomerId,
-        invoice.DepartmentId, invoice.Raised.Format(dateFormat),
+    department := ""
+    if version >= 101 {
+        department = " DEPARTMENT=" + invoice.DepartmentId
+    }
+    if version >= 102 {
+        department += " CURRENCY=" + invoice.Currency +
+            " JURISDICTION=" + invoice.Jurisdiction
+    }
+    if err := write("INVOICE ID=%d CUSTOMER=%d%s RAISED=%s DUE=%s "+
+        "PAID=%t%s\n", invoice.Id, invoice.CustomerId, department,
+        invoice.Raised.Format(dateFormat),
         invoice.Due.Format(dateFormat), invoice.Paid, note); err != nil {
         return err
     }
-    if err := write.writeItems(invoice.Items); err != nil {
+    if err := write.writeItems(version, invoice.Items); err != nil {
         return err
     }
     return write("\f\n")
 }
 
-func (write writerFunc) writeItems(items []*Item) error {
+func (write writerFunc) writeItems(version int, items []*Item) error {
     for _, item := range items {
         note := ""
         if item.Note != "" {
             note = noteSep + " " + item.Note
         }
-        if err := write("ITEM ID=%s PRICE=%.2f QUANTITY=%d TAXBAND=%d%s\n",
-            item.Id, item.Price, item.Quantity, item.TaxBand, note);
+        taxBand := ""
+        if version >= 101 {
+            taxBand = fmt.Sprintf(" TAXBAND=%d", item.TaxBand)
+        }
+        if err := write("ITEM ID=%s PRICE=%.2f QUANTITY=%d%s%s\n",
+            item.Id, item.Price, item.Quantity, taxBand, note);
             err != nil {
             return err
         }
@@ -102,6 +121,9 @@ func (TxtMarshaler) UnmarshalInvoices(reader io.Reader) (
             return nil, err
         }
     }
+    if err = migrate(invoices, version, fileVersion); err != nil {
+        return nil, err
+    }
     return invoices, nil
 }
 
@@ -110,8 +132,8 @@ func checkTxtVersion(bufferedReader *bufio.Reader) (version int,
     if _, err = fmt.Fscanf(bufferedReader, "INVOICES %d\n", &version);
         err != nil {
         err = errors.New("cannot read non-invoices text file")
-    } else if version > fileVersion {
-        err = fmt.Errorf("version %d is too new to read", version)
+    } else {
+        err = checkVersion(version)
     }
     return version, err
 }
@@ -140,7 +162,15 @@ func parseTxtInvoice(version, lino int, line string) (invoice *Invoice,
     err error) {
     invoice = &Invoice{}
     var raised, due string
-    if version == fileVersion {
+    if version >= 102 {
+        if _, err = fmt.Sscanf(line, "INVOICE ID=%d CUSTOMER=%d "+
+            "DEPARTMENT=%s CURRENCY=%s JURISDICTION=%s RAISED=%s DUE=%s "+
+            "PAID=%t", &invoice.Id, &invoice.CustomerId,
+            &invoice.DepartmentId, &invoice.Currency, &invoice.Jurisdiction,
+            &raised, &due, &invoice.Paid); err != nil {
+            return nil, fmt.Errorf("invalid invoice %v line %d", err, lino)
+        }
+    } else if version >= 101 {
         if _, err = fmt.Sscanf(line, "INVOICE ID=%d CUSTOMER=%d "+
             "DEPARTMENT=%s RAISED=%s DUE=%s PAID=%t", &invoice.Id,
             &invoice.CustomerId, &invoice.DepartmentId, &raised, &due,
@@ -164,16 +194,13 @@ func parseTxtInvoice(version, lino int, line string) (invoice *Invoice,
     if i := strings.Index(line, noteSep); i > -1 {
         invoice.Note = strings.TrimSpace(line[i+len(noteSep):])
     }
-    if version < fileVersion {
-        updateInvoice(invoice)
-    }
     return invoice, nil
 }
 
 func parseTxtItem(version, lino int, line string) (item *Item,
     err error) {
     item = &Item{}
-    if version == fileVersion {
+    if version >= 101 {
         if _, err = fmt.Sscanf(line, "ITEM ID=%s PRICE=%f "+
             "QUANTITY=%d TAXBAND=%d", &item.Id, &item.Price,
             &item.Quantity, &item.TaxBand); err != nil {
@@ -188,8 +215,5 @@ func parseTxtItem(version, lino int, line string) (item *Item,
     if i := strings.Index(line, noteSep); i > -1 {
         item.Note = strings.TrimSpace(line[i+len(noteSep):])
     }
-    if version < fileVersion {
-        updateItem(item)
-    }
     return item, nil
 }
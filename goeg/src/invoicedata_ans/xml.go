@@ -15,13 +15,15 @@ package main
 
 import (
     "encoding/xml"
-    "fmt"
     "io"
     "strings"
     "time"
 )
 
-type XMLMarshaler struct{}
+// Version is the file version to write; 0 means the current fileVersion.
+type XMLMarshaler struct {
+    Version int
+}
 
 type XMLInvoices struct {
     XMLName xml.Name      `xml:"INVOICES"`
@@ -33,7 +35,9 @@ type XMLInvoice struct {
     XMLName      xml.Name   `xml:"INVOICE"`
     Id           int        `xml:",attr"`
     CustomerId   int        `xml:",attr"`
-    DepartmentId string     `xml:",attr"`
+    DepartmentId string     `xml:",attr,omitempty"`
+    Currency     string     `xml:",attr,omitempty"`
+    Jurisdiction string     `xml:",attr,omitempty"`
     Raised       string     `xml:",attr"`
     Due          string     `xml:",attr"`
     Paid         bool       `xml:",attr"`
@@ -46,7 +50,7 @@ type XMLItem struct {
     Id       string   `xml:",attr"`
     Price    float64  `xml:",attr"`
     Quantity int      `xml:",attr"`
-    TaxBand  int      `xml:",attr"`
+    TaxBand  int      `xml:",attr,omitempty"`
     Note     string   `xml:"NOTE"`
 }
 
@@ -69,6 +73,8 @@ func XMLInvoiceForInvoice(invoice *Invoice) *XMLInvoice {
         Id:           invoice.Id,
         CustomerId:   invoice.CustomerId,
         DepartmentId: invoice.DepartmentId,
+        Currency:     invoice.Currency,
+        Jurisdiction: invoice.Jurisdiction,
         Raised:       invoice.Raised.Format(dateFormat),
         Due:          invoice.Due.Format(dateFormat),
         Paid:         invoice.Paid,
@@ -112,9 +118,13 @@ func (xmlInvoice *XMLInvoice) Invoice(version int) (invoice *Invoice,
         Note:       strings.TrimSpace(xmlInvoice.Note),
         Items:      make([]*Item, 0, len(xmlInvoice.Item)),
     }
-    if version == fileVersion {
+    if version >= 101 {
         invoice.DepartmentId = xmlInvoice.DepartmentId
     }
+    if version >= 102 {
+        invoice.Currency = xmlInvoice.Currency
+        invoice.Jurisdiction = xmlInvoice.Jurisdiction
+    }
     if invoice.Raised, err = time.Parse(dateFormat, xmlInvoice.Raised);
         err != nil {
         return nil, err
@@ -127,9 +137,6 @@ func (xmlInvoice *XMLInvoice) Invoice(version int) (invoice *Invoice,
         err != nil {
         return nil, err
     }
-    if version < fileVersion {
-        updateInvoice(invoice)
-    }
     return invoice, nil
 }
 
@@ -142,12 +149,8 @@ func itemsForXMLItems(version int, xmlItems []*XMLItem) (items []*Item,
             Quantity: xmlItem.Quantity,
             Note:     strings.TrimSpace(xmlItem.Note),
         }
-        if version == fileVersion {
+        if version >= 101 {
             item.TaxBand = xmlItem.TaxBand
-        } else if version < fileVersion {
-            if err = updateItem(item); err != nil {
-                return nil, err
-            }
         }
         items = append(items, item)
     }
@@ -155,12 +158,17 @@ func itemsForXMLItems(version int, xmlItems []*XMLItem) (items []*Item,
 }
 
 
-func (XMLMarshaler) MarshalInvoices(writer io.Writer,
-    invoices []*Invoice) error {
+func (marshaler XMLMarshaler) MarshalInvoices(writer io.Writer,
+    invoices []*Invoice) (err error) {
+    version := targetVersion(marshaler.Version)
+    if invoices, err = invoicesForVersion(invoices, version); err != nil {
+        return err
+    }
     if _, err := writer.Write([]byte(xml.Header)); err != nil {
         return err
     }
     xmlInvoices := XMLInvoicesForInvoices(invoices)
+    xmlInvoices.Version = version
     encoder := xml.NewEncoder(writer)
     return encoder.Encode(xmlInvoices)
 }
@@ -173,9 +181,16 @@ func (XMLMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice,
     if err := decoder.Decode(xmlInvoices); err != nil {
         return nil, err
     }
-    if xmlInvoices.Version > fileVersion {
-        return nil, fmt.Errorf("version %d is too new to read",
-            xmlInvoices.Version)
+    if err := checkVersion(xmlInvoices.Version); err != nil {
+        return nil, err
+    }
+    invoices, err := xmlInvoices.Invoices(xmlInvoices.Version)
+    if err != nil {
+        return nil, err
+    }
+    if err = migrate(invoices, xmlInvoices.Version, fileVersion);
+        err != nil {
+        return nil, err
     }
-    return xmlInvoices.Invoices(xmlInvoices.Version)
+    return invoices, nil
 }
@@ -0,0 +1,74 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package invoicestore implements an embedded store of invoices kept in
+// an append-only file with in-memory ordered indexes, and an HTTP JSON
+// API for it.
+//
+// Invoices, their items, and money use the invoicing package's JSON
+// encoding, which invoicedata's .jsn files also use, so that the two can
+// exchange invoices.
+package invoicestore
+
+import (
+    "encoding/json"
+    "invoicing"
+    "time"
+)
+
+const dateFormat = invoicing.DateFormat
+
+type Invoice struct {
+    Id         int
+    CustomerId int
+    Raised     time.Time
+    Due        time.Time
+    Paid       bool
+    Note       string
+    Items      []*Item
+}
+
+type Item = invoicing.Item
+
+// Money is an exact amount held in the currency's minor units; it is
+// encoded as a string like "400.89 USD".
+type Money = invoicing.Money
+
+func (invoice Invoice) MarshalJSON() ([]byte, error) {
+    return json.Marshal(invoicing.Invoice(invoice))
+}
+
+func (invoice *Invoice) UnmarshalJSON(data []byte) error {
+    return (*invoicing.Invoice)(invoice).UnmarshalJSON(data)
+}
+
+// validate returns an error if the invoice can't be stored. Decoded
+// invoices never have null items but those made in Go might.
+func (invoice *Invoice) validate() error {
+    for _, item := range invoice.Items {
+        if item == nil {
+            return ErrNullItem
+        }
+    }
+    return nil
+}
+
+func (invoice *Invoice) copy() *Invoice {
+    duplicate := *invoice
+    duplicate.Items = make([]*Item, len(invoice.Items))
+    for i, item := range invoice.Items {
+        itemCopy := *item
+        duplicate.Items[i] = &itemCopy
+    }
+    return &duplicate
+}
@@ -0,0 +1,239 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoicestore
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// DefaultLimit is the page size used when a list request has no limit.
+const DefaultLimit = 100
+
+// A ListResponse is the body of a response to GET /invoices.
+type ListResponse struct {
+    Total    int // The number of matching invoices
+    Offset   int
+    Invoices []*Invoice
+}
+
+// NewHandler returns an http.Handler that serves the store's invoices as
+// JSON:
+//
+//  GET    /invoices                list (?offset=&limit= and either
+//                                  ?customer=ID or ?from=&to= due dates,
+//                                  to being exclusive)
+//  POST   /invoices                create (an Id of 0 is assigned one)
+//  GET    /invoices/{id}           read
+//  PUT    /invoices/{id}           update
+//  DELETE /invoices/{id}           delete
+//  POST   /invoices/{id}/paid      mark paid
+//
+// Errors are reported with the appropriate status and a body of the form
+// {"Error": "message"}.
+func NewHandler(store *Store) http.Handler {
+    return handler{store}
+}
+
+type handler struct {
+    store *Store
+}
+
+func (handler handler) ServeHTTP(writer http.ResponseWriter,
+    request *http.Request) {
+    path := strings.Trim(request.URL.Path, "/")
+    parts := strings.Split(path, "/")
+    if parts[0] != "invoices" || len(parts) > 3 ||
+        len(parts) == 3 && parts[2] != "paid" {
+        writeError(writer, http.StatusNotFound,
+            fmt.Errorf("no such resource %q", request.URL.Path))
+        return
+    }
+    store := handler.store
+    if len(parts) == 1 {
+        switch request.Method {
+        case "GET":
+            store.handleList(writer, request)
+        case "POST":
+            store.handleCreate(writer, request)
+        default:
+            writeMethodNotAllowed(writer, "GET, POST")
+        }
+        return
+    }
+    id, err := strconv.Atoi(parts[1])
+    if err != nil {
+        writeError(writer, http.StatusBadRequest,
+            fmt.Errorf("invalid id %q", parts[1]))
+        return
+    }
+    if len(parts) == 3 {
+        if request.Method == "POST" {
+            store.handleMarkPaid(writer, id)
+        } else {
+            writeMethodNotAllowed(writer, "POST")
+        }
+        return
+    }
+    switch request.Method {
+    case "GET":
+        store.handleGet(writer, id)
+    case "PUT":
+        store.handleUpdate(writer, request, id)
+    case "DELETE":
+        store.handleDelete(writer, id)
+    default:
+        writeMethodNotAllowed(writer, "GET, PUT, DELETE")
+    }
+}
+
+func writeMethodNotAllowed(writer http.ResponseWriter, allowed string) {
+    writer.Header().Set("Allow", allowed)
+    writeError(writer, http.StatusMethodNotAllowed,
+        errors.New("method not allowed"))
+}
+
+func (store *Store) handleList(writer http.ResponseWriter,
+    request *http.Request) {
+    query := request.URL.Query()
+    var numbers [3]int
+    for i, name := range []string{"offset", "limit", "customer"} {
+        if value := query.Get(name); value != "" {
+            var err error
+            if numbers[i], err = strconv.Atoi(value); err != nil ||
+                numbers[i] < 0 {
+                writeError(writer, http.StatusBadRequest,
+                    fmt.Errorf("invalid %s %q", name, value))
+                return
+            }
+        }
+    }
+    offset, limit, customerId := numbers[0], numbers[1], numbers[2]
+    if limit == 0 {
+        limit = DefaultLimit
+    }
+    var dates [2]time.Time
+    for i, name := range []string{"from", "to"} {
+        if value := query.Get(name); value != "" {
+            var err error
+            if dates[i], err = time.Parse(dateFormat, value); err != nil {
+                writeError(writer, http.StatusBadRequest,
+                    fmt.Errorf("invalid %s date %q", name, value))
+                return
+            }
+        }
+    }
+    response := ListResponse{Offset: offset}
+    switch {
+    case query.Get("customer") != "":
+        response.Invoices, response.Total = store.ListByCustomer(
+            customerId, offset, limit)
+    case !dates[0].IsZero() || !dates[1].IsZero():
+        response.Invoices, response.Total = store.ListByDue(dates[0],
+            dates[1], offset, limit)
+    default:
+        response.Invoices, response.Total = store.List(offset, limit)
+    }
+    writeJSON(writer, http.StatusOK, response)
+}
+
+func (store *Store) handleCreate(writer http.ResponseWriter,
+    request *http.Request) {
+    var invoice Invoice
+    if err := json.NewDecoder(request.Body).Decode(&invoice); err != nil {
+        writeError(writer, http.StatusBadRequest, err)
+        return
+    }
+    id, err := store.Create(&invoice)
+    if err != nil {
+        writeStoreError(writer, err)
+        return
+    }
+    invoice.Id = id
+    writer.Header().Set("Location", fmt.Sprintf("/invoices/%d", id))
+    writeJSON(writer, http.StatusCreated, &invoice)
+}
+
+func (store *Store) handleGet(writer http.ResponseWriter, id int) {
+    if invoice, err := store.Get(id); err != nil {
+        writeStoreError(writer, err)
+    } else {
+        writeJSON(writer, http.StatusOK, invoice)
+    }
+}
+
+// handleUpdate accepts a body with the URL's Id or with an Id of 0.
+func (store *Store) handleUpdate(writer http.ResponseWriter,
+    request *http.Request, id int) {
+    var invoice Invoice
+    if err := json.NewDecoder(request.Body).Decode(&invoice); err != nil {
+        writeError(writer, http.StatusBadRequest, err)
+        return
+    }
+    if invoice.Id != 0 && invoice.Id != id {
+        writeError(writer, http.StatusBadRequest, fmt.Errorf(
+            "invoice %d sent to /invoices/%d", invoice.Id, id))
+        return
+    }
+    invoice.Id = id
+    if err := store.Update(&invoice); err != nil {
+        writeStoreError(writer, err)
+    } else {
+        writeJSON(writer, http.StatusOK, &invoice)
+    }
+}
+
+func (store *Store) handleDelete(writer http.ResponseWriter, id int) {
+    if err := store.Delete(id); err != nil {
+        writeStoreError(writer, err)
+    } else {
+        writer.WriteHeader(http.StatusNoContent)
+    }
+}
+
+func (store *Store) handleMarkPaid(writer http.ResponseWriter, id int) {
+    if invoice, err := store.MarkPaid(id); err != nil {
+        writeStoreError(writer, err)
+    } else {
+        writeJSON(writer, http.StatusOK, invoice)
+    }
+}
+
+func writeStoreError(writer http.ResponseWriter, err error) {
+    switch err {
+    case ErrNotFound:
+        writeError(writer, http.StatusNotFound, err)
+    case ErrExists:
+        writeError(writer, http.StatusConflict, err)
+    case ErrNullItem:
+        writeError(writer, http.StatusBadRequest, err)
+    default:
+        writeError(writer, http.StatusInternalServerError, err)
+    }
+}
+
+func writeError(writer http.ResponseWriter, status int, err error) {
+    writeJSON(writer, status, struct{ Error string }{err.Error()})
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+    writer.Header().Set("Content-Type", "application/json")
+    writer.WriteHeader(status)
+    json.NewEncoder(writer).Encode(value)
+}
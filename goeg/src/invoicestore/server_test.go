@@ -0,0 +1,115 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoicestore_test
+
+import (
+    "encoding/json"
+    "invoicestore"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func request(t *testing.T, server *httptest.Server, method, path,
+    body string, expectedStatus int, result interface{}) {
+    request, err := http.NewRequest(method, server.URL+path,
+        strings.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    response, err := http.DefaultClient.Do(request)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer response.Body.Close()
+    if response.StatusCode != expectedStatus {
+        t.Fatalf("%s %s: expected status %d got %d", method, path,
+            expectedStatus, response.StatusCode)
+    }
+    if result != nil {
+        if err := json.NewDecoder(response.Body).Decode(result);
+            err != nil {
+            t.Fatalf("%s %s: %v", method, path, err)
+        }
+    }
+}
+
+func TestHandler(t *testing.T) {
+    store, _, cleanup := openTempStore(t)
+    defer cleanup()
+    server := httptest.NewServer(invoicestore.NewHandler(store))
+    defer server.Close()
+
+    for _, invoice := range sampleInvoices() {
+        body, _ := json.Marshal(invoice)
+        request(t, server, "POST", "/invoices", string(body),
+            http.StatusCreated, nil)
+    }
+    var invoice invoicestore.Invoice
+    request(t, server, "POST", "/invoices", `{"CustomerId":7,`+
+        `"Raised":"2013-01-01","Due":"2013-01-31","Items":[{"Id":"X1",`+
+        `"Price":"1.50 USD","Quantity":2}]}`, http.StatusCreated, &invoice)
+    if invoice.Id != 9929 || invoice.Items[0].Price.Amount != 150 {
+        t.Fatalf("unexpected invoice created: %+v", invoice)
+    }
+    request(t, server, "POST", "/invoices", `{"Id":9929,"Raised":"x"}`,
+        http.StatusBadRequest, nil)
+    request(t, server, "POST", "/invoices", `{"Raised":"2013-01-01",`+
+        `"Due":"2013-01-31","Items":[null]}`, http.StatusBadRequest, nil)
+    request(t, server, "PUT", "/invoices/9929", `{"Raised":"2013-01-01",`+
+        `"Due":"2013-01-31","Items":[null]}`, http.StatusBadRequest, nil)
+
+    var list invoicestore.ListResponse
+    request(t, server, "GET", "/invoices?offset=1&limit=2", "",
+        http.StatusOK, &list)
+    if list.Total != 4 {
+        t.Fatalf("expected a total of 4, got %d", list.Total)
+    }
+    checkIds(t, "GET /invoices", list.Invoices, 5441, 9928)
+    request(t, server, "GET", "/invoices?customer=960", "", http.StatusOK,
+        &list)
+    checkIds(t, "GET /invoices?customer", list.Invoices, 5441, 9928)
+    request(t, server, "GET", "/invoices?from=2012-10-07&to=2013-02-01",
+        "", http.StatusOK, &list)
+    checkIds(t, "GET /invoices?from&to", list.Invoices, 9928, 9929)
+    request(t, server, "GET", "/invoices?limit=x", "",
+        http.StatusBadRequest, nil)
+
+    request(t, server, "POST", "/invoices/5441/paid", "", http.StatusOK,
+        &invoice)
+    if !invoice.Paid {
+        t.Fatal("invoice not marked paid")
+    }
+    invoice.Note = "Paid by cheque"
+    body, _ := json.Marshal(invoice)
+    request(t, server, "PUT", "/invoices/5441", string(body),
+        http.StatusOK, nil)
+    request(t, server, "PUT", "/invoices/9928", string(body),
+        http.StatusBadRequest, nil)
+    request(t, server, "GET", "/invoices/5441", "", http.StatusOK,
+        &invoice)
+    if invoice.Note != "Paid by cheque" || !invoice.Paid {
+        t.Fatalf("invoice not updated: %+v", invoice)
+    }
+    request(t, server, "DELETE", "/invoices/5441", "",
+        http.StatusNoContent, nil)
+    var failure struct{ Error string }
+    request(t, server, "GET", "/invoices/5441", "", http.StatusNotFound,
+        &failure)
+    if failure.Error != invoicestore.ErrNotFound.Error() {
+        t.Fatalf("unexpected error %q", failure.Error)
+    }
+    request(t, server, "GET", "/invoices/x", "", http.StatusBadRequest, nil)
+}
@@ -0,0 +1,352 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoicestore
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "invoicing"
+    "io"
+    "math"
+    "os"
+    "sync"
+    "time"
+
+    "qtrac.eu/omap"
+)
+
+const (
+    fileType    = "INVOICESTORE"
+    fileVersion = 100
+)
+
+var (
+    ErrNotFound = errors.New("invoice not found")
+    ErrExists   = errors.New("invoice already exists")
+    ErrNullItem = invoicing.ErrNullItem
+)
+
+// A record is one line of a store's file: either the whole of a created
+// or updated invoice, or the Id of a deleted one.
+type record struct {
+    Put    *Invoice `json:",omitempty"`
+    Delete int      `json:",omitempty"`
+}
+
+// Store holds invoices in memory indexed by Id, by CustomerId, and by Due
+// date, and records every change by appending to its file, so the file
+// is only ever read when the store is opened. It is safe for concurrent
+// use.
+type Store struct {
+    mutex      sync.RWMutex
+    file       *os.File
+    byId       *omap.Map // Id → *Invoice
+    byCustomer *omap.Map // customerKey → *Invoice
+    byDue      *omap.Map // dueKey → *Invoice
+    lastId     int
+}
+
+type customerKey struct {
+    customerId, id int
+}
+
+type dueKey struct {
+    due time.Time
+    id  int
+}
+
+// Open opens the store in the given file, creating the file if it
+// doesn't exist. If the last record is incomplete (e.g., because of a
+// crash while it was being written) it is discarded.
+func Open(filename string) (*Store, error) {
+    file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    store := &Store{file: file, byId: omap.NewIntKeyed(),
+        byCustomer: omap.New(func(a, b interface{}) bool {
+            α, β := a.(customerKey), b.(customerKey)
+            if α.customerId != β.customerId {
+                return α.customerId < β.customerId
+            }
+            return α.id < β.id
+        }),
+        byDue: omap.New(func(a, b interface{}) bool {
+            α, β := a.(dueKey), b.(dueKey)
+            if !α.due.Equal(β.due) {
+                return α.due.Before(β.due)
+            }
+            return α.id < β.id
+        })}
+    if err = store.load(); err != nil {
+        file.Close()
+        return nil, fmt.Errorf("%s: %v", filename, err)
+    }
+    return store, nil
+}
+
+func (store *Store) load() error {
+    reader := bufio.NewReader(store.file)
+    offset := int64(0)
+    for lino := 1; ; lino++ {
+        line, err := reader.ReadBytes('\n')
+        if err == io.EOF {
+            if len(line) > 0 { // Incomplete so never acknowledged
+                if err = store.truncate(offset); err != nil {
+                    return err
+                }
+            }
+            break
+        } else if err != nil {
+            return err
+        }
+        if lino == 1 {
+            err = checkHeader(line)
+        } else {
+            err = store.replay(line)
+        }
+        if err != nil {
+            return fmt.Errorf("%v line %d", err, lino)
+        }
+        offset += int64(len(line))
+    }
+    if offset == 0 { // New, or cut off while the header was written
+        header, _ := json.Marshal([]interface{}{fileType, fileVersion})
+        return store.append(header)
+    }
+    return nil
+}
+
+func checkHeader(line []byte) error {
+    var kind string
+    var version int
+    header := []interface{}{&kind, &version}
+    if err := json.Unmarshal(line, &header); err != nil ||
+        kind != fileType {
+        return errors.New("cannot read non-invoicestore file")
+    }
+    if version > fileVersion {
+        return fmt.Errorf("version %d is too new to read", version)
+    }
+    return nil
+}
+
+func (store *Store) replay(line []byte) error {
+    var change record
+    if err := json.Unmarshal(line, &change); err != nil {
+        return fmt.Errorf("invalid record %v", err)
+    }
+    if change.Put != nil {
+        store.put(change.Put)
+    } else {
+        store.remove(change.Delete)
+    }
+    return nil
+}
+
+func (store *Store) truncate(offset int64) error {
+    if err := store.file.Truncate(offset); err != nil {
+        return err
+    }
+    _, err := store.file.Seek(offset, io.SeekStart)
+    return err
+}
+
+// append writes a record as a single line and syncs it to disk.
+func (store *Store) append(line []byte) error {
+    if _, err := store.file.Write(append(line, '\n')); err != nil {
+        return err
+    }
+    return store.file.Sync()
+}
+
+func (store *Store) record(change record) error {
+    line, err := json.Marshal(change) // Newlines in strings are escaped
+    if err != nil {
+        return err
+    }
+    return store.append(line)
+}
+
+// put updates the indexes with the invoice, replacing any invoice with
+// the same Id.
+func (store *Store) put(invoice *Invoice) {
+    store.remove(invoice.Id)
+    store.byId.Insert(invoice.Id, invoice)
+    store.byCustomer.Insert(customerKey{invoice.CustomerId, invoice.Id},
+        invoice)
+    store.byDue.Insert(dueKey{invoice.Due, invoice.Id}, invoice)
+    if invoice.Id > store.lastId {
+        store.lastId = invoice.Id
+    }
+}
+
+func (store *Store) remove(id int) bool {
+    value, found := store.byId.Find(id)
+    if !found {
+        return false
+    }
+    invoice := value.(*Invoice)
+    store.byId.Delete(id)
+    store.byCustomer.Delete(customerKey{invoice.CustomerId, id})
+    store.byDue.Delete(dueKey{invoice.Due, id})
+    return true
+}
+
+func (store *Store) Close() error {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    return store.file.Close()
+}
+
+func (store *Store) Len() int {
+    store.mutex.RLock()
+    defer store.mutex.RUnlock()
+    return store.byId.Len()
+}
+
+// Get returns a copy of the invoice with the given Id.
+func (store *Store) Get(id int) (*Invoice, error) {
+    store.mutex.RLock()
+    defer store.mutex.RUnlock()
+    if value, found := store.byId.Find(id); found {
+        return value.(*Invoice).copy(), nil
+    }
+    return nil, ErrNotFound
+}
+
+// Create adds a copy of the invoice to the store and returns the Id it
+// was stored with: an invoice whose Id is 0 is given the next unused Id.
+func (store *Store) Create(invoice *Invoice) (int, error) {
+    if err := invoice.validate(); err != nil {
+        return 0, err
+    }
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    invoice = invoice.copy()
+    if invoice.Id == 0 {
+        invoice.Id = store.lastId + 1
+    } else if _, found := store.byId.Find(invoice.Id); found {
+        return 0, ErrExists
+    }
+    if err := store.record(record{Put: invoice}); err != nil {
+        return 0, err
+    }
+    store.put(invoice)
+    return invoice.Id, nil
+}
+
+// Update replaces the invoice that has the same Id with a copy of the
+// given one.
+func (store *Store) Update(invoice *Invoice) error {
+    if err := invoice.validate(); err != nil {
+        return err
+    }
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    if _, found := store.byId.Find(invoice.Id); !found {
+        return ErrNotFound
+    }
+    invoice = invoice.copy()
+    if err := store.record(record{Put: invoice}); err != nil {
+        return err
+    }
+    store.put(invoice)
+    return nil
+}
+
+func (store *Store) Delete(id int) error {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    if _, found := store.byId.Find(id); !found {
+        return ErrNotFound
+    }
+    if err := store.record(record{Delete: id}); err != nil {
+        return err
+    }
+    store.remove(id)
+    return nil
+}
+
+// MarkPaid sets the invoice's Paid field and returns a copy of it.
+func (store *Store) MarkPaid(id int) (*Invoice, error) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    value, found := store.byId.Find(id)
+    if !found {
+        return nil, ErrNotFound
+    }
+    invoice := value.(*Invoice).copy()
+    invoice.Paid = true
+    if err := store.record(record{Put: invoice}); err != nil {
+        return nil, err
+    }
+    store.put(invoice)
+    return invoice.copy(), nil
+}
+
+// List returns up to limit invoices (or all of them if limit is 0) in Id
+// order starting from the offset'th, along with how many there are in
+// all.
+func (store *Store) List(offset, limit int) ([]*Invoice, int) {
+    return store.page(store.byId, nil, nil, offset, limit)
+}
+
+// ListByCustomer is like List but only for the given customer's invoices
+// which it finds using the customer index.
+func (store *Store) ListByCustomer(customerId, offset, limit int) (
+    []*Invoice, int) {
+    return store.page(store.byCustomer, customerKey{customerId,
+        math.MinInt}, func(key interface{}) bool {
+        return key.(customerKey).customerId == customerId
+    }, offset, limit)
+}
+
+// ListByDue is like List but for invoices due in [from, to) in Due date
+// order, which it finds using the due date index; a zero from or to
+// means no limit.
+func (store *Store) ListByDue(from, to time.Time, offset, limit int) (
+    []*Invoice, int) {
+    var start interface{}
+    if !from.IsZero() {
+        start = dueKey{from, math.MinInt}
+    }
+    return store.page(store.byDue, start, func(key interface{}) bool {
+        return to.IsZero() || key.(dueKey).due.Before(to)
+    }, offset, limit)
+}
+
+// page returns copies of a page of the invoices in the index that come
+// from the start key (or the first if start is nil) onwards for as long
+// as within (if not nil) returns true, along with how many there are.
+func (store *Store) page(index *omap.Map, start interface{},
+    within func(interface{}) bool, offset, limit int) ([]*Invoice, int) {
+    store.mutex.RLock()
+    defer store.mutex.RUnlock()
+    invoices := []*Invoice{}
+    count := 0
+    index.DoFrom(start, func(key, value interface{}) bool {
+        if within != nil && !within(key) {
+            return false
+        }
+        if count >= offset && (limit <= 0 || len(invoices) < limit) {
+            invoices = append(invoices, value.(*Invoice).copy())
+        }
+        count++
+        return true
+    })
+    return invoices, count
+}
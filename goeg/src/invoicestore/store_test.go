@@ -0,0 +1,221 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoicestore_test
+
+import (
+    "invoicestore"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func date(text string) time.Time {
+    t, _ := time.Parse("2006-01-02", text)
+    return t
+}
+
+func sampleInvoices() []*invoicestore.Invoice {
+    return []*invoicestore.Invoice{
+        {5441, 960, date("2012-09-06"), date("2012-10-06"), false, "",
+            []*invoicestore.Item{{Id: "BE9066", Price: invoicestore.Money{
+                Amount: 40089, Currency: "USD"}, Quantity: 7,
+                Note: "Fragile"}}},
+        {2178, 372, date("2012-01-31"), date("2012-03-01"), true, "", nil},
+        {9928, 960, date("2012-12-31"), date("2013-01-30"), false, "",
+            []*invoicestore.Item{{Id: "AM7240", Price: invoicestore.Money{
+                Amount: 1000, Currency: "JPY"}, Quantity: 3}}},
+    }
+}
+
+func openTempStore(t *testing.T) (*invoicestore.Store, string, func()) {
+    dir, err := ioutil.TempDir("", "invoicestore")
+    if err != nil {
+        t.Fatal(err)
+    }
+    filename := filepath.Join(dir, "invoices.store")
+    store, err := invoicestore.Open(filename)
+    if err != nil {
+        os.RemoveAll(dir)
+        t.Fatal(err)
+    }
+    return store, filename, func() { store.Close(); os.RemoveAll(dir) }
+}
+
+func ids(invoices []*invoicestore.Invoice) []int {
+    var result []int
+    for _, invoice := range invoices {
+        result = append(result, invoice.Id)
+    }
+    return result
+}
+
+func checkIds(t *testing.T, what string, invoices []*invoicestore.Invoice,
+    expected ...int) {
+    actual := ids(invoices)
+    if len(actual) != len(expected) {
+        t.Fatalf("%s: expected %v got %v", what, expected, actual)
+    }
+    for i := range actual {
+        if actual[i] != expected[i] {
+            t.Fatalf("%s: expected %v got %v", what, expected, actual)
+        }
+    }
+}
+
+func TestStoreIndexes(t *testing.T) {
+    store, _, cleanup := openTempStore(t)
+    defer cleanup()
+    for _, invoice := range sampleInvoices() {
+        if _, err := store.Create(invoice); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if _, err := store.Create(sampleInvoices()[0]); err !=
+        invoicestore.ErrExists {
+        t.Fatalf("expected ErrExists, got %v", err)
+    }
+    invoices, total := store.List(0, 0)
+    checkIds(t, "List", invoices, 2178, 5441, 9928)
+    if invoices, total = store.List(1, 1); total != 3 {
+        t.Fatalf("expected a total of 3, got %d", total)
+    }
+    checkIds(t, "List page", invoices, 5441)
+    invoices, total = store.ListByCustomer(960, 0, 0)
+    checkIds(t, "ListByCustomer", invoices, 5441, 9928)
+    if invoices, total = store.ListByCustomer(960, 1, 1); total != 2 {
+        t.Fatalf("expected a total of 2, got %d", total)
+    }
+    checkIds(t, "ListByCustomer page", invoices, 9928)
+    invoices, _ = store.ListByCustomer(500, 0, 0)
+    checkIds(t, "ListByCustomer none", invoices)
+    invoices, _ = store.ListByDue(date("2012-10-06"), time.Time{}, 0, 0)
+    checkIds(t, "ListByDue", invoices, 5441, 9928)
+    invoices, _ = store.ListByDue(time.Time{}, date("2012-10-06"), 0, 0)
+    checkIds(t, "ListByDue", invoices, 2178)
+
+    // Changing a returned invoice mustn't change the store's.
+    invoice, err := store.Get(5441)
+    if err != nil {
+        t.Fatal(err)
+    }
+    invoice.Items[0].Quantity = 1
+    invoice.CustomerId = 372
+    invoice.Due = date("2012-02-01")
+    if invoice, _ = store.Get(5441); invoice.Items[0].Quantity != 7 {
+        t.Fatal("the store's invoice was changed through a copy")
+    }
+    invoice.CustomerId = 372
+    invoice.Due = date("2012-02-01")
+    if err := store.Update(invoice); err != nil {
+        t.Fatal(err)
+    }
+    invoices, _ = store.ListByCustomer(960, 0, 0)
+    checkIds(t, "ListByCustomer after update", invoices, 9928)
+    invoices, _ = store.ListByDue(time.Time{}, time.Time{}, 0, 0)
+    checkIds(t, "ListByDue after update", invoices, 5441, 2178, 9928)
+}
+
+func TestStoreReopen(t *testing.T) {
+    store, filename, cleanup := openTempStore(t)
+    defer cleanup()
+    for _, invoice := range sampleInvoices() {
+        if _, err := store.Create(invoice); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if _, err := store.MarkPaid(5441); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.Delete(2178); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.Delete(2178); err != invoicestore.ErrNotFound {
+        t.Fatalf("expected ErrNotFound, got %v", err)
+    }
+    invoice := &invoicestore.Invoice{CustomerId: 1,
+        Raised: date("2013-01-01"), Due: date("2013-01-31")}
+    if id, err := store.Create(invoice); err != nil || id != 9929 {
+        t.Fatalf("expected to create 9929, got %d %v", id, err)
+    }
+    store.Close()
+
+    // Simulate a crash part way through appending a record.
+    file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file.WriteString(`{"Delete":99`)
+    file.Close()
+
+    if store, err = invoicestore.Open(filename); err != nil {
+        t.Fatal(err)
+    }
+    invoices, _ := store.List(0, 0)
+    checkIds(t, "List after reopening", invoices, 5441, 9928, 9929)
+    if !invoices[0].Paid || invoices[0].Items[0].Price.String() !=
+        "400.89 USD" || invoices[1].Items[0].Price.String() != "1000 JPY" {
+        t.Fatalf("invoices changed by reopening: %v %v", invoices[0],
+            invoices[0].Items[0])
+    }
+    if err := store.Delete(9929); err != nil {
+        t.Fatal(err)
+    }
+    store.Close()
+    if store, err = invoicestore.Open(filename); err != nil {
+        t.Fatal(err)
+    }
+    if store.Len() != 2 {
+        t.Fatalf("expected 2 invoices, got %d", store.Len())
+    }
+}
+
+func TestOpenCutOffHeader(t *testing.T) {
+    store, filename, cleanup := openTempStore(t)
+    defer cleanup()
+    store.Close()
+    // Simulate a crash part way through writing a new store's header.
+    if err := ioutil.WriteFile(filename, []byte(`["INVOICES`), 0644);
+        err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 2; i++ {
+        store, err := invoicestore.Open(filename)
+        if err != nil {
+            t.Fatalf("open %d: %v", i+1, err)
+        }
+        if i == 0 {
+            if _, err = store.Create(sampleInvoices()[0]); err != nil {
+                t.Fatal(err)
+            }
+        } else if store.Len() != 1 {
+            t.Fatalf("expected 1 invoice, got %d", store.Len())
+        }
+        store.Close()
+    }
+}
+
+func TestOpenNonStore(t *testing.T) {
+    file, err := ioutil.TempFile("", "invoicestore")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(file.Name())
+    file.WriteString("\"INVOICES\"\n101\n")
+    file.Close()
+    if _, err := invoicestore.Open(file.Name()); err == nil {
+        t.Fatal("opened a non-invoicestore file")
+    }
+}
@@ -0,0 +1,99 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package invoicing provides the invoice, item, and exact money types
+// that invoicedata and invoicestore share, and their JSON encoding, so
+// that the two programs can exchange invoices.
+package invoicing
+
+import (
+    "encoding/json"
+    "errors"
+    "time"
+)
+
+const DateFormat = "2006-01-02" // This date must always be used
+
+// ErrNullItem is returned when decoding an invoice whose items include a
+// null since no program can make sense of a missing item.
+var ErrNullItem = errors.New("invoice has a null item")
+
+type Invoice struct {
+    Id         int
+    CustomerId int
+    Raised     time.Time
+    Due        time.Time
+    Paid       bool
+    Note       string
+    Items      []*Item
+}
+
+type Item struct {
+    Id       string
+    Price    Money // float64 with no currency in old files
+    Quantity int
+    Note     string
+}
+
+type JSONInvoice struct {
+    Id         int
+    CustomerId int
+    Raised     string // time.Time in Invoice struct
+    Due        string // time.Time in Invoice struct
+    Paid       bool
+    Note       string
+    Items      []*Item
+}
+
+func (invoice Invoice) MarshalJSON() ([]byte, error) {
+    jsonInvoice := JSONInvoice{
+        invoice.Id,
+        invoice.CustomerId,
+        invoice.Raised.Format(DateFormat),
+        invoice.Due.Format(DateFormat),
+        invoice.Paid,
+        invoice.Note,
+        invoice.Items,
+    }
+    return json.Marshal(jsonInvoice)
+}
+
+func (invoice *Invoice) UnmarshalJSON(data []byte) (err error) {
+    var jsonInvoice JSONInvoice
+    if err = json.Unmarshal(data, &jsonInvoice); err != nil {
+        return err
+    }
+    var raised, due time.Time
+    if raised, err = time.Parse(DateFormat, jsonInvoice.Raised);
+        err != nil {
+        return err
+    }
+    if due, err = time.Parse(DateFormat, jsonInvoice.Due); err != nil {
+        return err
+    }
+    for _, item := range jsonInvoice.Items {
+        if item == nil {
+            return ErrNullItem
+        }
+    }
+    *invoice = Invoice{
+        jsonInvoice.Id,
+        jsonInvoice.CustomerId,
+        raised,
+        due,
+        jsonInvoice.Paid,
+        jsonInvoice.Note,
+        jsonInvoice.Items,
+    }
+    return nil
+}
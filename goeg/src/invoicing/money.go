@@ -0,0 +1,233 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoicing
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Old invoice files stored prices as float64s with no currency; they
+// are read as being in this currency, as are amounts with no currency.
+const DefaultCurrency = "USD"
+
+// Money is an exact amount of a currency held in the currency's minor
+// units (e.g., cents for USD), so sums and products never suffer from
+// floating-point rounding errors.
+type Money struct {
+    Amount   int64  // In minor units
+    Currency string // ISO 4217 code, e.g., "USD"
+}
+
+// The number of minor unit digits for those currencies that don't use
+// the usual two.
+var minorDigitsForCurrency = map[string]int{"BHD": 3, "CLP": 0, "ISK": 0,
+    "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "OMR": 3, "TND": 3, "VND": 0}
+
+// MinorDigits returns the number of digits after the decimal point in
+// amounts of the currency.
+func MinorDigits(currency string) int {
+    if digits, found := minorDigitsForCurrency[currency]; found {
+        return digits
+    }
+    return 2
+}
+
+// MinorUnitsPerMajor returns how many minor units make a major unit of
+// the currency, e.g., 100 cents per dollar.
+func MinorUnitsPerMajor(currency string) int64 {
+    units := int64(1)
+    for i := 0; i < MinorDigits(currency); i++ {
+        units *= 10
+    }
+    return units
+}
+
+// MoneyFromFloat is used to read old files' float64 prices; the amount
+// is rounded to the nearest minor unit.
+func MoneyFromFloat(x float64, currency string) Money {
+    return Money{int64(math.Floor(x*float64(MinorUnitsPerMajor(currency))+
+        0.5)), currency}
+}
+
+// ParseMoney parses amounts like "400.89 USD"; if the currency is
+// omitted the DefaultCurrency is used. More decimal places than the
+// currency has are an error since they can't be held exactly.
+func ParseMoney(text string) (money Money, err error) {
+    fields := strings.Fields(text)
+    if len(fields) == 0 || len(fields) > 2 {
+        return money, fmt.Errorf("invalid money %q", text)
+    }
+    money.Currency = DefaultCurrency
+    if len(fields) == 2 {
+        money.Currency = fields[1]
+        if len(money.Currency) != 3 ||
+            strings.ToUpper(money.Currency) != money.Currency {
+            return money, fmt.Errorf("invalid currency %q", text)
+        }
+    }
+    whole, fraction := fields[0], ""
+    if i := strings.Index(whole, "."); i > -1 {
+        whole, fraction = whole[:i], whole[i+1:]
+    }
+    negative := strings.HasPrefix(whole, "-")
+    if negative {
+        whole = whole[1:]
+    }
+    // At most one sign, and only at the start
+    if whole == "" && fraction == "" || strings.ContainsAny(whole, "+-") ||
+        strings.ContainsAny(fraction, "+-") {
+        return money, fmt.Errorf("invalid money %q", text)
+    }
+    digits := MinorDigits(money.Currency)
+    if len(fraction) > digits {
+        return money, fmt.Errorf("too many decimal places in %q", text)
+    }
+    fraction += strings.Repeat("0", digits-len(fraction))
+    if money.Amount, err = strconv.ParseInt(whole+fraction, 10, 64);
+        err != nil {
+        return money, fmt.Errorf("invalid money %q", text)
+    }
+    if negative {
+        money.Amount = -money.Amount
+    }
+    return money, nil
+}
+
+// Decimal returns the amount without its currency, e.g., "400.89".
+func (money Money) Decimal() string {
+    digits := MinorDigits(money.Currency)
+    sign, amount := "", money.Amount
+    if amount < 0 {
+        sign, amount = "-", -amount
+    }
+    units := MinorUnitsPerMajor(money.Currency)
+    if digits == 0 {
+        return fmt.Sprintf("%s%d", sign, amount)
+    }
+    return fmt.Sprintf("%s%d.%0*d", sign, uint64(amount)/uint64(units),
+        digits, uint64(amount)%uint64(units))
+}
+
+func (money Money) String() string {
+    return money.Decimal() + " " + money.Currency
+}
+
+func (money Money) Float64() float64 {
+    return float64(money.Amount) / float64(MinorUnitsPerMajor(
+        money.Currency))
+}
+
+// Times, Plus, Minus, and Share return an error rather than an amount
+// that has silently wrapped around if the result won't fit an int64.
+func (money Money) Times(quantity int) (Money, error) {
+    amount, ok := multiply(money.Amount, int64(quantity))
+    if !ok {
+        return money, fmt.Errorf("%v times %d overflows", money, quantity)
+    }
+    return Money{amount, money.Currency}, nil
+}
+
+func (money Money) Plus(other Money) (Money, error) {
+    if money.Currency != other.Currency {
+        return money, fmt.Errorf("cannot add %s to %s", other.Currency,
+            money.Currency)
+    }
+    amount, ok := add(money.Amount, other.Amount)
+    if !ok {
+        return money, fmt.Errorf("%v plus %v overflows", money, other)
+    }
+    return Money{amount, money.Currency}, nil
+}
+
+func (money Money) Minus(other Money) (Money, error) {
+    if other.Amount == math.MinInt64 { // Has no positive counterpart
+        return money, fmt.Errorf("%v minus %v overflows", money, other)
+    }
+    return money.Plus(Money{-other.Amount, other.Currency})
+}
+
+// Share returns the given number of basis points (hundredths of a
+// percent) of the money, e.g., 1750 gives 17.5% for tax and 500 gives
+// 5% for a discount. The result is rounded half away from zero to the
+// nearest minor unit.
+func (money Money) Share(basisPoints int64) (Money, error) {
+    product, ok := multiply(money.Amount, basisPoints)
+    if !ok {
+        return money, fmt.Errorf("%d basis points of %v overflows",
+            basisPoints, money)
+    }
+    share := product / 10000
+    if remainder := product % 10000; remainder >= 5000 {
+        share++
+    } else if remainder <= -5000 {
+        share--
+    }
+    return Money{share, money.Currency}, nil
+}
+
+// add returns a + b and true, or false if the sum overflows.
+func add(a, b int64) (int64, bool) {
+    sum := a + b
+    if (b > 0 && sum < a) || (b < 0 && sum > a) {
+        return 0, false
+    }
+    return sum, true
+}
+
+// multiply returns a * b and true, or false if the product overflows.
+func multiply(a, b int64) (int64, bool) {
+    if a == 0 || b == 0 {
+        return 0, true
+    }
+    product := a * b
+    if product/b != a || (a == -1 && b == math.MinInt64) ||
+        (b == -1 && a == math.MinInt64) {
+        return 0, false
+    }
+    return product, true
+}
+
+func (money Money) MarshalText() ([]byte, error) {
+    return []byte(money.String()), nil
+}
+
+func (money *Money) UnmarshalText(text []byte) (err error) {
+    *money, err = ParseMoney(string(text))
+    return err
+}
+
+// MarshalJSON writes a string so that no precision is lost by readers
+// that hold JSON numbers as floats.
+func (money Money) MarshalJSON() ([]byte, error) {
+    return json.Marshal(money.String())
+}
+
+// UnmarshalJSON also accepts the bare numbers old files used.
+func (money *Money) UnmarshalJSON(data []byte) error {
+    var x float64
+    if err := json.Unmarshal(data, &x); err == nil {
+        *money = MoneyFromFloat(x, DefaultCurrency)
+        return nil
+    }
+    var text string
+    if err := json.Unmarshal(data, &text); err != nil {
+        return errors.New("invalid money " + string(data))
+    }
+    return money.UnmarshalText([]byte(text))
+}
@@ -0,0 +1,127 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoicing_test
+
+import (
+    "invoicing"
+    "math"
+    "testing"
+)
+
+func TestParseMoney(t *testing.T) {
+    for _, test := range []struct {
+        text     string
+        expected invoicing.Money
+    }{
+        {"400.89 USD", invoicing.Money{40089, "USD"}},
+        {"400.89", invoicing.Money{40089, invoicing.DefaultCurrency}},
+        {"0.1 GBP", invoicing.Money{10, "GBP"}},
+        {"-3.5 EUR", invoicing.Money{-350, "EUR"}},
+        {"1200 JPY", invoicing.Money{1200, "JPY"}},
+        {"0.125 KWD", invoicing.Money{125, "KWD"}},
+        {".5 USD", invoicing.Money{50, "USD"}},
+    } {
+        money, err := invoicing.ParseMoney(test.text)
+        if err != nil {
+            t.Errorf("%q: %v", test.text, err)
+        } else if money != test.expected {
+            t.Errorf("%q: %v != %v", test.text, money, test.expected)
+        }
+    }
+    for _, text := range []string{"", "1.234 USD", "1.5 JPY", "12 usd",
+        "1 USD extra", "abc", "1.-5", "+1", ".", "--5", "-+5", "-",
+        "1-5"} {
+        if money, err := invoicing.ParseMoney(text); err == nil {
+            t.Errorf("%q: parsed as %v", text, money)
+        }
+    }
+}
+
+func TestMoneyString(t *testing.T) {
+    for _, test := range []struct {
+        money    invoicing.Money
+        expected string
+    }{
+        {invoicing.Money{40089, "USD"}, "400.89 USD"},
+        {invoicing.Money{5, "USD"}, "0.05 USD"},
+        {invoicing.Money{-350, "EUR"}, "-3.50 EUR"},
+        {invoicing.Money{1200, "JPY"}, "1200 JPY"},
+        {invoicing.Money{125, "KWD"}, "0.125 KWD"},
+    } {
+        if actual := test.money.String(); actual != test.expected {
+            t.Errorf("%q != %q", actual, test.expected)
+        }
+        if money, err := invoicing.ParseMoney(test.expected); err != nil ||
+            money != test.money {
+            t.Errorf("%q didn't round trip: %v %v", test.expected, money,
+                err)
+        }
+    }
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+    // 0.1 + 0.2 is the classic float64 failure
+    total, err := invoicing.Money{10, "USD"}.Plus(invoicing.Money{20, "USD"})
+    if err != nil || total != (invoicing.Money{30, "USD"}) {
+        t.Errorf("0.10 + 0.20 = %v %v", total, err)
+    }
+    if _, err := total.Plus(invoicing.Money{1, "GBP"}); err == nil {
+        t.Error("added different currencies")
+    }
+    for _, test := range []struct {
+        money       invoicing.Money
+        basisPoints int64
+        expected    int64
+    }{
+        {invoicing.Money{1000, "GBP"}, 1750, 175},  // 17.5% VAT
+        {invoicing.Money{999, "GBP"}, 2000, 200},   // 199.8 rounds up
+        {invoicing.Money{1, "USD"}, 5000, 1},       // Half away from zero
+        {invoicing.Money{-1, "USD"}, 5000, -1},     // Half away from zero
+        {invoicing.Money{40089, "USD"}, 500, 2004}, // 5% of 2004.45
+    } {
+        if share, err := test.money.Share(test.basisPoints); err != nil ||
+            share.Amount != test.expected {
+            t.Errorf("%d bp of %v = %v not %d: %v", test.basisPoints,
+                test.money, share, test.expected, err)
+        }
+    }
+}
+
+func TestMoneyOverflow(t *testing.T) {
+    biggest := invoicing.Money{math.MaxInt64, "USD"}
+    smallest := invoicing.Money{math.MinInt64, "USD"}
+    one := invoicing.Money{1, "USD"}
+    if product, err := one.Times(math.MaxInt64); err != nil ||
+        product != biggest {
+        t.Errorf("1 times the biggest = %v %v", product, err)
+    }
+    for i, test := range []func() (invoicing.Money, error){
+        func() (invoicing.Money, error) { return biggest.Plus(one) },
+        func() (invoicing.Money, error) { return smallest.Minus(one) },
+        func() (invoicing.Money, error) { return one.Minus(smallest) },
+        func() (invoicing.Money, error) { return biggest.Times(2) },
+        func() (invoicing.Money, error) { return smallest.Times(-1) },
+        func() (invoicing.Money, error) {
+            return invoicing.Money{-1, "USD"}.Times(math.MinInt64)
+        },
+        func() (invoicing.Money, error) {
+            return invoicing.Money{1 << 32, "USD"}.Times(1 << 31)
+        },
+        func() (invoicing.Money, error) { return biggest.Share(2) },
+    } {
+        if money, err := test(); err == nil {
+            t.Errorf("#%d: expected an overflow got %v", i, money)
+        }
+    }
+}
@@ -14,16 +14,13 @@
 package main
 
 import (
-    "archive/tar"
-    "archive/zip"
-    "compress/gzip"
     "fmt"
     "io"
     "log"
     "os"
     "path/filepath"
+    "qtrac.eu/archive"
     "runtime"
-    "strings"
 )
 
 func main() {
@@ -35,8 +32,10 @@ func main() {
 
     }
     filename := os.Args[1]
-    if !validSuffix(filename) {
+    if format := archive.ForFilename(filename); format == nil {
         log.Fatalln("unrecognized archive suffix")
+    } else if format.NewWriter == nil {
+        log.Fatalf("%s archives can only be read\n", format)
     }
     files := commandLineFiles(os.Args[2:])
     if err := createArchive(filename, files); err != nil {
@@ -44,15 +43,6 @@ func main() {
     }
 }
 
-func validSuffix(filename string) bool {
-    for _, suffix := range []string{".zip", ".tar", ".tar.gz"} {
-        if strings.HasSuffix(filename, suffix) {
-            return true
-        }
-    }
-    return false
-}
-
 func commandLineFiles(files []string) []string {
     if runtime.GOOS == "windows" {
         args := make([]string, 0, len(files))
@@ -69,29 +59,20 @@ func commandLineFiles(files []string) []string {
 }
 
 func createArchive(filename string, files []string) error {
-    if strings.HasSuffix(filename, ".zip") {
-        return createZip(filename, files)
-    }
-    return createTar(filename, files)
-}
-
-func createZip(filename string, files []string) error {
-    file, err := os.Create(filename)
+    writer, err := archive.Create(filename)
     if err != nil {
         return err
     }
-    defer file.Close()
-    zipper := zip.NewWriter(file)
-    defer zipper.Close()
     for _, name := range files {
-        if err := writeFileToZip(zipper, name); err != nil {
+        if err := writeFileToArchive(writer, name); err != nil {
+            writer.Close()
             return err
         }
     }
-    return nil
+    return writer.Close()
 }
 
-func writeFileToZip(zipper *zip.Writer, filename string) error {
+func writeFileToArchive(writer archive.Writer, filename string) error {
     file, err := os.Open(filename)
     if err != nil {
         return err
@@ -101,71 +82,14 @@ func writeFileToZip(zipper *zip.Writer, filename string) error {
     if err != nil {
         return err
     }
-    header, err := zip.FileInfoHeader(info)
-    if err != nil {
-        return err
+    entry := archive.FileInfoEntry(info)
+    if entry.Name, err = archive.SanitizedName(filename); err != nil {
+        return fmt.Errorf("%s: %v", filename, err)
     }
-    header.Name = sanitizedName(filename)
-    writer, err := zipper.CreateHeader(header)
-    if err != nil {
+    content, err := writer.Create(entry)
+    if err != nil || info.IsDir() {
         return err
     }
-    _, err = io.Copy(writer, file)
+    _, err = io.Copy(content, file)
     return err
 }
-
-func createTar(filename string, files []string) error {
-    file, err := os.Create(filename)
-    if err != nil {
-        return err
-    }
-    defer file.Close()
-    var fileWriter io.WriteCloser = file
-    if strings.HasSuffix(filename, ".gz") {
-        fileWriter = gzip.NewWriter(file)
-        defer fileWriter.Close()
-    }
-    writer := tar.NewWriter(fileWriter)
-    defer writer.Close()
-    for _, name := range files {
-        if err := writeFileToTar(writer, name); err != nil {
-            return err
-        }
-    }
-    return nil
-}
-
-func writeFileToTar(writer *tar.Writer, filename string) error {
-    file, err := os.Open(filename)
-    if err != nil {
-        return err
-    }
-    defer file.Close()
-    stat, err := file.Stat()
-    if err != nil {
-        return err
-    }
-    header := &tar.Header{
-        Name:    sanitizedName(filename),
-        Mode:    int64(stat.Mode()),
-        Uid:     os.Getuid(),
-        Gid:     os.Getgid(),
-        Size:    stat.Size(),
-        ModTime: stat.ModTime(),
-    }
-    if err = writer.WriteHeader(header); err != nil {
-        return err
-    }
-    _, err = io.Copy(writer, file)
-    return err
-}
-
-func sanitizedName(filename string) string {
-    if len(filename) > 1 && filename[1] == ':' &&
-        runtime.GOOS == "windows" {
-        filename = filename[2:]
-    }
-    filename = filepath.ToSlash(filename)
-    filename = strings.TrimLeft(filename, "/.")
-    return strings.Replace(filename, "../", "", -1)
-}
@@ -0,0 +1,268 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package archive provides a uniform way to read and write archives
+// whatever their format, e.g., zip, tar, tar.gz, or tar.bz2.
+//
+// Each format is a registered back-end. Archives are written in the
+// format their filename's suffix names, and read in the format their
+// first bytes identify so a reader doesn't depend on the suffix.
+package archive
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "time"
+)
+
+var (
+    ErrFormat   = errors.New("archive: unrecognized archive")
+    ErrReadOnly = errors.New("archive: the format can't be written")
+    ErrUnsafe   = errors.New("archive: name leads outside its directory")
+)
+
+// An Entry describes one of the files or directories in an archive.
+type Entry struct {
+    Name     string // Slash-separated; a directory's ends with a slash
+    Size     int64  // Of the content in bytes; -1 if it isn't known
+    Mode     os.FileMode
+    ModTime  time.Time
+    Uid, Gid int // Only used by tar formats
+}
+
+// FileInfoEntry returns an Entry for the file described by the info,
+// e.g., from os.Stat(). The entry's name is the info's name so it
+// should normally be replaced with one that includes the file's path.
+func FileInfoEntry(info os.FileInfo) *Entry {
+    entry := &Entry{Name: info.Name(), Mode: info.Mode(),
+        ModTime: info.ModTime(), Uid: os.Getuid(), Gid: os.Getgid()}
+    if info.IsDir() {
+        entry.Name += "/"
+    } else {
+        entry.Size = info.Size()
+    }
+    return entry
+}
+
+func (entry *Entry) IsDir() bool { return entry.Mode.IsDir() }
+
+// A Reader reads an archive's entries in the order they are stored.
+type Reader interface {
+    // Next returns the next entry, or io.EOF after the last one.
+    Next() (*Entry, error)
+    // Open returns the content of the entry that Next() last returned;
+    // the content can't be read after the next call to Next().
+    Open() (io.ReadCloser, error)
+    Close() error
+}
+
+// A Writer adds entries to an archive; it must be closed to complete
+// the archive.
+type Writer interface {
+    // Create adds the entry and returns a writer for its content which
+    // must be the entry's size and which can only be written until the
+    // next call to Create(). Nothing should be written for directories.
+    Create(entry *Entry) (io.Writer, error)
+    Close() error
+}
+
+// A Format is an archive back-end. Match says whether an archive's
+// first bytes (which may be fewer than MagicLength if the archive is
+// short) are in the format. NewWriter is nil for read-only formats.
+type Format struct {
+    Name        string   // E.g., "tar.gz"
+    Suffixes    []string // E.g., ".tar.gz" and ".tgz"
+    MagicLength int
+    Match       func(magic []byte) bool
+    NewReader   func(reader io.ReaderAt, size int64) (Reader, error)
+    NewWriter   func(writer io.Writer) (Writer, error)
+}
+
+func (format *Format) String() string { return format.Name }
+
+var formats []*Format
+
+// Register adds the format; when reading, formats are tried in the order
+// they were registered so more specific ones, e.g., tar.gz, must be
+// registered before more general ones, e.g., gz. Register panics if
+// the format has no name, Match, or NewReader, or if its name is
+// already registered.
+func Register(format Format) {
+    if format.Name == "" || format.Match == nil ||
+        format.NewReader == nil {
+        panic("archive.Register(): incomplete format")
+    }
+    if ForName(format.Name) != nil {
+        panic(fmt.Sprintf("archive.Register(): %q is already registered",
+            format.Name))
+    }
+    formats = append(formats, &format)
+}
+
+// Formats returns the registered formats in the order they are tried.
+func Formats() []*Format {
+    return append([]*Format(nil), formats...)
+}
+
+// ForName returns the named format, or nil if there isn't one.
+func ForName(name string) *Format {
+    for _, format := range formats {
+        if format.Name == name {
+            return format
+        }
+    }
+    return nil
+}
+
+// ForFilename returns the format whose suffix the filename ends with
+// (ignoring case), or nil if there isn't one. The longest suffix wins so
+// that, e.g., "x.tar.gz" is tar.gz not gz.
+func ForFilename(filename string) *Format {
+    filename = strings.ToLower(filename)
+    var found *Format
+    longest := 0
+    for _, format := range formats {
+        for _, suffix := range format.Suffixes {
+            if len(suffix) > longest && strings.HasSuffix(filename,
+                suffix) {
+                found, longest = format, len(suffix)
+            }
+        }
+    }
+    return found
+}
+
+// Detect returns the format of the archive judging by its first bytes,
+// or ErrFormat if it isn't in any of the registered formats.
+func Detect(reader io.ReaderAt) (*Format, error) {
+    length := 0
+    for _, format := range formats {
+        if format.MagicLength > length {
+            length = format.MagicLength
+        }
+    }
+    magic := make([]byte, length)
+    count, err := reader.ReadAt(magic, 0)
+    if err != nil && err != io.EOF {
+        return nil, err
+    }
+    magic = magic[:count]
+    for _, format := range formats {
+        if format.Match(magic) {
+            return format, nil
+        }
+    }
+    return nil, ErrFormat
+}
+
+// NewReader returns a Reader for the archive in whatever format it is.
+func NewReader(reader io.ReaderAt, size int64) (Reader, error) {
+    format, err := Detect(reader)
+    if err != nil {
+        return nil, err
+    }
+    return format.NewReader(reader, size)
+}
+
+// Open opens the named archive for reading in whatever format it is.
+func Open(filename string) (Reader, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    reader, err := NewReader(file, info.Size())
+    if err != nil {
+        file.Close()
+        return nil, fmt.Errorf("%s: %v", filename, err)
+    }
+    return &fileReader{reader, file}, nil
+}
+
+// Create creates the named archive in the format its suffix names.
+func Create(filename string) (Writer, error) {
+    format := ForFilename(filename)
+    if format == nil {
+        return nil, ErrFormat
+    }
+    if format.NewWriter == nil {
+        return nil, ErrReadOnly
+    }
+    file, err := os.Create(filename)
+    if err != nil {
+        return nil, err
+    }
+    writer, err := format.NewWriter(file)
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    return &fileWriter{writer, file}, nil
+}
+
+// fileReader and fileWriter close the archive's file when they are
+// closed.
+type fileReader struct {
+    Reader
+    file *os.File
+}
+
+func (reader *fileReader) Close() error {
+    err := reader.Reader.Close()
+    if fileErr := reader.file.Close(); err == nil {
+        err = fileErr
+    }
+    return err
+}
+
+type fileWriter struct {
+    Writer
+    file *os.File
+}
+
+func (writer *fileWriter) Close() error {
+    err := writer.Writer.Close()
+    if fileErr := writer.file.Close(); err == nil {
+        err = fileErr
+    }
+    return err
+}
+
+// SanitizedName returns the filename as a clean relative slash-separated
+// path with no drive letter or leading slashes or dots so that it is safe
+// to store in an archive or to unpack to. It returns ErrUnsafe if the
+// cleaned path still has a ".." element.
+func SanitizedName(filename string) (string, error) {
+    if len(filename) > 1 && filename[1] == ':' &&
+        runtime.GOOS == "windows" {
+        filename = filename[2:]
+    }
+    filename = path.Clean(strings.TrimLeft(filepath.ToSlash(filename),
+        "/."))
+    for _, element := range strings.Split(filename, "/") {
+        if element == ".." {
+            return "", ErrUnsafe
+        }
+    }
+    return filename, nil
+}
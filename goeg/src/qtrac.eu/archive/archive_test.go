@@ -0,0 +1,227 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive_test
+
+import (
+    "compress/gzip"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "qtrac.eu/archive"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+var modTime = time.Date(2012, 10, 29, 0, 0, 0, 0, time.UTC)
+
+// readAll returns a line for each of the archive's entries giving its
+// name, mode, and content.
+func readAll(t *testing.T, filename string) []string {
+    reader, err := archive.Open(filename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader.Close()
+    var lines []string
+    for {
+        entry, err := reader.Next()
+        if err == io.EOF {
+            return lines
+        } else if err != nil {
+            t.Fatal(err)
+        }
+        content, err := reader.Open()
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, err := ioutil.ReadAll(content)
+        content.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if entry.Size >= 0 && entry.Size != int64(len(data)) {
+            t.Errorf("%s: expected %d bytes got %d", entry.Name,
+                entry.Size, len(data))
+        }
+        if !entry.ModTime.Equal(modTime) {
+            t.Errorf("%s: expected %v got %v", entry.Name, modTime,
+                entry.ModTime)
+        }
+        lines = append(lines, entry.Name+" "+entry.Mode.String()+" "+
+            string(data))
+    }
+}
+
+func TestRoundTrip(t *testing.T) {
+    dir, err := ioutil.TempDir("", "archive")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    expected := []string{"docs/ drwxr-xr-x ",
+        "docs/hello.txt -rw-r--r-- Hello!", "empty -rw------- "}
+    for _, suffix := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+        filename := filepath.Join(dir, "test"+suffix)
+        writer, err := archive.Create(filename)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, entry := range []archive.Entry{
+            {Name: "docs", Mode: os.ModeDir | 0755, ModTime: modTime},
+            {Name: "docs/hello.txt", Size: 6, Mode: 0644,
+                ModTime: modTime},
+            {Name: "empty", Mode: 0600, ModTime: modTime}} {
+            content, err := writer.Create(&entry)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if entry.Size > 0 {
+                io.WriteString(content, "Hello!")
+            }
+        }
+        if err := writer.Close(); err != nil {
+            t.Fatal(err)
+        }
+        if lines := readAll(t, filename); !reflect.DeepEqual(lines,
+            expected) {
+            t.Errorf("%s: expected\n%s\ngot\n%s", suffix,
+                strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+        }
+    }
+}
+
+func TestDetect(t *testing.T) {
+    dir, err := ioutil.TempDir("", "archive")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    for _, name := range []string{"zip", "tar", "tar.gz"} {
+        filename := filepath.Join(dir, "empty."+name)
+        writer, err := archive.Create(filename)
+        if err != nil {
+            t.Fatal(err)
+        }
+        writer.Close()
+        misnamed := filepath.Join(dir, "misnamed."+name+".dat")
+        if err := os.Rename(filename, misnamed); err != nil {
+            t.Fatal(err)
+        }
+        file, err := os.Open(misnamed)
+        if err != nil {
+            t.Fatal(err)
+        }
+        format, err := archive.Detect(file)
+        file.Close()
+        if err != nil || format.Name != name {
+            t.Errorf("expected %s got %v %v", name, format, err)
+        }
+    }
+    file, err := os.Open(filepath.Join("testdata", "hello.tar.bz2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    if format, err := archive.Detect(file); err != nil ||
+        format.Name != "tar.bz2" {
+        t.Errorf("expected tar.bz2 got %v %v", format, err)
+    }
+    text := filepath.Join(dir, "text.zip")
+    ioutil.WriteFile(text, []byte("not an archive"), 0644)
+    if _, err := archive.Open(text); err == nil ||
+        !strings.HasSuffix(err.Error(), archive.ErrFormat.Error()) {
+        t.Errorf("expected %v got %v", archive.ErrFormat, err)
+    }
+}
+
+func TestReadTarBzip2(t *testing.T) {
+    expected := []string{"notes/ drwxr-xr-x ",
+        "notes/hello.txt -rw-r--r-- Hello, bzip2!\n",
+        "notes/second.txt -rw-r--r-- second\n"}
+    if lines := readAll(t, filepath.Join("testdata", "hello.tar.bz2"));
+        !reflect.DeepEqual(lines, expected) {
+        t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"),
+            strings.Join(lines, "\n"))
+    }
+    if _, err := archive.Create(filepath.Join(os.TempDir(),
+        "x.tar.bz2")); err != archive.ErrReadOnly {
+        t.Errorf("expected %v got %v", archive.ErrReadOnly, err)
+    }
+}
+
+// bzip2 decompresses nothing until it has read a whole block, so this
+// archive is too big to recognize from the contents of its first bytes.
+func TestReadMultiBlockTarBzip2(t *testing.T) {
+    filename := filepath.Join("testdata", "numbers.tar.bz2")
+    lines := readAll(t, filename)
+    if len(lines) != 1 || !strings.HasPrefix(lines[0],
+        "numbers.txt -rw-r--r-- 1\n2\n") ||
+        !strings.HasSuffix(lines[0], "\n40000\n") {
+        t.Errorf("%s: unexpected entries", filename)
+    }
+}
+
+func TestReadGzip(t *testing.T) {
+    file, err := ioutil.TempFile("", "archive")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(file.Name())
+    zipper := gzip.NewWriter(file)
+    zipper.Name, zipper.ModTime = "readme.txt", modTime
+    io.WriteString(zipper, "Read me")
+    zipper.Close()
+    file.Close()
+    if lines := readAll(t, file.Name()); !reflect.DeepEqual(lines,
+        []string{"readme.txt -rw-r--r-- Read me"}) {
+        t.Errorf("unexpected gzip entries %q", lines)
+    }
+}
+
+func TestForFilename(t *testing.T) {
+    for filename, name := range map[string]string{"a.zip": "zip",
+        "A.TAR.GZ": "tar.gz", "a.tgz": "tar.gz", "a.gz": "gz",
+        "a.tar": "tar", "a.tar.bz2": "tar.bz2", "a.rar": ""} {
+        format := archive.ForFilename(filename)
+        if format == nil && name != "" ||
+            format != nil && format.Name != name {
+            t.Errorf("%s: expected %q got %v", filename, name, format)
+        }
+    }
+    if _, err := archive.Create("a.rar"); err != archive.ErrFormat {
+        t.Errorf("expected %v got %v", archive.ErrFormat, err)
+    }
+}
+
+func TestSanitizedName(t *testing.T) {
+    for name, expected := range map[string]string{"a/b.txt": "a/b.txt",
+        "/etc/passwd": "etc/passwd", "../../x": "x", "./a/../b": "b",
+        "x/....//....//etc": "x/..../..../etc", "a//b/./c/": "a/b/c"} {
+        if sanitized, err := archive.SanitizedName(name); err != nil ||
+            sanitized != expected {
+            t.Errorf("%s: expected %s got %s %v", name, expected,
+                sanitized, err)
+        }
+    }
+    for _, name := range []string{"a/../../etc", "x/../..", "a/b/../../.."} {
+        if sanitized, err := archive.SanitizedName(name); err !=
+            archive.ErrUnsafe {
+            t.Errorf("%s: expected %v got %s %v", name, archive.ErrUnsafe,
+                sanitized, err)
+        }
+    }
+}
@@ -0,0 +1,224 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/bzip2"
+    "compress/gzip"
+    "io"
+    "io/ioutil"
+    "strconv"
+    "strings"
+)
+
+// tarBlockSize is the size of a tar header, and maxCompressedHeader is
+// enough gzipped data to decompress one from.
+const (
+    tarBlockSize        = 512
+    maxCompressedHeader = 4096
+)
+
+// A bzip2 stream starts with "BZh", a block size digit, and then either
+// a block's or the end of stream's magic.
+var (
+    gzipMagic        = []byte{0x1F, 0x8B}
+    bzip2Magic       = []byte("BZh")
+    bzip2BlockMagic  = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+    bzip2EndMagic    = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+    bzip2MagicLength = len(bzip2Magic) + 1 + len(bzip2BlockMagic)
+)
+
+func init() {
+    Register(Format{Name: "tar", Suffixes: []string{".tar"},
+        MagicLength: tarBlockSize, Match: isTarHeader,
+        NewReader: func(reader io.ReaderAt, size int64) (Reader, error) {
+            return newTarReader(io.NewSectionReader(reader, 0, size), nil)
+        }, NewWriter: func(writer io.Writer) (Writer, error) {
+            return newTarWriter(writer, nil), nil
+        }})
+    Register(Format{Name: "tar.gz", Suffixes: []string{".tar.gz", ".tgz"},
+        MagicLength: maxCompressedHeader, Match: func(magic []byte) bool {
+            return bytes.HasPrefix(magic, gzipMagic) &&
+                isTarHeader(gunzipped(magic))
+        }, NewReader: func(reader io.ReaderAt, size int64) (Reader, error) {
+            unzipper, err := gzip.NewReader(io.NewSectionReader(reader, 0,
+                size))
+            if err != nil {
+                return nil, err
+            }
+            return newTarReader(unzipper, unzipper)
+        }, NewWriter: func(writer io.Writer) (Writer, error) {
+            zipper := gzip.NewWriter(writer)
+            return newTarWriter(zipper, zipper), nil
+        }})
+    Register(Format{Name: "tar.bz2", Suffixes: []string{".tar.bz2",
+        ".tbz2"}, MagicLength: bzip2MagicLength, Match: isBzip2Header,
+        NewReader: func(reader io.ReaderAt, size int64) (Reader, error) {
+            return newTarReader(bzip2.NewReader(io.NewSectionReader(reader,
+                0, size)), nil)
+        }}) // The standard library can't write bzip2
+    Register(Format{Name: "gz", Suffixes: []string{".gz"},
+        MagicLength: len(gzipMagic), Match: func(magic []byte) bool {
+            return bytes.HasPrefix(magic, gzipMagic)
+        }, NewReader: newGzipReader})
+}
+
+// isTarHeader returns true if the block starts with a tar header, i.e.,
+// one whose checksum is right since old tar headers have no magic, or
+// with the zeros that end a (in this case empty) tar archive.
+func isTarHeader(block []byte) bool {
+    if len(block) < tarBlockSize {
+        return false
+    }
+    if bytes.Count(block[:tarBlockSize], []byte{0}) == tarBlockSize {
+        return true
+    }
+    checksum, err := strconv.ParseInt(strings.Trim(string(block[148:156]),
+        " \x00"), 8, 64)
+    if err != nil {
+        return false
+    }
+    var sum int64
+    for i, octet := range block[:tarBlockSize] {
+        if i >= 148 && i < 156 { // The checksum counts as spaces
+            octet = ' '
+        }
+        sum += int64(octet)
+    }
+    return sum == checksum
+}
+
+// isBzip2Header returns true if the data starts a bzip2 stream; since
+// bzip2 can't decompress anything until it has read a whole block (of up
+// to 900KB), every bzip2 stream is taken to be a tar.bz2 without looking
+// for a tar header inside it.
+func isBzip2Header(magic []byte) bool {
+    if len(magic) < bzip2MagicLength || !bytes.HasPrefix(magic,
+        bzip2Magic) || magic[3] < '1' || magic[3] > '9' {
+        return false
+    }
+    return bytes.Equal(magic[4:bzip2MagicLength], bzip2BlockMagic) ||
+        bytes.Equal(magic[4:bzip2MagicLength], bzip2EndMagic)
+}
+
+// gunzipped returns the start of the gzipped data's content.
+func gunzipped(data []byte) []byte {
+    unzipper, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil
+    }
+    return decompressed(unzipper)
+}
+
+// decompressed returns the first block of the reader's content or as
+// much as it could read.
+func decompressed(reader io.Reader) []byte {
+    block := make([]byte, tarBlockSize)
+    count, _ := io.ReadFull(reader, block)
+    return block[:count]
+}
+
+type tarReader struct {
+    *tar.Reader
+    closer io.Closer // The decompressor, if any
+}
+
+func newTarReader(reader io.Reader, closer io.Closer) (Reader, error) {
+    return &tarReader{tar.NewReader(reader), closer}, nil
+}
+
+func (reader *tarReader) Next() (*Entry, error) {
+    header, err := reader.Reader.Next()
+    if err != nil {
+        return nil, err
+    }
+    info := header.FileInfo()
+    return &Entry{Name: header.Name, Size: header.Size, Mode: info.Mode(),
+        ModTime: header.ModTime, Uid: header.Uid, Gid: header.Gid}, nil
+}
+
+func (reader *tarReader) Open() (io.ReadCloser, error) {
+    return ioutil.NopCloser(reader.Reader), nil
+}
+
+func (reader *tarReader) Close() error {
+    if reader.closer != nil {
+        return reader.closer.Close()
+    }
+    return nil
+}
+
+type tarWriter struct {
+    *tar.Writer
+    closer io.Closer // The compressor, if any
+}
+
+func newTarWriter(writer io.Writer, closer io.Closer) Writer {
+    return &tarWriter{tar.NewWriter(writer), closer}
+}
+
+func (writer *tarWriter) Create(entry *Entry) (io.Writer, error) {
+    header := &tar.Header{Name: entry.Name, Size: entry.Size,
+        Mode: int64(entry.Mode.Perm()), ModTime: entry.ModTime,
+        Uid: entry.Uid, Gid: entry.Gid, Typeflag: tar.TypeReg}
+    if entry.IsDir() {
+        header.Typeflag, header.Size = tar.TypeDir, 0
+        if !strings.HasSuffix(header.Name, "/") {
+            header.Name += "/"
+        }
+    }
+    if err := writer.WriteHeader(header); err != nil {
+        return nil, err
+    }
+    return writer.Writer, nil
+}
+
+func (writer *tarWriter) Close() error {
+    err := writer.Writer.Close()
+    if writer.closer != nil {
+        if closeErr := writer.closer.Close(); err == nil {
+            err = closeErr
+        }
+    }
+    return err
+}
+
+// A gzipReader reads a gzipped file as an archive of that one file.
+type gzipReader struct {
+    *gzip.Reader
+    done bool
+}
+
+func newGzipReader(reader io.ReaderAt, size int64) (Reader, error) {
+    unzipper, err := gzip.NewReader(io.NewSectionReader(reader, 0, size))
+    if err != nil {
+        return nil, err
+    }
+    return &gzipReader{Reader: unzipper}, nil
+}
+
+func (reader *gzipReader) Next() (*Entry, error) {
+    if reader.done {
+        return nil, io.EOF
+    }
+    reader.done = true
+    return &Entry{Name: reader.Header.Name, Size: -1, Mode: 0644,
+        ModTime: reader.Header.ModTime}, nil
+}
+
+func (reader *gzipReader) Open() (io.ReadCloser, error) {
+    return ioutil.NopCloser(reader.Reader), nil
+}
@@ -0,0 +1,85 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+    "archive/zip"
+    "bytes"
+    "io"
+    "strings"
+)
+
+func init() {
+    Register(Format{Name: "zip", Suffixes: []string{".zip"},
+        MagicLength: 4, Match: func(magic []byte) bool {
+            // A local file header or, for an empty archive, the end of
+            // the central directory
+            return bytes.HasPrefix(magic, []byte("PK\x03\x04")) ||
+                bytes.HasPrefix(magic, []byte("PK\x05\x06"))
+        }, NewReader: newZipReader, NewWriter: newZipWriter})
+}
+
+type zipReader struct {
+    files   []*zip.File
+    current *zip.File
+}
+
+func newZipReader(reader io.ReaderAt, size int64) (Reader, error) {
+    zipped, err := zip.NewReader(reader, size)
+    if err != nil {
+        return nil, err
+    }
+    return &zipReader{files: zipped.File}, nil
+}
+
+func (reader *zipReader) Next() (*Entry, error) {
+    if len(reader.files) == 0 {
+        reader.current = nil
+        return nil, io.EOF
+    }
+    reader.current, reader.files = reader.files[0], reader.files[1:]
+    header := reader.current.FileHeader
+    return &Entry{Name: header.Name, Size: int64(header.UncompressedSize64),
+        Mode: header.Mode(), ModTime: header.Modified}, nil
+}
+
+func (reader *zipReader) Open() (io.ReadCloser, error) {
+    if reader.current == nil {
+        return nil, io.EOF
+    }
+    return reader.current.Open()
+}
+
+func (reader *zipReader) Close() error { return nil }
+
+type zipWriter struct {
+    *zip.Writer
+}
+
+func newZipWriter(writer io.Writer) (Writer, error) {
+    return zipWriter{zip.NewWriter(writer)}, nil
+}
+
+func (writer zipWriter) Create(entry *Entry) (io.Writer, error) {
+    header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate,
+        Modified: entry.ModTime}
+    if entry.IsDir() && !strings.HasSuffix(header.Name, "/") {
+        header.Name += "/"
+    }
+    header.SetMode(entry.Mode)
+    if entry.IsDir() {
+        header.Method = zip.Store
+    }
+    return writer.CreateHeader(header)
+}
@@ -139,6 +139,18 @@ func (m *Map) Do(function func(interface{}, interface{})) {
     do(m.root, function)
 }
 
+// DoFrom calls the given function on every key-value in the Map in order
+// starting from the first key that isn't less than the given key (or
+// from the first key if the given key is nil), stopping as soon as the
+// function returns false. For example:
+//      myMap.DoFrom(low, func(key, value interface{}) bool {
+//              return key.(int) < high
+//          })
+func (m *Map) DoFrom(key interface{},
+    function func(interface{}, interface{}) bool) {
+    m.doFrom(m.root, key, function)
+}
+
 // Len returns the number of key-value pairs in the map.
 func (m *Map) Len() int {
     return m.length
@@ -206,6 +218,21 @@ func do(root *node, function func(interface{}, interface{})) {
     }
 }
 
+// doFrom returns false if the function stopped the iteration.
+func (m *Map) doFrom(root *node, key interface{},
+    function func(interface{}, interface{}) bool) bool {
+    if root == nil {
+        return true
+    }
+    if key == nil || !m.less(root.key, key) {
+        if !m.doFrom(root.left, key, function) ||
+            !function(root.key, root.value) {
+            return false
+        }
+    }
+    return m.doFrom(root.right, key, function)
+}
+
 // We do not provide an exported First() method because this is an
 // implementation detail.
 func first(root *node) *node {
@@ -16,6 +16,7 @@
 package omap_test
 
 import (
+    "fmt"
     "qtrac.eu/omap"
     "strings"
     "testing"
@@ -86,6 +87,32 @@ func TestIntKeyOMapDelete(t *testing.T) {
     }
 }
 
+func TestIntKeyOMapDoFrom(t *testing.T) {
+    intMap := omap.NewIntKeyed()
+    for _, number := range []int{9, 1, 8, 2, 7, 3, 6, 4, 5, 0} {
+        intMap.Insert(number*2, number)
+    }
+    for _, test := range []struct {
+        from     interface{}
+        to       int
+        expected string
+    }{{nil, 8, "0123"}, {7, 13, "456"}, {8, 100, "456789"},
+        {19, 100, ""}} {
+        var numbers []string
+        intMap.DoFrom(test.from, func(key, value interface{}) bool {
+            if key.(int) >= test.to {
+                return false
+            }
+            numbers = append(numbers, fmt.Sprint(value))
+            return true
+        })
+        if actual := strings.Join(numbers, ""); actual != test.expected {
+            t.Errorf("from %v to %d: %q != %q", test.from, test.to,
+                actual, test.expected)
+        }
+    }
+}
+
 func TestPassing(t *testing.T) {
     intMap := omap.NewIntKeyed()
     intMap.Insert(7, 7)
@@ -14,12 +14,32 @@
 package main
 
 import (
+    "fmt"
     "image"
     "image/color"
+    "log"
+    "os"
+    "path/filepath"
     "shaper_ans2/shapes"
+    "strings"
 )
 
 func main() {
+    if len(os.Args) == 2 && os.Args[1] == "shapes" {
+        listShapes()
+        return
+    }
+    if len(os.Args) > 1 {
+        if len(os.Args) != 4 || os.Args[1] != "render" {
+            log.Fatalf("usage: %s [render scene.json out.{png,jpg,svg,"+
+                "pdf} | shapes]\n", filepath.Base(os.Args[0]))
+        }
+        if err := render(os.Args[2], os.Args[3]); err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        return
+    }
     img := shapes.FilledImage(420, 220, image.White)
     fill := color.RGBA{200, 200, 200, 0xFF} // light gray
     for i := 0; i < 10; i++ {
@@ -37,3 +57,48 @@ func main() {
     }
     shapes.SaveImage(img, "rectangle.png")
 }
+
+// listShapes prints the shapes that scene files can use and the fields
+// each one has besides the common ones, e.g., "star: Radius (required,
+// 1-1024), InnerRadius (0-1024), Points (3-60)" or "hexagon: Radius
+// (required, 1-1024), Sides (6)".
+func listShapes() {
+    for _, name := range shapes.Names() {
+        options, _ := shapes.Options(name)
+        fields := make([]string, 0, len(options))
+        for _, option := range options {
+            var notes []string
+            if option.Required {
+                notes = append(notes, "required")
+            }
+            if option.Max > 0 && option.Min == option.Max {
+                notes = append(notes, fmt.Sprintf("%d", option.Max))
+            } else if option.Max > 0 {
+                notes = append(notes, fmt.Sprintf("%d-%d", option.Min,
+                    option.Max))
+            }
+            field := option.Field
+            if notes != nil {
+                field += " (" + strings.Join(notes, ", ") + ")"
+            }
+            fields = append(fields, field)
+        }
+        fmt.Printf("%s: %s\n", name, strings.Join(fields, ", "))
+    }
+}
+
+func render(sceneFile, imageFile string) error {
+    scene, err := shapes.ReadScene(sceneFile)
+    if err != nil {
+        return err
+    }
+    canvas, err := shapes.NewCanvas(filepath.Ext(imageFile), scene.Width,
+        scene.Height, scene.Background)
+    if err != nil {
+        return err
+    }
+    if err := scene.Draw(canvas); err != nil {
+        return err
+    }
+    return shapes.SaveCanvas(canvas, imageFile)
+}
@@ -0,0 +1,285 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/gif"
+    "io"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+const (
+    maxFrameRate = 50 // GIF delays are in 100ths of a second
+    maxFrames    = 10000
+)
+
+// A Keyframe sets some of a shape's properties at a moment in an
+// animation. Between keyframes each property changes steadily from one
+// keyframe that sets it to the next and after the last it stays put.
+// Those properties left nil (or zero for the radius and sides) are set
+// by other keyframes or stay as they were when the shape was added.
+type Keyframe struct {
+    At       time.Duration // From the start of the animation
+    Position *image.Point  // Where the shape is drawn
+    Radius   int           // Only for Radiusers
+    Sides    int           // Only for Sideser
+    Fill     color.Color
+}
+
+// An Animation draws its shapes on a frame for every 1/frameRate of a
+// second of its duration.
+type Animation struct {
+    width, height int
+    background    color.Color
+    duration      time.Duration
+    frameRate     int
+    tracks        []*track
+}
+
+// A track is a shape and its keyframes in time order.
+type track struct {
+    shape     Shaper
+    keyframes []Keyframe
+}
+
+// NewAnimation returns an animation of the given size and duration; the
+// background may be nil for white and the frame rate is bounded to
+// 1-50 frames per second.
+func NewAnimation(width, height int, background color.Color,
+    duration time.Duration, frameRate int) *Animation {
+    if background == nil {
+        background = color.White
+    }
+    frameRate = makeBoundedIntFunc(1, maxFrameRate)(frameRate)
+    if minimum := time.Second / time.Duration(frameRate); duration <
+        minimum {
+        duration = minimum
+    }
+    return &Animation{width: saneLength(width), height: saneLength(height),
+        background: background, duration: duration, frameRate: frameRate}
+}
+
+func (animation *Animation) Bounds() image.Rectangle {
+    return image.Rect(0, 0, animation.width, animation.height)
+}
+
+func (animation *Animation) Duration() time.Duration {
+    return animation.duration
+}
+
+func (animation *Animation) FrameRate() int { return animation.frameRate }
+
+// Frames returns how many frames the animation has: one at the start and
+// one for every 1/frameRate of a second until the end.
+func (animation *Animation) Frames() int {
+    frames := int(math.Floor(animation.duration.Seconds()*
+        float64(animation.frameRate))) + 1
+    if frames > maxFrames {
+        return maxFrames
+    }
+    return frames
+}
+
+// Add adds the shape to be drawn at x, y and animated by the keyframes;
+// shapes are drawn in the order they were added. The position and the
+// shape's properties as they are now act as a keyframe at the start
+// (which keyframes at the start override). The shape itself is changed
+// while each frame is drawn but left as it was afterwards.
+func (animation *Animation) Add(shape Shaper, x, y int,
+    keyframes ...Keyframe) error {
+    start := Keyframe{Position: &image.Point{x, y}, Fill: shape.Fill()}
+    radiuser, isRadiuser := shape.(Radiuser)
+    if isRadiuser {
+        start.Radius = radiuser.Radius()
+    }
+    sideser, isSideser := shape.(Sideser)
+    if isSideser {
+        start.Sides = sideser.Sides()
+    }
+    for _, keyframe := range keyframes {
+        switch {
+        case keyframe.At < 0:
+            return fmt.Errorf("shapes.Add(): keyframe at %v is before the "+
+                "start", keyframe.At)
+        case keyframe.Radius != 0 && !isRadiuser:
+            return fmt.Errorf("shapes.Add(): %v has no radius", shape)
+        case keyframe.Sides != 0 && !isSideser:
+            return fmt.Errorf("shapes.Add(): %v has no sides", shape)
+        }
+    }
+    keyframes = append([]Keyframe{start}, keyframes...)
+    sort.SliceStable(keyframes, func(i, j int) bool {
+        return keyframes[i].At < keyframes[j].At
+    })
+    animation.tracks = append(animation.tracks, &track{shape, keyframes})
+    return nil
+}
+
+// Frame returns the given frame (counting from 0) drawn on a new canvas.
+func (animation *Animation) Frame(frame int) (*RasterCanvas, error) {
+    if frame < 0 || frame >= animation.Frames() {
+        return nil, fmt.Errorf("shapes.Frame(): frame %d is not in 0-%d",
+            frame, animation.Frames()-1)
+    }
+    at := time.Duration(frame) * time.Second /
+        time.Duration(animation.frameRate)
+    canvas := FilledImage(animation.width, animation.height,
+        animation.background)
+    for _, track := range animation.tracks {
+        if err := track.draw(canvas, at); err != nil {
+            return nil, err
+        }
+    }
+    return canvas, nil
+}
+
+// draw draws the track's shape as it is at the given time and then
+// restores the shape's properties.
+func (track *track) draw(canvas Canvas, at time.Duration) error {
+    shape := track.shape
+    fill := shape.Fill()
+    defer shape.SetFill(fill)
+    if from, to, fraction := track.between(at, func(keyframe Keyframe) bool {
+        return keyframe.Fill != nil
+    }); from != nil {
+        shape.SetFill(mix(from.Fill, to.Fill, fraction))
+    }
+    if radiuser, ok := shape.(Radiuser); ok {
+        radius := radiuser.Radius()
+        defer radiuser.SetRadius(radius)
+        if from, to, fraction := track.between(at,
+            func(keyframe Keyframe) bool {
+                return keyframe.Radius != 0
+            }); from != nil {
+            radiuser.SetRadius(lerp(from.Radius, to.Radius, fraction))
+        }
+    }
+    if sideser, ok := shape.(Sideser); ok {
+        sides := sideser.Sides()
+        defer sideser.SetSides(sides)
+        if from, to, fraction := track.between(at,
+            func(keyframe Keyframe) bool {
+                return keyframe.Sides != 0
+            }); from != nil {
+            sideser.SetSides(lerp(from.Sides, to.Sides, fraction))
+        }
+    }
+    from, to, fraction := track.between(at, func(keyframe Keyframe) bool {
+        return keyframe.Position != nil
+    })
+    return shape.Draw(canvas, lerp(from.Position.X, to.Position.X,
+        fraction), lerp(from.Position.Y, to.Position.Y, fraction))
+}
+
+// between returns the keyframes that set a property (as reported by
+// sets) either side of the time, and the fraction of the way the time is
+// from one to the other; the keyframes are the same one if the time is
+// before the first or after the last, and nil if none set the property.
+func (track *track) between(at time.Duration,
+    sets func(Keyframe) bool) (from, to *Keyframe, fraction float64) {
+    for i := range track.keyframes {
+        keyframe := &track.keyframes[i]
+        if !sets(*keyframe) {
+            continue
+        }
+        if keyframe.At > at {
+            if from == nil {
+                return keyframe, keyframe, 0
+            }
+            return from, keyframe, float64(at-from.At) /
+                float64(keyframe.At-from.At)
+        }
+        from = keyframe
+    }
+    return from, from, 0
+}
+
+func lerp(from, to int, fraction float64) int {
+    return int(math.Floor(float64(from) + float64(to-from)*fraction + 0.5))
+}
+
+// WriteGIF writes the animation as an animated GIF that loops forever.
+// The frames share a palette of their own colors if there are no more
+// than 256 of them, or else of colors chosen to be close to them (in
+// which case the frames are dithered).
+func (animation *Animation) WriteGIF(writer io.Writer) error {
+    frames := make([]image.Image, 0, animation.Frames())
+    for i := 0; i < animation.Frames(); i++ {
+        frame, err := animation.Frame(i)
+        if err != nil {
+            return err
+        }
+        frames = append(frames, frame)
+    }
+    palette, exact := paletteFor(frames, 256)
+    delay := int(math.Floor(100/float64(animation.frameRate) + 0.5))
+    result := &gif.GIF{}
+    for _, frame := range frames {
+        paletted := image.NewPaletted(frame.Bounds(), palette)
+        if exact {
+            draw.Draw(paletted, paletted.Rect, frame, image.ZP, draw.Src)
+        } else {
+            draw.FloydSteinberg.Draw(paletted, paletted.Rect, frame,
+                image.ZP)
+        }
+        result.Image = append(result.Image, paletted)
+        result.Delay = append(result.Delay, delay)
+    }
+    return gif.EncodeAll(writer, result)
+}
+
+// Save saves the animation as an animated GIF if the filename ends with
+// .gif, or as a numbered sequence of .png or .jpg (or .jpeg) images,
+// e.g., for walk.png as walk001.png, walk002.png, and so on (with as
+// many digits as the number of frames needs).
+func (animation *Animation) Save(filename string) error {
+    suffix := filepath.Ext(filename)
+    switch strings.ToLower(suffix) {
+    case ".gif":
+        file, err := os.Create(filename)
+        if err != nil {
+            return err
+        }
+        if err := animation.WriteGIF(file); err != nil {
+            file.Close()
+            return err
+        }
+        return file.Close()
+    case ".png", ".jpg", ".jpeg":
+        stem := strings.TrimSuffix(filename, suffix)
+        digits := len(fmt.Sprint(animation.Frames()))
+        for i := 0; i < animation.Frames(); i++ {
+            frame, err := animation.Frame(i)
+            if err != nil {
+                return err
+            }
+            if err := SaveImage(frame, fmt.Sprintf("%s%0*d%s", stem,
+                digits, i+1, suffix)); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    return fmt.Errorf("shapes.Save(): '%s' has an unrecognized suffix",
+        filename)
+}
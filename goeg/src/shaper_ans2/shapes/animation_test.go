@@ -0,0 +1,205 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "image/gif"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "shaper_ans2/shapes"
+    "testing"
+    "time"
+)
+
+func TestAnimationFrames(t *testing.T) {
+    animation := shapes.NewAnimation(100, 60, nil, time.Second, 4)
+    if frames := animation.Frames(); frames != 5 {
+        t.Errorf("expected 5 frames (0s to 1s) got %d", frames)
+    }
+    polygon := shapes.NewRegularPolygon(red, 10, 3)
+    polygon.SetFilled(true)
+    if err := animation.Add(polygon, 10, 30, shapes.Keyframe{
+        At: time.Second, Position: &image.Point{90, 30}, Radius: 20,
+        Sides: 7, Fill: blue}); err != nil {
+        t.Fatal(err)
+    }
+    frame, err := animation.Frame(2) // Half way
+    if err != nil {
+        t.Fatal(err)
+    }
+    middle := frame.At(50, 30).(color.RGBA)
+    if middle.R != 0x80 || middle.G != 0 || middle.B != 0x80 {
+        t.Errorf("expected half way between red and blue got %v", middle)
+    }
+    // A 5-sided radius 15 polygon is 15 below its middle but not above
+    checkPixels(t, "frame 2", frame, nil, []image.Point{{34, 30},
+        {66, 30}, {50, 46}})
+    if frame.At(50, 44) == color.Color(white) {
+        t.Error("expected the polygon's radius to be 15 half way")
+    }
+    if polygon.Sides() != 3 || polygon.Radius() != 10 ||
+        polygon.Fill() != color.Color(red) {
+        t.Errorf("expected the polygon to be restored got %v", polygon)
+    }
+    last, err := animation.Frame(4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if last.At(90, 30) != color.Color(blue) || last.At(10, 30) !=
+        color.Color(white) {
+        t.Error("expected the polygon to end up blue at 90, 30")
+    }
+    if _, err := animation.Frame(5); err == nil {
+        t.Error("expected an error for a frame after the end")
+    }
+}
+
+func TestAnimationKeyframes(t *testing.T) {
+    animation := shapes.NewAnimation(100, 20, color.White, 4*time.Second,
+        1)
+    circle := shapes.NewCircle(red, 2)
+    // Keyframes out of order, with the first position only at 2s
+    if err := animation.Add(circle, 10, 10, shapes.Keyframe{
+        At: 4 * time.Second, Position: &image.Point{90, 10}},
+        shapes.Keyframe{At: 2 * time.Second,
+            Position: &image.Point{50, 10}}); err != nil {
+        t.Fatal(err)
+    }
+    for i, x := range []int{10, 30, 50, 70, 90} {
+        frame, err := animation.Frame(i)
+        if err != nil {
+            t.Fatal(err)
+        }
+        checkPixels(t, "circle", frame, []image.Point{{x + 2, 10}},
+            []image.Point{{x, 10}})
+    }
+    rectangle := shapes.NewRectangle(red, image.Rect(0, 0, 5, 5))
+    for _, keyframe := range []shapes.Keyframe{{Radius: 3}, {Sides: 3},
+        {At: -time.Second}} {
+        if err := animation.Add(rectangle, 0, 0, keyframe); err == nil {
+            t.Errorf("expected an error for keyframe %+v", keyframe)
+        }
+    }
+}
+
+func TestAnimatedGIF(t *testing.T) {
+    animation := shapes.NewAnimation(40, 40, nil, time.Second, 10)
+    circle := shapes.NewCircle(red, 15)
+    circle.SetFilled(true)
+    animation.Add(circle, 20, 20, shapes.Keyframe{At: time.Second,
+        Fill: blue})
+    var buffer bytes.Buffer
+    if err := animation.WriteGIF(&buffer); err != nil {
+        t.Fatal(err)
+    }
+    result, err := gif.DecodeAll(&buffer)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(result.Image) != 11 || result.Delay[0] != 10 ||
+        result.LoopCount != 0 {
+        t.Errorf("expected 11 frames 1/10s apart looping got %d frames "+
+            "%d/100s apart looping %d", len(result.Image), result.Delay[0],
+            result.LoopCount)
+    }
+    // Few enough colors to use them exactly
+    first, last := result.Image[0], result.Image[10]
+    if !sameColor(first.At(20, 20), red) || !sameColor(last.At(20, 20),
+        blue) || !sameColor(last.At(1, 1), white) {
+        t.Errorf("expected exact colors got %v, %v, %v", first.At(20, 20),
+            last.At(20, 20), last.At(1, 1))
+    }
+}
+
+// TestGIFPalette checks that frames with too many colors get a palette
+// of 256 that is close to them.
+func TestGIFPalette(t *testing.T) {
+    animation := shapes.NewAnimation(256, 64, nil, 0, 1)
+    rectangle := shapes.NewRectangle(red, image.Rect(0, 0, 255, 63))
+    rectangle.SetFilled(true)
+    rectangle.SetPaint(shapes.LinearGradient{image.Pt(0, 0),
+        image.Pt(255, 63), []shapes.Stop{{0, red}, {0.5, blue},
+            {1, color.RGBA{0, 0xFF, 0, 0xFF}}}})
+    animation.Add(rectangle, 0, 0)
+    var buffer bytes.Buffer
+    if err := animation.WriteGIF(&buffer); err != nil {
+        t.Fatal(err)
+    }
+    result, err := gif.DecodeAll(&buffer)
+    if err != nil {
+        t.Fatal(err)
+    }
+    frame := result.Image[0]
+    if len(frame.Palette) != 256 {
+        t.Errorf("expected 256 colors got %d", len(frame.Palette))
+    }
+    expected, _ := animation.Frame(0)
+    var total int64
+    for y := 0; y < 64; y++ {
+        for x := 0; x < 256; x++ {
+            total += colorDistance(frame.At(x, y), expected.At(x, y))
+        }
+    }
+    if average := total / (256 * 64); average > 8 {
+        t.Errorf("expected colors close to the frame's, on average "+
+            "they are %d apart", average)
+    }
+}
+
+func TestSaveAnimation(t *testing.T) {
+    dir, err := ioutil.TempDir("", "shapes")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    animation := shapes.NewAnimation(20, 20, nil, time.Second, 10)
+    animation.Add(shapes.NewCircle(red, 5), 10, 10)
+    if err := animation.Save(filepath.Join(dir, "frame.png")); err != nil {
+        t.Fatal(err)
+    }
+    if err := animation.Save(filepath.Join(dir, "loop.gif")); err != nil {
+        t.Fatal(err)
+    }
+    names, _ := filepath.Glob(filepath.Join(dir, "*"))
+    if len(names) != 12 || filepath.Base(names[0]) != "frame01.png" ||
+        filepath.Base(names[10]) != "frame11.png" ||
+        filepath.Base(names[11]) != "loop.gif" {
+        t.Errorf("expected frame01.png to frame11.png and loop.gif got %v",
+            names)
+    }
+    if err := animation.Save(filepath.Join(dir, "x.svg")); err == nil {
+        t.Error("expected an error for an unsupported suffix")
+    }
+}
+
+func sameColor(a, b color.Color) bool { return colorDistance(a, b) == 0 }
+
+func colorDistance(a, b color.Color) int64 {
+    r1, g1, b1, a1 := a.RGBA()
+    r2, g2, b2, a2 := b.RGBA()
+    distance := int64(0)
+    for _, pair := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2},
+        {a1, a2}} {
+        difference := int64(pair[0]>>8) - int64(pair[1]>>8)
+        if difference < 0 {
+            difference = -difference
+        }
+        distance += difference
+    }
+    return distance
+}
@@ -0,0 +1,97 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// A Canvas is what shapes draw themselves on. Every primitive takes
+// pixel coordinates with 0, 0 at the top-left; raster canvases set
+// pixels while vector canvases record resolution-independent paths.
+type Canvas interface {
+    Bounds() image.Rectangle
+    Line(start, end image.Point, fill color.Color, style Style)
+    Circle(center image.Point, radius int, fill color.Color, style Style)
+    Ellipse(center image.Point, radiusX, radiusY int, fill color.Color,
+        style Style)
+    // Polyline outlines the open path through the points.
+    Polyline(points []image.Point, fill color.Color, style Style)
+    // Polygon outlines the polygon, closing it if the last point isn't
+    // the first.
+    Polygon(points []image.Point, fill color.Color, style Style)
+    // FillRect fills the pixels from rect.Min to rect.Max inclusive.
+    FillRect(rect image.Rectangle, paint Paint)
+    // FillPolygon fills the inside of the polygon as given by the rule.
+    FillPolygon(points []image.Point, paint Paint, rule FillRule,
+        antiAlias bool)
+    // FillEllipse fills the pixels that are within radiusX horizontally
+    // and radiusY vertically of the center, like Circle's outline.
+    FillEllipse(center image.Point, radiusX, radiusY int, paint Paint,
+        antiAlias bool)
+}
+
+// NewCanvas returns a canvas suitable for saving to a file with the
+// given suffix (.png, .jpg, .jpeg, .svg, or .pdf) using SaveCanvas().
+func NewCanvas(suffix string, width, height int,
+    background color.Color) (Canvas, error) {
+    switch strings.ToLower(suffix) {
+    case ".jpg", ".jpeg", ".png":
+        return FilledImage(width, height, background), nil
+    case ".svg":
+        return NewSVGCanvas(width, height, background), nil
+    case ".pdf":
+        return NewPDFCanvas(width, height, background), nil
+    }
+    return nil, fmt.Errorf("shapes.NewCanvas(): '%s' is an unrecognized "+
+        "suffix", suffix)
+}
+
+// SaveCanvas saves a raster canvas as a .png or .jpg (or .jpeg) image,
+// an SVGCanvas as a .svg file, and a PDFCanvas as a .pdf file.
+func SaveCanvas(canvas Canvas, filename string) error {
+    suffix := strings.ToLower(filepath.Ext(filename))
+    switch canvas := canvas.(type) {
+    case *SVGCanvas:
+        if suffix == ".svg" {
+            return saveWriterTo(canvas, filename)
+        }
+    case *PDFCanvas:
+        if suffix == ".pdf" {
+            return saveWriterTo(canvas, filename)
+        }
+    case image.Image:
+        return SaveImage(canvas, filename)
+    }
+    return fmt.Errorf("shapes.SaveCanvas(): can't save a %T as '%s'",
+        canvas, filename)
+}
+
+func saveWriterTo(writerTo io.WriterTo, filename string) error {
+    file, err := os.Create(filename)
+    if err != nil {
+        return err
+    }
+    if _, err = writerTo.WriteTo(file); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
@@ -0,0 +1,170 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/color"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "shaper_ans2/shapes"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+var red = color.RGBA{0xFF, 0, 0, 0xFF}
+
+func drawTestShapes(canvas shapes.Canvas) error {
+    circle, _ := shapes.New("circle", shapes.Option{Fill: red, Radius: 20})
+    square, _ := shapes.New("square", shapes.Option{Fill: red, Radius: 20})
+    rectangle, _ := shapes.New("rectangle", shapes.Option{Fill: red,
+        Rect: image.Rect(0, 0, 30, 10), Filled: true})
+    if err := shapes.DrawShapes(canvas, 30, 30, circle, square);
+        err != nil {
+        return err
+    }
+    return rectangle.Draw(canvas, 60, 10)
+}
+
+func TestRasterCanvas(t *testing.T) {
+    canvas, err := shapes.NewCanvas(".png", 100, 60, image.White)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := drawTestShapes(canvas); err != nil {
+        t.Fatal(err)
+    }
+    img := canvas.(image.Image)
+    for _, point := range []image.Point{{50, 30}, {10, 30}, {30, 50},
+        {60, 10}, {90, 20}} {
+        if img.At(point.X, point.Y) != color.Color(red) {
+            t.Errorf("expected %v to be red", point)
+        }
+    }
+    if img.At(30, 30) != color.Color(color.RGBA{0xFF, 0xFF, 0xFF,
+        0xFF}) {
+        t.Error("expected the circle's center to be white")
+    }
+}
+
+func TestSVGCanvas(t *testing.T) {
+    canvas := shapes.NewSVGCanvas(100, 60, color.White)
+    if err := drawTestShapes(canvas); err != nil {
+        t.Fatal(err)
+    }
+    var buffer bytes.Buffer
+    if _, err := canvas.WriteTo(&buffer); err != nil {
+        t.Fatal(err)
+    }
+    svg := buffer.String()
+    for _, text := range []string{
+        `width="100" height="60" viewBox="0 0 100 60"`,
+        `<rect x="0" y="0" width="100" height="60" fill="#FFFFFF"/>`,
+        `<circle cx="30.5" cy="30.5" r="20" fill="none" stroke="#FF0000"/>`,
+        `<circle cx="31.5" cy="30.5" r="20"`,
+        `<polygon points="30.5,50.5 50.5,30.5 30.5,10.5 10.5,29.5"`,
+        `<rect x="60" y="10" width="31" height="11" fill="#FF0000"/>`,
+        "</svg>\n"} {
+        if !strings.Contains(svg, text) {
+            t.Errorf("expected %q in\n%s", text, svg)
+        }
+    }
+    translucent := shapes.NewSVGCanvas(10, 10, nil)
+    translucent.Line(image.Pt(0, 0), image.Pt(9, 9), color.NRGBA{0, 0, 0xFF,
+        0x80}, shapes.Style{})
+    buffer.Reset()
+    translucent.WriteTo(&buffer)
+    if !strings.Contains(buffer.String(),
+        `stroke="#0000FF" stroke-opacity="0.502"`) {
+        t.Errorf("expected a translucent stroke in\n%s", buffer.String())
+    }
+}
+
+func TestPDFCanvas(t *testing.T) {
+    canvas := shapes.NewPDFCanvas(100, 60, color.White)
+    if err := drawTestShapes(canvas); err != nil {
+        t.Fatal(err)
+    }
+    var buffer bytes.Buffer
+    if _, err := canvas.WriteTo(&buffer); err != nil {
+        t.Fatal(err)
+    }
+    pdf := buffer.Bytes()
+    for _, text := range []string{"%PDF-1.4\n", "/MediaBox [0 0 100 60]",
+        "1 0 0 -1 0 60 cm\n", "q 1 1 1 rg 0 0 100 60 re f Q\n",
+        "q 1 0 0 RG 50.5 30.5 m\n", "q 1 0 0 rg 60 10 31 11 re f Q\n",
+        "q 1 0 0 RG 30.5 50.5 m 50.5 30.5 l 30.5 10.5 l 10.5 29.5 l s Q\n",
+        "%%EOF\n"} {
+        if !bytes.Contains(pdf, []byte(text)) {
+            t.Errorf("expected %q in\n%s", text, pdf)
+        }
+    }
+    checkXref(t, pdf, 4)
+}
+
+// checkXref checks that every xref entry gives the offset of its object.
+func checkXref(t *testing.T, pdf []byte, count int) {
+    xref := regexp.MustCompile(`(?m)^(\d{10}) 00000 n $`).FindAllSubmatch(
+        pdf, -1)
+    if len(xref) != count {
+        t.Fatalf("expected %d objects, got %d", count, len(xref))
+    }
+    for i, match := range xref {
+        offset, _ := strconv.Atoi(string(match[1]))
+        if !bytes.HasPrefix(pdf[offset:], []byte(fmt.Sprintf("%d 0 obj",
+            i+1))) {
+            t.Errorf("xref entry %d has the wrong offset %d", i+1, offset)
+        }
+    }
+}
+
+func TestSaveCanvas(t *testing.T) {
+    dir, err := ioutil.TempDir("", "shapes")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    for _, suffix := range []string{".png", ".jpg", ".svg", ".pdf"} {
+        canvas, err := shapes.NewCanvas(suffix, 100, 60, color.White)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := drawTestShapes(canvas); err != nil {
+            t.Fatal(err)
+        }
+        filename := filepath.Join(dir, "shapes"+suffix)
+        if err := shapes.SaveCanvas(canvas, filename); err != nil {
+            t.Fatal(err)
+        }
+        if info, err := os.Stat(filename); err != nil || info.Size() == 0 {
+            t.Errorf("%s: not saved", filename)
+        }
+    }
+    if _, err := shapes.NewCanvas(".gif", 10, 10, nil); err == nil {
+        t.Error("expected an error for an unsupported suffix")
+    }
+    if err := shapes.SaveCanvas(shapes.NewSVGCanvas(10, 10, nil),
+        filepath.Join(dir, "shapes.png")); err == nil {
+        t.Error("expected an error saving an SVGCanvas as a .png")
+    }
+}
+
+var _ io.WriterTo = (*shapes.SVGCanvas)(nil)
+var _ io.WriterTo = (*shapes.PDFCanvas)(nil)
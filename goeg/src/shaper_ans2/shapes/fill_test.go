@@ -0,0 +1,206 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "shaper_ans2/shapes"
+    "strings"
+    "testing"
+)
+
+var blue = color.RGBA{0, 0, 0xFF, 0xFF}
+
+// A five-pointed star whose middle is inside for the non-zero rule but
+// outside for the even-odd rule.
+var star = []image.Point{{10, 38}, {20, 2}, {30, 38}, {2, 15}, {38, 15}}
+
+func TestFillRules(t *testing.T) {
+    for _, test := range []struct {
+        rule   shapes.FillRule
+        middle color.Color
+    }{{shapes.NonZero, blue}, {shapes.EvenOdd, white}} {
+        canvas := shapes.FilledImage(40, 40, white)
+        canvas.FillPolygon(star, shapes.Solid{blue}, test.rule, false)
+        if canvas.At(20, 22) != test.middle {
+            t.Errorf("%s: expected the middle to be %v got %v", test.rule,
+                test.middle, canvas.At(20, 22))
+        }
+        if canvas.At(20, 6) != color.Color(blue) {
+            t.Errorf("%s: expected the top point to be filled", test.rule)
+        }
+    }
+}
+
+func TestFilledShapes(t *testing.T) {
+    for _, name := range []string{"circle", "hexagon", "rectangle"} {
+        canvas := shapes.FilledImage(40, 40, white)
+        shape, err := shapes.New(name, shapes.Option{Fill: red, Radius: 10,
+            Rect: image.Rect(-10, -10, 10, 10), Filled: true})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !shape.(shapes.Filleder).Filled() {
+            t.Fatalf("%s: shapes.New() ignored Filled", name)
+        }
+        if err := shape.Draw(canvas, 20, 20); err != nil {
+            t.Fatal(err)
+        }
+        for _, point := range []image.Point{{20, 20}, {20, 12}, {15, 25}} {
+            if canvas.At(point.X, point.Y) != color.Color(red) {
+                t.Errorf("%s: expected %v to be filled", name, point)
+            }
+        }
+        if canvas.At(20, 35) != color.Color(white) {
+            t.Errorf("%s: filled beyond its radius", name)
+        }
+    }
+}
+
+func TestAdjacentFillsDontOverlap(t *testing.T) {
+    canvas := shapes.FilledImage(20, 10, white)
+    translucent := shapes.Solid{color.NRGBA{0, 0, 0, 0x80}}
+    canvas.FillPolygon([]image.Point{{0, 0}, {10, 0}, {10, 10}, {0, 10}},
+        translucent, shapes.NonZero, false)
+    canvas.FillPolygon([]image.Point{{10, 0}, {20, 0}, {20, 10}, {10, 10}},
+        translucent, shapes.NonZero, false)
+    for x := 0; x < 20; x++ {
+        if canvas.At(x, 5) != canvas.At(0, 5) {
+            t.Errorf("pixel %d, 5 is %v not %v", x, canvas.At(x, 5),
+                canvas.At(0, 5))
+        }
+    }
+}
+
+func TestAntiAliasedFill(t *testing.T) {
+    aliased := shapes.FilledImage(40, 40, white)
+    aliased.FillEllipse(image.Pt(20, 20), 15, 8, shapes.Solid{blue}, false)
+    smooth := shapes.FilledImage(40, 40, white)
+    smooth.FillEllipse(image.Pt(20, 20), 15, 8, shapes.Solid{blue}, true)
+    partial := 0
+    for y := 0; y < 40; y++ {
+        for x := 0; x < 40; x++ {
+            pixel := color.RGBAModel.Convert(smooth.At(x, y)).(color.RGBA)
+            if pixel != white && pixel != blue {
+                partial++
+            } else if pixel == blue &&
+                aliased.At(x, y) != color.Color(blue) {
+                t.Errorf("%d, %d is fully covered but wasn't filled", x, y)
+            }
+        }
+    }
+    if partial == 0 {
+        t.Error("expected partially covered pixels at the edge")
+    }
+    if aliased.At(20, 12) != color.Color(blue) ||
+        aliased.At(20, 11) != color.Color(white) ||
+        aliased.At(35, 20) != color.Color(blue) {
+        t.Error("expected the ellipse to reach its radii")
+    }
+}
+
+func TestGradients(t *testing.T) {
+    stops := []shapes.Stop{{0, red}, {1, blue}}
+    canvas := shapes.FilledImage(40, 40, white)
+    linear, _ := shapes.New("rectangle", shapes.Option{Filled: true,
+        Rect: image.Rect(0, 0, 20, 2), Paint: shapes.LinearGradient{
+            Start: image.Pt(0, 0), End: image.Pt(20, 0), Stops: stops}})
+    linear.Draw(canvas, 10, 5) // The gradient moves with the shape
+    if canvas.At(10, 5) != color.Color(red) ||
+        canvas.At(30, 5) != color.Color(blue) {
+        t.Errorf("expected red to blue got %v to %v", canvas.At(10, 5),
+            canvas.At(30, 5))
+    }
+    if middle := color.RGBAModel.Convert(canvas.At(20, 5)).(color.RGBA);
+        middle.R < 0x70 || middle.R > 0x90 || middle.B < 0x70 ||
+            middle.B > 0x90 {
+        t.Errorf("expected purple halfway along got %v", middle)
+    }
+    radial, _ := shapes.New("circle", shapes.Option{Filled: true,
+        Radius: 10, Paint: shapes.RadialGradient{Radius: 10,
+            Stops: stops}})
+    radial.Draw(canvas, 20, 25)
+    if canvas.At(20, 25) != color.Color(red) ||
+        canvas.At(30, 25) != color.Color(blue) {
+        t.Errorf("expected red in the middle to blue at the edge got %v "+
+            "to %v", canvas.At(20, 25), canvas.At(30, 25))
+    }
+}
+
+func TestPattern(t *testing.T) {
+    tile := image.NewRGBA(image.Rect(0, 0, 2, 1))
+    tile.Set(0, 0, red)
+    tile.Set(1, 0, blue)
+    canvas := shapes.FilledImage(20, 20, white)
+    canvas.FillRect(image.Rect(3, 3, 10, 10), shapes.Pattern{Image: tile,
+        Origin: image.Pt(1, 0)})
+    for x := 3; x <= 10; x++ {
+        expected := color.Color(red)
+        if x%2 == 0 {
+            expected = blue
+        }
+        if canvas.At(x, 7) != expected {
+            t.Errorf("expected %d, 7 to be %v got %v", x, expected,
+                canvas.At(x, 7))
+        }
+    }
+}
+
+func TestVectorFills(t *testing.T) {
+    tile := image.NewRGBA(image.Rect(0, 0, 2, 2))
+    draw := func(canvas shapes.Canvas) {
+        canvas.FillPolygon(star, shapes.Solid{blue}, shapes.EvenOdd, false)
+        canvas.FillEllipse(image.Pt(20, 20), 10, 5, shapes.LinearGradient{
+            End: image.Pt(10, 0), Stops: []shapes.Stop{{0.2, red},
+                {0.5, white}, {0.8, blue}}}, false)
+        canvas.FillRect(image.Rect(0, 0, 9, 9), shapes.RadialGradient{
+            Radius: 5})
+        canvas.FillRect(image.Rect(0, 0, 9, 9), shapes.Pattern{Image: tile})
+    }
+    svgCanvas := shapes.NewSVGCanvas(40, 40, nil)
+    draw(svgCanvas)
+    var buffer bytes.Buffer
+    svgCanvas.WriteTo(&buffer)
+    svg := buffer.String()
+    for _, text := range []string{`fill="#0000FF" fill-rule="evenodd"/>`,
+        `<ellipse cx="20.5" cy="20.5" rx="10.5" ry="5.5" ` +
+            `fill="url(#paint1)"/>`,
+        `<linearGradient id="paint1" gradientUnits="userSpaceOnUse" ` +
+            `x1="0.5" y1="0.5" x2="10.5" y2="0.5">`,
+        `<stop offset="0.5" stop-color="#FFFFFF"/>`,
+        `<radialGradient id="paint2"`, `<pattern id="paint3"`,
+        `fill="url(#paint3)"/>`} {
+        if !strings.Contains(svg, text) {
+            t.Errorf("expected %q in\n%s", text, svg)
+        }
+    }
+    pdfCanvas := shapes.NewPDFCanvas(40, 40, nil)
+    draw(pdfCanvas)
+    buffer.Reset()
+    pdfCanvas.WriteTo(&buffer)
+    pdf := buffer.Bytes()
+    for _, text := range []string{" 38.5 15.5 l h f* Q\n",
+        "q /Pattern cs /P0 scn 31 20.5 m\n",
+        "/Pattern << /P0 5 0 R /P1 6 0 R /P2 7 0 R >>",
+        "/ShadingType 2 /Coords [0.5 0.5 10.5 0.5]",
+        "/Bounds [0.2 0.5 0.8]", "/ShadingType 3 /Coords [0.5 0.5 0 0.5 " +
+            "0.5 5]", "/PatternType 1", "/XStep 2 /YStep 2"} {
+        if !bytes.Contains(pdf, []byte(text)) {
+            t.Errorf("expected %q in\n%s", text, pdf)
+        }
+    }
+    checkXref(t, pdf, 7)
+}
@@ -0,0 +1,288 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "flag"
+    "fmt"
+    "font"
+    "image"
+    "image/color"
+    "image/png"
+    "os"
+    "path/filepath"
+    "shaper_ans2/shapes"
+    "testing"
+)
+
+// The golden images are of this package's shapes only: the other shaper
+// programs' shapes packages are the book's examples, which draw straight
+// on a draw.Image without a Canvas. Run "go test -update" to rewrite the
+// golden images after an intentional change to how shapes are drawn; look
+// at them before committing them.
+var update = flag.Bool("update", false,
+    "rewrite the golden images in "+goldenDir)
+
+const (
+    goldenDir  = "testdata/golden"
+    goldenSize = 64
+    // A pixel differs if its perceptual difference (0-1) is over this,
+    // and an image matches if no more than maxDiffering pixels do; these
+    // allow for floating-point differences between platforms.
+    diffThreshold = 0.1
+    maxDiffering  = 4
+)
+
+var (
+    gray     = color.RGBA{0xC0, 0xC0, 0xC0, 0xFF}
+    seaBlue  = color.NRGBA{0, 0x40, 0xFF, 0x99}
+    deepTeal = color.RGBA{0, 0x60, 0x60, 0xFF}
+)
+
+// goldenShapes make each kind of shape scaled to the size (half its
+// width and height).
+var goldenShapes = []struct {
+    name   string
+    option func(size int) shapes.Option
+}{
+    {"circle", func(size int) shapes.Option {
+        return shapes.Option{Radius: size}
+    }},
+    {"hexagon", func(size int) shapes.Option {
+        return shapes.Option{Radius: size}
+    }},
+    {"rectangle", func(size int) shapes.Option {
+        return shapes.Option{Rect: image.Rect(-size, -size/2, size,
+            size/2)}
+    }},
+    {"ellipse", func(size int) shapes.Option {
+        return shapes.Option{Radius: size, RadiusY: size / 2}
+    }},
+    {"arc", func(size int) shapes.Option {
+        return shapes.Option{Radius: size, StartAngle: 200, EndAngle: 70}
+    }},
+    {"pie", func(size int) shapes.Option {
+        return shapes.Option{Radius: size, StartAngle: 30, EndAngle: 300}
+    }},
+    {"star", func(size int) shapes.Option {
+        return shapes.Option{Radius: size, InnerRadius: size * 2 / 5}
+    }},
+    {"roundedrectangle", func(size int) shapes.Option {
+        return shapes.Option{Rect: image.Rect(-size, -size*2/3, size,
+            size*2/3), CornerRadius: size / 3}
+    }},
+    {"polyline", func(size int) shapes.Option {
+        return shapes.Option{Vertices: []image.Point{{-size, size},
+            {-size / 3, -size}, {size / 3, size / 2}, {size, -size / 2}}}
+    }},
+    {"cubicbezier", func(size int) shapes.Option {
+        return shapes.Option{Vertices: []image.Point{{-size, 0},
+            {-size / 2, -size * 2}, {size / 2, size * 2}, {size, 0}}}
+    }},
+    {"text", func(size int) shapes.Option {
+        return shapes.Option{Text: "Go!", Align: shapes.AlignCenter,
+            Font: font.New("monospace", size)}
+    }},
+}
+
+// goldenVariants are the sizes, positions, colors, and styles each
+// shape is drawn with. The edge and corner positions are on the canvas
+// with the shape partly off it; the outside one is off the canvas with
+// the shape partly on it, which Draw() accepts since checkBounds() only
+// checks an empty rectangle.
+var goldenVariants = []struct {
+    name       string
+    size, x, y int
+    fill       color.Color
+    background color.Color
+    filled     bool
+    style      shapes.Style
+}{
+    {"small", 8, 32, 32, red, white, false, shapes.Style{}},
+    {"large", 24, 32, 32, seaBlue, gray, true, shapes.Style{}},
+    {"thick", 20, 32, 32, deepTeal, white, false,
+        shapes.Style{Width: 3, AntiAlias: true}},
+    {"edge", 16, 3, 32, red, white, true, shapes.Style{}},
+    {"corner", 16, 60, 62, seaBlue, gray, false,
+        shapes.Style{Width: 2, Join: shapes.RoundJoin, AntiAlias: true}},
+    {"outside", 20, -8, 70, deepTeal, white, true,
+        shapes.Style{AntiAlias: true}},
+}
+
+func TestGoldenImages(t *testing.T) {
+    for _, shape := range goldenShapes {
+        for _, variant := range goldenVariants {
+            name := shape.name + "-" + variant.name
+            option := shape.option(variant.size)
+            option.Fill = variant.fill
+            option.Filled = variant.filled
+            option.Style = variant.style
+            shaper, err := shapes.New(shape.name, option)
+            if err != nil {
+                t.Fatal(err)
+            }
+            canvas := shapes.FilledImage(goldenSize, goldenSize,
+                variant.background)
+            if err := shaper.Draw(canvas, variant.x, variant.y); err != nil {
+                t.Errorf("%s: %v", name, err)
+                continue
+            }
+            checkGolden(t, name, canvas)
+        }
+    }
+}
+
+// checkGolden compares the image with testdata/golden/name.png, or
+// rewrites the golden image if the -update flag is given. If they
+// differ it writes the image and a diff image to a temporary directory.
+func checkGolden(t *testing.T, name string, img image.Image) {
+    filename := filepath.Join(goldenDir, name+".png")
+    if *update {
+        if err := writePNG(filename, img); err != nil {
+            t.Fatal(err)
+        }
+        return
+    }
+    golden, err := readPNG(filename)
+    if err != nil {
+        t.Errorf("%s: %v (run go test -update to create it)", name, err)
+        return
+    }
+    diff, differing := perceptualDiff(golden, img)
+    if differing <= maxDiffering {
+        return
+    }
+    dir := filepath.Join(os.TempDir(), "shapes-golden")
+    got, diffName := filepath.Join(dir, name+".png"),
+        filepath.Join(dir, name+"-diff.png")
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := writePNG(got, img); err != nil {
+        t.Fatal(err)
+    }
+    if err := writePNG(diffName, diff); err != nil {
+        t.Fatal(err)
+    }
+    t.Errorf("%s: %d pixels differ from %s; see %s and %s", name,
+        differing, filename, got, diffName)
+}
+
+// perceptualDiff returns an image of the differences and the number of
+// pixels that differ noticeably. The diff image is a faded copy of the
+// expected image with the differing pixels in red, or in magenta where
+// only one image has the pixel at all.
+func perceptualDiff(expected, actual image.Image) (*image.RGBA, int) {
+    bounds := expected.Bounds().Union(actual.Bounds())
+    diff := image.NewRGBA(bounds)
+    differing := 0
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            point := image.Pt(x, y)
+            if !point.In(expected.Bounds()) ||
+                !point.In(actual.Bounds()) {
+                diff.Set(x, y, color.RGBA{0xFF, 0, 0xFF, 0xFF})
+                differing++
+                continue
+            }
+            if perceptualDelta(expected.At(x, y),
+                actual.At(x, y)) > diffThreshold {
+                diff.Set(x, y, color.RGBA{0xFF, 0, 0, 0xFF})
+                differing++
+                continue
+            }
+            luma := uint8(0xFF - (0xFF-yiq(expected.At(x, y))[0])/4)
+            diff.Set(x, y, color.RGBA{luma, luma, luma, 0xFF})
+        }
+    }
+    return diff, differing
+}
+
+// perceptualDelta returns how different the colors look from 0 (the
+// same) to 1 (black and white) by comparing them in the YIQ color space,
+// which weights brightness over hue as the eye does; translucent colors
+// are compared as if on white.
+func perceptualDelta(a, b color.Color) float64 {
+    const maxDelta = 35215 // Of black and white
+    yiqA, yiqB := yiq(a), yiq(b)
+    dy, di, dq := yiqA[0]-yiqB[0], yiqA[1]-yiqB[1], yiqA[2]-yiqB[2]
+    return (0.5053*dy*dy + 0.299*di*di + 0.1957*dq*dq) / maxDelta
+}
+
+func yiq(c color.Color) [3]float64 {
+    r, g, b, a := c.RGBA()
+    white := float64(0xFFFF - a) // Blending premultiplied onto white
+    red := (float64(r) + white) / 0x101
+    green := (float64(g) + white) / 0x101
+    blue := (float64(b) + white) / 0x101
+    return [3]float64{0.29889531*red + 0.58662247*green +
+        0.11448223*blue, 0.59597799*red - 0.27417610*green -
+        0.32180189*blue, 0.21147017*red - 0.52261711*green +
+        0.31114694*blue}
+}
+
+func TestPerceptualDiff(t *testing.T) {
+    expected := shapes.FilledImage(20, 20, white)
+    shapes.NewCircle(red, 6).Draw(expected, 10, 10)
+    nearly := shapes.FilledImage(20, 20, color.RGBA{0xFE, 0xFE, 0xFF,
+        0xFF})
+    shapes.NewCircle(color.RGBA{0xF8, 4, 0, 0xFF}, 6).Draw(nearly, 10, 10)
+    if _, differing := perceptualDiff(expected, nearly); differing != 0 {
+        t.Errorf("expected near colors to match but %d pixels differ",
+            differing)
+    }
+    moved := shapes.FilledImage(20, 20, white)
+    shapes.NewCircle(red, 6).Draw(moved, 11, 10)
+    diff, differing := perceptualDiff(expected, moved)
+    if differing <= maxDiffering {
+        t.Errorf("expected a moved circle to differ but only %d pixels "+
+            "do", differing)
+    }
+    if diff.At(16, 10) != color.Color(color.RGBA{0xFF, 0, 0, 0xFF}) ||
+        diff.At(10, 10) == color.Color(color.RGBA{0xFF, 0, 0, 0xFF}) {
+        t.Error("expected the diff image to show where they differ")
+    }
+    if _, differing := perceptualDiff(expected,
+        shapes.FilledImage(20, 21, white)); differing < 20 {
+        t.Error("expected images of different sizes to differ")
+    }
+}
+
+func writePNG(filename string, img image.Image) error {
+    if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+        return err
+    }
+    file, err := os.Create(filename)
+    if err != nil {
+        return err
+    }
+    if err := png.Encode(file, img); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+func readPNG(filename string) (image.Image, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    img, err := png.Decode(file)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", filename, err)
+    }
+    return img, nil
+}
@@ -0,0 +1,321 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "math"
+)
+
+type Transformer interface {
+    Transform() Transform
+    SetTransform(transform Transform)
+}
+
+// A Group is a shape made of other shapes (including other groups) each
+// drawn at its own position relative to the group's origin. The group's
+// transform is applied to them all about the origin, which is where the
+// group itself is drawn.
+//
+// Lines and polygons are transformed exactly; circles, ellipses, and
+// rectangles stay as such when the transform allows and otherwise become
+// polygons. Stroke widths are scaled. Paints are moved with the group
+// but only a RasterCanvas rotates, scales, or skews them; vector canvases
+// use such paints' colors at 0, 0.
+type Group struct {
+    fill      color.Color
+    style     Style
+    transform Transform
+    children  []groupChild
+}
+
+type groupChild struct {
+    Drawer
+    x, y int
+}
+
+func NewGroup(transform Transform) *Group {
+    return &Group{fill: color.Black, transform: transform}
+}
+
+// Add adds the shape to be drawn at x, y relative to the group's origin;
+// shapes are drawn in the order they were added.
+func (group *Group) Add(shape Drawer, x, y int) {
+    group.children = append(group.children, groupChild{shape, x, y})
+}
+
+func (group *Group) Len() int { return len(group.children) }
+
+func (group *Group) Transform() Transform { return group.transform }
+
+func (group *Group) SetTransform(transform Transform) {
+    group.transform = transform
+}
+
+func (group *Group) Fill() color.Color { return group.fill }
+
+// SetFill sets the fill of every shape in the group that has one.
+func (group *Group) SetFill(fill color.Color) {
+    if fill == nil { // We silently treat a nil color as black
+        fill = color.Black
+    }
+    group.fill = fill
+    for _, child := range group.children {
+        if filler, ok := child.Drawer.(Filler); ok {
+            filler.SetFill(fill)
+        }
+    }
+}
+
+func (group *Group) Style() Style { return saneStyle(group.style) }
+
+// SetStyle sets the style of every shape in the group that has one.
+func (group *Group) SetStyle(style Style) {
+    group.style = saneStyle(style)
+    for _, child := range group.children {
+        if styler, ok := child.Drawer.(Styler); ok {
+            styler.SetStyle(style)
+        }
+    }
+}
+
+func (group *Group) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    transform := group.transform.Then(Translation(float64(x),
+        float64(y)))
+    // Nested groups combine their transforms so that points are only
+    // rounded to pixels once
+    if outer, ok := canvas.(*transformCanvas); ok {
+        transform = transform.Then(outer.transform)
+        canvas = outer.canvas
+    }
+    transformed := &transformCanvas{canvas, transform}
+    for _, child := range group.children {
+        if err := child.Draw(transformed, child.x, child.y); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (group *Group) String() string {
+    return fmt.Sprintf("group(shapes=%d, transform=%v)",
+        len(group.children), group.transform)
+}
+
+// transformCanvas transforms what is drawn on it and draws it on the
+// canvas it wraps.
+type transformCanvas struct {
+    canvas    Canvas
+    transform Transform
+}
+
+// Bounds returns the part of the untransformed plane that is at least
+// partly on the wrapped canvas.
+func (canvas *transformCanvas) Bounds() image.Rectangle {
+    bounds := canvas.canvas.Bounds()
+    inverse, ok := canvas.transform.Inverse()
+    if !ok {
+        return image.Rectangle{}
+    }
+    var corners []vec
+    for _, corner := range []image.Point{bounds.Min, bounds.Max,
+        image.Pt(bounds.Min.X, bounds.Max.Y),
+        image.Pt(bounds.Max.X, bounds.Min.Y)} {
+        x, y := inverse.Apply(float64(corner.X), float64(corner.Y))
+        corners = append(corners, vec{x, y})
+    }
+    return vecBounds(0, corners...)
+}
+
+func (canvas *transformCanvas) Line(start, end image.Point,
+    fill color.Color, style Style) {
+    canvas.canvas.Line(canvas.point(start), canvas.point(end), fill,
+        canvas.style(style))
+}
+
+func (canvas *transformCanvas) Circle(center image.Point, radius int,
+    fill color.Color, style Style) {
+    if canvas.transform.isConformal() {
+        canvas.canvas.Circle(canvas.point(center), canvas.length(radius),
+            fill, canvas.style(style))
+    } else {
+        canvas.canvas.Polygon(canvas.ovalPoints(center, float64(radius),
+            float64(radius)), fill, canvas.style(style))
+    }
+}
+
+func (canvas *transformCanvas) Ellipse(center image.Point,
+    radiusX, radiusY int, fill color.Color, style Style) {
+    transform := canvas.transform
+    switch {
+    case transform.isRectilinear():
+        radiusX, radiusY = transform.radii(radiusX, radiusY)
+        canvas.canvas.Ellipse(canvas.point(center), radiusX, radiusY, fill,
+            canvas.style(style))
+    case radiusX == radiusY && transform.isConformal():
+        canvas.canvas.Circle(canvas.point(center), canvas.length(radiusX),
+            fill, canvas.style(style))
+    default:
+        canvas.canvas.Polygon(canvas.ovalPoints(center, float64(radiusX),
+            float64(radiusY)), fill, canvas.style(style))
+    }
+}
+
+func (canvas *transformCanvas) Polyline(points []image.Point,
+    fill color.Color, style Style) {
+    canvas.canvas.Polyline(canvas.points(points), fill,
+        canvas.style(style))
+}
+
+func (canvas *transformCanvas) Polygon(points []image.Point,
+    fill color.Color, style Style) {
+    canvas.canvas.Polygon(canvas.points(points), fill, canvas.style(style))
+}
+
+func (canvas *transformCanvas) FillRect(rect image.Rectangle,
+    paint Paint) {
+    rect = rect.Canon()
+    if canvas.transform.isRectilinear() {
+        // Transform the outer edges of the pixels and fill those pixels
+        // whose middles are between them
+        x0, y0 := canvas.transform.Apply(float64(rect.Min.X)-0.5,
+            float64(rect.Min.Y)-0.5)
+        x1, y1 := canvas.transform.Apply(float64(rect.Max.X)+0.5,
+            float64(rect.Max.Y)+0.5)
+        first := image.Pt(int(math.Ceil(math.Min(x0, x1))),
+            int(math.Ceil(math.Min(y0, y1))))
+        last := image.Pt(int(math.Ceil(math.Max(x0, x1)))-1,
+            int(math.Ceil(math.Max(y0, y1)))-1)
+        if first.X <= last.X && first.Y <= last.Y {
+            canvas.canvas.FillRect(image.Rectangle{first, last},
+                canvas.paint(paint))
+        }
+        return
+    }
+    // A filled polygon leaves out its right and bottom edges
+    rect.Max = rect.Max.Add(image.Pt(1, 1))
+    canvas.canvas.FillPolygon(canvas.points([]image.Point{rect.Min,
+        image.Pt(rect.Max.X, rect.Min.Y), rect.Max,
+        image.Pt(rect.Min.X, rect.Max.Y)}), canvas.paint(paint), NonZero,
+        false)
+}
+
+func (canvas *transformCanvas) FillPolygon(points []image.Point,
+    paint Paint, rule FillRule, antiAlias bool) {
+    canvas.canvas.FillPolygon(canvas.points(points), canvas.paint(paint),
+        rule, antiAlias)
+}
+
+func (canvas *transformCanvas) FillEllipse(center image.Point,
+    radiusX, radiusY int, paint Paint, antiAlias bool) {
+    transform := canvas.transform
+    if transform.isRectilinear() {
+        radiusX, radiusY = transform.radii(radiusX, radiusY)
+        canvas.canvas.FillEllipse(canvas.point(center), radiusX, radiusY,
+            canvas.paint(paint), antiAlias)
+        return
+    }
+    // A filled ellipse reaches the outer edges of the pixels at its radii
+    canvas.canvas.FillPolygon(canvas.ovalPoints(center,
+        float64(radiusX)+0.5, float64(radiusY)+0.5), canvas.paint(paint),
+        NonZero, antiAlias)
+}
+
+func (canvas *transformCanvas) point(point image.Point) image.Point {
+    return roundedPoint(canvas.transform.Apply(float64(point.X),
+        float64(point.Y)))
+}
+
+func (canvas *transformCanvas) points(points []image.Point) []image.Point {
+    transformed := make([]image.Point, len(points))
+    for i, point := range points {
+        transformed[i] = canvas.point(point)
+    }
+    return transformed
+}
+
+// ovalPoints returns the transformed points of a polygon approximating
+// the ellipse with enough sides to look curved once transformed.
+func (canvas *transformCanvas) ovalPoints(center image.Point,
+    radiusX, radiusY float64) []image.Point {
+    radius := math.Max(radiusX, radiusY) * canvas.transform.scale()
+    sides := curveSides(2*math.Pi*radius, 16, maxCurveSides)
+    points := make([]image.Point, sides)
+    for i := range points {
+        θ := float64(i) * 2 * math.Pi / float64(sides)
+        points[i] = roundedPoint(canvas.transform.Apply(
+            float64(center.X)+radiusX*math.Cos(θ),
+            float64(center.Y)+radiusY*math.Sin(θ)))
+    }
+    return points
+}
+
+func (canvas *transformCanvas) length(length int) int {
+    return roundedLength(float64(length) * canvas.transform.scale())
+}
+
+func (canvas *transformCanvas) style(style Style) Style {
+    style = saneStyle(style)
+    scale := canvas.transform.scale()
+    if scale == 1 {
+        return style
+    }
+    style.Width = style.width() * scale
+    for i := range style.Dashes {
+        style.Dashes[i] *= scale
+    }
+    style.Dashes = saneDashes(style.Dashes) // Scaled down they may be tiny
+    return style
+}
+
+func (canvas *transformCanvas) paint(paint Paint) Paint {
+    transform := canvas.transform
+    if transform.isTranslation() &&
+        transform.E == math.Round(transform.E) &&
+        transform.F == math.Round(transform.F) {
+        return translatePaint(paint, image.Pt(int(transform.E),
+            int(transform.F)))
+    }
+    if _, ok := paint.(Solid); ok {
+        return paint
+    }
+    inverse, ok := transform.Inverse()
+    if !ok {
+        return paint
+    }
+    return transformedPaint{paint, inverse}
+}
+
+// transformedPaint is a paint seen through a transform; At() is given
+// transformed points which the inverse maps back to the paint's.
+type transformedPaint struct {
+    Paint
+    inverse Transform
+}
+
+func (paint transformedPaint) At(x, y float64) color.Color {
+    // Paints are sampled at the middles of pixels whereas transforms map
+    // the pixels' integer coordinates
+    x, y = paint.inverse.Apply(x-0.5, y-0.5)
+    return paint.Paint.At(x+0.5, y+0.5)
+}
+
+func roundedLength(length float64) int {
+    return int(math.Round(length))
+}
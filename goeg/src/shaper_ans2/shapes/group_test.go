@@ -0,0 +1,159 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "image"
+    "image/color"
+    "math"
+    "shaper_ans2/shapes"
+    "testing"
+    "time"
+)
+
+var _ shapes.Shaper = (*shapes.Group)(nil)
+
+func TestTransforms(t *testing.T) {
+    for _, test := range []struct {
+        transform shapes.Transform
+        x, y      float64
+    }{
+        {shapes.Identity, 3, 4},
+        {shapes.Translation(10, -2), 13, 2},
+        {shapes.Rotation(90), -4, 3},
+        {shapes.Scaling(2, -1), 6, -4},
+        {shapes.Skewing(45, 0), 7, 4},
+        {shapes.Rotation(90).Then(shapes.Translation(1, 1)), -3, 4},
+        {shapes.Translation(1, 1).Then(shapes.Rotation(90)), -5, 4},
+    } {
+        x, y := test.transform.Apply(3, 4)
+        if math.Abs(x-test.x) > 1e-9 || math.Abs(y-test.y) > 1e-9 {
+            t.Errorf("%v: expected %g, %g got %g, %g", test.transform,
+                test.x, test.y, x, y)
+        }
+        inverse, ok := test.transform.Inverse()
+        if x, y = inverse.Apply(x, y); !ok || math.Abs(x-3) > 1e-9 ||
+            math.Abs(y-4) > 1e-9 {
+            t.Errorf("%v: the inverse gave %g, %g", test.transform, x, y)
+        }
+    }
+    if _, ok := shapes.Scaling(0, 1).Inverse(); ok {
+        t.Error("expected no inverse for a transform that squashes")
+    }
+}
+
+func TestGroup(t *testing.T) {
+    square, _ := shapes.New("rectangle", shapes.Option{Fill: red,
+        Rect: image.Rect(0, 0, 10, 4), Filled: true})
+    group := shapes.NewGroup(shapes.Rotation(90))
+    group.Add(square, 5, 0)
+    canvas := shapes.FilledImage(40, 40, white)
+    if err := group.Draw(canvas, 20, 20); err != nil {
+        t.Fatal(err)
+    }
+    // Rotated clockwise the rectangle hangs down from 20, 25 to 16, 35
+    checkPixels(t, "rotated", canvas, []image.Point{{20, 25}, {16, 35},
+        {18, 30}}, []image.Point{{25, 20}, {21, 30}, {20, 36}})
+
+    // Nested groups combine their transforms
+    outer := shapes.NewGroup(shapes.Scaling(2, 2))
+    outer.Add(group, 0, 0)
+    canvas = shapes.FilledImage(40, 40, white)
+    if err := outer.Draw(canvas, 20, 0); err != nil {
+        t.Fatal(err)
+    }
+    checkPixels(t, "nested", canvas, []image.Point{{20, 10}, {12, 30},
+        {16, 20}}, []image.Point{{21, 10}, {20, 31}, {10, 20}})
+
+    group.SetFill(blue)
+    if group.Fill() != color.Color(blue) || square.Fill() !=
+        color.Color(blue) {
+        t.Error("expected SetFill() to set the group's shapes' fills")
+    }
+}
+
+func TestGroupCircles(t *testing.T) {
+    circle := shapes.NewCircle(red, 5)
+    for _, test := range []struct {
+        transform shapes.Transform
+        drawn     []image.Point
+        notDrawn  []image.Point
+    }{
+        {shapes.Scaling(2, 2), []image.Point{{30, 20}, {20, 10}},
+            []image.Point{{25, 20}, {20, 20}}},
+        {shapes.Scaling(2, 1), []image.Point{{30, 20}, {20, 15}},
+            []image.Point{{25, 20}, {20, 10}}},
+        {shapes.Skewing(0, 45), []image.Point{{25, 25}, {15, 15}},
+            []image.Point{{25, 20}, {20, 20}}},
+    } {
+        group := shapes.NewGroup(test.transform)
+        group.Add(circle, 0, 0)
+        canvas := shapes.FilledImage(40, 40, white)
+        if err := group.Draw(canvas, 20, 20); err != nil {
+            t.Fatal(err)
+        }
+        checkPixels(t, group.String(), canvas, test.drawn, test.notDrawn)
+    }
+}
+
+func TestGroupPaint(t *testing.T) {
+    gradient := shapes.LinearGradient{End: image.Pt(9, 0),
+        Stops: []shapes.Stop{{0, red}, {1, blue}}}
+    rectangle, _ := shapes.New("rectangle", shapes.Option{Filled: true,
+        Rect: image.Rect(0, 0, 9, 9), Paint: gradient})
+    group := shapes.NewGroup(shapes.Rotation(90))
+    group.Add(rectangle, 0, 0)
+    canvas := shapes.FilledImage(40, 40, white)
+    if err := group.Draw(canvas, 20, 10); err != nil {
+        t.Fatal(err)
+    }
+    // The gradient turns with the rectangle so runs from top to bottom
+    if canvas.At(15, 10) != color.Color(red) ||
+        canvas.At(15, 19) != color.Color(blue) {
+        t.Errorf("expected red to blue downwards got %v to %v",
+            canvas.At(15, 10), canvas.At(15, 19))
+    }
+}
+
+// Hugely scaled curves must still be drawn quickly: the canvas clips
+// them and they are flattened into a bounded number of sides.
+func TestHugeScaling(t *testing.T) {
+    filled := shapes.NewCircle(red, 40)
+    filled.SetFilled(true)
+    dashed := shapes.NewEllipse(red, 40, 20)
+    dashed.SetStyle(shapes.Style{Dashes: []float64{4, 4}})
+    rounded := shapes.NewRoundedRectangle(red, image.Rect(-40, -40, 40,
+        40), 1000000)
+    bezier := shapes.NewCubicBezier(red, []image.Point{{-1000000, 0},
+        {0, 1000000}, {0, -1000000}, {1000000, 0}})
+    for _, transform := range []shapes.Transform{shapes.Scaling(1e7, 1e7),
+        shapes.Scaling(1e7, 1e7).Then(shapes.Rotation(30))} {
+        for _, shape := range []shapes.Drawer{filled, dashed, rounded,
+            bezier} {
+            group := shapes.NewGroup(transform)
+            group.Add(shape, 0, 0)
+            canvas := shapes.FilledImage(100, 100, white)
+            done := make(chan error, 1)
+            go func() { done <- group.Draw(canvas, 50, 50) }()
+            select {
+            case err := <-done:
+                if err != nil {
+                    t.Error(err)
+                }
+            case <-time.After(10 * time.Second):
+                t.Fatalf("drawing %v in %v didn't finish", shape, group)
+            }
+        }
+    }
+}
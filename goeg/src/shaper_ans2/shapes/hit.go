@@ -0,0 +1,431 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "image"
+    "math"
+)
+
+// A Bounder can say where it draws without drawing. Both methods are
+// relative to where the shape is drawn (i.e., the x, y given to Draw()).
+type Bounder interface {
+    // Bounds returns a rectangle containing every pixel the shape draws.
+    Bounds() image.Rectangle
+    // Contains returns true if the pixel is inside the shape or on its
+    // outline; the inside of an open shape (e.g., an unfilled Polyline)
+    // doesn't count.
+    Contains(point image.Point) bool
+}
+
+// reach returns how far from an outline's path (through the middles of
+// pixels) the middles of the pixels it draws on can be.
+func reach(style Style) float64 {
+    halfWidth := style.width() / 2
+    if style.AntiAlias {
+        return halfWidth + 0.5
+    }
+    return math.Max(halfWidth, 0.5)
+}
+
+// margin returns how many pixels beyond a path its outline can draw,
+// leaving aside any mitered or beveled corners.
+func margin(style Style) int {
+    return int(math.Ceil(reach(style) - 0.5))
+}
+
+// pathBounds returns the bounds of the path, widened by the margin of
+// its outline (and the corners that stick out further) if it isn't
+// filled.
+func pathBounds(points []image.Point, closed, filled bool,
+    style Style) image.Rectangle {
+    bounds := image.Rectangle{points[0], points[0].Add(image.Pt(1, 1))}
+    for _, point := range points[1:] {
+        bounds = bounds.Union(image.Rectangle{point,
+            point.Add(image.Pt(1, 1))})
+    }
+    if filled {
+        return bounds
+    }
+    style = saneStyle(style)
+    bounds = bounds.Inset(-margin(style))
+    for _, corner := range joinCorners(centerPath(points, closed), closed,
+        style) {
+        bounds = bounds.Union(vecBounds(0, corner...))
+    }
+    return bounds
+}
+
+// centerPath returns the path through the middles of the pixels at the
+// points, closing it if it is closed and doesn't end where it starts.
+func centerPath(points []image.Point, closed bool) []vec {
+    path := make([]vec, len(points), len(points)+1)
+    for i, point := range points {
+        path[i] = centerVec(point)
+    }
+    if closed && points[0] != points[len(points)-1] {
+        path = append(path, path[0])
+    }
+    return path
+}
+
+// pathContains returns true if the point is inside the closed path (by
+// the fill rule) or, if it is outlined, near enough to the path to be on
+// the outline.
+func pathContains(points []image.Point, closed, filled bool,
+    rule FillRule, style Style, point image.Point) bool {
+    style = saneStyle(style)
+    middle := centerVec(point)
+    path := centerPath(points, closed || filled)
+    if closed || filled {
+        for _, span := range polygonSpans(path, middle.y, rule) {
+            if span.left <= middle.x && middle.x <= span.right {
+                return true
+            }
+        }
+    }
+    tolerance := reach(style)
+    if filled {
+        if !style.AntiAlias {
+            return false
+        }
+        // Antialiased fills partly cover pixels whose middles are out
+        tolerance = math.Sqrt2 / 2
+    } else if style.AntiAlias {
+        // Antialiased sides fade out over half a pixel past their ends
+        tolerance = math.Hypot(tolerance, 0.5)
+    }
+    for i := 0; i+1 < len(path); i++ {
+        if distanceToSegment(middle, path[i], path[i+1]) <= tolerance {
+            return true
+        }
+    }
+    if len(path) == 1 {
+        return middle.distance(path[0]) <= tolerance
+    }
+    if !filled {
+        for _, corner := range joinCorners(path, closed, style) {
+            if insideConvex(corner, middle) ||
+                (style.AntiAlias && overlapsPixel(corner, middle)) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// joinCorners returns the beveled or mitered corners of a thick
+// outline; round corners are no further from the path than the rest of
+// the outline.
+func joinCorners(path []vec, closed bool, style Style) [][]vec {
+    if style.isThin() || style.Join == RoundJoin {
+        return nil
+    }
+    last := len(path) - 1
+    var corners [][]vec
+    add := func(previous, vertex, next vec) {
+        if corner := joinCorner(previous, vertex, next,
+            style); corner != nil {
+            corners = append(corners, corner)
+        }
+    }
+    for i := 1; i < last; i++ {
+        add(path[i-1], path[i], path[i+1])
+    }
+    if closed && last > 1 {
+        add(path[last-1], path[0], path[1])
+    }
+    return corners
+}
+
+// overlapsPixel returns true if the convex polygon and the pixel whose
+// middle is given (nearly always) overlap, i.e., if one has a corner in
+// the other.
+func overlapsPixel(polygon []vec, middle vec) bool {
+    for _, offset := range []vec{{-0.5, -0.5}, {0.5, -0.5}, {0.5, 0.5},
+        {-0.5, 0.5}} {
+        if insideConvex(polygon, middle.add(offset)) {
+            return true
+        }
+    }
+    for _, point := range polygon {
+        if math.Abs(point.x-middle.x) <= 0.5 &&
+            math.Abs(point.y-middle.y) <= 0.5 {
+            return true
+        }
+    }
+    return false
+}
+
+func distanceToSegment(point, start, end vec) float64 {
+    along := end.sub(start)
+    t := 0.0
+    if length := along.dot(along); length > 0 {
+        t = math.Max(0, math.Min(1, point.sub(start).dot(along)/length))
+    }
+    return point.distance(start.add(along.scale(t)))
+}
+
+// ovalBounds returns the bounds of an ellipse centered on 0, 0.
+func ovalBounds(radiusX, radiusY int, filled bool,
+    style Style) image.Rectangle {
+    bounds := image.Rect(-radiusX, -radiusY, radiusX+1, radiusY+1)
+    if filled {
+        return bounds
+    }
+    return bounds.Inset(-margin(saneStyle(style)))
+}
+
+// ovalContains returns true if the point is inside the ellipse centered
+// on 0, 0 or on its outline.
+func ovalContains(radiusX, radiusY int, filled bool, style Style,
+    point image.Point) bool {
+    style = saneStyle(style)
+    rx, ry := float64(radiusX), float64(radiusY)
+    tolerance := reach(style)
+    if style.isThin() && !style.AntiAlias {
+        // The midpoint algorithm can step half a diagonal off the curve
+        tolerance = math.Sqrt2 / 2
+    }
+    if filled {
+        // Fills reach the outer edges of the pixels at the radii
+        rx, ry = rx+0.5, ry+0.5
+        tolerance = 0
+        if style.AntiAlias {
+            tolerance = math.Sqrt2 / 2
+        }
+    }
+    x, y := float64(point.X), float64(point.Y)
+    level := x*x/(rx*rx) + y*y/(ry*ry) - 1
+    if level <= 0 {
+        return true
+    }
+    // The level divided by the length of its gradient is close to (but
+    // no more than) the distance to the ellipse
+    return level/math.Hypot(2*x/(rx*rx), 2*y/(ry*ry)) <= tolerance
+}
+
+func (circle *Circle) Bounds() image.Rectangle {
+    return ovalBounds(circle.radius, circle.radius, circle.filled,
+        circle.style)
+}
+
+func (circle *Circle) Contains(point image.Point) bool {
+    return ovalContains(circle.radius, circle.radius, circle.filled,
+        circle.style, point)
+}
+
+func (ellipse *Ellipse) Bounds() image.Rectangle {
+    return ovalBounds(ellipse.radiusX, ellipse.radiusY, ellipse.filled,
+        ellipse.style)
+}
+
+func (ellipse *Ellipse) Contains(point image.Point) bool {
+    return ovalContains(ellipse.radiusX, ellipse.radiusY, ellipse.filled,
+        ellipse.style, point)
+}
+
+// Bounds allows for getPoints() truncating the corners, which depending
+// on where the polygon is drawn can put them a pixel further up or left.
+func (polygon *RegularPolygon) Bounds() image.Rectangle {
+    bounds := pathBounds(polygon.corners(), true, polygon.filled,
+        polygon.style)
+    bounds.Min = bounds.Min.Sub(image.Pt(1, 1))
+    return bounds
+}
+
+func (polygon *RegularPolygon) Contains(point image.Point) bool {
+    corners := polygon.corners()
+    for _, shift := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+        if pathContains(corners, true, polygon.filled, polygon.rule,
+            polygon.style, point.Add(shift)) {
+            return true
+        }
+    }
+    return false
+}
+
+// corners returns the polygon's points relative to its middle, rounding
+// down like getPoints() does for a polygon drawn at positive x, y. But
+// coordinates that are whole numbers but for rounding errors are rounded
+// to the whole number rather than sometimes a pixel below it.
+func (polygon *RegularPolygon) corners() []image.Point {
+    points := getPoints(0, 0, polygon.sides, float64(polygon.Radius()))
+    radius := float64(polygon.Radius())
+    for i := 0; i < polygon.sides; i++ {
+        θ := float64(i) * 2 * math.Pi / float64(polygon.sides)
+        points[i] = image.Pt(int(math.Floor(radius*math.Sin(θ)+1e-9)),
+            int(math.Floor(radius*math.Cos(θ)+1e-9)))
+    }
+    points[polygon.sides] = points[0]
+    return points
+}
+
+func (rectangle *Rectangle) Bounds() image.Rectangle {
+    return pathBounds(rectangle.corners(), true,
+        rectangle.filled, rectangle.style)
+}
+
+func (rectangle *Rectangle) Contains(point image.Point) bool {
+    if rectangle.filled {
+        return point.In(rectangle.Bounds())
+    }
+    return pathContains(rectangle.corners(), true, false, NonZero,
+        rectangle.style, point)
+}
+
+func (rectangle *Rectangle) corners() []image.Point {
+    rect := rectangle.Rectangle
+    return []image.Point{rect.Min, image.Pt(rect.Max.X, rect.Min.Y),
+        rect.Max, image.Pt(rect.Min.X, rect.Max.Y)}
+}
+
+func (arc *Arc) Bounds() image.Rectangle {
+    return pathBounds(arc.arcPoints(0, 0), false, arc.filled,
+        arc.style)
+}
+
+func (arc *Arc) Contains(point image.Point) bool {
+    return pathContains(arc.arcPoints(0, 0), false, arc.filled, arc.rule,
+        arc.style, point)
+}
+
+func (pie *Pie) Bounds() image.Rectangle {
+    return pathBounds(pie.corners(), true, pie.filled, pie.style)
+}
+
+func (pie *Pie) Contains(point image.Point) bool {
+    return pathContains(pie.corners(), true, pie.filled, pie.rule,
+        pie.style, point)
+}
+
+func (pie *Pie) corners() []image.Point {
+    points := pie.arcPoints(0, 0)
+    if pie.end-pie.start < 360 {
+        points = append(points, image.ZP)
+    }
+    return points
+}
+
+func (star *Star) Bounds() image.Rectangle {
+    return pathBounds(star.corners(0, 0), true, star.filled,
+        star.style)
+}
+
+func (star *Star) Contains(point image.Point) bool {
+    return pathContains(star.corners(0, 0), true, star.filled, star.rule,
+        star.style, point)
+}
+
+// Bounds allows for antialiased fills since they reach half way into the
+// pixels beyond the rectangle (which aliased fills leave alone).
+func (rectangle *RoundedRectangle) Bounds() image.Rectangle {
+    bounds := rectangle.Rectangle.Bounds()
+    if rectangle.filled && rectangle.style.AntiAlias {
+        bounds.Max = bounds.Max.Add(image.Pt(1, 1))
+    }
+    return bounds
+}
+
+func (rectangle *RoundedRectangle) Contains(point image.Point) bool {
+    return pathContains(rectangle.corners(), true, rectangle.filled,
+        rectangle.rule, rectangle.style, point)
+}
+
+func (rectangle *RoundedRectangle) corners() []image.Point {
+    rect := rectangle.Rectangle.Rectangle
+    if rectangle.filled {
+        rect.Max = rect.Max.Add(image.Pt(1, 1))
+    }
+    return roundedCorners(rect, rectangle.cornerRadius)
+}
+
+func (polyline *Polyline) Bounds() image.Rectangle {
+    return pathBounds(polyline.vertices, false, polyline.filled,
+        polyline.style)
+}
+
+func (polyline *Polyline) Contains(point image.Point) bool {
+    return pathContains(polyline.vertices, false, polyline.filled,
+        polyline.rule, polyline.style, point)
+}
+
+func (bezier *Bezier) Bounds() image.Rectangle {
+    return pathBounds(bezier.flatten(0, 0), false, bezier.filled,
+        bezier.style)
+}
+
+func (bezier *Bezier) Contains(point image.Point) bool {
+    return pathContains(bezier.flatten(0, 0), false, bezier.filled,
+        bezier.rule, bezier.style, point)
+}
+
+// Bounds returns the bounds of the group's shapes once transformed.
+func (group *Group) Bounds() image.Rectangle {
+    var bounds image.Rectangle
+    for _, child := range group.children {
+        if bounder, ok := child.Drawer.(Bounder); ok {
+            bounds = bounds.Union(transformBounds(group.transform,
+                bounder.Bounds().Add(image.Pt(child.x, child.y))))
+        }
+    }
+    return bounds
+}
+
+// Contains maps the point back to the shapes' untransformed pixels, and
+// since it is unlikely to land in the middle of one, tries each of those
+// around where it lands. Rotated or scaled outlines are redrawn rather
+// than transformed pixel by pixel so may be a pixel out.
+func (group *Group) Contains(point image.Point) bool {
+    inverse, ok := group.transform.Inverse()
+    if !ok {
+        return false
+    }
+    x, y := inverse.Apply(float64(point.X), float64(point.Y))
+    left, top := int(math.Floor(x)), int(math.Floor(y))
+    for _, child := range group.children {
+        bounder, ok := child.Drawer.(Bounder)
+        if !ok {
+            continue
+        }
+        for _, point := range []image.Point{{left, top}, {left + 1, top},
+            {left, top + 1}, {left + 1, top + 1}} {
+            if bounder.Contains(point.Sub(image.Pt(child.x, child.y))) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// transformBounds returns the pixels that are at least partly covered
+// by the transformed pixels in the bounds.
+func transformBounds(transform Transform,
+    bounds image.Rectangle) image.Rectangle {
+    if bounds.Empty() {
+        return bounds
+    }
+    // Transforms map the pixels' integer coordinates so the edges of the
+    // pixels are half a pixel before and after them
+    var corners []vec
+    for _, corner := range []vec{{float64(bounds.Min.X) - 0.5,
+        float64(bounds.Min.Y) - 0.5}, {float64(bounds.Max.X) - 0.5,
+        float64(bounds.Min.Y) - 0.5}, {float64(bounds.Max.X) - 0.5,
+        float64(bounds.Max.Y) - 0.5}, {float64(bounds.Min.X) - 0.5,
+        float64(bounds.Max.Y) - 0.5}} {
+        x, y := transform.Apply(corner.x, corner.y)
+        corners = append(corners, vec{x + 0.5, y + 0.5})
+    }
+    return vecBounds(0, corners...)
+}
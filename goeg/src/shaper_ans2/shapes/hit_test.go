@@ -0,0 +1,267 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "math/rand"
+    "shaper_ans2/shapes"
+    "testing"
+)
+
+var _ shapes.Bounder = shapes.SceneShape{}
+
+// TestBoundsCoverDrawing checks that every pixel a shape draws is within
+// its bounds and is one it contains.
+func TestBoundsCoverDrawing(t *testing.T) {
+    names := []string{"circle", "ellipse", "triangle", "hexagon",
+        "rectangle", "arc", "pie", "star", "roundedrectangle",
+        "polyline", "quadraticbezier", "cubicbezier"}
+    styles := []shapes.Style{{}, {Width: 5}, {Width: 4, AntiAlias: true},
+        {Width: 7, Join: shapes.RoundJoin}, {Width: 3,
+            Join: shapes.BevelJoin}}
+    for _, name := range names {
+        for _, filled := range []bool{false, true} {
+            for _, style := range styles {
+                option := shapes.Option{Fill: red, Radius: 20, RadiusY: 12,
+                    Rect: image.Rect(-20, -10, 20, 15), CornerRadius: 6,
+                    StartAngle: 30, EndAngle: 200, Vertices: []image.Point{
+                        {-20, 10}, {0, -30}, {20, 10}}, Filled: filled,
+                    Style: style}
+                if name == "cubicbezier" {
+                    option.Vertices = append(option.Vertices,
+                        image.Pt(30, -10))
+                }
+                shape, err := shapes.New(name, option)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                checkCoverage(t, fmt.Sprint(shape, " ", style), shape)
+            }
+        }
+    }
+}
+
+func checkCoverage(t *testing.T, name string, shape shapes.Shaper) {
+    canvas := shapes.FilledImage(100, 100, white)
+    if err := shape.Draw(canvas, 50, 50); err != nil {
+        t.Fatal(err)
+    }
+    bounds := shape.Bounds()
+    for y := 0; y < 100; y++ {
+        for x := 0; x < 100; x++ {
+            if canvas.At(x, y) == color.Color(white) {
+                continue
+            }
+            point := image.Pt(x-50, y-50)
+            if !point.In(bounds) {
+                t.Errorf("%s: drew %v outside its bounds %v", name, point,
+                    bounds)
+                return
+            }
+            if !shape.Contains(point) {
+                t.Errorf("%s: drew %v but doesn't contain it", name, point)
+                return
+            }
+        }
+    }
+}
+
+func TestBounds(t *testing.T) {
+    thick := shapes.Style{Width: 5}
+    circle := shapes.NewCircle(red, 10)
+    rectangle := shapes.NewRectangle(red, image.Rect(0, 0, 20, 10))
+    triangle := shapes.NewRegularPolygon(red, 10, 3)
+    for _, test := range []struct {
+        shape    shapes.Shaper
+        style    shapes.Style
+        expected image.Rectangle
+    }{
+        {circle, shapes.Style{}, image.Rect(-10, -10, 11, 11)},
+        {circle, thick, image.Rect(-12, -12, 13, 13)},
+        {rectangle, shapes.Style{}, image.Rect(0, 0, 21, 11)},
+        {rectangle, thick, image.Rect(-2, -2, 23, 13)},
+        {triangle, shapes.Style{}, image.Rect(-10, -6, 9, 11)},
+    } {
+        test.shape.SetStyle(test.style)
+        if bounds := test.shape.Bounds(); bounds != test.expected {
+            t.Errorf("%v %v: expected bounds %v got %v", test.shape,
+                test.style, test.expected, bounds)
+        }
+    }
+}
+
+func TestContains(t *testing.T) {
+    polyline := shapes.NewPolyline(red, []image.Point{{0, 0}, {10, 10},
+        {20, 0}})
+    pie := shapes.NewPie(red, 10, 0, 90)
+    pie.SetFilled(true)
+    for _, test := range []struct {
+        shape   shapes.Shaper
+        inside  []image.Point
+        outside []image.Point
+    }{
+        // The insides of closed outlines count but not of open ones
+        {shapes.NewCircle(red, 10), []image.Point{{0, 0}, {10, 0},
+            {0, -10}}, []image.Point{{11, 0}, {8, 8}}},
+        {polyline, []image.Point{{0, 0}, {5, 5}, {20, 0}},
+            []image.Point{{10, 5}, {10, 11}, {21, 0}}},
+        {pie, []image.Point{{0, 0}, {5, 5}, {1, 9}}, []image.Point{{-1, 0},
+            {5, -5}, {-5, 5}}},
+    } {
+        for _, point := range test.inside {
+            if !test.shape.Contains(point) {
+                t.Errorf("%v: expected to contain %v", test.shape, point)
+            }
+        }
+        for _, point := range test.outside {
+            if test.shape.Contains(point) {
+                t.Errorf("%v: expected not to contain %v", test.shape,
+                    point)
+            }
+        }
+    }
+}
+
+func TestGroupBoundsCoverDrawing(t *testing.T) {
+    filled := shapes.NewRectangle(red, image.Rect(-5, -5, 5, 5))
+    filled.SetFilled(true)
+    for _, transform := range []shapes.Transform{shapes.Translation(5, -3),
+        shapes.Skewing(20, 0), shapes.Scaling(2, 1).Then(
+            shapes.Rotation(90))} {
+        group := shapes.NewGroup(transform)
+        group.Add(filled, -20, 0)
+        group.Add(shapes.NewCircle(red, 8), 20, 0)
+        checkCoverage(t, fmt.Sprint(group), group)
+    }
+    group := shapes.NewGroup(shapes.Translation(10, 0))
+    group.Add(shapes.NewCircle(red, 5), 0, 20)
+    if bounds := group.Bounds(); bounds != image.Rect(5, 15, 16, 26) {
+        t.Errorf("expected the group's bounds to be %v got %v",
+            image.Rect(5, 15, 16, 26), bounds)
+    }
+}
+
+func sceneOfCircles(count int) *shapes.Scene {
+    random := rand.New(rand.NewSource(1))
+    scene := &shapes.Scene{Width: 400, Height: 400}
+    for i := 0; i < count; i++ {
+        circle := shapes.NewCircle(red, 2+random.Intn(10))
+        circle.SetFilled(true)
+        scene.Shapes = append(scene.Shapes, shapes.SceneShape{
+            Shaper: circle, X: random.Intn(400), Y: random.Intn(400)})
+    }
+    return scene
+}
+
+func TestShapesAt(t *testing.T) {
+    scene := &shapes.Scene{Width: 100, Height: 100}
+    for _, x := range []int{40, 50, 60} {
+        circle := shapes.NewCircle(red, 10)
+        circle.SetFilled(true)
+        scene.Shapes = append(scene.Shapes, shapes.SceneShape{
+            Shaper: circle, X: x, Y: 50})
+    }
+    for _, test := range []struct {
+        point image.Point
+        xs    []int
+    }{{image.Pt(50, 50), []int{60, 50, 40}}, {image.Pt(35, 50),
+        []int{40}}, {image.Pt(50, 70), nil}} {
+        found := scene.ShapesAt(test.point)
+        xs := []int{}
+        for _, shape := range found {
+            xs = append(xs, shape.X)
+        }
+        if fmt.Sprint(xs) != fmt.Sprint(test.xs) {
+            t.Errorf("%v: expected shapes at %v got %v", test.point,
+                test.xs, xs)
+        }
+    }
+    scene.Shapes[2].X = 100
+    scene.Reindex()
+    if found := scene.ShapesAt(image.Pt(50, 50)); len(found) != 2 {
+        t.Errorf("expected 2 shapes after moving one got %d", len(found))
+    }
+}
+
+func TestIntersects(t *testing.T) {
+    a := shapes.NewCircle(red, 10)
+    b := shapes.NewRectangle(red, image.Rect(0, 0, 5, 5))
+    b.SetFilled(true)
+    for _, test := range []struct {
+        x, y     int
+        expected bool
+    }{
+        {0, 0, true},
+        {-2, -2, true}, // Inside the circle's outline counts too
+        {10, 0, true},
+        {11, 0, false},
+        {6, 6, true},
+        {8, 8, false}, // Within the bounds but outside the circle
+    } {
+        if intersects := shapes.Intersects(shapes.SceneShape{Shaper: a},
+            shapes.SceneShape{Shaper: b, X: test.x, Y: test.y});
+            intersects != test.expected {
+            t.Errorf("rectangle at %d, %d: expected %t got %t", test.x,
+                test.y, test.expected, intersects)
+        }
+    }
+}
+
+// TestOverlaps checks the quadtree finds the same pairs as comparing
+// every shape with every other.
+func TestOverlaps(t *testing.T) {
+    scene := sceneOfCircles(200)
+    var expected [][2]shapes.SceneShape
+    for i, a := range scene.Shapes {
+        for _, b := range scene.Shapes[i+1:] {
+            if shapes.Intersects(a, b) {
+                expected = append(expected, [2]shapes.SceneShape{a, b})
+            }
+        }
+    }
+    overlaps := scene.Overlaps()
+    if len(expected) == 0 || len(overlaps) != len(expected) {
+        t.Fatalf("expected %d overlaps got %d", len(expected),
+            len(overlaps))
+    }
+    for i := range overlaps {
+        if overlaps[i] != expected[i] {
+            t.Errorf("overlap %d: expected %v got %v", i, expected[i],
+                overlaps[i])
+        }
+    }
+    point := image.Pt(200, 200)
+    var at []shapes.SceneShape
+    for _, shape := range scene.Shapes {
+        if shape.Contains(point) {
+            at = append([]shapes.SceneShape{shape}, at...)
+        }
+    }
+    if found := scene.ShapesAt(point); fmt.Sprint(found) !=
+        fmt.Sprint(at) {
+        t.Errorf("expected %v at %v got %v", at, point, found)
+    }
+}
+
+func BenchmarkShapesAt(b *testing.B) {
+    scene := sceneOfCircles(1000)
+    scene.ShapesAt(image.ZP)
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        scene.ShapesAt(image.Pt(i%400, i/400%400))
+    }
+}
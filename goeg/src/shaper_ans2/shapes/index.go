@@ -0,0 +1,181 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "image"
+    "sort"
+)
+
+// Bounds returns the bounds of the pixels the shape draws at its
+// position.
+func (shape SceneShape) Bounds() image.Rectangle {
+    return shape.Shaper.Bounds().Add(image.Pt(shape.X, shape.Y))
+}
+
+// Contains returns true if the shape at its position covers the point.
+func (shape SceneShape) Contains(point image.Point) bool {
+    return shape.Shaper.Contains(point.Sub(image.Pt(shape.X, shape.Y)))
+}
+
+// Intersects returns true if the shapes have at least one pixel in
+// common as far as their Contains() methods are concerned.
+func Intersects(a, b SceneShape) bool {
+    common := a.Bounds().Intersect(b.Bounds())
+    for y := common.Min.Y; y < common.Max.Y; y++ {
+        for x := common.Min.X; x < common.Max.X; x++ {
+            if a.Contains(image.Pt(x, y)) && b.Contains(image.Pt(x, y)) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// ShapesAt returns the scene's shapes that contain the point, topmost
+// (i.e., last drawn) first.
+func (scene *Scene) ShapesAt(point image.Point) []SceneShape {
+    var found []int
+    scene.spatialIndex().search(image.Rectangle{point,
+        point.Add(image.Pt(1, 1))}, func(i int) {
+        if scene.Shapes[i].Contains(point) {
+            found = append(found, i)
+        }
+    })
+    sort.Ints(found)
+    shapes := make([]SceneShape, 0, len(found))
+    for j := len(found) - 1; j >= 0; j-- {
+        shapes = append(shapes, scene.Shapes[found[j]])
+    }
+    return shapes
+}
+
+// Overlaps returns every pair of the scene's shapes that intersect, each
+// pair in drawing order.
+func (scene *Scene) Overlaps() [][2]SceneShape {
+    index := scene.spatialIndex()
+    var pairs [][2]SceneShape
+    for i, shape := range scene.Shapes {
+        var others []int
+        index.search(index.bounds[i], func(j int) {
+            if j > i && Intersects(shape, scene.Shapes[j]) {
+                others = append(others, j)
+            }
+        })
+        sort.Ints(others)
+        for _, j := range others {
+            pairs = append(pairs, [2]SceneShape{shape, scene.Shapes[j]})
+        }
+    }
+    return pairs
+}
+
+// Reindex must be called after changing any of the scene's shapes (other
+// than by adding or removing them) so that ShapesAt() and Overlaps()
+// know where they are.
+func (scene *Scene) Reindex() {
+    scene.index = nil
+}
+
+func (scene *Scene) spatialIndex() *quadtree {
+    if scene.index == nil || len(scene.index.bounds) != len(scene.Shapes) {
+        scene.index = newQuadtree(scene.Shapes)
+    }
+    return scene.index
+}
+
+const (
+    quadCapacity = 8 // Items a node holds before it is split
+    quadMaxDepth = 8
+)
+
+// A quadtree finds the shapes whose bounds overlap a rectangle without
+// looking at most of the others. Each node holds the shapes that are in
+// its region but not wholly in one of its four quarters.
+type quadtree struct {
+    quadNode
+    bounds []image.Rectangle // Each shape's bounds by index
+}
+
+type quadNode struct {
+    region   image.Rectangle
+    depth    int
+    items    []int
+    quarters []*quadNode
+}
+
+func newQuadtree(shapes []SceneShape) *quadtree {
+    tree := &quadtree{bounds: make([]image.Rectangle, len(shapes))}
+    for i, shape := range shapes {
+        tree.bounds[i] = shape.Bounds()
+        tree.region = tree.region.Union(tree.bounds[i])
+    }
+    for i := range shapes {
+        tree.insert(i, tree.bounds)
+    }
+    return tree
+}
+
+func (node *quadNode) insert(i int, bounds []image.Rectangle) {
+    for _, quarter := range node.quarters {
+        if bounds[i].In(quarter.region) {
+            quarter.insert(i, bounds)
+            return
+        }
+    }
+    node.items = append(node.items, i)
+    if node.quarters == nil && len(node.items) > quadCapacity &&
+        node.depth < quadMaxDepth && node.region.Dx() > 1 &&
+        node.region.Dy() > 1 {
+        node.split(bounds)
+    }
+}
+
+func (node *quadNode) split(bounds []image.Rectangle) {
+    min, max := node.region.Min, node.region.Max
+    middle := min.Add(max).Div(2)
+    for _, region := range []image.Rectangle{
+        {min, middle}, {image.Pt(middle.X, min.Y), image.Pt(max.X,
+            middle.Y)}, {image.Pt(min.X, middle.Y), image.Pt(middle.X,
+            max.Y)}, {middle, max}} {
+        node.quarters = append(node.quarters, &quadNode{region: region,
+            depth: node.depth + 1})
+    }
+    items := node.items
+    node.items = nil
+    for _, i := range items {
+        node.insert(i, bounds)
+    }
+}
+
+// search calls found with the index of each shape whose bounds overlap
+// the rectangle.
+func (tree *quadtree) search(rect image.Rectangle, found func(int)) {
+    tree.quadNode.search(rect, tree.bounds, found)
+}
+
+func (node *quadNode) search(rect image.Rectangle,
+    bounds []image.Rectangle, found func(int)) {
+    if !rect.Overlaps(node.region) {
+        return
+    }
+    for _, i := range node.items {
+        if rect.Overlaps(bounds[i]) {
+            found(i)
+        }
+    }
+    for _, quarter := range node.quarters {
+        quarter.search(rect, bounds, found)
+    }
+}
@@ -0,0 +1,222 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "image"
+    "image/color"
+    "math"
+    "sort"
+)
+
+// FillRule says which parts of a self-intersecting outline are inside.
+type FillRule int
+
+const (
+    NonZero FillRule = iota // Inside if the outline winds around it
+    EvenOdd                 // Inside if crossed an odd number of times
+)
+
+func (rule FillRule) String() string {
+    if rule == EvenOdd {
+        return "evenodd"
+    }
+    return "nonzero"
+}
+
+// A Paint gives the color at each point of a filled shape. The points
+// (and a gradient's points, which refer to the middles of pixels) are
+// relative to where the shape is drawn (its x, y); the vector
+// canvases know how to write Solid, LinearGradient, RadialGradient, and
+// Pattern paints and use the color at the x, y for other kinds.
+type Paint interface {
+    At(x, y float64) color.Color
+}
+
+type Solid struct {
+    Color color.Color
+}
+
+func (solid Solid) At(x, y float64) color.Color {
+    if solid.Color == nil { // As for fills we treat nil as black
+        return color.Black
+    }
+    return solid.Color
+}
+
+// A Stop is a gradient's color at the given offset between 0.0 and 1.0
+// along the gradient; colors between stops are interpolated and those
+// beyond the first and last stops are the same as them.
+type Stop struct {
+    Offset float64
+    Color  color.Color
+}
+
+type LinearGradient struct {
+    Start, End image.Point
+    Stops      []Stop
+}
+
+func (gradient LinearGradient) At(x, y float64) color.Color {
+    start, end := centerVec(gradient.Start), centerVec(gradient.End)
+    axis := end.sub(start)
+    t := 0.0
+    if length := axis.dot(axis); length > 0 {
+        t = vec{x, y}.sub(start).dot(axis) / length
+    }
+    return colorAtOffset(gradient.Stops, t)
+}
+
+type RadialGradient struct {
+    Center image.Point
+    Radius int
+    Stops  []Stop
+}
+
+func (gradient RadialGradient) At(x, y float64) color.Color {
+    t := 0.0
+    if gradient.Radius > 0 {
+        t = vec{x, y}.distance(centerVec(gradient.Center)) /
+            float64(gradient.Radius)
+    }
+    return colorAtOffset(gradient.Stops, t)
+}
+
+// A Pattern tiles the image in every direction with one tile's top-left
+// at Origin.
+type Pattern struct {
+    Image  image.Image
+    Origin image.Point
+}
+
+func (pattern Pattern) At(x, y float64) color.Color {
+    if pattern.Image == nil || pattern.Image.Bounds().Empty() {
+        return color.Transparent
+    }
+    bounds := pattern.Image.Bounds()
+    column := modulo(int(math.Floor(x))-pattern.Origin.X, bounds.Dx())
+    row := modulo(int(math.Floor(y))-pattern.Origin.Y, bounds.Dy())
+    return pattern.Image.At(bounds.Min.X+column, bounds.Min.Y+row)
+}
+
+func modulo(x, y int) int {
+    if x %= y; x < 0 {
+        x += y
+    }
+    return x
+}
+
+// saneStops returns the stops in offset order with their offsets
+// bounded to 0.0 to 1.0 and nil colors replaced with black; a gradient
+// without stops is black.
+func saneStops(stops []Stop) []Stop {
+    if len(stops) == 0 {
+        return []Stop{{0, color.Black}}
+    }
+    sane := make([]Stop, len(stops))
+    for i, stop := range stops {
+        sane[i] = stop
+        sane[i].Offset = math.Max(0, math.Min(1, stop.Offset))
+        if math.IsNaN(stop.Offset) {
+            sane[i].Offset = 0
+        }
+        if stop.Color == nil {
+            sane[i].Color = color.Black
+        }
+    }
+    sort.SliceStable(sane, func(i, j int) bool {
+        return sane[i].Offset < sane[j].Offset
+    })
+    return sane
+}
+
+func colorAtOffset(stops []Stop, t float64) color.Color {
+    stops = saneStops(stops)
+    if t <= stops[0].Offset {
+        return stops[0].Color
+    }
+    for i := 1; i < len(stops); i++ {
+        if t <= stops[i].Offset {
+            before, after := stops[i-1], stops[i]
+            return mix(before.Color, after.Color, (t-before.Offset)/
+                (after.Offset-before.Offset))
+        }
+    }
+    return stops[len(stops)-1].Color
+}
+
+// mix returns the color the given fraction of the way from a to b.
+func mix(a, b color.Color, fraction float64) color.Color {
+    from := color.NRGBAModel.Convert(a).(color.NRGBA)
+    to := color.NRGBAModel.Convert(b).(color.NRGBA)
+    channel := func(x, y uint8) uint8 {
+        return uint8(float64(x) + (float64(y)-float64(x))*fraction + 0.5)
+    }
+    return color.NRGBA{channel(from.R, to.R), channel(from.G, to.G),
+        channel(from.B, to.B), channel(from.A, to.A)}
+}
+
+// translatePaint returns the paint moved by the offset so that a paint
+// given relative to a shape can be used at the shape's position.
+func translatePaint(paint Paint, offset image.Point) Paint {
+    switch paint := paint.(type) {
+    case Solid:
+        return paint
+    case LinearGradient:
+        paint.Start = paint.Start.Add(offset)
+        paint.End = paint.End.Add(offset)
+        return paint
+    case RadialGradient:
+        paint.Center = paint.Center.Add(offset)
+        return paint
+    case Pattern:
+        paint.Origin = paint.Origin.Add(offset)
+        return paint
+    }
+    return translatedPaint{paint, offset}
+}
+
+type translatedPaint struct {
+    Paint
+    offset image.Point
+}
+
+func (paint translatedPaint) At(x, y float64) color.Color {
+    return paint.Paint.At(x-float64(paint.offset.X),
+        y-float64(paint.offset.Y))
+}
+
+// paintImage is an image.Image of the paint where each pixel has the
+// paint's color at the pixel's middle.
+type paintImage struct {
+    paint  Paint
+    bounds image.Rectangle
+}
+
+func (img paintImage) ColorModel() color.Model { return color.NRGBAModel }
+
+func (img paintImage) Bounds() image.Rectangle { return img.bounds }
+
+func (img paintImage) At(x, y int) color.Color {
+    return img.paint.At(float64(x)+0.5, float64(y)+0.5)
+}
+
+// sourceImage returns the image to composite for the paint over the
+// bounds.
+func sourceImage(paint Paint, bounds image.Rectangle) image.Image {
+    if solid, ok := paint.(Solid); ok {
+        return &image.Uniform{solid.At(0, 0)}
+    }
+    return paintImage{paint, bounds}
+}
@@ -0,0 +1,359 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/color"
+    "io"
+    "strings"
+)
+
+// Control point distance for drawing a quarter circle with one cubic
+// Bézier curve.
+const circleKappa = 0.5522847498
+
+// PDFCanvas is a Canvas that records what is drawn as the content of a
+// single-page PDF whose points correspond to pixels. Like an SVGCanvas
+// it maps pixel coordinates to the centers of the pixels and leaves
+// antialiasing to the viewer.
+type PDFCanvas struct {
+    width, height int
+    content       bytes.Buffer
+    alphas        []uint8  // Index i has graphics state /GSi
+    patterns      []string // Index i is pattern /Pi, object i + 5
+}
+
+// NewPDFCanvas returns a canvas of the given size; the background may be
+// nil for none.
+func NewPDFCanvas(width, height int, background color.Color) *PDFCanvas {
+    canvas := &PDFCanvas{width: saneLength(width),
+        height: saneLength(height)}
+    // Flip the y-axis so that 0, 0 is the top-left as for images
+    fmt.Fprintf(&canvas.content, "1 0 0 -1 0 %d cm\n", canvas.height)
+    if background != nil {
+        canvas.FillRect(image.Rect(0, 0, canvas.width-1, canvas.height-1),
+            Solid{background})
+    }
+    return canvas
+}
+
+func (canvas *PDFCanvas) Bounds() image.Rectangle {
+    return image.Rect(0, 0, canvas.width, canvas.height)
+}
+
+func (canvas *PDFCanvas) Line(start, end image.Point, fill color.Color,
+    style Style) {
+    fmt.Fprintf(&canvas.content, "%s %s %s m %s %s l S Q\n",
+        canvas.strokeState(fill, style), centerOf(start.X),
+        centerOf(start.Y), centerOf(end.X), centerOf(end.Y))
+}
+
+func (canvas *PDFCanvas) Circle(center image.Point, radius int,
+    fill color.Color, style Style) {
+    fmt.Fprintf(&canvas.content, "%s %sS Q\n",
+        canvas.strokeState(fill, style), ellipsePath(center,
+            float64(radius), float64(radius)))
+}
+
+func (canvas *PDFCanvas) Ellipse(center image.Point, radiusX, radiusY int,
+    fill color.Color, style Style) {
+    fmt.Fprintf(&canvas.content, "%s %sS Q\n",
+        canvas.strokeState(fill, style), ellipsePath(center,
+            float64(radiusX), float64(radiusY)))
+}
+
+// ellipsePath returns a closed path approximating the ellipse with four
+// Bézier curves.
+func ellipsePath(center image.Point, radiusX, radiusY float64) string {
+    x, y, rx, ry := float64(center.X)+0.5, float64(center.Y)+0.5, radiusX,
+        radiusY
+    kx, ky := rx*circleKappa, ry*circleKappa
+    path := fmt.Sprintf("%s %s m\n", formatFloat(x+rx), formatFloat(y))
+    for _, curve := range [][6]float64{
+        {x + rx, y + ky, x + kx, y + ry, x, y + ry},
+        {x - kx, y + ry, x - rx, y + ky, x - rx, y},
+        {x - rx, y - ky, x - kx, y - ry, x, y - ry},
+        {x + kx, y - ry, x + rx, y - ky, x + rx, y}} {
+        for _, coordinate := range curve {
+            path += formatFloat(coordinate) + " "
+        }
+        path += "c\n"
+    }
+    return path
+}
+
+func (canvas *PDFCanvas) Polygon(points []image.Point,
+    fill color.Color, style Style) {
+    canvas.content.WriteString(canvas.strokeState(fill, style))
+    for i, point := range openPoints(points) {
+        operator := "l"
+        if i == 0 {
+            operator = "m"
+        }
+        fmt.Fprintf(&canvas.content, " %s %s %s", centerOf(point.X),
+            centerOf(point.Y), operator)
+    }
+    canvas.content.WriteString(" s Q\n")
+}
+
+func (canvas *PDFCanvas) Polyline(points []image.Point,
+    fill color.Color, style Style) {
+    canvas.content.WriteString(canvas.strokeState(fill, style))
+    for i, point := range points {
+        operator := "l"
+        if i == 0 {
+            operator = "m"
+        }
+        fmt.Fprintf(&canvas.content, " %s %s %s", centerOf(point.X),
+            centerOf(point.Y), operator)
+    }
+    canvas.content.WriteString(" S Q\n")
+}
+
+func (canvas *PDFCanvas) FillRect(rect image.Rectangle, paint Paint) {
+    rect = rect.Canon()
+    fmt.Fprintf(&canvas.content, "q %s %d %d %d %d re f Q\n",
+        canvas.fillState(paint), rect.Min.X, rect.Min.Y, rect.Dx()+1,
+        rect.Dy()+1)
+}
+
+func (canvas *PDFCanvas) FillPolygon(points []image.Point, paint Paint,
+    rule FillRule, antiAlias bool) {
+    canvas.content.WriteString("q " + canvas.fillState(paint))
+    for i, point := range openPoints(points) {
+        operator := "l"
+        if i == 0 {
+            operator = "m"
+        }
+        fmt.Fprintf(&canvas.content, " %s %s %s", centerOf(point.X),
+            centerOf(point.Y), operator)
+    }
+    operator := "f"
+    if rule == EvenOdd {
+        operator = "f*"
+    }
+    fmt.Fprintf(&canvas.content, " h %s Q\n", operator)
+}
+
+func (canvas *PDFCanvas) FillEllipse(center image.Point,
+    radiusX, radiusY int, paint Paint, antiAlias bool) {
+    fmt.Fprintf(&canvas.content, "q %s %sf Q\n", canvas.fillState(paint),
+        ellipsePath(center, float64(radiusX)+0.5, float64(radiusY)+0.5))
+}
+
+// fillState returns the operators that set the nonstroking color (or
+// pattern) for the paint; unknown kinds of paint use the paint's color
+// at 0, 0. PDF gradients and patterns are opaque.
+func (canvas *PDFCanvas) fillState(paint Paint) string {
+    switch paint := paint.(type) {
+    case Solid:
+        return canvas.alphaState(paint.At(0, 0)) + pdfColor(paint.At(0, 0),
+            "rg")
+    case LinearGradient:
+        start, end := centerVec(paint.Start), centerVec(paint.End)
+        return canvas.addShading(fmt.Sprintf("/ShadingType 2 "+
+            "/Coords [%s %s %s %s]", formatFloat(start.x),
+            formatFloat(start.y), formatFloat(end.x), formatFloat(end.y)),
+            paint.Stops)
+    case RadialGradient:
+        x, y := centerOf(paint.Center.X), centerOf(paint.Center.Y)
+        return canvas.addShading(fmt.Sprintf("/ShadingType 3 "+
+            "/Coords [%s %s 0 %[1]s %[2]s %d]", x, y, paint.Radius),
+            paint.Stops)
+    case Pattern:
+        if paint.Image != nil && !paint.Image.Bounds().Empty() {
+            return canvas.addTiling(paint)
+        }
+    }
+    return canvas.fillState(Solid{paint.At(0, 0)})
+}
+
+// addShading adds a shading pattern (whose space, like the content's,
+// has 0, 0 at the top-left) and returns the operators that select it.
+func (canvas *PDFCanvas) addShading(shading string, stops []Stop) string {
+    return canvas.addPattern(fmt.Sprintf("<< /Type /Pattern /PatternType 2 "+
+        "/Matrix [1 0 0 -1 0 %d] /Shading << %s /ColorSpace /DeviceRGB "+
+        "/Function %s /Extend [true true] >> >>", canvas.height, shading,
+        pdfStopsFunction(stops)))
+}
+
+// addTiling adds a tiling pattern that paints the pattern's image once
+// per tile and returns the operators that select it.
+func (canvas *PDFCanvas) addTiling(pattern Pattern) string {
+    bounds := pattern.Image.Bounds()
+    var data bytes.Buffer
+    fmt.Fprintf(&data, "q %d 0 0 -%d 0 %[2]d cm\nBI /W %[1]d /H %[2]d "+
+        "/CS /RGB /BPC 8 /F /AHx ID\n", bounds.Dx(), bounds.Dy())
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            nrgba := color.NRGBAModel.Convert(pattern.Image.At(x,
+                y)).(color.NRGBA)
+            fmt.Fprintf(&data, "%02X%02X%02X", nrgba.R, nrgba.G, nrgba.B)
+        }
+        data.WriteByte('\n')
+    }
+    data.WriteString(">\nEI Q\n")
+    return canvas.addPattern(fmt.Sprintf("<< /Type /Pattern /PatternType 1 "+
+        "/PaintType 1 /TilingType 1 /BBox [0 0 %d %d] /XStep %[1]d "+
+        "/YStep %[2]d /Matrix [1 0 0 -1 %d %d] /Resources << >> "+
+        "/Length %d >>\nstream\n%sendstream", bounds.Dx(), bounds.Dy(),
+        pattern.Origin.X, canvas.height-pattern.Origin.Y, data.Len(),
+        data.String()))
+}
+
+func (canvas *PDFCanvas) addPattern(object string) string {
+    canvas.patterns = append(canvas.patterns, object)
+    return fmt.Sprintf("/Pattern cs /P%d scn", len(canvas.patterns)-1)
+}
+
+// pdfStopsFunction returns a function from 0.0 to 1.0 to the stops'
+// colors: an exponential (type 2) function for each pair of stops
+// stitched together by a type 3 function.
+func pdfStopsFunction(stops []Stop) string {
+    stops = saneStops(stops)
+    if first := stops[0]; first.Offset > 0 {
+        stops = append([]Stop{{0, first.Color}}, stops...)
+    }
+    if last := stops[len(stops)-1]; last.Offset < 1 {
+        stops = append(stops, Stop{1, last.Color})
+    }
+    functions := make([]string, 0, len(stops)-1)
+    bounds := make([]string, 0, len(stops)-2)
+    encode := make([]string, 0, len(stops)-1)
+    for i := 1; i < len(stops); i++ {
+        functions = append(functions, fmt.Sprintf("<< /FunctionType 2 "+
+            "/Domain [0 1] /C0 [%s] /C1 [%s] /N 1 >>",
+            pdfRGB(stops[i-1].Color), pdfRGB(stops[i].Color)))
+        if i < len(stops)-1 {
+            bounds = append(bounds, formatFloat(stops[i].Offset))
+        }
+        encode = append(encode, "0 1")
+    }
+    if len(functions) == 1 {
+        return functions[0]
+    }
+    return fmt.Sprintf("<< /FunctionType 3 /Domain [0 1] /Functions [%s] "+
+        "/Bounds [%s] /Encode [%s] >>", strings.Join(functions, " "),
+        strings.Join(bounds, " "), strings.Join(encode, " "))
+}
+
+// strokeState saves the graphics state and sets it for stroking in the
+// given color and style; the caller must restore it (with Q) afterwards.
+func (canvas *PDFCanvas) strokeState(fill color.Color,
+    style Style) string {
+    style = saneStyle(style)
+    state := "q " + canvas.alphaState(fill) + pdfColor(fill, "RG")
+    if width := style.width(); width != 1 {
+        state += fmt.Sprintf(" %s w", formatFloat(width))
+    }
+    if style.Dashes != nil {
+        dashes := make([]string, 0, len(style.Dashes))
+        for _, length := range style.Dashes {
+            dashes = append(dashes, formatFloat(length))
+        }
+        state += fmt.Sprintf(" [%s] 0 d", strings.Join(dashes, " "))
+    }
+    if style.Join != MiterJoin {
+        state += fmt.Sprintf(" %d j", style.Join) // Round 1, bevel 2
+    }
+    return state
+}
+
+// alphaState returns the operator that selects a graphics state with
+// the color's alpha for both stroking and filling, or "" if the color is
+// opaque.
+func (canvas *PDFCanvas) alphaState(fill color.Color) string {
+    alpha := color.NRGBAModel.Convert(fill).(color.NRGBA).A
+    if alpha == 0xFF {
+        return ""
+    }
+    i := 0
+    for ; i < len(canvas.alphas); i++ {
+        if canvas.alphas[i] == alpha {
+            break
+        }
+    }
+    if i == len(canvas.alphas) {
+        canvas.alphas = append(canvas.alphas, alpha)
+    }
+    return fmt.Sprintf("/GS%d gs ", i)
+}
+
+// WriteTo writes a complete PDF 1.4 file with a cross-reference table
+// giving each object's byte offset.
+func (canvas *PDFCanvas) WriteTo(writer io.Writer) (int64, error) {
+    var buffer bytes.Buffer
+    buffer.WriteString("%PDF-1.4\n")
+    objects := []string{
+        "<< /Type /Catalog /Pages 2 0 R >>",
+        "<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+        fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+            "/Contents 4 0 R /Resources << %s>> >>", canvas.width,
+            canvas.height, canvas.resources()),
+        fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream",
+            canvas.content.Len(), canvas.content.String()),
+    }
+    objects = append(objects, canvas.patterns...)
+    offsets := make([]int, len(objects))
+    for i, object := range objects {
+        offsets[i] = buffer.Len()
+        fmt.Fprintf(&buffer, "%d 0 obj\n%s\nendobj\n", i+1, object)
+    }
+    xref := buffer.Len()
+    fmt.Fprintf(&buffer, "xref\n0 %d\n0000000000 65535 f \n",
+        len(objects)+1)
+    for _, offset := range offsets {
+        fmt.Fprintf(&buffer, "%010d 00000 n \n", offset)
+    }
+    fmt.Fprintf(&buffer, "trailer\n<< /Size %d /Root 1 0 R >>\n"+
+        "startxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+    return buffer.WriteTo(writer)
+}
+
+func (canvas *PDFCanvas) resources() string {
+    resources := ""
+    if len(canvas.alphas) > 0 {
+        resources += "/ExtGState << "
+        for i, alpha := range canvas.alphas {
+            opacity := formatFloat(float64(alpha) / 0xFF)
+            resources += fmt.Sprintf("/GS%d << /CA %s /ca %s >> ", i,
+                opacity, opacity)
+        }
+        resources += ">> "
+    }
+    if len(canvas.patterns) > 0 {
+        resources += "/Pattern << "
+        for i := range canvas.patterns {
+            resources += fmt.Sprintf("/P%d %d 0 R ", i, i+5)
+        }
+        resources += ">> "
+    }
+    return resources
+}
+
+// pdfColor returns the operator that sets the stroking ("RG") or
+// nonstroking ("rg") color; the alpha is set separately.
+func pdfColor(fill color.Color, operator string) string {
+    return pdfRGB(fill) + " " + operator
+}
+
+func pdfRGB(fill color.Color) string {
+    nrgba := color.NRGBAModel.Convert(fill).(color.NRGBA)
+    return fmt.Sprintf("%s %s %s", formatFloat(float64(nrgba.R)/0xFF),
+        formatFloat(float64(nrgba.G)/0xFF),
+        formatFloat(float64(nrgba.B)/0xFF))
+}
@@ -0,0 +1,485 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "log"
+    "math"
+)
+
+// The zero value is invalid! Use NewEllipse() to create a valid Ellipse.
+type Ellipse struct {
+    shape
+    radiusX, radiusY int
+}
+
+func NewEllipse(fill color.Color, radiusX, radiusY int) *Ellipse {
+    return &Ellipse{newShape(fill), saneRadius(radiusX),
+        saneRadius(radiusY)}
+}
+
+func (ellipse *Ellipse) Radii() (radiusX, radiusY int) {
+    return ellipse.radiusX, ellipse.radiusY
+}
+
+func (ellipse *Ellipse) SetRadii(radiusX, radiusY int) {
+    ellipse.radiusX = saneRadius(radiusX)
+    ellipse.radiusY = saneRadius(radiusY)
+}
+
+func (ellipse *Ellipse) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    if ellipse.filled {
+        canvas.FillEllipse(image.Pt(x, y), ellipse.radiusX,
+            ellipse.radiusY, ellipse.paintAt(image.Pt(x, y)),
+            ellipse.style.AntiAlias)
+    } else {
+        canvas.Ellipse(image.Pt(x, y), ellipse.radiusX, ellipse.radiusY,
+            ellipse.fill, ellipse.style)
+    }
+    return nil
+}
+
+func (ellipse *Ellipse) String() string {
+    return fmt.Sprintf("ellipse(fill=%v, radii=%d×%d)", ellipse.fill,
+        ellipse.radiusX, ellipse.radiusY)
+}
+
+// The zero value is invalid! Use NewArc() to create a valid Arc. An arc
+// is part of a circle's circumference; if filled the chord closes it.
+type Arc struct {
+    *Circle
+    start, end float64
+}
+
+// NewArc returns an arc from the start angle clockwise to the end angle
+// (both in degrees clockwise from 3 o'clock); equal angles give a whole
+// circle.
+func NewArc(fill color.Color, radius int, start, end float64) *Arc {
+    arc := &Arc{Circle: NewCircle(fill, radius)}
+    arc.SetAngles(start, end)
+    return arc
+}
+
+// Angles returns the start angle from 0° up to 360° and the end angle
+// which is more than the start and up to 360° beyond it.
+func (arc *Arc) Angles() (start, end float64) {
+    return arc.start, arc.end
+}
+
+func (arc *Arc) SetAngles(start, end float64) {
+    if math.IsNaN(start) || math.IsInf(start, 0) || math.IsNaN(end) ||
+        math.IsInf(end, 0) {
+        log.Printf("%s(): replaced %g° to %g° with 0° to 360°\n",
+            caller(0), start, end)
+        start, end = 0, 0
+    }
+    sweep := math.Mod(end-start, 360)
+    if sweep <= 0 {
+        sweep += 360
+    }
+    arc.start = math.Mod(start, 360)
+    if arc.start < 0 {
+        arc.start += 360
+    }
+    arc.end = arc.start + sweep
+}
+
+func (arc *Arc) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    points := arc.arcPoints(x, y)
+    if arc.filled {
+        canvas.FillPolygon(points, arc.paintAt(image.Pt(x, y)), arc.rule,
+            arc.style.AntiAlias)
+    } else {
+        canvas.Polyline(points, arc.fill, arc.style)
+    }
+    return nil
+}
+
+// arcPoints returns points along the arc close enough together for
+// its sides to look curved.
+func (arc *Arc) arcPoints(x, y int) []image.Point {
+    radius := float64(arc.Radius())
+    start, sweep := arc.start*math.Pi/180, (arc.end-arc.start)*math.Pi/180
+    sides := int(math.Max(2, math.Ceil(sweep*radius/2)))
+    points := make([]image.Point, 0, sides+1)
+    for i := 0; i <= sides; i++ {
+        θ := start + sweep*float64(i)/float64(sides)
+        points = append(points, roundedPoint(float64(x)+
+            radius*math.Cos(θ), float64(y)+radius*math.Sin(θ)))
+    }
+    return points
+}
+
+func (arc *Arc) String() string {
+    return fmt.Sprintf("arc(fill=%v, radius=%d, angles=%g°-%g°)",
+        arc.Fill(), arc.Radius(), arc.start, arc.end)
+}
+
+// The zero value is invalid! Use NewPie() to create a valid Pie. A pie
+// is an arc closed by two radii.
+type Pie struct {
+    *Arc
+}
+
+func NewPie(fill color.Color, radius int, start, end float64) *Pie {
+    return &Pie{NewArc(fill, radius, start, end)}
+}
+
+func (pie *Pie) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    points := pie.arcPoints(x, y)
+    if pie.end-pie.start < 360 {
+        points = append(points, image.Pt(x, y))
+    }
+    if pie.filled {
+        canvas.FillPolygon(points, pie.paintAt(image.Pt(x, y)), pie.rule,
+            pie.style.AntiAlias)
+    } else {
+        canvas.Polygon(points, pie.Fill(), pie.style)
+    }
+    return nil
+}
+
+func (pie *Pie) String() string {
+    return fmt.Sprintf("pie(fill=%v, radius=%d, angles=%g°-%g°)",
+        pie.Fill(), pie.Radius(), pie.start, pie.end)
+}
+
+// The zero value is invalid! Use NewStar() to create a valid Star. Its
+// points are Radius() from the middle and the corners between them are
+// InnerRadius() from the middle; the first point is at the top.
+type Star struct {
+    *Circle
+    innerRadius, points int
+}
+
+func NewStar(fill color.Color, radius, innerRadius, points int) *Star {
+    return &Star{NewCircle(fill, radius), saneRadius(innerRadius),
+        saneSides(points)}
+}
+
+func (star *Star) InnerRadius() int {
+    return star.innerRadius
+}
+
+func (star *Star) SetInnerRadius(radius int) {
+    star.innerRadius = saneRadius(radius)
+}
+
+func (star *Star) Points() int {
+    return star.points
+}
+
+func (star *Star) SetPoints(points int) {
+    star.points = saneSides(points)
+}
+
+func (star *Star) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    corners := star.corners(x, y)
+    if star.filled {
+        canvas.FillPolygon(corners, star.paintAt(image.Pt(x, y)),
+            star.rule, star.style.AntiAlias)
+    } else {
+        canvas.Polygon(corners, star.Fill(), star.style)
+    }
+    return nil
+}
+
+func (star *Star) corners(x, y int) []image.Point {
+    corners := make([]image.Point, 0, 2*star.points)
+    for i := 0; i < 2*star.points; i++ {
+        radius := float64(star.Radius())
+        if i%2 == 1 {
+            radius = float64(star.innerRadius)
+        }
+        θ := float64(i)*math.Pi/float64(star.points) - math.Pi/2
+        corners = append(corners, roundedPoint(float64(x)+
+            radius*math.Cos(θ), float64(y)+radius*math.Sin(θ)))
+    }
+    return corners
+}
+
+func (star *Star) String() string {
+    return fmt.Sprintf("star(fill=%v, radius=%d, innerRadius=%d, "+
+        "points=%d)", star.Fill(), star.Radius(), star.innerRadius,
+        star.points)
+}
+
+// The zero value is invalid! Use NewRoundedRectangle() to create a valid
+// RoundedRectangle. The corner radius is reduced to half the width or
+// height if it is more than that.
+type RoundedRectangle struct {
+    *Rectangle
+    cornerRadius int
+}
+
+func NewRoundedRectangle(fill color.Color, rect image.Rectangle,
+    cornerRadius int) *RoundedRectangle {
+    return &RoundedRectangle{NewRectangle(fill, rect),
+        saneCornerRadius(cornerRadius)}
+}
+
+func (rectangle *RoundedRectangle) CornerRadius() int {
+    return rectangle.cornerRadius
+}
+
+func (rectangle *RoundedRectangle) SetCornerRadius(radius int) {
+    rectangle.cornerRadius = saneCornerRadius(radius)
+}
+
+// x, y are the top-left as for a Rectangle
+func (rectangle *RoundedRectangle) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    rect := rectangle.Rectangle.Rectangle.Add(image.Pt(x, y))
+    if rectangle.filled {
+        // Like FillRect() this fills the pixels at rect.Max too
+        rect.Max = rect.Max.Add(image.Pt(1, 1))
+        canvas.FillPolygon(roundedCorners(rect, rectangle.cornerRadius),
+            rectangle.paintAt(image.Pt(x, y)), rectangle.rule,
+            rectangle.style.AntiAlias)
+    } else {
+        canvas.Polygon(roundedCorners(rect, rectangle.cornerRadius),
+            rectangle.fill, rectangle.style)
+    }
+    return nil
+}
+
+// roundedCorners returns the outline of the rectangle with each corner
+// replaced by a quarter circle.
+func roundedCorners(rect image.Rectangle, radius int) []image.Point {
+    radius = minimum(radius, minimum(rect.Dx(), rect.Dy())/2)
+    if radius == 0 {
+        return []image.Point{rect.Min, image.Pt(rect.Max.X, rect.Min.Y),
+            rect.Max, image.Pt(rect.Min.X, rect.Max.Y)}
+    }
+    r := float64(radius)
+    steps := curveSides(math.Pi/2*r, 2, maxCurveSides/4) // Per corner
+    var points []image.Point
+    // The corners' centers clockwise from the bottom-right
+    for i, center := range []image.Point{rect.Max.Sub(image.Pt(radius,
+        radius)), image.Pt(rect.Min.X+radius, rect.Max.Y-radius),
+        rect.Min.Add(image.Pt(radius, radius)), image.Pt(rect.Max.X-radius,
+            rect.Min.Y+radius)} {
+        for step := 0; step <= steps; step++ {
+            θ := (float64(i) + float64(step)/float64(steps)) * math.Pi / 2
+            points = append(points, roundedPoint(float64(center.X)+
+                r*math.Cos(θ), float64(center.Y)+r*math.Sin(θ)))
+        }
+    }
+    return points
+}
+
+func (rectangle *RoundedRectangle) String() string {
+    return fmt.Sprintf("roundedrectangle(fill=%v, rect=%v, "+
+        "cornerRadius=%d)", rectangle.fill, rectangle.Rectangle.Rectangle,
+        rectangle.cornerRadius)
+}
+
+// The zero value is invalid! Use NewPolyline() to create a valid
+// Polyline. Its vertices are relative to where it is drawn; if filled it
+// is closed by joining the last vertex to the first.
+type Polyline struct {
+    shape
+    vertices []image.Point
+}
+
+func NewPolyline(fill color.Color, vertices []image.Point) *Polyline {
+    polyline := &Polyline{shape: newShape(fill)}
+    polyline.SetVertices(vertices)
+    return polyline
+}
+
+// Vertices returns a copy so that the caller can't change the polyline
+// behind its back.
+func (polyline *Polyline) Vertices() []image.Point {
+    return append([]image.Point(nil), polyline.vertices...)
+}
+
+func (polyline *Polyline) SetVertices(vertices []image.Point) {
+    polyline.vertices = saneVertices(vertices, 1)
+}
+
+func (polyline *Polyline) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    polyline.drawPath(canvas, x, y, offsetPoints(polyline.vertices, x, y))
+    return nil
+}
+
+// drawPath outlines the open path or if the shape is filled fills it.
+func (shape shape) drawPath(canvas Canvas, x, y int, path []image.Point) {
+    if shape.filled {
+        canvas.FillPolygon(path, shape.paintAt(image.Pt(x, y)), shape.rule,
+            shape.style.AntiAlias)
+    } else {
+        canvas.Polyline(path, shape.fill, shape.style)
+    }
+}
+
+func (polyline *Polyline) String() string {
+    return fmt.Sprintf("polyline(fill=%v, vertices=%v)", polyline.fill,
+        polyline.vertices)
+}
+
+// The zero value is invalid! Use NewQuadraticBezier() or NewCubicBezier()
+// to create a valid Bezier. A Bezier is a path of curves each of which
+// starts where the previous one ends; its vertices are the first curve's
+// start followed by each curve's control points (one for quadratic, two
+// for cubic curves) and end, all relative to where it is drawn.
+type Bezier struct {
+    shape
+    degree   int // 2 for quadratic and 3 for cubic curves
+    vertices []image.Point
+}
+
+func NewQuadraticBezier(fill color.Color,
+    vertices []image.Point) *Bezier {
+    bezier := &Bezier{shape: newShape(fill), degree: 2}
+    bezier.SetVertices(vertices)
+    return bezier
+}
+
+func NewCubicBezier(fill color.Color, vertices []image.Point) *Bezier {
+    bezier := &Bezier{shape: newShape(fill), degree: 3}
+    bezier.SetVertices(vertices)
+    return bezier
+}
+
+// Vertices returns a copy so that the caller can't change the curves
+// behind their back.
+func (bezier *Bezier) Vertices() []image.Point {
+    return append([]image.Point(nil), bezier.vertices...)
+}
+
+// SetVertices drops any vertices after the last complete curve.
+func (bezier *Bezier) SetVertices(vertices []image.Point) {
+    bezier.vertices = saneVertices(vertices, bezier.degree)
+}
+
+func (bezier *Bezier) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    bezier.drawPath(canvas, x, y, bezier.flatten(x, y))
+    return nil
+}
+
+// flatten returns the curves as a path of sides short enough to look
+// curved.
+func (bezier *Bezier) flatten(x, y int) []image.Point {
+    vertices := make([]vec, 0, len(bezier.vertices))
+    for _, vertex := range bezier.vertices {
+        vertices = append(vertices, vec{float64(x + vertex.X),
+            float64(y + vertex.Y)})
+    }
+    path := []image.Point{roundedPoint(vertices[0].x, vertices[0].y)}
+    for i := 0; i+bezier.degree < len(vertices); i += bezier.degree {
+        curve := vertices[i : i+bezier.degree+1]
+        length := 0.0 // Of the control polygon, which is at least as long
+        for j := 1; j < len(curve); j++ {
+            length += curve[j].distance(curve[j-1])
+        }
+        steps := curveSides(length, 1, maxCurveSides)
+        for step := 1; step <= steps; step++ {
+            point := deCasteljau(curve, float64(step)/float64(steps))
+            if next := roundedPoint(point.x, point.y); next !=
+                path[len(path)-1] {
+                path = append(path, next)
+            }
+        }
+    }
+    return path
+}
+
+// deCasteljau returns the point on the curve the fraction t along it.
+func deCasteljau(curve []vec, t float64) vec {
+    points := append([]vec(nil), curve...)
+    for n := len(points) - 1; n > 0; n-- {
+        for i := 0; i < n; i++ {
+            points[i] = points[i].lerp(points[i+1], t)
+        }
+    }
+    return points[0]
+}
+
+func (bezier *Bezier) String() string {
+    name := "cubicbezier"
+    if bezier.degree == 2 {
+        name = "quadraticbezier"
+    }
+    return fmt.Sprintf("%s(fill=%v, vertices=%v)", name, bezier.fill,
+        bezier.vertices)
+}
+
+// saneVertices returns a copy of the vertices without any after the
+// last of the curves that have the given number of vertices after the
+// first; fewer than one curve's worth are replaced with a default line
+// or curve.
+func saneVertices(vertices []image.Point, perCurve int) []image.Point {
+    count := len(vertices)
+    if count > 0 {
+        count -= (count - 1) % perCurve
+    }
+    if count < perCurve+1 {
+        valid := make([]image.Point, perCurve+1)
+        for i := range valid {
+            valid[i] = image.Pt(16*i/perCurve, 16*(i%2))
+        }
+        log.Printf("%s(): replaced %v with %v\n", caller(1), vertices,
+            valid)
+        return valid
+    }
+    if count != len(vertices) {
+        log.Printf("%s(): dropped %v\n", caller(1), vertices[count:])
+    }
+    return append([]image.Point(nil), vertices[:count]...)
+}
+
+func offsetPoints(points []image.Point, x, y int) []image.Point {
+    offset := make([]image.Point, len(points))
+    for i, point := range points {
+        offset[i] = point.Add(image.Pt(x, y))
+    }
+    return offset
+}
+
+// roundedPoint rounds halves up rather than away from zero so that a
+// shape's points round the same way wherever it is drawn.
+func roundedPoint(x, y float64) image.Point {
+    return image.Pt(int(math.Floor(x+0.5)), int(math.Floor(y+0.5)))
+}
+
+func minimum(a, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
@@ -0,0 +1,180 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/color"
+    "reflect"
+    "shaper_ans2/shapes"
+    "strings"
+    "testing"
+)
+
+var (
+    _ shapes.Radiiser       = (*shapes.Ellipse)(nil)
+    _ shapes.Angler         = (*shapes.Arc)(nil)
+    _ shapes.Angler         = (*shapes.Pie)(nil)
+    _ shapes.Radiuser       = (*shapes.Pie)(nil)
+    _ shapes.Pointser       = (*shapes.Star)(nil)
+    _ shapes.InnerRadiuser  = (*shapes.Star)(nil)
+    _ shapes.CornerRadiuser = (*shapes.RoundedRectangle)(nil)
+    _ shapes.Rectangler     = (*shapes.RoundedRectangle)(nil)
+    _ shapes.Verticeser     = (*shapes.Polyline)(nil)
+    _ shapes.Verticeser     = (*shapes.Bezier)(nil)
+)
+
+// checkPixels checks that the points which should be drawn are red and
+// those which shouldn't are white.
+func checkPixels(t *testing.T, name string, img image.Image,
+    drawn, notDrawn []image.Point) {
+    for _, point := range drawn {
+        if img.At(point.X, point.Y) != color.Color(red) {
+            t.Errorf("%s: expected %v to be drawn", name, point)
+        }
+    }
+    for _, point := range notDrawn {
+        if img.At(point.X, point.Y) != color.Color(white) {
+            t.Errorf("%s: expected %v not to be drawn", name, point)
+        }
+    }
+}
+
+func TestNewPrimitives(t *testing.T) {
+    curve := []image.Point{{-20, 10}, {0, -30}, {20, 10}}
+    for _, test := range []struct {
+        name     string
+        option   shapes.Option
+        drawn    []image.Point
+        notDrawn []image.Point
+    }{
+        {"ellipse", shapes.Option{Radius: 20, RadiusY: 10},
+            []image.Point{{50, 30}, {10, 30}, {30, 20}, {30, 40}},
+            []image.Point{{30, 30}, {51, 30}, {30, 19}}},
+        {"ellipse", shapes.Option{Radius: 20, RadiusY: 10, Filled: true},
+            []image.Point{{50, 30}, {30, 30}, {30, 20}},
+            []image.Point{{51, 30}, {30, 19}}},
+        {"arc", shapes.Option{Radius: 20, StartAngle: 180},
+            []image.Point{{10, 30}, {30, 10}, {50, 30}},
+            []image.Point{{30, 30}, {30, 50}}},
+        {"pie", shapes.Option{Radius: 20, StartAngle: -45, EndAngle: 45,
+            Filled: true}, []image.Point{{30, 30}, {45, 30}, {48, 30}},
+            []image.Point{{29, 30}, {35, 20}, {35, 40}, {10, 30}}},
+        {"star", shapes.Option{Radius: 20},
+            []image.Point{{30, 10}, {30, 40}},
+            []image.Point{{30, 30}, {30, 9}}},
+        {"star", shapes.Option{Radius: 20, Points: 4, InnerRadius: 2,
+            Filled: true}, []image.Point{{30, 30}, {30, 11}, {11, 30}},
+            []image.Point{{20, 20}, {40, 40}}},
+        {"roundedrectangle", shapes.Option{Rect: image.Rect(-20, -10, 20,
+            10), CornerRadius: 5}, []image.Point{{30, 20}, {10, 30},
+            {30, 40}, {50, 30}}, []image.Point{{10, 20}, {50, 40},
+            {30, 30}}},
+        {"roundedrectangle", shapes.Option{Rect: image.Rect(-20, -10, 20,
+            10), CornerRadius: 5, Filled: true}, []image.Point{{30, 30},
+            {30, 40}, {50, 30}}, []image.Point{{10, 20}, {50, 40},
+            {30, 41}, {51, 30}}},
+        {"polyline", shapes.Option{Vertices: []image.Point{{-20, 0}, {0,
+            -20}, {20, 0}}}, []image.Point{{10, 30}, {20, 20}, {50, 30}},
+            []image.Point{{30, 30}, {40, 30}}},
+        {"polyline", shapes.Option{Vertices: []image.Point{{-20, 0}, {0,
+            -20}, {20, 0}}, Filled: true}, []image.Point{{30, 20},
+            {30, 25}}, []image.Point{{30, 31}}},
+        {"quadraticbezier", shapes.Option{Vertices: curve},
+            []image.Point{{10, 40}, {30, 20}, {50, 40}},
+            []image.Point{{30, 0}, {30, 40}}},
+        {"cubicbezier", shapes.Option{Vertices: []image.Point{{-20, 0},
+            {-20, -20}, {20, -20}, {20, 0}}}, []image.Point{{10, 30},
+            {30, 15}, {50, 30}}, []image.Point{{30, 10}, {30, 30}}},
+    } {
+        test.option.Fill = red
+        shape, err := shapes.New(test.name, test.option)
+        if err != nil {
+            t.Fatal(err)
+        }
+        canvas := shapes.FilledImage(60, 60, white)
+        if err := shape.Draw(canvas, 30, 30); err != nil {
+            t.Fatal(err)
+        }
+        checkPixels(t, fmt.Sprint(shape), canvas, test.drawn,
+            test.notDrawn)
+    }
+}
+
+func TestPrimitiveSetters(t *testing.T) {
+    arc := shapes.NewArc(red, 10, -90, -90)
+    if start, end := arc.Angles(); start != 270 || end != 630 {
+        t.Errorf("expected 270° to 630° got %g° to %g°", start, end)
+    }
+    arc.SetAngles(350, 10)
+    if start, end := arc.Angles(); start != 350 || end != 370 {
+        t.Errorf("expected 350° to 370° got %g° to %g°", start, end)
+    }
+    star := shapes.NewStar(red, 10, 5, 2)
+    if star.Points() != 3 {
+        t.Errorf("expected a star to have at least 3 points")
+    }
+    ellipse := shapes.NewEllipse(red, 0, 5000)
+    if radiusX, radiusY := ellipse.Radii(); radiusX != 1 ||
+        radiusY != 1024 {
+        t.Errorf("expected radii 1×1024 got %d×%d", radiusX, radiusY)
+    }
+    vertices := []image.Point{{0, 0}, {10, 0}}
+    polyline := shapes.NewPolyline(red, vertices)
+    vertices[0] = image.Pt(5, 5)
+    if polyline.Vertices()[0] != image.ZP {
+        t.Error("the polyline's vertices were changed from outside")
+    }
+    bezier := shapes.NewCubicBezier(red, []image.Point{{0, 0}, {1, 1},
+        {2, 2}, {3, 3}, {4, 4}, {5, 5}})
+    if !reflect.DeepEqual(bezier.Vertices(), []image.Point{{0, 0}, {1, 1},
+        {2, 2}, {3, 3}}) {
+        t.Errorf("expected the incomplete curve dropped got %v",
+            bezier.Vertices())
+    }
+    if vertices := shapes.NewQuadraticBezier(red, nil).Vertices();
+        len(vertices) != 3 {
+        t.Errorf("expected a default quadratic curve got %v", vertices)
+    }
+}
+
+func TestVectorPrimitives(t *testing.T) {
+    ellipse := shapes.NewEllipse(red, 20, 10)
+    polyline := shapes.NewPolyline(red, []image.Point{{0, 0}, {10, 5}})
+    svgCanvas := shapes.NewSVGCanvas(60, 60, nil)
+    shapes.DrawShapes(svgCanvas, 30, 30, ellipse, polyline)
+    var buffer bytes.Buffer
+    svgCanvas.WriteTo(&buffer)
+    for _, text := range []string{`<ellipse cx="30.5" cy="30.5" rx="20" ` +
+        `ry="10" fill="none" stroke="#FF0000"/>`,
+        `<polyline points="30.5,30.5 40.5,35.5" fill="none" ` +
+            `stroke="#FF0000"/>`} {
+        if !strings.Contains(buffer.String(), text) {
+            t.Errorf("expected %q in\n%s", text, buffer.String())
+        }
+    }
+    pdfCanvas := shapes.NewPDFCanvas(60, 60, nil)
+    shapes.DrawShapes(pdfCanvas, 30, 30, ellipse, polyline)
+    buffer.Reset()
+    pdfCanvas.WriteTo(&buffer)
+    for _, text := range []string{"q 1 0 0 RG 50.5 30.5 m\n",
+        "50.5 36.023 41.546 40.5 30.5 40.5 c\n",
+        "q 1 0 0 RG 30.5 30.5 m 40.5 35.5 l S Q\n"} {
+        if !strings.Contains(buffer.String(), text) {
+            t.Errorf("expected %q in\n%s", text, buffer.String())
+        }
+    }
+}
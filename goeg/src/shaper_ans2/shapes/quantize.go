@@ -0,0 +1,150 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "image"
+    "image/color"
+    "sort"
+)
+
+// A colorCount is one of an image's colors and how many pixels have it.
+type colorCount struct {
+    color.RGBA
+    count int
+}
+
+// paletteFor returns a palette of at most size colors for the images
+// and whether it has exactly their colors. If there are too many colors
+// the palette is chosen by median cut: the colors are split at the
+// median of the channel they vary most in until there are enough groups
+// and each group is represented by its average.
+func paletteFor(images []image.Image, size int) (color.Palette, bool) {
+    counts := make(map[color.RGBA]int)
+    for _, img := range images {
+        bounds := img.Bounds()
+        for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+            for x := bounds.Min.X; x < bounds.Max.X; x++ {
+                counts[color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)]++
+            }
+        }
+    }
+    colors := make([]colorCount, 0, len(counts))
+    for rgba, count := range counts {
+        colors = append(colors, colorCount{rgba, count})
+    }
+    // Most frequent first so the palette is the same every time
+    sort.Slice(colors, func(i, j int) bool {
+        if colors[i].count != colors[j].count {
+            return colors[i].count > colors[j].count
+        }
+        return packed(colors[i].RGBA) < packed(colors[j].RGBA)
+    })
+    if len(colors) <= size {
+        palette := make(color.Palette, 0, len(colors))
+        for _, entry := range colors {
+            palette = append(palette, entry.RGBA)
+        }
+        return palette, true
+    }
+    boxes := [][]colorCount{colors}
+    for len(boxes) < size {
+        widest, channel, width := -1, 0, 0
+        for i, box := range boxes {
+            if len(box) < 2 {
+                continue
+            }
+            if c, w := widestChannel(box); w > width {
+                widest, channel, width = i, c, w
+            }
+        }
+        if widest == -1 {
+            break
+        }
+        low, high := splitBox(boxes[widest], channel)
+        boxes[widest] = low
+        boxes = append(boxes, high)
+    }
+    palette := make(color.Palette, 0, len(boxes))
+    for _, box := range boxes {
+        palette = append(palette, averageOf(box))
+    }
+    return palette, false
+}
+
+func channelOf(rgba color.RGBA, i int) uint8 {
+    return [...]uint8{rgba.R, rgba.G, rgba.B, rgba.A}[i]
+}
+
+// packed returns the channels as one number to order colors by.
+func packed(rgba color.RGBA) uint32 {
+    return uint32(rgba.R)<<24 | uint32(rgba.G)<<16 | uint32(rgba.B)<<8 |
+        uint32(rgba.A)
+}
+
+// widestChannel returns the channel whose values in the box vary most
+// and by how much.
+func widestChannel(box []colorCount) (int, int) {
+    widest, width := 0, -1
+    for i := 0; i < 4; i++ {
+        low, high := 255, 0
+        for _, entry := range box {
+            value := int(channelOf(entry.RGBA, i))
+            if value < low {
+                low = value
+            }
+            if value > high {
+                high = value
+            }
+        }
+        if high-low > width {
+            widest, width = i, high-low
+        }
+    }
+    return widest, width
+}
+
+// splitBox sorts the box's colors by the channel and splits them where
+// half the pixels are on each side; neither half is empty.
+func splitBox(box []colorCount, i int) ([]colorCount, []colorCount) {
+    sort.SliceStable(box, func(a, b int) bool {
+        return channelOf(box[a].RGBA, i) < channelOf(box[b].RGBA, i)
+    })
+    total := 0
+    for _, entry := range box {
+        total += entry.count
+    }
+    middle, seen := 1, box[0].count
+    for middle < len(box)-1 && seen*2 < total {
+        seen += box[middle].count
+        middle++
+    }
+    return box[:middle], box[middle:]
+}
+
+func averageOf(box []colorCount) color.RGBA {
+    var sums [4]int
+    total := 0
+    for _, entry := range box {
+        for i := range sums {
+            sums[i] += int(channelOf(entry.RGBA, i)) * entry.count
+        }
+        total += entry.count
+    }
+    var average [4]uint8
+    for i, sum := range sums {
+        average[i] = uint8((sum + total/2) / total)
+    }
+    return color.RGBA{average[0], average[1], average[2], average[3]}
+}
@@ -0,0 +1,611 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "image"
+    "image/color"
+    "image/draw"
+    "math"
+    "sort"
+)
+
+// RasterCanvas is a Canvas that draws on an image by setting pixels; it
+// is also a draw.Image so it can be saved with SaveImage().
+//
+// Each outline is first rendered into a coverage mask which is then
+// composited onto the image in one go, so translucent outlines are
+// blended evenly even where their sides overlap.
+type RasterCanvas struct {
+    draw.Image
+}
+
+func NewRasterCanvas(img draw.Image) *RasterCanvas {
+    return &RasterCanvas{img}
+}
+
+func (canvas *RasterCanvas) Line(start, end image.Point, fill color.Color,
+    style Style) {
+    canvas.stroke([]vec{centerVec(start), centerVec(end)}, false, fill,
+        style)
+}
+
+func (canvas *RasterCanvas) Polyline(points []image.Point,
+    fill color.Color, style Style) {
+    path := make([]vec, 0, len(points))
+    for _, point := range points {
+        path = append(path, centerVec(point))
+    }
+    canvas.stroke(path, false, fill, style)
+}
+
+func (canvas *RasterCanvas) Polygon(points []image.Point,
+    fill color.Color, style Style) {
+    if len(points) == 0 {
+        return
+    }
+    path := make([]vec, 0, len(points)+1)
+    for _, point := range points {
+        path = append(path, centerVec(point))
+    }
+    if points[0] != points[len(points)-1] {
+        path = append(path, path[0])
+    }
+    canvas.stroke(path, true, fill, style)
+}
+
+func (canvas *RasterCanvas) Circle(center image.Point, radius int,
+    fill color.Color, style Style) {
+    style = saneStyle(style)
+    if style.Dashes != nil {
+        canvas.stroke(circlePath(centerVec(center), float64(radius)), true,
+            fill, style)
+        return
+    }
+    halfWidth := style.width() / 2
+    mask := newCoverageMask(canvas.Bounds(), image.Rect(center.X-radius,
+        center.Y-radius, center.X+radius+1, center.Y+radius+1).Inset(
+        -int(halfWidth)-1))
+    if style.isThin() && !style.AntiAlias {
+        mask.midpointCircle(center, radius)
+    } else {
+        mask.ring(centerVec(center), float64(radius), halfWidth,
+            style.AntiAlias)
+    }
+    canvas.composite(mask, fill)
+}
+
+func (canvas *RasterCanvas) Ellipse(center image.Point,
+    radiusX, radiusY int, fill color.Color, style Style) {
+    if radiusX == radiusY {
+        canvas.Circle(center, radiusX, fill, style)
+        return
+    }
+    canvas.stroke(ovalPath(centerVec(center), float64(radiusX),
+        float64(radiusY)), true, fill, style)
+}
+
+func (canvas *RasterCanvas) FillRect(rect image.Rectangle, paint Paint) {
+    rect = rect.Canon()
+    rect.Max = rect.Max.Add(image.Pt(1, 1))
+    draw.Draw(canvas.Image, rect, sourceImage(paint, rect), rect.Min,
+        draw.Over)
+}
+
+// FillPolygon fills the pixels whose middles are inside the polygon; a
+// pixel whose middle is exactly on a right or bottom edge is outside so
+// that polygons sharing an edge never both fill it.
+func (canvas *RasterCanvas) FillPolygon(points []image.Point, paint Paint,
+    rule FillRule, antiAlias bool) {
+    if len(points) < 3 {
+        return
+    }
+    path := make([]vec, 0, len(points))
+    for _, point := range points {
+        path = append(path, centerVec(point))
+    }
+    mask := newCoverageMask(canvas.Bounds(), vecBounds(1, path...))
+    mask.fillSpans(antiAlias, func(y float64) []span {
+        return polygonSpans(path, y, rule)
+    })
+    canvas.compositePaint(mask, paint)
+}
+
+// FillEllipse fills the ellipse whose edge passes through the outer
+// edges of the pixels radiusX and radiusY away from the center.
+func (canvas *RasterCanvas) FillEllipse(center image.Point,
+    radiusX, radiusY int, paint Paint, antiAlias bool) {
+    middle := centerVec(center)
+    rx, ry := float64(radiusX)+0.5, float64(radiusY)+0.5
+    mask := newCoverageMask(canvas.Bounds(), vecBounds(1,
+        middle.sub(vec{rx, ry}), middle.add(vec{rx, ry})))
+    mask.fillSpans(antiAlias, func(y float64) []span {
+        dy := (y - middle.y) / ry
+        if dy <= -1 || dy >= 1 {
+            return nil
+        }
+        dx := rx * math.Sqrt(1-dy*dy)
+        return []span{{middle.x - dx, middle.x + dx}}
+    })
+    canvas.compositePaint(mask, paint)
+}
+
+// stroke renders the path (which if closed ends where it starts) and
+// composites it using the fill color.
+func (canvas *RasterCanvas) stroke(path []vec, closed bool,
+    fill color.Color, style Style) {
+    style = saneStyle(style)
+    halfWidth := style.width() / 2
+    bounds := image.Rectangle{}
+    for _, point := range path {
+        bounds = bounds.Union(image.Rect(int(point.x), int(point.y),
+            int(point.x)+1, int(point.y)+1))
+    }
+    mask := newCoverageMask(canvas.Bounds(), bounds.Inset(
+        -int(math.Ceil(halfWidth*miterLimit))-1))
+    if mask.Rect.Empty() {
+        return
+    }
+    for _, piece := range dash(path, style.Dashes) {
+        mask.stroke(piece, closed && style.Dashes == nil, style)
+    }
+    canvas.composite(mask, fill)
+}
+
+func (canvas *RasterCanvas) composite(mask *coverageMask,
+    fill color.Color) {
+    draw.DrawMask(canvas.Image, mask.Rect, &image.Uniform{fill}, image.ZP,
+        mask, mask.Rect.Min, draw.Over)
+}
+
+func (canvas *RasterCanvas) compositePaint(mask *coverageMask,
+    paint Paint) {
+    draw.DrawMask(canvas.Image, mask.Rect, sourceImage(paint, mask.Rect),
+        mask.Rect.Min, mask, mask.Rect.Min, draw.Over)
+}
+
+func centerVec(point image.Point) vec {
+    return vec{float64(point.X) + 0.5, float64(point.Y) + 0.5}
+}
+
+// circlePath returns a closed path of short sides approximating a circle
+// for those strokes (e.g., dashed ones) that need the circumference as a
+// path.
+func circlePath(center vec, radius float64) []vec {
+    return ovalPath(center, radius, radius)
+}
+
+// Curves are flattened into sides about two pixels long but never into
+// more than this many, however large a transform makes them.
+const maxCurveSides = 4096
+
+// curveSides returns how many sides a curve of the given length needs,
+// at least minimum and at most maxSides.
+func curveSides(length float64, minimum, maxSides int) int {
+    return int(math.Min(float64(maxSides), math.Max(float64(minimum),
+        math.Ceil(length/2))))
+}
+
+// ovalPath returns a closed path of short sides approximating an
+// ellipse.
+func ovalPath(center vec, radiusX, radiusY float64) []vec {
+    radius := math.Max(radiusX, radiusY)
+    sides := curveSides(2*math.Pi*radius, 16, maxCurveSides)
+    path := make([]vec, sides+1)
+    for i := 0; i < sides; i++ {
+        θ := float64(i) * 2 * math.Pi / float64(sides)
+        path[i] = vec{center.x + radiusX*math.Cos(θ),
+            center.y + radiusY*math.Sin(θ)}
+    }
+    path[sides] = path[0]
+    return path
+}
+
+// A coverageMask holds how much of each pixel (0 to 0xFF) an outline
+// covers; where parts of the outline overlap the greater coverage wins.
+type coverageMask struct {
+    *image.Alpha
+}
+
+func newCoverageMask(canvasBounds, bounds image.Rectangle) *coverageMask {
+    return &coverageMask{image.NewAlpha(bounds.Intersect(canvasBounds))}
+}
+
+func (mask *coverageMask) cover(x, y int, coverage float64) {
+    if coverage <= 0 || !(image.Point{x, y}.In(mask.Rect)) {
+        return
+    }
+    alpha := uint8(math.Min(coverage, 1)*0xFF + 0.5)
+    if alpha > mask.AlphaAt(x, y).A {
+        mask.SetAlpha(x, y, color.Alpha{alpha})
+    }
+}
+
+// coverArea calls coverage for the middle of every pixel in the bounds
+// (clipped to the mask) and covers the pixel by the amount returned.
+func (mask *coverageMask) coverArea(bounds image.Rectangle,
+    coverage func(point vec) float64) {
+    bounds = bounds.Intersect(mask.Rect)
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            mask.cover(x, y, coverage(vec{float64(x) + 0.5,
+                float64(y) + 0.5}))
+        }
+    }
+}
+
+func (mask *coverageMask) stroke(path []vec, closed bool, style Style) {
+    for i := 0; i+1 < len(path); i++ {
+        switch {
+        case style.isThin() && !style.AntiAlias:
+            mask.line(pixelOf(path[i]), pixelOf(path[i+1]))
+        case style.isThin():
+            mask.wuLine(path[i], path[i+1])
+        default:
+            mask.segment(path[i], path[i+1], style.width()/2,
+                style.AntiAlias)
+        }
+    }
+    if style.isThin() {
+        return // One-pixel lines need no joins
+    }
+    last := len(path) - 1
+    for i := 1; i < last; i++ {
+        mask.join(path[i-1], path[i], path[i+1], style)
+    }
+    if closed && last > 1 {
+        mask.join(path[last-1], path[0], path[1], style)
+    }
+}
+
+func pixelOf(point vec) image.Point {
+    return image.Pt(int(math.Floor(point.x)), int(math.Floor(point.y)))
+}
+
+// coverageOf turns a signed distance inside an edge (negative is
+// outside) into coverage: antialiased coverage fades over one pixel
+// across the edge while aliased coverage is all or nothing.
+func coverageOf(inside float64, antiAlias bool) float64 {
+    coverage := math.Max(0, math.Min(1, inside+0.5))
+    if !antiAlias {
+        coverage = math.Floor(coverage + 0.5)
+    }
+    return coverage
+}
+
+// segment covers a side of a thick outline; its ends are cut off square
+// (butt ends), with joins filling the gaps at corners.
+func (mask *coverageMask) segment(start, end vec, halfWidth float64,
+    antiAlias bool) {
+    length := start.distance(end)
+    if length == 0 {
+        return
+    }
+    along := end.sub(start).scale(1 / length)
+    across := along.normal()
+    mask.coverArea(vecBounds(halfWidth+1, start, end),
+        func(point vec) float64 {
+            offset := point.sub(start)
+            distance := offset.dot(along)
+            return coverageOf(halfWidth-math.Abs(offset.dot(across)),
+                antiAlias) * coverageOf(math.Min(distance,
+                length-distance), antiAlias)
+        })
+}
+
+// ring covers a thick circle's outline.
+func (mask *coverageMask) ring(center vec, radius, halfWidth float64,
+    antiAlias bool) {
+    mask.coverArea(mask.Rect, func(point vec) float64 {
+        return coverageOf(halfWidth-math.Abs(point.distance(center)-
+            radius), antiAlias)
+    })
+}
+
+// join covers the corner at vertex between sides from previous and to
+// next.
+func (mask *coverageMask) join(previous, vertex, next vec, style Style) {
+    halfWidth := style.width() / 2
+    if style.Join == RoundJoin {
+        mask.coverArea(vecBounds(halfWidth+1, vertex),
+            func(point vec) float64 {
+                return coverageOf(halfWidth-point.distance(vertex),
+                    style.AntiAlias)
+            })
+        return
+    }
+    if corner := joinCorner(previous, vertex, next, style); corner != nil {
+        mask.convexPolygon(corner, style.AntiAlias)
+    }
+}
+
+// joinCorner returns the polygon that fills the gap on the outside of a
+// beveled or mitered corner, or nil if the sides go straight on.
+func joinCorner(previous, vertex, next vec, style Style) []vec {
+    halfWidth := style.width() / 2
+    in, out := vertex.sub(previous).unit(), next.sub(vertex).unit()
+    turn := in.cross(out)
+    if math.Abs(turn) < 1e-9 {
+        return nil // Straight on so there's no gap to fill
+    }
+    outside := -math.Copysign(halfWidth, turn)
+    inNormal, outNormal := in.normal().scale(outside),
+        out.normal().scale(outside)
+    corner := []vec{vertex, vertex.add(inNormal)}
+    bisector := inNormal.add(outNormal)
+    // The miter's length relative to the stroke width is 1 / cos(θ/2)
+    // where θ is the angle between the sides' normals.
+    if cosHalf := bisector.length() / (2 * halfWidth); style.Join ==
+        MiterJoin && cosHalf > 1.0/miterLimit {
+        corner = append(corner, vertex.add(bisector.unit().scale(
+            halfWidth/cosHalf)))
+    }
+    return append(corner, vertex.add(outNormal))
+}
+
+// convexPolygon covers the inside of a convex polygon; antialiased
+// coverage is the fraction of a 4 × 4 grid of samples inside it.
+func (mask *coverageMask) convexPolygon(points []vec, antiAlias bool) {
+    mask.coverArea(vecBounds(1, points...), func(point vec) float64 {
+        if !antiAlias {
+            if insideConvex(points, point) {
+                return 1
+            }
+            return 0
+        }
+        count := 0
+        for i := 0; i < 4; i++ {
+            for j := 0; j < 4; j++ {
+                if insideConvex(points, point.add(vec{
+                    (float64(i) - 1.5) / 4, (float64(j) - 1.5) / 4})) {
+                    count++
+                }
+            }
+        }
+        return float64(count) / 16
+    })
+}
+
+// insideConvex returns true if the point is inside the convex polygon or
+// on its edge.
+func insideConvex(points []vec, point vec) bool {
+    sign := 0.0
+    for i, a := range points {
+        b := points[(i+1)%len(points)]
+        cross := b.sub(a).cross(point.sub(a))
+        if cross*sign < 0 {
+            return false
+        }
+        if cross != 0 {
+            sign = cross
+        }
+    }
+    return true
+}
+
+// vecBounds returns the pixels within margin of the points.
+func vecBounds(margin float64, points ...vec) image.Rectangle {
+    minimum, maximum := points[0], points[0]
+    for _, point := range points[1:] {
+        minimum = vec{math.Min(minimum.x, point.x),
+            math.Min(minimum.y, point.y)}
+        maximum = vec{math.Max(maximum.x, point.x),
+            math.Max(maximum.y, point.y)}
+    }
+    return image.Rect(int(math.Floor(minimum.x-margin)),
+        int(math.Floor(minimum.y-margin)),
+        int(math.Ceil(maximum.x+margin)), int(math.Ceil(maximum.y+margin)))
+}
+
+// A span is the part of a scanline from left to right that is inside a
+// filled shape.
+type span struct{ left, right float64 }
+
+// Antialiased fills sample each row of pixels with this many scanlines;
+// horizontally the spans' exact coverage of each pixel is used.
+const scanlinesPerPixel = 4
+
+// fillSpans covers the pixels inside the spans that spansAt returns for
+// each scanline; aliased fills use one scanline through each row's
+// middle and cover the pixels whose middles are inside the spans.
+func (mask *coverageMask) fillSpans(antiAlias bool,
+    spansAt func(y float64) []span) {
+    bounds := mask.Rect
+    row := make([]float64, bounds.Dx())
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        if !antiAlias {
+            for _, span := range spansAt(float64(y) + 0.5) {
+                left := math.Max(span.left, float64(bounds.Min.X))
+                right := math.Min(span.right, float64(bounds.Max.X))
+                first := int(math.Ceil(left - 0.5))
+                last := int(math.Ceil(right-0.5)) - 1
+                for x := first; x <= last; x++ {
+                    mask.cover(x, y, 1)
+                }
+            }
+            continue
+        }
+        for i := range row {
+            row[i] = 0
+        }
+        for i := 0; i < scanlinesPerPixel; i++ {
+            for _, span := range spansAt(float64(y) + (float64(i)+0.5)/
+                scanlinesPerPixel) {
+                left := math.Max(span.left, float64(bounds.Min.X))
+                right := math.Min(span.right, float64(bounds.Max.X))
+                for x := int(math.Floor(left)); float64(x) < right; x++ {
+                    overlap := math.Min(right, float64(x+1)) -
+                        math.Max(left, float64(x))
+                    row[x-bounds.Min.X] += overlap / scanlinesPerPixel
+                }
+            }
+        }
+        for i, coverage := range row {
+            mask.cover(bounds.Min.X+i, y, coverage)
+        }
+    }
+}
+
+// polygonSpans returns the spans of the closed polygon at scanline y.
+// Each edge counts from its top end up to (but not including) its bottom
+// end so that a vertex joining two edges is only crossed once.
+func polygonSpans(path []vec, y float64, rule FillRule) []span {
+    type crossing struct {
+        x       float64
+        winding int
+    }
+    var crossings []crossing
+    for i, a := range path {
+        b := path[(i+1)%len(path)]
+        winding := 1
+        if a.y > b.y {
+            a, b, winding = b, a, -1
+        }
+        if y < a.y || y >= b.y {
+            continue
+        }
+        crossings = append(crossings, crossing{a.x + (y-a.y)*(b.x-a.x)/
+            (b.y-a.y), winding})
+    }
+    sort.Slice(crossings, func(i, j int) bool {
+        return crossings[i].x < crossings[j].x
+    })
+    var spans []span
+    winding := 0
+    for i, crossing := range crossings {
+        before := winding
+        if rule == EvenOdd {
+            winding ^= 1
+        } else {
+            winding += crossing.winding
+        }
+        if before == 0 && winding != 0 {
+            spans = append(spans, span{left: crossing.x})
+        } else if before != 0 && winding == 0 {
+            spans[len(spans)-1].right = crossings[i].x
+        }
+    }
+    return spans
+}
+
+// wuLine covers a one-pixel-wide antialiased line using Xiaolin Wu's
+// algorithm; each pixel along the line's major axis is shared between
+// the two pixels nearest the line in proportion to their closeness.
+func (mask *coverageMask) wuLine(start, end vec) {
+    // Work in coordinates where pixel middles are whole numbers
+    x0, y0, x1, y1 := start.x-0.5, start.y-0.5, end.x-0.5, end.y-0.5
+    steep := math.Abs(y1-y0) > math.Abs(x1-x0)
+    if steep {
+        x0, y0, x1, y1 = y0, x0, y1, x1
+    }
+    if x0 > x1 {
+        x0, y0, x1, y1 = x1, y1, x0, y0
+    }
+    plot := func(x, y int, coverage float64) {
+        if steep {
+            x, y = y, x
+        }
+        mask.cover(x, y, coverage)
+    }
+    gradient := 1.0
+    if Δx := x1 - x0; Δx > 0 {
+        gradient = (y1 - y0) / Δx
+    }
+    first, last := int(math.Floor(x0+0.5)), int(math.Floor(x1+0.5))
+    for x := first; x <= last; x++ {
+        y := y0 + gradient*(float64(x)-x0)
+        whole := math.Floor(y)
+        fraction := y - whole
+        plot(x, int(whole), 1-fraction)
+        plot(x, int(whole)+1, fraction)
+    }
+}
+
+func (mask *coverageMask) midpointCircle(center image.Point, radius int) {
+    // Algorithm taken from
+    // http://en.wikipedia.org/wiki/Midpoint_circle_algorithm
+    x0, y0 := center.X, center.Y
+    f := 1 - radius
+    ddF_x, ddF_y := 1, -2*radius
+    x, y := 0, radius
+
+    mask.cover(x0, y0+radius, 1)
+    mask.cover(x0, y0-radius, 1)
+    mask.cover(x0+radius, y0, 1)
+    mask.cover(x0-radius, y0, 1)
+
+    for x < y {
+        if f >= 0 {
+            y--
+            ddF_y += 2
+            f += ddF_y
+        }
+        x++
+        ddF_x += 2
+        f += ddF_x
+        mask.cover(x0+x, y0+y, 1)
+        mask.cover(x0-x, y0+y, 1)
+        mask.cover(x0+x, y0-y, 1)
+        mask.cover(x0-x, y0-y, 1)
+        mask.cover(x0+y, y0+x, 1)
+        mask.cover(x0-y, y0+x, 1)
+        mask.cover(x0+y, y0-x, 1)
+        mask.cover(x0-y, y0-x, 1)
+    }
+}
+
+// Based on my Perl Image::Base.pm module's line() method 
+func (mask *coverageMask) line(start, end image.Point) {
+    x0, x1 := start.X, end.X
+    y0, y1 := start.Y, end.Y
+    Δx := math.Abs(float64(x1 - x0))
+    Δy := math.Abs(float64(y1 - y0))
+    if Δx >= Δy { // shallow slope
+        if x0 > x1 {
+            x0, y0, x1, y1 = x1, y1, x0, y0
+        }
+        y := y0
+        yStep := 1
+        if y0 > y1 {
+            yStep = -1
+        }
+        remainder := float64(int(Δx/2)) - Δx
+        for x := x0; x <= x1; x++ {
+            mask.cover(x, y, 1)
+            remainder += Δy
+            if remainder >= 0.0 {
+                remainder -= Δx
+                y += yStep
+            }
+        }
+    } else { // steep slope
+        if y0 > y1 {
+            x0, y0, x1, y1 = x1, y1, x0, y0
+        }
+        x := x0
+        xStep := 1
+        if x0 > x1 {
+            xStep = -1
+        }
+        remainder := float64(int(Δy/2)) - Δy
+        for y := y0; y <= y1; y++ {
+            mask.cover(x, y, 1)
+            remainder += Δx
+            if remainder >= 0.0 {
+                remainder -= Δy
+                x += xStep
+            }
+        }
+    }
+}
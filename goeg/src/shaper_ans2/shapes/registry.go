@@ -0,0 +1,232 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+    "sync"
+)
+
+// A Factory makes a new shape from the fields of the option that it
+// uses; New() sets the common fields (Filled, Style, Paint, and
+// FillRule) afterwards so a factory need only pass on the Fill.
+type Factory func(option Option) (Shaper, error)
+
+// An OptionSpec describes one of the Option fields that a shape uses,
+// e.g., OptionSpec{Field: "Radius", Required: true, Min: 1, Max: 1024}.
+// If Max is positive a whole number must be in the Min-Max range, and
+// a Rect's width and height must be.
+type OptionSpec struct {
+    Field    string
+    Required bool
+    Min, Max int
+}
+
+type registration struct {
+    factory Factory
+    options []OptionSpec
+}
+
+var (
+    registryLock sync.RWMutex
+    registry     = make(map[string]registration)
+)
+
+// commonOptions are the Option fields that every shape has and that are
+// not listed in the shapes' OptionSpecs.
+var commonOptions = map[string]bool{"Fill": true, "Filled": true,
+    "Style": true, "Paint": true, "FillRule": true}
+
+// Register makes the shape available to New() and in scene files under
+// the given name. The options describe the Option fields the factory
+// uses besides the common ones. Register panics if the name is empty or
+// already registered, if the factory is nil, or if an OptionSpec's
+// Field isn't one of Option's.
+func Register(name string, factory func(Option) (Shaper, error),
+    options ...OptionSpec) {
+    if name == "" {
+        panic("shapes.Register(): empty shape name")
+    }
+    if factory == nil {
+        panic(fmt.Sprintf("shapes.Register(): nil factory for %q", name))
+    }
+    optionType := reflect.TypeOf(Option{})
+    for _, spec := range options {
+        if _, found := optionType.FieldByName(spec.Field); !found ||
+            commonOptions[spec.Field] {
+            panic(fmt.Sprintf("shapes.Register(): %q has an invalid "+
+                "option %q", name, spec.Field))
+        }
+    }
+    registryLock.Lock()
+    defer registryLock.Unlock()
+    if _, found := registry[name]; found {
+        panic(fmt.Sprintf("shapes.Register(): %q is already registered",
+            name))
+    }
+    registry[name] = registration{factory,
+        append([]OptionSpec(nil), options...)}
+}
+
+// Names returns the registered shapes' names in alphabetical order.
+func Names() []string {
+    registryLock.RLock()
+    defer registryLock.RUnlock()
+    names := make([]string, 0, len(registry))
+    for name := range registry {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Options returns the OptionSpecs of the named shape and true, or nil
+// and false if there's no such shape.
+func Options(name string) ([]OptionSpec, bool) {
+    registered, found := lookup(name)
+    if !found {
+        return nil, false
+    }
+    return append([]OptionSpec(nil), registered.options...), true
+}
+
+func lookup(name string) (registration, bool) {
+    registryLock.RLock()
+    defer registryLock.RUnlock()
+    registered, found := registry[name]
+    return registered, found
+}
+
+// New returns a new shape of the named kind, e.g., "circle" or
+// "hexagon", made from the option; the fields it doesn't use are
+// ignored. See Names() for the kinds and Options() for their fields.
+func New(shape string, option Option) (Shaper, error) {
+    registered, found := lookup(shape)
+    if !found {
+        return nil, fmt.Errorf("shapes.New(): invalid shape '%s'", shape)
+    }
+    shaper, err := registered.factory(option)
+    if err != nil {
+        return nil, fmt.Errorf("shapes.New(): %v", err)
+    }
+    setCommonOptions(shaper, option)
+    return shaper, nil
+}
+
+// setCommonOptions sets those of the common fields that the shape
+// supports.
+func setCommonOptions(shaper Shaper, option Option) {
+    shaper.SetStyle(option.Style)
+    if shaper, ok := shaper.(Filleder); ok {
+        shaper.SetFilled(option.Filled)
+    }
+    if shaper, ok := shaper.(Painter); ok {
+        shaper.SetPaint(option.Paint)
+    }
+    if shaper, ok := shaper.(FillRuler); ok {
+        shaper.SetFillRule(option.FillRule)
+    }
+}
+
+var (
+    radiusSpec = OptionSpec{Field: "Radius", Required: true, Min: 1,
+        Max: maxRadius}
+    rectSpec = OptionSpec{Field: "Rect", Required: true, Min: 1,
+        Max: maxLength}
+    anglesSpecs = []OptionSpec{radiusSpec, {Field: "StartAngle"},
+        {Field: "EndAngle"}}
+    verticesSpec = OptionSpec{Field: "Vertices", Required: true}
+)
+
+var sidesForShape = map[string]int{"triangle": 3, "square": 4,
+    "pentagon": 5, "hexagon": 6, "heptagon": 7, "octagon": 8,
+    "enneagon": 9, "nonagon": 9, "decagon": 10}
+
+func init() {
+    for name, sides := range sidesForShape { // Sides can only be sides
+        Register(name, regularPolygonFactory(name, sides), radiusSpec,
+            OptionSpec{Field: "Sides", Min: sides, Max: sides})
+    }
+    Register("polygon", func(option Option) (Shaper, error) {
+        return NewRegularPolygon(option.Fill, option.Radius,
+            option.Sides), nil
+    }, radiusSpec, OptionSpec{Field: "Sides", Required: true,
+        Min: minSides, Max: maxSides})
+    Register("circle", func(option Option) (Shaper, error) {
+        return NewCircle(option.Fill, option.Radius), nil
+    }, radiusSpec)
+    Register("rectangle", func(option Option) (Shaper, error) {
+        return NewRectangle(option.Fill, option.Rect), nil
+    }, rectSpec)
+    Register("ellipse", func(option Option) (Shaper, error) {
+        radiusY := option.RadiusY
+        if radiusY == 0 {
+            radiusY = option.Radius
+        }
+        return NewEllipse(option.Fill, option.Radius, radiusY), nil
+    }, radiusSpec, OptionSpec{Field: "RadiusY", Max: maxRadius})
+    Register("arc", func(option Option) (Shaper, error) {
+        return NewArc(option.Fill, option.Radius, option.StartAngle,
+            option.EndAngle), nil
+    }, anglesSpecs...)
+    Register("pie", func(option Option) (Shaper, error) {
+        return NewPie(option.Fill, option.Radius, option.StartAngle,
+            option.EndAngle), nil
+    }, anglesSpecs...)
+    Register("star", func(option Option) (Shaper, error) {
+        points, innerRadius := option.Points, option.InnerRadius
+        if points == 0 {
+            points = 5
+        }
+        if innerRadius == 0 && option.Radius > 1 {
+            innerRadius = option.Radius / 2
+        }
+        return NewStar(option.Fill, option.Radius, innerRadius, points),
+            nil
+    }, radiusSpec, OptionSpec{Field: "InnerRadius", Max: maxRadius},
+        OptionSpec{Field: "Points", Min: minSides, Max: maxSides})
+    Register("roundedrectangle", func(option Option) (Shaper, error) {
+        return NewRoundedRectangle(option.Fill, option.Rect,
+            option.CornerRadius), nil
+    }, rectSpec, OptionSpec{Field: "CornerRadius", Max: maxRadius})
+    Register("polyline", func(option Option) (Shaper, error) {
+        return NewPolyline(option.Fill, option.Vertices), nil
+    }, verticesSpec)
+    Register("quadraticbezier", func(option Option) (Shaper, error) {
+        return NewQuadraticBezier(option.Fill, option.Vertices), nil
+    }, verticesSpec)
+    Register("cubicbezier", func(option Option) (Shaper, error) {
+        return NewCubicBezier(option.Fill, option.Vertices), nil
+    }, verticesSpec)
+    Register("text", func(option Option) (Shaper, error) {
+        label := NewText(option.Fill, option.Text, option.Font)
+        label.SetAlignment(option.Align)
+        return label, nil
+    }, OptionSpec{Field: "Text", Required: true}, OptionSpec{Field: "Font"},
+        OptionSpec{Field: "Align"})
+}
+
+// regularPolygonFactory returns the factory for a named regular polygon
+// which, if the option has Sides, must have the right number of them.
+func regularPolygonFactory(name string, sides int) Factory {
+    return func(option Option) (Shaper, error) {
+        if option.Sides != 0 && option.Sides != sides {
+            return nil, fmt.Errorf("a %s has %d sides not %d", name, sides,
+                option.Sides)
+        }
+        return NewRegularPolygon(option.Fill, option.Radius, sides), nil
+    }
+}
@@ -0,0 +1,132 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "errors"
+    "image"
+    "reflect"
+    "shaper_ans2/shapes"
+    "sort"
+    "strings"
+    "testing"
+)
+
+// A dot is a circle whose radius defaults to 2 and which refuses to have
+// a radius over 100.
+func newDot(option shapes.Option) (shapes.Shaper, error) {
+    radius := option.Radius
+    if radius == 0 {
+        radius = 2
+    } else if radius > 100 {
+        return nil, errors.New("dots are small")
+    }
+    return shapes.NewCircle(option.Fill, radius), nil
+}
+
+func init() {
+    shapes.Register("dot", newDot, shapes.OptionSpec{Field: "Radius",
+        Min: 1, Max: 1000})
+}
+
+func TestRegistryNames(t *testing.T) {
+    names := shapes.Names()
+    if !sort.StringsAreSorted(names) {
+        t.Errorf("expected sorted names got %v", names)
+    }
+    for _, name := range []string{"circle", "dot", "hexagon", "polygon",
+        "rectangle", "text"} {
+        if i := sort.SearchStrings(names, name); i == len(names) ||
+            names[i] != name {
+            t.Errorf("expected %q in %v", name, names)
+        }
+    }
+    if options, found := shapes.Options("polygon"); !found ||
+        !reflect.DeepEqual(options, []shapes.OptionSpec{{"Radius", true,
+            1, 1024}, {"Sides", true, 3, 60}}) {
+        t.Errorf("unexpected polygon options %v", options)
+    }
+    if options, found := shapes.Options("hexagon"); !found ||
+        !reflect.DeepEqual(options, []shapes.OptionSpec{{"Radius", true,
+            1, 1024}, {"Sides", false, 6, 6}}) {
+        t.Errorf("unexpected hexagon options %v", options)
+    }
+    if _, found := shapes.Options("hexgon"); found {
+        t.Error("expected no options for an unregistered shape")
+    }
+}
+
+func TestRegisteredShape(t *testing.T) {
+    dot, err := shapes.New("dot", shapes.Option{Fill: red,
+        Filled: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    canvas := shapes.FilledImage(10, 10, white)
+    if err := dot.Draw(canvas, 5, 5); err != nil {
+        t.Fatal(err)
+    }
+    checkPixels(t, "dot", canvas, []image.Point{{5, 5}, {7, 5}},
+        []image.Point{{8, 5}})
+    if _, err := shapes.New("dot", shapes.Option{Radius: 500}); err ==
+        nil || err.Error() != "shapes.New(): dots are small" {
+        t.Errorf("expected the factory's error got %v", err)
+    }
+    scene, err := shapes.ParseScene([]byte(`{"width": 10, "height": 10,
+        "shapes": [{"type": "dot", "x": 5, "y": 5, "filled": true}]}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if dot, ok := scene.Shapes[0].Shaper.(*shapes.Circle); !ok ||
+        dot.Radius() != 2 || !dot.Filled() {
+        t.Errorf("expected a filled dot got %v", scene.Shapes[0])
+    }
+    _, err = shapes.ParseScene([]byte(`{"width": 10, "height": 10,
+        "shapes": [{"type": "dot", "x": 5, "y": 5, "rect": [0, 0, 1, 1]},
+        {"type": "dot", "x": 5, "y": 5, "radius": 2000},
+        {"type": "dot", "x": 5, "y": 5, "radius": 200}]}`))
+    expected := "shapes[0] (dot): a dot has no rect\n" +
+        "shapes[1] (dot): radius 2000 is outside 1-1000\n" +
+        "shapes[2] (dot): dots are small"
+    if err == nil || err.Error() != expected {
+        t.Errorf("expected\n%s\ngot\n%v", expected, err)
+    }
+}
+
+func TestRegisterPanics(t *testing.T) {
+    for _, test := range []struct {
+        name    string
+        factory func(shapes.Option) (shapes.Shaper, error)
+        option  string
+        panic   string
+    }{
+        {"", newDot, "Radius", "empty shape name"},
+        {"spot", nil, "Radius", `nil factory for "spot"`},
+        {"spot", newDot, "Colour", `"spot" has an invalid option "Colour"`},
+        {"spot", newDot, "Filled", `"spot" has an invalid option "Filled"`},
+        {"circle", newDot, "Radius", `"circle" is already registered`},
+    } {
+        func() {
+            defer func() {
+                message, _ := recover().(string)
+                if !strings.HasSuffix(message, test.panic) {
+                    t.Errorf("expected a panic with %q got %q",
+                        test.panic, message)
+                }
+            }()
+            shapes.Register(test.name, test.factory,
+                shapes.OptionSpec{Field: test.option})
+        }()
+    }
+}
@@ -0,0 +1,485 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "font"
+    "image"
+    "image/color"
+    "io/ioutil"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// A Scene is a canvas size and background and the shapes to draw on it,
+// usually read from a JSON scene file such as:
+//
+//  {"width": 240, "height": 100, "background": "white",
+//   "shapes": [
+//    {"type": "rectangle", "x": 10, "y": 10, "rect": [0, 0, 60, 40],
+//     "fill": "#C8C8C8", "filled": true},
+//    {"type": "circle", "x": 110, "y": 50, "radius": 30, "fill": "red",
+//     "z": -1},
+//    {"type": "polygon", "x": 190, "y": 50, "radius": 30, "sides": 7}]}
+//
+// The type is any of the registered Names(), e.g., circle, rectangle,
+// polygon (which needs the sides), a regular polygon such as triangle or
+// hexagon, or text (which needs the text and may have a font, e.g.,
+// {"size": 16}, and an align of left, center, or right). A shape's
+// other fields are the Options() it uses with lower case initials, e.g.,
+// "cornerRadius", with a rect given as [x0, y0, x1, y1] and vertices as
+// [[x0, y0], [x1, y1], ...]. The x and y are where the shape is drawn
+// and must be on the canvas. Colors are "#RGB", "#RRGGBB", "#RRGGBBAA",
+// or one of the colorNames; the fill defaults to black and the
+// background to white. Shapes are drawn in order of their z (default 0)
+// and for equal z in the order given.
+type Scene struct {
+    Width, Height int
+    Background    color.Color
+    Shapes        []SceneShape // In drawing order
+    index         *quadtree    // Built on demand by ShapesAt() etc.
+}
+
+type SceneShape struct {
+    Shaper
+    X, Y, Z int
+}
+
+// A SceneError lists every problem found in a scene, each one saying
+// which entry it is in, e.g., "shapes[2] (hexagon): missing radius".
+type SceneError struct {
+    Filename string
+    Problems []string
+}
+
+func (err *SceneError) Error() string {
+    prefix := ""
+    if err.Filename != "" {
+        prefix = err.Filename + ": "
+    }
+    return prefix + strings.Join(err.Problems, "\n"+prefix)
+}
+
+var colorNames = map[string]color.Color{"black": color.Black,
+    "white": color.White, "transparent": color.Transparent,
+    "red": color.RGBA{0xFF, 0, 0, 0xFF}, "green": color.RGBA{0, 0x80, 0,
+        0xFF}, "blue": color.RGBA{0, 0, 0xFF, 0xFF},
+    "yellow": color.RGBA{0xFF, 0xFF, 0, 0xFF},
+    "cyan": color.RGBA{0, 0xFF, 0xFF, 0xFF},
+    "magenta": color.RGBA{0xFF, 0, 0xFF, 0xFF},
+    "gray": color.RGBA{0x80, 0x80, 0x80, 0xFF}}
+
+type sceneFile struct {
+    Width      *int              `json:"width"`
+    Height     *int              `json:"height"`
+    Background string            `json:"background"`
+    Shapes     []json.RawMessage `json:"shapes"`
+}
+
+// A sceneEntry's pointer fields are nil if they were not given; those
+// with an option tag are the Option fields of the same name.
+type sceneEntry struct {
+    Type         string     `json:"type"`
+    X            *int       `json:"x"`
+    Y            *int       `json:"y"`
+    Z            int        `json:"z"`
+    Fill         string     `json:"fill"`
+    Filled       bool       `json:"filled"`
+    Radius       *int       `json:"radius" option:"Radius"`
+    RadiusY      *int       `json:"radiusY" option:"RadiusY"`
+    InnerRadius  *int       `json:"innerRadius" option:"InnerRadius"`
+    CornerRadius *int       `json:"cornerRadius" option:"CornerRadius"`
+    Points       *int       `json:"points" option:"Points"`
+    Sides        *int       `json:"sides" option:"Sides"`
+    StartAngle   *float64   `json:"startAngle" option:"StartAngle"`
+    EndAngle     *float64   `json:"endAngle" option:"EndAngle"`
+    Rect         *[4]int    `json:"rect" option:"Rect"`
+    Vertices     *[][2]int  `json:"vertices" option:"Vertices"`
+    Text         *string    `json:"text" option:"Text"`
+    Font         *sceneFont `json:"font" option:"Font"`
+    Align        *string    `json:"align" option:"Align"`
+}
+
+type sceneFont struct {
+    Family string `json:"family"`
+    Size   int    `json:"size"`
+}
+
+var alignments = map[string]Alignment{"": AlignLeft, "left": AlignLeft,
+    "center": AlignCenter, "right": AlignRight}
+
+// ReadScene reads and validates the scene in the named JSON file; if
+// there are problems the error is a *SceneError.
+func ReadScene(filename string) (*Scene, error) {
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    scene, err := ParseScene(data)
+    if err, ok := err.(*SceneError); ok {
+        err.Filename = filename
+    }
+    return scene, err
+}
+
+// ParseScene parses and validates a JSON scene; if there are problems
+// the error is a *SceneError.
+func ParseScene(data []byte) (*Scene, error) {
+    var file sceneFile
+    if err := strictUnmarshal(data, &file); err != nil {
+        return nil, &SceneError{Problems: []string{jsonProblem(data, 0,
+            err)}}
+    }
+    var problems []string
+    problem := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Sprintf(format, args...))
+    }
+    scene := &Scene{Width: checkSize(problem, "width", file.Width),
+        Height: checkSize(problem, "height", file.Height),
+        Background: color.White}
+    var bounds image.Rectangle // Only set if the size is valid
+    if problems == nil {
+        bounds = image.Rect(0, 0, scene.Width, scene.Height)
+    }
+    if file.Background != "" {
+        background, err := parseColor(file.Background)
+        if err != nil {
+            problem("background: %v", err)
+        }
+        scene.Background = background
+    }
+    if len(file.Shapes) == 0 {
+        problem("there are no shapes")
+    }
+    start := 0 // Where each entry is in the data
+    for i, raw := range file.Shapes {
+        start += bytes.Index(data[start:], raw)
+        var entry sceneEntry
+        if err := strictUnmarshal(raw, &entry); err != nil {
+            problem("shapes[%d]: %s", i, jsonProblem(data, start, err))
+            continue
+        }
+        name := fmt.Sprintf("shapes[%d]", i)
+        if entry.Type != "" {
+            name += " (" + entry.Type + ")"
+        }
+        shapeProblems := len(problems)
+        shape := newSceneShape(entry, bounds, func(format string,
+            args ...interface{}) {
+            problem(name+": "+format, args...)
+        })
+        if len(problems) == shapeProblems {
+            scene.Shapes = append(scene.Shapes, SceneShape{shape, *entry.X,
+                *entry.Y, entry.Z})
+        }
+    }
+    if problems != nil {
+        return nil, &SceneError{Problems: problems}
+    }
+    sort.SliceStable(scene.Shapes, func(i, j int) bool {
+        return scene.Shapes[i].Z < scene.Shapes[j].Z
+    })
+    return scene, nil
+}
+
+// newSceneShape reports every problem with the entry; the shape it
+// returns is only valid if there weren't any. The position is only
+// checked if the canvas bounds aren't empty.
+func newSceneShape(entry sceneEntry, bounds image.Rectangle,
+    problem func(string, ...interface{})) Shaper {
+    if entry.X == nil || entry.Y == nil {
+        problem("missing position (x and y)")
+    } else if !bounds.Empty() && !image.Pt(*entry.X,
+        *entry.Y).In(bounds) {
+        problem("position %d, %d is outside the %dx%d canvas", *entry.X,
+            *entry.Y, bounds.Dx(), bounds.Dy())
+    }
+    fill := color.Color(color.Black)
+    if entry.Fill != "" {
+        var err error
+        if fill, err = parseColor(entry.Fill); err != nil {
+            problem("fill: %v", err)
+        }
+    }
+    if entry.Type == "" {
+        problem("missing type")
+        return nil
+    }
+    registered, found := lookup(entry.Type)
+    if !found {
+        problem("unknown type %q", entry.Type)
+        return nil
+    }
+    option := Option{Fill: fill, Filled: entry.Filled}
+    if !setSceneOptions(&option, entry, registered.options, problem) {
+        return nil
+    }
+    shape, err := registered.factory(option)
+    if err != nil {
+        problem("%v", err)
+        return nil
+    }
+    setCommonOptions(shape, option)
+    return shape
+}
+
+// sceneFields are the sceneEntry fields for each Option field, and
+// sceneHints say what the required ones look like.
+var sceneFields = make(map[string]reflect.StructField)
+var sceneHints = map[string]string{"Rect": " ([x0, y0, x1, y1])",
+    "Vertices": " ([[x0, y0], [x1, y1], ...])"}
+
+func init() {
+    entryType := reflect.TypeOf(sceneEntry{})
+    for i := 0; i < entryType.NumField(); i++ {
+        field := entryType.Field(i)
+        if name := field.Tag.Get("option"); name != "" {
+            sceneFields[name] = field
+        }
+    }
+}
+
+// setSceneOptions sets the option's fields from those the entry gives
+// that the specs say the shape uses and returns true, or reports the
+// problems and returns false.
+func setSceneOptions(option *Option, entry sceneEntry, specs []OptionSpec,
+    problem func(string, ...interface{})) bool {
+    valid := true
+    report := func(format string, args ...interface{}) {
+        valid = false
+        problem(format, args...)
+    }
+    entryValue := reflect.ValueOf(entry)
+    var unused []string
+    for i := 0; i < entryValue.NumField(); i++ {
+        field := entryValue.Type().Field(i)
+        if name := field.Tag.Get("option"); name != "" &&
+            !entryValue.Field(i).IsNil() && !usesOption(specs, name) {
+            unused = append(unused, jsonName(field))
+        }
+    }
+    if unused != nil {
+        report("a %s has no %s", entry.Type, joinOr(unused))
+    }
+    for _, spec := range specs {
+        sceneField, found := sceneFields[spec.Field]
+        if !found { // A field that scene files can't give, e.g., Paint
+            continue
+        }
+        name := jsonName(sceneField)
+        value := entryValue.FieldByIndex(sceneField.Index)
+        if value.IsNil() {
+            if spec.Required {
+                report("missing %s%s", name, sceneHints[spec.Field])
+            }
+            continue
+        }
+        value = value.Elem()
+        switch spec.Field {
+        case "Rect":
+            corners := value.Interface().([4]int)
+            option.Rect = image.Rect(corners[0], corners[1], corners[2],
+                corners[3])
+            if width, height := option.Rect.Dx(), option.Rect.Dy();
+                spec.Max > 0 && (width < spec.Min || width > spec.Max ||
+                    height < spec.Min || height > spec.Max) {
+                report("rect %v must be %d-%d wide and high", corners,
+                    spec.Min, spec.Max)
+            }
+        case "Vertices":
+            for _, vertex := range value.Interface().([][2]int) {
+                option.Vertices = append(option.Vertices,
+                    image.Pt(vertex[0], vertex[1]))
+            }
+        case "Font":
+            textFont := value.Interface().(sceneFont)
+            if textFont.Family == "" {
+                textFont.Family = defaultFontFamily
+            }
+            if textFont.Size < minFontSize || textFont.Size > maxFontSize {
+                report("font size %d is outside %d-%d", textFont.Size,
+                    minFontSize, maxFontSize)
+            } else {
+                option.Font = font.New(textFont.Family, textFont.Size)
+            }
+        case "Align":
+            align, found := alignments[value.String()]
+            if !found {
+                report("align must be left, center, or right not %q",
+                    value.String())
+            }
+            option.Align = align
+        default: // Whole numbers, numbers, and strings are as they are
+            if value.Kind() == reflect.Int && spec.Max > 0 &&
+                (value.Int() < int64(spec.Min) ||
+                    value.Int() > int64(spec.Max)) {
+                if spec.Min == spec.Max {
+                    report("%s must be %d not %d", name, spec.Max,
+                        value.Int())
+                } else {
+                    report("%s %d is outside %d-%d", name, value.Int(),
+                        spec.Min, spec.Max)
+                }
+            }
+            reflect.ValueOf(option).Elem().FieldByName(spec.Field).Set(
+                value)
+        }
+    }
+    return valid
+}
+
+func usesOption(specs []OptionSpec, field string) bool {
+    for _, spec := range specs {
+        if spec.Field == field {
+            return true
+        }
+    }
+    return false
+}
+
+func jsonName(field reflect.StructField) string {
+    return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+// joinOr returns the words as a list, e.g., "radius, rect, or sides".
+func joinOr(words []string) string {
+    if len(words) < 3 {
+        return strings.Join(words, " or ")
+    }
+    return strings.Join(words[:len(words)-1], ", ") + ", or " +
+        words[len(words)-1]
+}
+
+// Draw draws the scene's shapes on the canvas, which should be the
+// scene's size, e.g., one made by NewCanvas(suffix, scene.Width,
+// scene.Height, scene.Background).
+func (scene *Scene) Draw(canvas Canvas) error {
+    for _, shape := range scene.Shapes {
+        if err := shape.Draw(canvas, shape.X, shape.Y); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func checkSize(problem func(string, ...interface{}), name string,
+    size *int) int {
+    if size == nil {
+        problem("missing %s", name)
+        return 1
+    }
+    if *size < 1 || *size > maxLength {
+        problem("%s %d is outside 1-%d", name, *size, maxLength)
+    }
+    return *size
+}
+
+// parseColor returns the named color or the color given in hexadecimal
+// as #RGB, #RRGGBB, or #RRGGBBAA.
+func parseColor(text string) (color.Color, error) {
+    if fill, found := colorNames[strings.ToLower(text)]; found {
+        return fill, nil
+    }
+    hex := strings.TrimPrefix(text, "#")
+    if len(hex) == 3 {
+        hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2],
+            hex[2]})
+    }
+    if len(hex) == 6 {
+        hex += "FF"
+    }
+    value, err := strconv.ParseUint(hex, 16, 32)
+    if !strings.HasPrefix(text, "#") || len(hex) != 8 || err != nil {
+        return nil, fmt.Errorf("invalid color %q", text)
+    }
+    return color.NRGBA{uint8(value >> 24), uint8(value >> 16),
+        uint8(value >> 8), uint8(value)}, nil
+}
+
+// jsonKind describes the kind of value a scene file's field holds, e.g.,
+// "a whole number" or "a list of 4 whole numbers".
+func jsonKind(kind reflect.Type) string {
+    switch kind.Kind() {
+    case reflect.Int:
+        return "a whole number"
+    case reflect.Float64:
+        return "a number"
+    case reflect.String:
+        return "a string"
+    case reflect.Bool:
+        return "true or false"
+    case reflect.Array:
+        return fmt.Sprintf("a list of %d %s", kind.Len(),
+            plural(jsonKind(kind.Elem())))
+    case reflect.Slice:
+        if kind.Elem() == reflect.TypeOf(json.RawMessage{}) {
+            return "a list of shapes"
+        }
+        return "a list of " + plural(jsonKind(kind.Elem()))
+    }
+    return "an object"
+}
+
+// plural turns a kind such as "a list of 2 whole numbers" into "lists
+// of 2 whole numbers".
+func plural(kind string) string {
+    kind = strings.TrimPrefix(strings.TrimPrefix(kind, "a "), "an ")
+    if i := strings.Index(kind, " of "); i > -1 {
+        return kind[:i] + "s" + kind[i:]
+    }
+    return kind + "s"
+}
+
+func strictUnmarshal(data []byte, value interface{}) error {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.DisallowUnknownFields()
+    return decoder.Decode(value)
+}
+
+// jsonProblem describes the error giving the line and column in the data
+// for syntax and type errors in the JSON that starts at the given index;
+// for type errors the column is at the end of the wrong value.
+func jsonProblem(data []byte, start int, err error) string {
+    var offset int64
+    message := strings.TrimPrefix(err.Error(), "json: ")
+    switch err := err.(type) {
+    case *json.SyntaxError:
+        offset = err.Offset
+    case *json.UnmarshalTypeError:
+        offset = err.Offset
+        article := "a"
+        if strings.ContainsAny(err.Value[:1], "aeiou") {
+            article = "an"
+        }
+        message = fmt.Sprintf("%s must be %s not %s %s", err.Field,
+            jsonKind(err.Type), article, err.Value)
+    default:
+        return message
+    }
+    // The offset is just after the bad character or value
+    if offset += int64(start) - 1; offset > int64(len(data)) {
+        offset = int64(len(data))
+    } else if offset < 0 {
+        offset = 0
+    }
+    before := data[:offset]
+    line := bytes.Count(before, []byte("\n")) + 1
+    column := len(before) - bytes.LastIndexByte(before, '\n')
+    return fmt.Sprintf("line %d, column %d: %s", line, column, message)
+}
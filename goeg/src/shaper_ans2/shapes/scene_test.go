@@ -0,0 +1,145 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "image/color"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "shaper_ans2/shapes"
+    "strings"
+    "testing"
+)
+
+const testScene = `{"width": 60, "height": 40, "background": "#FFF",
+ "shapes": [
+  {"type": "circle", "x": 20, "y": 20, "radius": 10, "fill": "blue",
+   "filled": true, "z": 1},
+  {"type": "rectangle", "x": 5, "y": 5, "rect": [0, 0, 50, 30],
+   "fill": "#FF0000", "filled": true},
+  {"type": "polygon", "x": 45, "y": 20, "radius": 8, "sides": 5,
+   "fill": "#00FF0080"}]}`
+
+func TestParseScene(t *testing.T) {
+    scene, err := shapes.ParseScene([]byte(testScene))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if scene.Width != 60 || scene.Height != 40 ||
+        scene.Background != color.Color(color.NRGBA{0xFF, 0xFF, 0xFF,
+            0xFF}) || len(scene.Shapes) != 3 {
+        t.Fatalf("unexpected scene %+v", scene)
+    }
+    if _, ok := scene.Shapes[2].Shaper.(*shapes.Circle); !ok ||
+        scene.Shapes[2].Z != 1 {
+        t.Error("expected the circle to be drawn last")
+    }
+    if polygon := scene.Shapes[1].Shaper.(*shapes.RegularPolygon);
+        polygon.Sides() != 5 || polygon.Fill() != color.Color(
+            color.NRGBA{0, 0xFF, 0, 0x80}) {
+        t.Errorf("unexpected polygon %v", polygon)
+    }
+    canvas := shapes.FilledImage(scene.Width, scene.Height,
+        scene.Background)
+    if err := scene.Draw(canvas); err != nil {
+        t.Fatal(err)
+    }
+    if canvas.At(20, 20) != color.Color(blue) ||
+        canvas.At(40, 30) != color.Color(red) {
+        t.Error("expected the circle to be drawn on the rectangle")
+    }
+}
+
+func TestSceneErrors(t *testing.T) {
+    for _, test := range []struct {
+        scene    string
+        problems []string
+    }{
+        {`{"width": 0, "height": 10, "background": "#12",
+          "shapes": [{"type": "circle", "x": 500, "y": 1, "radius": 1}]}`,
+            []string{"width 0 is outside 1-4096",
+                `background: invalid color "#12"`}},
+        {`{"width": 10, "height": 10, "shapes": [
+          {"type": "hexgon", "x": 1, "y": 1},
+          {"type": "circle", "x": 10, "y": 1},
+          {"type": "rectangle", "x": 1, "y": 1, "radius": 2},
+          {"type": "pentagon", "x": 1, "y": 1, "radius": 2, "sides": 6},
+          {"type": "polygon", "x": 1, "y": 1, "radius": 2, "sides": 99},
+          {"x": 1, "y": 1, "fill": "mauve"}]}`,
+            []string{`shapes[0] (hexgon): unknown type "hexgon"`,
+                "shapes[1] (circle): position 10, 1 is outside the " +
+                    "10x10 canvas",
+                "shapes[1] (circle): missing radius",
+                "shapes[2] (rectangle): a rectangle has no radius",
+                "shapes[2] (rectangle): missing rect ([x0, y0, x1, y1])",
+                "shapes[3] (pentagon): sides must be 5 not 6",
+                "shapes[4] (polygon): sides 99 is outside 3-60",
+                `shapes[5]: fill: invalid color "mauve"`,
+                "shapes[5]: missing type"}},
+        {`{"width": 10, "height": 10, "shapes": [
+          {"type": "circle", "x": 1, "y": 1, "radius": 2, "colour": 1},
+          {"type": "circle", "x": 1, "y": 1, "radius": "big"}]}`,
+            []string{`shapes[0]: unknown field "colour"`,
+                "shapes[1]: line 3, column 60: radius must be a whole " +
+                    "number not a string"}},
+        {`{"width": 10, "height": 10, "shapes": [
+          {"type": "text", "x": 1, "y": 1, "radius": 2, "sides": 3,
+           "font": {"size": 200}, "align": "middle"},
+          {"type": "circle", "x": 1, "y": 1, "radius": 2, "text": "A"}]}`,
+            []string{"shapes[0] (text): a text has no radius or sides",
+                "shapes[0] (text): missing text",
+                "shapes[0] (text): font size 200 is outside 5-144",
+                `shapes[0] (text): align must be left, center, or right ` +
+                    `not "middle"`,
+                "shapes[1] (circle): a circle has no text"}},
+        {"{\"width\": 10,\n \"height\": 10 \"shapes\": []}",
+            []string{"line 2, column 15: invalid character '\"' after " +
+                "object key:value pair"}},
+    } {
+        _, err := shapes.ParseScene([]byte(test.scene))
+        sceneErr, ok := err.(*shapes.SceneError)
+        if !ok {
+            t.Errorf("expected a *SceneError got %v", err)
+            continue
+        }
+        if got := strings.Join(sceneErr.Problems, "\n"); got !=
+            strings.Join(test.problems, "\n") {
+            t.Errorf("expected\n%s\ngot\n%s",
+                strings.Join(test.problems, "\n"), got)
+        }
+    }
+}
+
+func TestReadScene(t *testing.T) {
+    dir, err := ioutil.TempDir("", "shapes")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    filename := filepath.Join(dir, "scene.json")
+    if err := ioutil.WriteFile(filename, []byte(testScene), 0644);
+        err != nil {
+        t.Fatal(err)
+    }
+    if scene, err := shapes.ReadScene(filename); err != nil ||
+        len(scene.Shapes) != 3 {
+        t.Errorf("failed to read the scene: %v", err)
+    }
+    ioutil.WriteFile(filename, []byte(`{"width": 1, "height": 1}`), 0644)
+    if _, err := shapes.ReadScene(filename); err == nil ||
+        err.Error() != filename+": there are no shapes" {
+        t.Errorf("expected the error to give the filename got %v", err)
+    }
+}
@@ -11,10 +11,17 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package shapes is the only one of the shaper programs' shapes packages
+// that draws on a Canvas, so only shaper_ans2 can save SVG and PDF files
+// as well as PNGs and JPEGs, and the only one with ellipses, arcs, pies,
+// stars, rounded rectangles, polylines, and Bézier paths. The other shaper
+// programs keep the shapes packages that the book presents, which differ
+// in design on purpose.
 package shapes
 
 import (
     "fmt"
+    "font"
     "image"
     "image/color"
     "image/draw"
@@ -28,12 +35,20 @@ import (
     "strings"
 )
 
-var saneLength, saneRadius, saneSides func(int) int
+const (
+    maxLength = 4096
+    maxRadius = 1024
+    minSides  = 3
+    maxSides  = 60
+)
+
+var saneLength, saneRadius, saneCornerRadius, saneSides func(int) int
 
 func init() {
-    saneLength = makeBoundedIntFunc(1, 4096)
-    saneRadius = makeBoundedIntFunc(1, 1024)
-    saneSides = makeBoundedIntFunc(3, 60)
+    saneLength = makeBoundedIntFunc(1, maxLength)
+    saneRadius = makeBoundedIntFunc(1, maxRadius)
+    saneCornerRadius = makeBoundedIntFunc(0, maxRadius)
+    saneSides = makeBoundedIntFunc(minSides, maxSides)
 }
 
 func makeBoundedIntFunc(minimum, maximum int) func(int) int {
@@ -55,7 +70,7 @@ func makeBoundedIntFunc(minimum, maximum int) func(int) int {
 func saneRectangle(rect image.Rectangle) image.Rectangle {
     rect = rect.Canon()
     width, height := rect.Dx(), rect.Dy()
-    if width < 1 || width > 4096 || height < 1 || height > 4096 {
+    if width < 1 || width > maxLength || height < 1 || height > maxLength {
         return image.Rect(0, 0, 16, 16)
     }
     return rect
@@ -64,10 +79,12 @@ func saneRectangle(rect image.Rectangle) image.Rectangle {
 type Shaper interface {
     Drawer
     Filler
+    Styler
+    Bounder
 }
 
 type Drawer interface {
-    Draw(img draw.Image, x, y int) error
+    Draw(canvas Canvas, x, y int) error
 }
 
 type Filler interface {
@@ -75,6 +92,11 @@ type Filler interface {
     SetFill(fill color.Color)
 }
 
+type Styler interface {
+    Style() Style
+    SetStyle(style Style)
+}
+
 type Radiuser interface {
     Radius() int
     SetRadius(radius int)
@@ -90,11 +112,54 @@ type Rectangler interface {
     SetRect(image.Rectangle)
 }
 
+type Radiiser interface {
+    Radii() (radiusX, radiusY int)
+    SetRadii(radiusX, radiusY int)
+}
+
+type InnerRadiuser interface {
+    InnerRadius() int
+    SetInnerRadius(radius int)
+}
+
+type CornerRadiuser interface {
+    CornerRadius() int
+    SetCornerRadius(radius int)
+}
+
+// Angles are in degrees clockwise from 3 o'clock.
+type Angler interface {
+    Angles() (start, end float64)
+    SetAngles(start, end float64)
+}
+
+type Pointser interface {
+    Points() int
+    SetPoints(points int)
+}
+
+type Verticeser interface {
+    Vertices() []image.Point
+    SetVertices(vertices []image.Point)
+}
+
 type Filleder interface {
     Filled() bool
     SetFilled(bool)
 }
 
+// A Painter's paint (if not nil) is used instead of its fill color when
+// it is filled.
+type Painter interface {
+    Paint() Paint
+    SetPaint(paint Paint)
+}
+
+type FillRuler interface {
+    FillRule() FillRule
+    SetFillRule(rule FillRule)
+}
+
 /*
    This is unexported so that we are forced to use NewCircle() to create
    one thus ensuring that we always start with valid values since the zero
@@ -103,13 +168,19 @@ type Filleder interface {
    newShape() is unexported since we don't want undrawable shapes to be
    created.
 */
-type shape struct{ fill color.Color }
+type shape struct {
+    fill   color.Color
+    style  Style
+    filled bool
+    paint  Paint
+    rule   FillRule
+}
 
 func newShape(fill color.Color) shape {
     if fill == nil { // We silently treat a nil color as black
         fill = color.Black
     }
-    return shape{fill}
+    return shape{fill: fill}
 }
 
 func (shape shape) Fill() color.Color { return shape.fill }
@@ -121,6 +192,32 @@ func (shape *shape) SetFill(fill color.Color) {
     shape.fill = fill
 }
 
+// Style returns a copy so that the caller can't change the shape's
+// dashes behind its back.
+func (shape shape) Style() Style { return saneStyle(shape.style) }
+
+func (shape *shape) SetStyle(style Style) { shape.style = saneStyle(style) }
+
+func (shape shape) Filled() bool { return shape.filled }
+
+func (shape *shape) SetFilled(filled bool) { shape.filled = filled }
+
+func (shape shape) Paint() Paint { return shape.paint }
+
+func (shape *shape) SetPaint(paint Paint) { shape.paint = paint }
+
+func (shape shape) FillRule() FillRule { return shape.rule }
+
+func (shape *shape) SetFillRule(rule FillRule) { shape.rule = rule }
+
+// paintAt returns the paint for filling the shape drawn at the offset.
+func (shape shape) paintAt(offset image.Point) Paint {
+    if shape.paint == nil {
+        return Solid{shape.fill}
+    }
+    return translatePaint(shape.paint, offset)
+}
+
 // The zero value is invalid! Use NewCircle() to create a valid Circle.
 type Circle struct {
     shape
@@ -141,43 +238,19 @@ func (circle *Circle) SetRadius(radius int) {
     circle.radius = saneRadius(radius)
 }
 
-func (circle *Circle) Draw(img draw.Image, x, y int) error {
-    // Algorithm taken from
-    // http://en.wikipedia.org/wiki/Midpoint_circle_algorithm
+func (circle *Circle) Draw(canvas Canvas, x, y int) error {
     // No need to check the radius is in bounds because you can only
     // create circles using NewCircle() which guarantees it is within
-    // bounds. But the x, y might be outside the image so we check.
-    if err := checkBounds(img, x, y); err != nil {
+    // bounds. But the x, y might be outside the canvas so we check.
+    if err := checkBounds(canvas, x, y); err != nil {
         return err
     }
-    fill, radius := circle.fill, circle.radius
-    x0, y0 := x, y
-    f := 1 - radius
-    ddF_x, ddF_y := 1, -2*radius
-    x, y = 0, radius
-
-    img.Set(x0, y0+radius, fill)
-    img.Set(x0, y0-radius, fill)
-    img.Set(x0+radius, y0, fill)
-    img.Set(x0-radius, y0, fill)
-
-    for x < y {
-        if f >= 0 {
-            y--
-            ddF_y += 2
-            f += ddF_y
-        }
-        x++
-        ddF_x += 2
-        f += ddF_x
-        img.Set(x0+x, y0+y, fill)
-        img.Set(x0-x, y0+y, fill)
-        img.Set(x0+x, y0-y, fill)
-        img.Set(x0-x, y0-y, fill)
-        img.Set(x0+y, y0+x, fill)
-        img.Set(x0-y, y0+x, fill)
-        img.Set(x0+y, y0-x, fill)
-        img.Set(x0-y, y0-x, fill)
+    if circle.filled {
+        canvas.FillEllipse(image.Pt(x, y), circle.radius, circle.radius,
+            circle.paintAt(image.Pt(x, y)), circle.style.AntiAlias)
+    } else {
+        canvas.Circle(image.Pt(x, y), circle.radius, circle.fill,
+            circle.style)
     }
     return nil
 }
@@ -187,9 +260,9 @@ func (circle *Circle) String() string {
         circle.radius)
 }
 
-func checkBounds(img image.Image, x, y int) error {
-    if !image.Rect(x, y, x, y).In(img.Bounds()) {
-        return fmt.Errorf("%s(): point (%d, %d) is outside the image\n",
+func checkBounds(canvas Canvas, x, y int) error {
+    if !image.Rect(x, y, x, y).In(canvas.Bounds()) {
+        return fmt.Errorf("%s(): point (%d, %d) is outside the canvas\n",
             caller(1), x, y)
     }
     return nil
@@ -226,17 +299,20 @@ func (polygon *RegularPolygon) SetSides(sides int) {
     polygon.sides = saneSides(sides)
 }
 
-func (polygon *RegularPolygon) Draw(img draw.Image, x, y int) error {
+func (polygon *RegularPolygon) Draw(canvas Canvas, x, y int) error {
     // No need to check the radius or sides are in bounds because you can
     // only create polygons using NewRegularPolygon() which guarantees they
-    // are within bounds. But the x, y might be outside the image so we
+    // are within bounds. But the x, y might be outside the canvas so we
     // check. len(points) == sides + 1
-    if err := checkBounds(img, x, y); err != nil {
+    if err := checkBounds(canvas, x, y); err != nil {
         return err
     }
     points := getPoints(x, y, polygon.sides, float64(polygon.Radius()))
-    for i := 0; i < polygon.sides; i++ { // Draw lines between the apexes
-        drawLine(img, points[i], points[i+1], polygon.Fill())
+    if polygon.filled {
+        canvas.FillPolygon(points, polygon.paintAt(image.Pt(x, y)),
+            polygon.rule, polygon.style.AntiAlias)
+    } else {
+        canvas.Polygon(points, polygon.Fill(), polygon.style)
     }
     return nil
 }
@@ -256,52 +332,6 @@ func getPoints(x, y, sides int, radius float64) []image.Point {
     return points
 }
 
-// Based on my Perl Image::Base.pm module's line() method 
-func drawLine(img draw.Image, start, end image.Point,
-    fill color.Color) {
-    x0, x1 := start.X, end.X
-    y0, y1 := start.Y, end.Y
-    Δx := math.Abs(float64(x1 - x0))
-    Δy := math.Abs(float64(y1 - y0))
-    if Δx >= Δy { // shallow slope
-        if x0 > x1 {
-            x0, y0, x1, y1 = x1, y1, x0, y0
-        }
-        y := y0
-        yStep := 1
-        if y0 > y1 {
-            yStep = -1
-        }
-        remainder := float64(int(Δx/2)) - Δx
-        for x := x0; x <= x1; x++ {
-            img.Set(x, y, fill)
-            remainder += Δy
-            if remainder >= 0.0 {
-                remainder -= Δx
-                y += yStep
-            }
-        }
-    } else { // steep slope
-        if y0 > y1 {
-            x0, y0, x1, y1 = x1, y1, x0, y0
-        }
-        x := x0
-        xStep := 1
-        if x0 > x1 {
-            xStep = -1
-        }
-        remainder := float64(int(Δy/2)) - Δy
-        for y := y0; y <= y1; y++ {
-            img.Set(x, y, fill)
-            remainder += Δx
-            if remainder >= 0.0 {
-                remainder -= Δy
-                x += xStep
-            }
-        }
-    }
-}
-
 func (polygon *RegularPolygon) String() string {
     return fmt.Sprintf("polygon(fill=%v, radius=%d, sides=%d)",
         polygon.Fill(), polygon.Radius(), polygon.sides)
@@ -312,11 +342,10 @@ func (polygon *RegularPolygon) String() string {
 type Rectangle struct {
     shape
     image.Rectangle
-    filled bool
 }
 
 func NewRectangle(fill color.Color, rect image.Rectangle) *Rectangle {
-    return &Rectangle{newShape(fill), saneRectangle(rect), false}
+    return &Rectangle{newShape(fill), saneRectangle(rect)}
 }
 
 func (rectangle *Rectangle) Rect() image.Rectangle {
@@ -327,64 +356,47 @@ func (rectangle *Rectangle) SetRect(rect image.Rectangle) {
     rectangle.Rectangle = saneRectangle(rect)
 }
 
-func (rectangle *Rectangle) Filled() bool {
-    return rectangle.filled
-}
-
-func (rectangle *Rectangle) SetFilled(filled bool) {
-    rectangle.filled = filled
-}
-
 // x, y are the top-left (for radius-based shapes they are the middle)
-func (rectangle *Rectangle) Draw(img draw.Image, x, y int) error {
-    if err := checkBounds(img, x, y); err != nil {
+func (rectangle *Rectangle) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
         return err
     }
-    fill := rectangle.fill
-    x0, x1 := rectangle.Rectangle.Min.X, rectangle.Rectangle.Max.X
-    y0, y1 := rectangle.Rectangle.Min.Y, rectangle.Rectangle.Max.Y
-    x0 += x
-    x1 += x
-    y0 += y
-    y1 += y
+    rect := rectangle.Rectangle.Add(image.Pt(x, y))
     if !rectangle.filled {
-        drawLine(img, image.Point{x0, y0}, image.Point{x1, y0}, fill)
-        drawLine(img, image.Point{x0, y1}, image.Point{x1, y1}, fill)
-        drawLine(img, image.Point{x0, y0}, image.Point{x0, y1}, fill)
-        drawLine(img, image.Point{x1, y0}, image.Point{x1, y1}, fill)
+        canvas.Polygon([]image.Point{rect.Min, image.Pt(rect.Max.X,
+            rect.Min.Y), rect.Max, image.Pt(rect.Min.X, rect.Max.Y)},
+            rectangle.fill, rectangle.style)
     } else {
-        draw.Draw(img, image.Rect(x0, y0, x1+1, y1+1),
-            &image.Uniform{fill}, image.Pt(x0, y0), draw.Src)
+        canvas.FillRect(rect, rectangle.paintAt(image.Pt(x, y)))
     }
     return nil
 }
 
+// The fields that a shape doesn't use are ignored, and those that are
+// zero get defaults where it makes sense.
 type Option struct {
-    Fill   color.Color
-    Radius int
-    Rect   image.Rectangle
-    Filled bool
-}
-
-func New(shape string, option Option) (Shaper, error) {
-    sidesForShape := map[string]int{"triangle": 3, "square": 4,
-        "pentagon": 5, "hexagon": 6, "heptagon": 7, "octagon": 8,
-        "enneagon": 9, "nonagon": 9, "decagon": 10}
-    if sides, found := sidesForShape[shape]; found {
-        return NewRegularPolygon(option.Fill, option.Radius, sides), nil
-    }
-    if shape == "rectangle" {
-        rect := NewRectangle(option.Fill, option.Rect)
-        rect.SetFilled(option.Filled)
-        return rect, nil
-    }
-    if shape != "circle" {
-        return nil, fmt.Errorf("shapes.New(): invalid shape '%s'", shape)
-    }
-    return NewCircle(option.Fill, option.Radius), nil
-}
-
-func FilledImage(width, height int, fill color.Color) draw.Image {
+    Fill         color.Color
+    Radius       int
+    RadiusY      int // Ellipse; 0 means the same as Radius
+    InnerRadius  int // Star; 0 means half the Radius
+    CornerRadius int // Rounded rectangle
+    Points       int // Star; 0 means 5
+    StartAngle   float64
+    EndAngle     float64 // Arc and pie; the same as StartAngle for 360°
+    Rect         image.Rectangle
+    Vertices     []image.Point // Polyline and Bézier curves
+    Filled       bool
+    Style        Style
+    Paint        Paint
+    FillRule     FillRule
+    Sides        int // Polygon; the other regular polygons have their own
+    Text         string
+    Font         *font.Font // Text; nil means the default
+    Align        Alignment
+}
+
+// FilledImage returns a RasterCanvas which is also a draw.Image.
+func FilledImage(width, height int, fill color.Color) *RasterCanvas {
     if fill == nil {
         fill = color.Black
     }
@@ -392,19 +404,19 @@ func FilledImage(width, height int, fill color.Color) draw.Image {
     height = saneLength(height)
     img := image.NewRGBA(image.Rect(0, 0, width, height))
     draw.Draw(img, img.Bounds(), &image.Uniform{fill}, image.ZP, draw.Src)
-    return img
+    return NewRasterCanvas(img)
 }
 
-func DrawShapes(img draw.Image, x, y int, shapes ...Drawer) error {
+func DrawShapes(canvas Canvas, x, y int, shapes ...Drawer) error {
     for _, shape := range shapes {
-        if err := shape.Draw(img, x, y); err != nil {
+        if err := shape.Draw(canvas, x, y); err != nil {
             return err
         }
         // Thicker so that it shows up better in screenshots
-        if err := shape.Draw(img, x+1, y); err != nil {
+        if err := shape.Draw(canvas, x+1, y); err != nil {
             return err
         }
-        if err := shape.Draw(img, x, y+1); err != nil {
+        if err := shape.Draw(canvas, x, y+1); err != nil {
             return err
         }
     }
@@ -0,0 +1,174 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "math"
+)
+
+// Join says how the outlines of a shape's sides meet at its corners.
+type Join int
+
+const (
+    MiterJoin Join = iota // Sharp corners (beveled if very sharp)
+    RoundJoin
+    BevelJoin
+)
+
+func (join Join) String() string {
+    switch join {
+    case RoundJoin:
+        return "round"
+    case BevelJoin:
+        return "bevel"
+    }
+    return "miter"
+}
+
+// Corners sharper than this ratio of miter length to stroke width are
+// beveled instead (the same default limit as SVG uses).
+const miterLimit = 4
+
+// Style says how a shape's outline is stroked. The zero value is a solid
+// one-pixel-wide aliased line as the shapes have always been drawn with.
+// Translucent fill colors are always alpha blended.
+type Style struct {
+    Width     float64   // In pixels; widths below 1 are treated as 1
+    Dashes    []float64 // Alternating dash and gap lengths; nil is solid
+    Join      Join
+    AntiAlias bool
+}
+
+func (style Style) String() string {
+    return fmt.Sprintf("style(width=%g, dashes=%v, join=%v, "+
+        "antialias=%t)", style.width(), style.Dashes, style.Join,
+        style.AntiAlias)
+}
+
+func (style Style) width() float64 {
+    if style.Width < 1 {
+        return 1
+    }
+    return style.Width
+}
+
+// isThin is true for strokes that are drawn a pixel at a time.
+func (style Style) isThin() bool {
+    return style.width() == 1
+}
+
+// Dashes and gaps shorter than this are lengthened to it so that a path
+// is never cut into more pieces than it has half pixels.
+const minDashLength = 0.5
+
+// saneStyle returns a copy of the style with its width bounded and
+// unusable dashes (e.g., negative or all zero lengths) removed.
+func saneStyle(style Style) Style {
+    if style.Width > 256 {
+        style.Width = 256
+    }
+    if style.Join < MiterJoin || style.Join > BevelJoin {
+        style.Join = MiterJoin
+    }
+    style.Dashes = saneDashes(style.Dashes)
+    return style
+}
+
+// saneDashes returns a copy of the dashes with an even number of lengths
+// none of which is shorter than minDashLength, or nil for a solid line.
+func saneDashes(dashes []float64) []float64 {
+    total := 0.0
+    for _, length := range dashes {
+        if length < 0 || math.IsNaN(length) || math.IsInf(length, 0) {
+            return nil
+        }
+        total += length
+    }
+    if total == 0 {
+        return nil
+    }
+    sane := make([]float64, 0, 2*len(dashes))
+    for _, length := range dashes {
+        sane = append(sane, math.Max(length, minDashLength))
+    }
+    if len(sane)%2 == 1 { // As SVG does, e.g., 5 → 5 5
+        sane = append(sane, sane...)
+    }
+    return sane
+}
+
+// vec is a point in vector coordinates where the middle of pixel x, y
+// is at x + 0.5, y + 0.5.
+type vec struct{ x, y float64 }
+
+func (a vec) add(b vec) vec { return vec{a.x + b.x, a.y + b.y} }
+func (a vec) sub(b vec) vec { return vec{a.x - b.x, a.y - b.y} }
+func (a vec) scale(k float64) vec { return vec{a.x * k, a.y * k} }
+func (a vec) dot(b vec) float64 { return a.x*b.x + a.y*b.y }
+func (a vec) cross(b vec) float64 { return a.x*b.y - a.y*b.x }
+func (a vec) length() float64 { return math.Hypot(a.x, a.y) }
+func (a vec) normal() vec { return vec{-a.y, a.x} }
+func (a vec) distance(b vec) float64 { return a.sub(b).length() }
+func (a vec) lerp(b vec, t float64) vec {
+    return a.add(b.sub(a).scale(t))
+}
+
+func (a vec) unit() vec {
+    if length := a.length(); length > 0 {
+        return a.scale(1 / length)
+    }
+    return a
+}
+
+// dash splits a path into the pieces that the dashes leave drawn; a
+// closed path's last point must already equal its first.
+func dash(path []vec, dashes []float64) [][]vec {
+    if dashes == nil {
+        return [][]vec{path}
+    }
+    var pieces [][]vec
+    var piece []vec
+    index, left := 0, dashes[0] // Into the dashes; length left of dash
+    on := true
+    for i := 0; i+1 < len(path); i++ {
+        start, end := path[i], path[i+1]
+        length := start.distance(end)
+        for done := 0.0; done < length; {
+            step := math.Min(left, length-done)
+            if done+step == done && step > 0 { // Too small to advance
+                break
+            }
+            if on {
+                if piece == nil {
+                    piece = []vec{start.lerp(end, done/length)}
+                }
+                piece = append(piece, start.lerp(end, (done+step)/length))
+            }
+            done += step
+            if left -= step; left <= 0 {
+                if on && piece != nil {
+                    pieces = append(pieces, piece)
+                    piece = nil
+                }
+                index = (index + 1) % len(dashes)
+                left, on = dashes[index], !on
+            }
+        }
+    }
+    if piece != nil {
+        pieces = append(pieces, piece)
+    }
+    return pieces
+}
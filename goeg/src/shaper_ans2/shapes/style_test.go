@@ -0,0 +1,212 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "reflect"
+    "shaper_ans2/shapes"
+    "strings"
+    "testing"
+    "time"
+)
+
+var white = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+
+func TestStyleIsSanitized(t *testing.T) {
+    circle := shapes.NewCircle(red, 10)
+    dashes := []float64{5}
+    circle.SetStyle(shapes.Style{Width: 1000, Dashes: dashes})
+    style := circle.Style()
+    if style.Width != 256 {
+        t.Errorf("expected width 256 got %g", style.Width)
+    }
+    if !reflect.DeepEqual(style.Dashes, []float64{5, 5}) {
+        t.Errorf("expected dashes [5 5] got %v", style.Dashes)
+    }
+    style.Dashes[0] = 1
+    dashes[0] = 1
+    if circle.Style().Dashes[0] != 5 {
+        t.Error("the shape's dashes were changed from outside")
+    }
+    for _, dashes := range [][]float64{{0, 0}, {5, -1}} {
+        circle.SetStyle(shapes.Style{Dashes: dashes})
+        if circle.Style().Dashes != nil {
+            t.Errorf("expected %v to be solid", dashes)
+        }
+    }
+    circle.SetStyle(shapes.Style{Dashes: []float64{1e-7, 0, 3}})
+    if dashes := circle.Style().Dashes; !reflect.DeepEqual(dashes,
+        []float64{0.5, 0.5, 3, 0.5, 0.5, 3}) {
+        t.Errorf("expected short dashes lengthened got %v", dashes)
+    }
+    square, _ := shapes.New("square", shapes.Option{Fill: red, Radius: 10,
+        Style: shapes.Style{Width: 3, Join: shapes.RoundJoin}})
+    if style := square.Style(); style.Width != 3 ||
+        style.Join != shapes.RoundJoin {
+        t.Errorf("shapes.New() ignored the style: %v", style)
+    }
+}
+
+// Tiny dashes, whether given or made by scaling a group down, must not
+// cut a path into so many pieces that drawing never finishes.
+func TestTinyDashes(t *testing.T) {
+    circle := shapes.NewCircle(red, 40)
+    circle.SetStyle(shapes.Style{Dashes: []float64{1e-7, 1e-7}})
+    group := shapes.NewGroup(shapes.Scaling(1e-9, 1e-9))
+    dashed := shapes.NewCircle(red, 40)
+    dashed.SetStyle(shapes.Style{Dashes: []float64{4, 4}})
+    group.Add(dashed, 0, 0)
+    for _, shape := range []shapes.Drawer{circle, group} {
+        canvas := shapes.FilledImage(100, 100, white)
+        done := make(chan error, 1)
+        go func() { done <- shape.Draw(canvas, 50, 50) }()
+        select {
+        case err := <-done:
+            if err != nil {
+                t.Error(err)
+            }
+        case <-time.After(10 * time.Second):
+            t.Fatalf("drawing %v with tiny dashes didn't finish", shape)
+        }
+    }
+}
+
+func TestThickAndAntiAliasedLines(t *testing.T) {
+    for _, test := range []struct {
+        style       shapes.Style
+        rows        int // Rows the horizontal line covers at x = 20
+        partialRows bool
+    }{
+        {shapes.Style{}, 1, false},
+        {shapes.Style{Width: 5}, 5, false},
+        {shapes.Style{Width: 4, AntiAlias: true}, 5, true},
+    } {
+        canvas := shapes.FilledImage(40, 40, white)
+        canvas.Line(image.Pt(5, 20), image.Pt(35, 20), color.Black,
+            test.style)
+        rows, partialRows := 0, false
+        for y := 0; y < 40; y++ {
+            gray := color.GrayModel.Convert(canvas.At(20, y)).(color.Gray)
+            if gray.Y != 0xFF {
+                rows++
+                partialRows = partialRows || gray.Y != 0
+            }
+        }
+        if rows != test.rows || partialRows != test.partialRows {
+            t.Errorf("%v: expected %d rows (partial %t) got %d (%t)",
+                test.style, test.rows, test.partialRows, rows,
+                partialRows)
+        }
+    }
+    canvas := shapes.FilledImage(40, 40, white)
+    canvas.Line(image.Pt(0, 0), image.Pt(39, 13), color.Black,
+        shapes.Style{AntiAlias: true})
+    partial := 0
+    for x := 0; x < 40; x++ {
+        for y := 0; y < 40; y++ {
+            if gray := color.GrayModel.Convert(canvas.At(x,
+                y)).(color.Gray); gray.Y != 0 && gray.Y != 0xFF {
+                partial++
+            }
+        }
+    }
+    if partial < 40 {
+        t.Errorf("expected a Wu line to shade pixels, only %d are",
+            partial)
+    }
+}
+
+func TestDashesAndJoins(t *testing.T) {
+    canvas := shapes.FilledImage(40, 10, white)
+    canvas.Line(image.Pt(0, 5), image.Pt(39, 5), color.Black,
+        shapes.Style{Dashes: []float64{4, 6}})
+    var pattern bytes.Buffer
+    for x := 0; x < 40; x++ {
+        if canvas.At(x, 5) == color.Color(white) {
+            pattern.WriteByte('.')
+        } else {
+            pattern.WriteByte('#')
+        }
+    }
+    if pattern.String() != "#####.....#####.....#####.....#####....." {
+        t.Errorf("unexpected dashes %s", pattern.String())
+    }
+    // The outer corner of a square's top-left is only covered by a miter.
+    for _, test := range []struct {
+        join    shapes.Join
+        covered bool
+    }{{shapes.MiterJoin, true}, {shapes.RoundJoin, false},
+        {shapes.BevelJoin, false}} {
+        canvas := shapes.FilledImage(40, 40, white)
+        canvas.Polygon([]image.Point{{10, 10}, {30, 10}, {30, 30},
+            {10, 30}}, color.Black, shapes.Style{Width: 8,
+            Join: test.join})
+        if covered := canvas.At(7, 7) != color.Color(white);
+            covered != test.covered {
+            t.Errorf("%v join: expected the corner covered %t",
+                test.join, test.covered)
+        }
+    }
+}
+
+func TestAlphaBlending(t *testing.T) {
+    canvas := shapes.FilledImage(40, 40, white)
+    translucent := color.NRGBA{0, 0, 0, 0x80}
+    canvas.Polygon([]image.Point{{10, 10}, {30, 10}, {30, 30}, {10, 30}},
+        translucent, shapes.Style{Width: 5})
+    // Corners, where two sides overlap, must be no darker than sides.
+    corner, side := canvas.At(10, 10), canvas.At(20, 10)
+    if corner != side {
+        t.Errorf("expected the corner %v to match the side %v", corner,
+            side)
+    }
+    if gray := color.GrayModel.Convert(side).(color.Gray); gray.Y < 0x70 ||
+        gray.Y > 0x90 {
+        t.Errorf("expected a half-blended gray got %v", gray)
+    }
+    canvas.FillRect(image.Rect(0, 0, 3, 3), shapes.Solid{translucent})
+    if canvas.At(0, 0) == color.Color(color.RGBA{0, 0, 0, 0xFF}) {
+        t.Error("expected FillRect to blend")
+    }
+}
+
+func TestVectorStyles(t *testing.T) {
+    style := shapes.Style{Width: 2.5, Dashes: []float64{3, 1},
+        Join: shapes.BevelJoin}
+    fill := color.NRGBA{0, 0, 0xFF, 0x80}
+    svg := shapes.NewSVGCanvas(20, 20, nil)
+    svg.Circle(image.Pt(10, 10), 5, fill, style)
+    var buffer bytes.Buffer
+    svg.WriteTo(&buffer)
+    if !strings.Contains(buffer.String(), `stroke="#0000FF" `+
+        `stroke-opacity="0.502" stroke-width="2.5" `+
+        `stroke-dasharray="3 1" stroke-linejoin="bevel"`) {
+        t.Errorf("unexpected SVG style in\n%s", buffer.String())
+    }
+    pdf := shapes.NewPDFCanvas(20, 20, nil)
+    pdf.Circle(image.Pt(10, 10), 5, fill, style)
+    buffer.Reset()
+    pdf.WriteTo(&buffer)
+    for _, text := range []string{
+        "q /GS0 gs 0 0 1 RG 2.5 w [3 1] 0 d 2 j 15.5 10.5 m\n",
+        "/Resources << /ExtGState << /GS0 << /CA 0.502 /ca 0.502 >> >> >>"} {
+        if !strings.Contains(buffer.String(), text) {
+            t.Errorf("expected %q in\n%s", text, buffer.String())
+        }
+    }
+    checkXref(t, buffer.Bytes(), 4)
+}
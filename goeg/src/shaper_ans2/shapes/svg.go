@@ -0,0 +1,272 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "bytes"
+    "encoding/base64"
+    "fmt"
+    "image"
+    "image/color"
+    "image/png"
+    "io"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// SVGCanvas is a Canvas that records what is drawn as SVG elements.
+// Pixel coordinates are mapped to the centers of the pixels so that
+// one-pixel-wide strokes cover the same pixels as on a RasterCanvas.
+// Whether strokes are antialiased is left to the viewer.
+type SVGCanvas struct {
+    width, height int
+    defs          bytes.Buffer // Gradients and patterns
+    paints        int          // Number of paints in defs
+    body          bytes.Buffer
+}
+
+// NewSVGCanvas returns a canvas of the given size; the background may be
+// nil for a transparent canvas.
+func NewSVGCanvas(width, height int, background color.Color) *SVGCanvas {
+    canvas := &SVGCanvas{width: saneLength(width),
+        height: saneLength(height)}
+    if background != nil {
+        canvas.FillRect(image.Rect(0, 0, canvas.width-1, canvas.height-1),
+            Solid{background})
+    }
+    return canvas
+}
+
+func (canvas *SVGCanvas) Bounds() image.Rectangle {
+    return image.Rect(0, 0, canvas.width, canvas.height)
+}
+
+func (canvas *SVGCanvas) Line(start, end image.Point, fill color.Color,
+    style Style) {
+    fmt.Fprintf(&canvas.body, "<line x1=\"%s\" y1=\"%s\" x2=\"%s\" "+
+        "y2=\"%s\" %s/>\n", centerOf(start.X), centerOf(start.Y),
+        centerOf(end.X), centerOf(end.Y), svgStroke(fill, style))
+}
+
+func (canvas *SVGCanvas) Circle(center image.Point, radius int,
+    fill color.Color, style Style) {
+    fmt.Fprintf(&canvas.body, "<circle cx=\"%s\" cy=\"%s\" r=\"%d\" "+
+        "fill=\"none\" %s/>\n", centerOf(center.X), centerOf(center.Y),
+        radius, svgStroke(fill, style))
+}
+
+func (canvas *SVGCanvas) Ellipse(center image.Point, radiusX, radiusY int,
+    fill color.Color, style Style) {
+    fmt.Fprintf(&canvas.body, "<ellipse cx=\"%s\" cy=\"%s\" rx=\"%d\" "+
+        "ry=\"%d\" fill=\"none\" %s/>\n", centerOf(center.X),
+        centerOf(center.Y), radiusX, radiusY, svgStroke(fill, style))
+}
+
+func (canvas *SVGCanvas) Polyline(points []image.Point,
+    fill color.Color, style Style) {
+    canvas.body.WriteString("<polyline points=\"")
+    for i, point := range points {
+        if i > 0 {
+            canvas.body.WriteByte(' ')
+        }
+        fmt.Fprintf(&canvas.body, "%s,%s", centerOf(point.X),
+            centerOf(point.Y))
+    }
+    fmt.Fprintf(&canvas.body, "\" fill=\"none\" %s/>\n",
+        svgStroke(fill, style))
+}
+
+func (canvas *SVGCanvas) Polygon(points []image.Point,
+    fill color.Color, style Style) {
+    points = openPoints(points)
+    canvas.body.WriteString("<polygon points=\"")
+    for i, point := range points {
+        if i > 0 {
+            canvas.body.WriteByte(' ')
+        }
+        fmt.Fprintf(&canvas.body, "%s,%s", centerOf(point.X),
+            centerOf(point.Y))
+    }
+    fmt.Fprintf(&canvas.body, "\" fill=\"none\" %s/>\n",
+        svgStroke(fill, style))
+}
+
+func (canvas *SVGCanvas) FillRect(rect image.Rectangle, paint Paint) {
+    rect = rect.Canon()
+    fmt.Fprintf(&canvas.body, "<rect x=\"%d\" y=\"%d\" width=\"%d\" "+
+        "height=\"%d\" %s/>\n", rect.Min.X, rect.Min.Y, rect.Dx()+1,
+        rect.Dy()+1, canvas.fill(paint))
+}
+
+func (canvas *SVGCanvas) FillPolygon(points []image.Point, paint Paint,
+    rule FillRule, antiAlias bool) {
+    points = openPoints(points)
+    canvas.body.WriteString("<polygon points=\"")
+    for i, point := range points {
+        if i > 0 {
+            canvas.body.WriteByte(' ')
+        }
+        fmt.Fprintf(&canvas.body, "%s,%s", centerOf(point.X),
+            centerOf(point.Y))
+    }
+    canvas.body.WriteString("\" " + canvas.fill(paint))
+    if rule == EvenOdd {
+        canvas.body.WriteString(" fill-rule=\"evenodd\"")
+    }
+    canvas.body.WriteString("/>\n")
+}
+
+func (canvas *SVGCanvas) FillEllipse(center image.Point,
+    radiusX, radiusY int, paint Paint, antiAlias bool) {
+    fmt.Fprintf(&canvas.body, "<ellipse cx=\"%s\" cy=\"%s\" rx=\"%s\" "+
+        "ry=\"%s\" %s/>\n", centerOf(center.X), centerOf(center.Y),
+        centerOf(radiusX), centerOf(radiusY), canvas.fill(paint))
+}
+
+// fill returns the fill attributes for the paint, adding a gradient or
+// pattern to the defs if needed; unknown kinds of paint are filled with
+// the paint's color at 0, 0.
+func (canvas *SVGCanvas) fill(paint Paint) string {
+    var id string
+    switch paint := paint.(type) {
+    case LinearGradient:
+        id = canvas.newPaintId()
+        start, end := centerVec(paint.Start), centerVec(paint.End)
+        fmt.Fprintf(&canvas.defs, "<linearGradient id=\"%s\" "+
+            "gradientUnits=\"userSpaceOnUse\" x1=\"%s\" y1=\"%s\" "+
+            "x2=\"%s\" y2=\"%s\">\n", id, formatFloat(start.x),
+            formatFloat(start.y), formatFloat(end.x), formatFloat(end.y))
+        writeSVGStops(&canvas.defs, paint.Stops)
+        canvas.defs.WriteString("</linearGradient>\n")
+    case RadialGradient:
+        id = canvas.newPaintId()
+        fmt.Fprintf(&canvas.defs, "<radialGradient id=\"%s\" "+
+            "gradientUnits=\"userSpaceOnUse\" cx=\"%s\" cy=\"%s\" "+
+            "r=\"%d\">\n", id, centerOf(paint.Center.X),
+            centerOf(paint.Center.Y), paint.Radius)
+        writeSVGStops(&canvas.defs, paint.Stops)
+        canvas.defs.WriteString("</radialGradient>\n")
+    case Pattern:
+        if paint.Image == nil || paint.Image.Bounds().Empty() {
+            return svgPaint("fill", color.Transparent)
+        }
+        var data bytes.Buffer
+        if err := png.Encode(&data, paint.Image); err != nil {
+            return svgPaint("fill", paint.At(0, 0))
+        }
+        id = canvas.newPaintId()
+        size := paint.Image.Bounds().Size()
+        fmt.Fprintf(&canvas.defs, "<pattern id=\"%s\" "+
+            "patternUnits=\"userSpaceOnUse\" x=\"%d\" y=\"%d\" "+
+            "width=\"%d\" height=\"%d\">\n", id, paint.Origin.X,
+            paint.Origin.Y, size.X, size.Y)
+        fmt.Fprintf(&canvas.defs, "<image width=\"%d\" height=\"%d\" "+
+            "xmlns:xlink=\"http://www.w3.org/1999/xlink\" "+
+            "xlink:href=\"data:image/png;base64,%s\"/>\n</pattern>\n",
+            size.X, size.Y, base64.StdEncoding.EncodeToString(data.Bytes()))
+    default:
+        return svgPaint("fill", paint.At(0, 0))
+    }
+    return fmt.Sprintf("fill=\"url(#%s)\"", id)
+}
+
+func (canvas *SVGCanvas) newPaintId() string {
+    canvas.paints++
+    return fmt.Sprintf("paint%d", canvas.paints)
+}
+
+func writeSVGStops(writer io.Writer, stops []Stop) {
+    for _, stop := range saneStops(stops) {
+        nrgba := color.NRGBAModel.Convert(stop.Color).(color.NRGBA)
+        fmt.Fprintf(writer, "<stop offset=\"%s\" "+
+            "stop-color=\"#%02X%02X%02X\"", formatFloat(stop.Offset),
+            nrgba.R, nrgba.G, nrgba.B)
+        if nrgba.A != 0xFF {
+            fmt.Fprintf(writer, " stop-opacity=\"%s\"",
+                formatFloat(float64(nrgba.A)/0xFF))
+        }
+        fmt.Fprint(writer, "/>\n")
+    }
+}
+
+func (canvas *SVGCanvas) WriteTo(writer io.Writer) (int64, error) {
+    var buffer bytes.Buffer
+    fmt.Fprintf(&buffer, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"+
+        "<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" "+
+        "width=\"%d\" height=\"%d\" viewBox=\"0 0 %[1]d %[2]d\">\n",
+        canvas.width, canvas.height)
+    if canvas.defs.Len() > 0 {
+        buffer.WriteString("<defs>\n")
+        buffer.Write(canvas.defs.Bytes())
+        buffer.WriteString("</defs>\n")
+    }
+    buffer.Write(canvas.body.Bytes())
+    buffer.WriteString("</svg>\n")
+    return buffer.WriteTo(writer)
+}
+
+// svgStroke returns the stroke attributes; those that have SVG's
+// default values are omitted.
+func svgStroke(fill color.Color, style Style) string {
+    style = saneStyle(style)
+    stroke := svgPaint("stroke", fill)
+    if width := style.width(); width != 1 {
+        stroke += fmt.Sprintf(" stroke-width=\"%s\"", formatFloat(width))
+    }
+    if style.Dashes != nil {
+        dashes := make([]string, 0, len(style.Dashes))
+        for _, length := range style.Dashes {
+            dashes = append(dashes, formatFloat(length))
+        }
+        stroke += fmt.Sprintf(" stroke-dasharray=\"%s\"",
+            strings.Join(dashes, " "))
+    }
+    if style.Join != MiterJoin {
+        stroke += fmt.Sprintf(" stroke-linejoin=\"%s\"", style.Join)
+    }
+    return stroke
+}
+
+// svgPaint returns the attributes for stroking or filling in the given
+// color; only translucent colors get an opacity attribute.
+func svgPaint(attribute string, fill color.Color) string {
+    nrgba := color.NRGBAModel.Convert(fill).(color.NRGBA)
+    paint := fmt.Sprintf("%s=\"#%02X%02X%02X\"", attribute, nrgba.R,
+        nrgba.G, nrgba.B)
+    if nrgba.A != 0xFF {
+        paint += fmt.Sprintf(" %s-opacity=\"%s\"", attribute,
+            formatFloat(float64(nrgba.A)/0xFF))
+    }
+    return paint
+}
+
+// centerOf returns the vector coordinate of the middle of a pixel.
+func centerOf(coordinate int) string {
+    return formatFloat(float64(coordinate) + 0.5)
+}
+
+// formatFloat gives at most three decimal places and no trailing zeros.
+func formatFloat(x float64) string {
+    return strconv.FormatFloat(math.Round(x*1000)/1000, 'f', -1, 64)
+}
+
+// openPoints drops the last point if it merely closes the polygon since
+// vector polygons are closed anyway.
+func openPoints(points []image.Point) []image.Point {
+    if len(points) > 1 && points[0] == points[len(points)-1] {
+        return points[:len(points)-1]
+    }
+    return points
+}
@@ -0,0 +1,232 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "font"
+    "image"
+    "image/color"
+    "strings"
+    "unicode/utf8"
+)
+
+// Alignment says where a Text's lines go relative to the x it is drawn
+// at: starting there, centered on it, or ending there.
+type Alignment int
+
+const (
+    AlignLeft Alignment = iota
+    AlignCenter
+    AlignRight
+)
+
+func (align Alignment) String() string {
+    switch align {
+    case AlignCenter:
+        return "center"
+    case AlignRight:
+        return "right"
+    }
+    return "left"
+}
+
+// The sizes (in points) that the font package accepts, and the family
+// used if none is given.
+const (
+    minFontSize       = 5
+    maxFontSize       = 144
+    defaultFontFamily = "monospace"
+)
+
+// Each character takes up a cell this many pixels wide and high (before
+// scaling) to leave gaps between characters and between lines.
+const (
+    cellWidth  = glyphWidth + 1
+    cellHeight = glyphHeight + 1
+)
+
+type Texter interface {
+    Text() string
+    SetText(text string)
+}
+
+type Fonter interface {
+    Font() *font.Font
+    SetFont(textFont *font.Font)
+}
+
+type Aligner interface {
+    Alignment() Alignment
+    SetAlignment(align Alignment)
+}
+
+// The zero value is invalid! Use NewText() to create a valid Text.
+//
+// Text is drawn with the built-in bitmap font whatever the font's family
+// says; the font's size sets how much each pixel of the font is scaled
+// up by (see Scale()). Texts are always filled (with their paint if they
+// have one) and have no outline so their style is ignored.
+type Text struct {
+    shape
+    text  string
+    font  *font.Font
+    align Alignment
+}
+
+// NewText returns a Text for the text (which may have several lines);
+// if the font is nil the default is 8pt which is drawn unscaled.
+func NewText(fill color.Color, text string, textFont *font.Font) *Text {
+    label := &Text{shape: newShape(fill), text: text}
+    label.SetFont(textFont)
+    return label
+}
+
+func (label *Text) Text() string { return label.text }
+
+func (label *Text) SetText(text string) { label.text = text }
+
+// Font returns a copy so that the caller can't change the text's font
+// behind its back.
+func (label *Text) Font() *font.Font {
+    return font.New(label.font.Family(), label.font.Size())
+}
+
+func (label *Text) SetFont(textFont *font.Font) {
+    if textFont == nil {
+        label.font = font.New(defaultFontFamily, glyphHeight)
+    } else {
+        label.font = font.New(textFont.Family(), textFont.Size())
+    }
+}
+
+func (label *Text) Alignment() Alignment { return label.align }
+
+func (label *Text) SetAlignment(align Alignment) {
+    if align < AlignLeft || align > AlignRight {
+        align = AlignLeft
+    }
+    label.align = align
+}
+
+// Scale returns how many pixels wide and high each of the font's pixels
+// is drawn: the font's size in points divided by the font's height,
+// rounded, so 8pt is 1, 12pt is 2, and so on.
+func (label *Text) Scale() int {
+    scale := (label.font.Size() + glyphHeight/2) / glyphHeight
+    if scale < 1 {
+        return 1
+    }
+    return scale
+}
+
+// x, y are the top of the first line at the left, middle, or right
+// depending on the alignment.
+func (label *Text) Draw(canvas Canvas, x, y int) error {
+    if err := checkBounds(canvas, x, y); err != nil {
+        return err
+    }
+    scale := label.Scale()
+    paint := label.paintAt(image.Pt(x, y))
+    lines := label.lines()
+    for i, bounds := range label.lineBounds() {
+        left, top := x+bounds.Min.X, y+bounds.Min.Y
+        for _, char := range lines[i] {
+            glyph := glyphFor(char)
+            for row, bits := range glyph {
+                for column := 0; column < glyphWidth; {
+                    if !isSet(bits, column) {
+                        column++
+                        continue
+                    }
+                    start := column
+                    for column < glyphWidth && isSet(bits, column) {
+                        column++
+                    }
+                    canvas.FillRect(image.Rect(left+start*scale,
+                        top+row*scale, left+column*scale-1,
+                        top+(row+1)*scale-1), paint)
+                }
+            }
+            left += cellWidth * scale
+        }
+    }
+    return nil
+}
+
+func (label *Text) lines() []string {
+    return strings.Split(label.text, "\n")
+}
+
+// lineBounds returns the bounds of each line relative to where the text
+// is drawn; lines are as wide as their characters less the gap after
+// the last one, and as high as a glyph.
+func (label *Text) lineBounds() []image.Rectangle {
+    scale := label.Scale()
+    lines := label.lines()
+    bounds := make([]image.Rectangle, 0, len(lines))
+    for i, line := range lines {
+        width := 0
+        if count := utf8.RuneCountInString(line); count > 0 {
+            width = (count*cellWidth - 1) * scale
+        }
+        left := 0
+        switch label.align {
+        case AlignCenter:
+            left = -width / 2
+        case AlignRight:
+            left = 1 - width
+        }
+        top := i * cellHeight * scale
+        bounds = append(bounds, image.Rect(left, top, left+width,
+            top+glyphHeight*scale))
+    }
+    return bounds
+}
+
+func (label *Text) Bounds() image.Rectangle {
+    var bounds image.Rectangle
+    for _, line := range label.lineBounds() {
+        bounds = bounds.Union(line)
+    }
+    return bounds
+}
+
+// Contains returns true if the point is anywhere on a line of the text,
+// including the gaps between characters.
+func (label *Text) Contains(point image.Point) bool {
+    for _, line := range label.lineBounds() {
+        if point.In(line) {
+            return true
+        }
+    }
+    return false
+}
+
+func (label *Text) String() string {
+    return fmt.Sprintf("text(fill=%v, text=%q, font=%v, align=%v)",
+        label.fill, label.text, label.font, label.align)
+}
+
+func glyphFor(char rune) [glyphHeight]uint8 {
+    if index := int(char - firstGlyph); index >= 0 &&
+        index < len(glyphs) {
+        return glyphs[index]
+    }
+    return missingGlyph
+}
+
+func isSet(bits uint8, column int) bool {
+    return bits&(1<<uint(glyphWidth-1-column)) != 0
+}
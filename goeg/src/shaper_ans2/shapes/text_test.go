@@ -0,0 +1,127 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes_test
+
+import (
+    "bytes"
+    "font"
+    "image"
+    "shaper_ans2/shapes"
+    "strings"
+    "testing"
+)
+
+var (
+    _ shapes.Texter  = (*shapes.Text)(nil)
+    _ shapes.Fonter  = (*shapes.Text)(nil)
+    _ shapes.Aligner = (*shapes.Text)(nil)
+)
+
+func TestTextGlyphs(t *testing.T) {
+    canvas := shapes.FilledImage(40, 40, white)
+    label := shapes.NewText(red, "T.", nil)
+    if err := label.Draw(canvas, 10, 10); err != nil {
+        t.Fatal(err)
+    }
+    // The T's bar and stem, then the dot 6 pixels further on
+    checkPixels(t, "T.", canvas, []image.Point{{10, 10}, {14, 10},
+        {12, 16}, {17, 15}, {18, 16}}, []image.Point{{9, 10}, {15, 10},
+        {11, 11}, {12, 17}, {17, 14}, {19, 16}})
+    canvas = shapes.FilledImage(40, 40, white)
+    label.SetFont(font.New("monospace", 16)) // Doubled
+    if err := label.Draw(canvas, 10, 10); err != nil {
+        t.Fatal(err)
+    }
+    checkPixels(t, "T. ×2", canvas, []image.Point{{10, 10}, {11, 11},
+        {19, 11}, {14, 23}, {15, 23}}, []image.Point{{20, 10},
+        {14, 24}, {13, 12}})
+}
+
+func TestTextLayout(t *testing.T) {
+    for _, test := range []struct {
+        size     int
+        align    shapes.Alignment
+        scale    int
+        expected image.Rectangle
+    }{
+        // "abc\nde" is 3 characters of 6 pixels less the trailing gap
+        // wide and 2 lines of 9 pixels less the gap below high
+        {8, shapes.AlignLeft, 1, image.Rect(0, 0, 17, 17)},
+        {10, shapes.AlignCenter, 1, image.Rect(-8, 0, 9, 17)},
+        {8, shapes.AlignRight, 1, image.Rect(-16, 0, 1, 17)},
+        {12, shapes.AlignLeft, 2, image.Rect(0, 0, 34, 34)},
+        {5, shapes.AlignLeft, 1, image.Rect(0, 0, 17, 17)},
+        {144, shapes.AlignLeft, 18, image.Rect(0, 0, 306, 306)},
+    } {
+        label := shapes.NewText(red, "abc\nde", font.New("serif",
+            test.size))
+        label.SetAlignment(test.align)
+        if scale := label.Scale(); scale != test.scale {
+            t.Errorf("%dpt: expected scale %d got %d", test.size,
+                test.scale, scale)
+        }
+        if bounds := label.Bounds(); bounds != test.expected {
+            t.Errorf("%v: expected bounds %v got %v", label,
+                test.expected, bounds)
+        }
+    }
+    label := shapes.NewText(red, "abc\nde", nil)
+    label.SetAlignment(shapes.AlignCenter)
+    checkCoverage(t, "centered text", label)
+    if !label.Contains(image.Pt(5, 2)) || label.Contains(image.Pt(8, 9)) {
+        t.Error("expected gaps between characters but not after lines " +
+            "to count as part of the text")
+    }
+}
+
+func TestNewText(t *testing.T) {
+    textFont := font.New("serif", 16)
+    shape, err := shapes.New("text", shapes.Option{Fill: red,
+        Text: "é?", Font: textFont, Align: shapes.AlignRight})
+    if err != nil {
+        t.Fatal(err)
+    }
+    label := shape.(*shapes.Text)
+    textFont.SetSize(24)
+    if label.Font().Size() != 16 || label.Font().Family() != "serif" ||
+        label.Alignment() != shapes.AlignRight {
+        t.Errorf("unexpected font or alignment: %v", label)
+    }
+    label.SetAlignment(7)
+    if label.Alignment() != shapes.AlignLeft {
+        t.Errorf("expected an invalid alignment to be left got %v",
+            label.Alignment())
+    }
+    // Characters outside the font are drawn as boxes
+    canvas := shapes.FilledImage(40, 40, white)
+    if err := label.Draw(canvas, 5, 5); err != nil {
+        t.Fatal(err)
+    }
+    checkPixels(t, label.String(), canvas, []image.Point{{5, 5}, {14, 5},
+        {5, 17}, {14, 18}}, []image.Point{{7, 7}, {12, 12}})
+}
+
+func TestVectorText(t *testing.T) {
+    label := shapes.NewText(red, "-", nil)
+    canvas := shapes.NewSVGCanvas(20, 20, nil)
+    if err := label.Draw(canvas, 2, 2); err != nil {
+        t.Fatal(err)
+    }
+    var svg bytes.Buffer
+    canvas.WriteTo(&svg)
+    if !strings.Contains(svg.String(), `<rect x="2" y="5" width="5" `+
+        `height="1" fill="#FF0000"/>`) {
+        t.Errorf("expected the dash as a rect got\n%s", svg.String())
+    }
+}
@@ -0,0 +1,116 @@
+// Copyright © 2011-12 Qtrac Ltd.
+// 
+// This program or package and any associated files are licensed under the
+// Apache License, Version 2.0 (the "License"); you may not use these files
+// except in compliance with the License. You can get a copy of the License
+// at: http://www.apache.org/licenses/LICENSE-2.0.
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapes
+
+import (
+    "fmt"
+    "math"
+)
+
+// A Transform is an affine transformation which maps x, y to
+// A×x + C×y + E, B×x + D×y + F (the same as SVG's matrix(a b c d e f)).
+// Since the y-axis points down, positive angles are clockwise.
+type Transform struct {
+    A, B, C, D, E, F float64
+}
+
+var Identity = Transform{A: 1, D: 1}
+
+func Translation(x, y float64) Transform {
+    return Transform{1, 0, 0, 1, x, y}
+}
+
+func Rotation(degrees float64) Transform {
+    sin, cos := math.Sincos(degrees * math.Pi / 180)
+    if math.Mod(degrees, 90) == 0 { // Keep right angles exact
+        sin, cos = math.Round(sin), math.Round(cos)
+    }
+    return Transform{cos, sin, -sin, cos, 0, 0}
+}
+
+func Scaling(x, y float64) Transform {
+    return Transform{x, 0, 0, y, 0, 0}
+}
+
+// Skewing slants vertical lines by the x angle and horizontal lines by
+// the y angle.
+func Skewing(xDegrees, yDegrees float64) Transform {
+    return Transform{1, math.Tan(yDegrees * math.Pi / 180),
+        math.Tan(xDegrees * math.Pi / 180), 1, 0, 0}
+}
+
+// Then returns the transform that applies this one and then the next,
+// e.g., Rotation(30).Then(Translation(10, 0)) rotates then moves right.
+func (t Transform) Then(next Transform) Transform {
+    return Transform{next.A*t.A + next.C*t.B, next.B*t.A + next.D*t.B,
+        next.A*t.C + next.C*t.D, next.B*t.C + next.D*t.D,
+        next.A*t.E + next.C*t.F + next.E, next.B*t.E + next.D*t.F + next.F}
+}
+
+func (t Transform) Apply(x, y float64) (float64, float64) {
+    return t.A*x + t.C*y + t.E, t.B*x + t.D*y + t.F
+}
+
+// Inverse returns the transform that undoes this one; it is false if
+// there isn't one because this one squashes everything onto a line.
+func (t Transform) Inverse() (Transform, bool) {
+    det := t.determinant()
+    if math.Abs(det) < 1e-12 {
+        return Identity, false
+    }
+    return Transform{t.D / det, -t.B / det, -t.C / det, t.A / det,
+        (t.C*t.F - t.D*t.E) / det, (t.B*t.E - t.A*t.F) / det}, true
+}
+
+func (t Transform) String() string {
+    return fmt.Sprintf("matrix(%s %s %s %s %s %s)", formatFloat(t.A),
+        formatFloat(t.B), formatFloat(t.C), formatFloat(t.D),
+        formatFloat(t.E), formatFloat(t.F))
+}
+
+func (t Transform) determinant() float64 { return t.A*t.D - t.B*t.C }
+
+// scale is how much the transform scales areas by, as a length.
+func (t Transform) scale() float64 {
+    return math.Sqrt(math.Abs(t.determinant()))
+}
+
+// isTranslation is true if the transform only moves things.
+func (t Transform) isTranslation() bool {
+    return t.A == 1 && t.B == 0 && t.C == 0 && t.D == 1
+}
+
+// isRectilinear is true if horizontal and vertical lines stay horizontal
+// or vertical.
+func (t Transform) isRectilinear() bool {
+    return t.B == 0 && t.C == 0 || t.A == 0 && t.D == 0
+}
+
+// radii returns the radii of an axis-aligned ellipse after a rectilinear
+// transform.
+func (t Transform) radii(radiusX, radiusY int) (int, int) {
+    if t.A == 0 { // Turned by a right angle
+        return roundedLength(float64(radiusY) * math.Abs(t.C)),
+            roundedLength(float64(radiusX) * math.Abs(t.B))
+    }
+    return roundedLength(float64(radiusX) * math.Abs(t.A)),
+        roundedLength(float64(radiusY) * math.Abs(t.D))
+}
+
+// isConformal is true if circles stay circles.
+func (t Transform) isConformal() bool {
+    const ε = 1e-9
+    return math.Abs(t.A*t.C+t.B*t.D) < ε &&
+        math.Abs(t.A*t.A+t.B*t.B-t.C*t.C-t.D*t.D) < ε
+}